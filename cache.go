@@ -0,0 +1,168 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io/fs"
+	"sync"
+)
+
+// Cache keeps the documents decoded from files, decoding a file again only
+// once it changed, so services reading their configuration often, like on
+// every health check or reload request, don't parse unchanged files
+// A file changed if its modification time or size changed and its content
+// hash did too, so files touched without being changed aren't decoded
+// again. Override files, included files and the files read by file() and
+// templatefile() are checked as well
+// With WithFS, the files are checked in the file system
+// It's safe for concurrent use
+type Cache struct {
+	mu      sync.Mutex
+	opts    []Option
+	entries map[string]cacheEntry
+}
+
+// A document kept by a Cache
+type cacheEntry struct {
+	// The decoded document
+	parser *Parser
+
+	// SHA-256 of the content of the file
+	hash [sha256.Size]byte
+
+	// Stamps of the file and of the files it was decoded along with
+	stamps map[string]fileStamp
+}
+
+// What tells if a file changed without reading it
+type fileStamp struct {
+	exists bool
+
+	// Modification time, in nanoseconds since the Unix epoch
+	modTime int64
+	size    int64
+}
+
+// NewCache creates a cache decoding files with the options
+func NewCache(opts ...Option) *Cache {
+	return &Cache{opts: opts, entries: map[string]cacheEntry{}}
+}
+
+// Decode works like SafeDecode, but returns the document decoded the last
+// time if none of its files changed since then
+// The same *Parser is returned to every caller until the files change, so
+// it must not be modified
+// Errors aren't kept, files that can't be decoded are decoded again on the
+// next call
+func (c *Cache) Decode(filename string) (*Parser, error) {
+	c.mu.Lock()
+	entry, found := c.entries[filename]
+	c.mu.Unlock()
+
+	// The stamp is taken before reading, so a change made while reading is
+	// noticed the next time
+	o := newOptions(c.opts)
+	stamp, err := statFile(o, filename)
+	if err != nil {
+		return nil, err
+	}
+	if found && entry.stamps[filename] == stamp && entry.fresh(o, filename) {
+		return entry.parser, nil
+	}
+
+	data, err := o.readCAFEBytes(filename)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(data)
+	if found && entry.hash == hash && entry.fresh(o, filename) {
+		entry.stamps = copyStamps(entry.stamps)
+		entry.stamps[filename] = stamp
+		c.store(filename, entry)
+		return entry.parser, nil
+	}
+
+	p, err := safeDecode(filename, func() (*Parser, error) {
+		return decodeFile(filename, []rune(string(data)), c.opts)
+	})
+	if err != nil {
+		c.Forget(filename)
+		return nil, err
+	}
+
+	// Files the document was decoded along with
+	stamps := map[string]fileStamp{filename: stamp}
+	dependencies := append(append([]string{}, p.includedFiles...), p.readFiles...)
+	if override, hasOverride := overrideFile(filename); hasOverride {
+		dependencies = append(dependencies, override)
+	}
+	for _, dependency := range dependencies {
+		if stamps[dependency], err = statFile(o, dependency); err != nil {
+			return nil, err
+		}
+	}
+	c.store(filename, cacheEntry{parser: p, hash: hash, stamps: stamps})
+	return p, nil
+}
+
+// Forget drops the document kept for a file
+func (c *Cache) Forget(filename string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, filename)
+}
+
+// Keeps the document of a file
+func (c *Cache) store(filename string, entry cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[filename] = entry
+}
+
+// Checks if the files a document was decoded along with are unchanged
+func (e cacheEntry) fresh(o options, filename string) bool {
+	for path, stamp := range e.stamps {
+		if path == filename {
+			continue
+		}
+		if current, err := statFile(o, path); err != nil || current != stamp {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the stamp of a file, which may not exist
+func statFile(o options, path string) (fileStamp, error) {
+	info, err := o.stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fileStamp{}, nil
+	}
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{exists: true, modTime: info.ModTime().UnixNano(), size: info.Size()}, nil
+}
+
+// Returns a copy of the stamps of an entry, which other calls may be reading
+func copyStamps(stamps map[string]fileStamp) map[string]fileStamp {
+	copied := make(map[string]fileStamp, len(stamps))
+	for path, stamp := range stamps {
+		copied[path] = stamp
+	}
+	return copied
+}
@@ -0,0 +1,132 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "config.cafe")
+	modTime := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	write := func(path string, content string) {
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		modTime = modTime.Add(time.Second)
+		assert.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+	write(filename, "port = 8080\ninclude \"limits.cafe\"\n")
+	write(filepath.Join(dir, "limits.cafe"), "workers = 4\n")
+
+	cache := NewCache()
+	p, err := cache.Decode(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, 8080, p.Attributes["port"].Value)
+
+	// Unchanged files aren't decoded again
+	cached, err := cache.Decode(filename)
+	assert.NoError(t, err)
+	assert.Same(t, p, cached)
+
+	// Neither are files touched without being changed
+	write(filename, "port = 8080\ninclude \"limits.cafe\"\n")
+	cached, err = cache.Decode(filename)
+	assert.NoError(t, err)
+	assert.Same(t, p, cached)
+
+	// Changes of the file, its included files and its override file are
+	// noticed
+	write(filename, "port = 9090\ninclude \"limits.cafe\"\n")
+	p, err = cache.Decode(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, 9090, p.Attributes["port"].Value)
+
+	write(filepath.Join(dir, "limits.cafe"), "workers = 8\n")
+	p, err = cache.Decode(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, p.Attributes["workers"].Value)
+
+	write(filepath.Join(dir, "config.override.cafe"), "port = 443\n")
+	p, err = cache.Decode(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, 443, p.Attributes["port"].Value)
+
+	// Errors aren't kept
+	write(filename, "port = \"unclosed\n")
+	_, err = cache.Decode(filename)
+	assert.Error(t, err)
+	write(filename, "port = 8080\n")
+	p, err = cache.Decode(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, 443, p.Attributes["port"].Value)
+
+	cache.Forget(filename)
+	cached, err = cache.Decode(filename)
+	assert.NoError(t, err)
+	assert.NotSame(t, p, cached)
+
+	_, err = cache.Decode(filepath.Join(dir, "missing.cafe"))
+	assert.Error(t, err)
+}
+
+func TestCacheReadFiles(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "config.cafe")
+	modTime := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	write := func(path string, content string) {
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		modTime = modTime.Add(time.Second)
+		assert.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+	write(filename, "motd = file(\"motd.txt\")\n")
+	write(filepath.Join(dir, "motd.txt"), "hello")
+
+	cache := NewCache()
+	p, err := cache.Decode(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", p.Attributes["motd"].Value)
+
+	// Changes of the files read by file() are noticed
+	write(filepath.Join(dir, "motd.txt"), "goodbye")
+	p, err = cache.Decode(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "goodbye", p.Attributes["motd"].Value)
+}
+
+func TestCacheFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"config.cafe": {Data: []byte("port = 8080\n"), ModTime: time.Unix(1, 0)},
+	}
+	cache := NewCache(withFS(fsys))
+	p, err := cache.Decode("config.cafe")
+	assert.NoError(t, err)
+	assert.Equal(t, 8080, p.Attributes["port"].Value)
+
+	cached, err := cache.Decode("config.cafe")
+	assert.NoError(t, err)
+	assert.Same(t, p, cached)
+
+	// The files are checked in the file system, not in the disk
+	fsys["config.cafe"] = &fstest.MapFile{Data: []byte("port = 9090\n"), ModTime: time.Unix(2, 0)}
+	p, err = cache.Decode("config.cafe")
+	assert.NoError(t, err)
+	assert.Equal(t, 9090, p.Attributes["port"].Value)
+}
@@ -14,13 +14,370 @@
 // along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package cafe
 
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PanicError is returned by SafeDecode when the lexer or the parser
+// panicked while decoding a file
+type PanicError struct {
+	// Value passed to panic
+	Value interface{}
+
+	// Stack trace of the goroutine at the moment of the panic
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic while decoding: %v\n%s", e.Value, e.Stack)
+}
+
+// Unwrap returns the value passed to panic if it's an error, such as the
+// *ParseError of an invalid expression
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Suffix of the files overriding the file with the same name
+const overrideSuffix = ".override.cafe"
+
 // Convert a CAFE file to a Go struct
-func Decode(filename string) (*Parser, error) {
-	input, err := readCAFEFile(filename)
+// If there's a name.override.cafe file next to name.cafe, it's decoded on
+// top of it and can override its attributes
+// All the redefinitions, reserved names and other errors of the file are
+// returned at once, joined with errors.Join, while an expression that
+// can't be evaluated stops the decoding with its *ParseError
+func Decode(filename string, opts ...Option) (*Parser, error) {
+	input, err := newOptions(opts).readCAFEFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return decodeFile(filename, input, opts)
+}
+
+// Decodes the contents of a file, along with its override file
+func decodeFile(filename string, input []rune, opts []Option) (*Parser, error) {
+	var p *Parser
+	err := recoverParseError(func() {
+		p = newParser(input, opts...)
+		p.filename = filename
+		p.source = []byte(string(input))
+		p.parseItems(p.opts.logger)
+	})
+	if err != nil {
+		return nil, stoppedError(p, fileError(err, filename))
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return decodeOverride(p, filename, opts)
+}
+
+// DecodeFS works like Decode, but reads the file, its override file and
+// the files it includes or reads from a file system, such as the embed.FS
+// of the configuration bundled with a program or a zip archive
+// Paths are slash-separated and relative to the root of fsys, as in
+// "config/app.cafe"
+func DecodeFS(fsys fs.FS, name string, opts ...Option) (*Parser, error) {
+	return Decode(name, append(opts, withFS(fsys))...)
+}
+
+// Reads the files of a decoding from a file system
+func withFS(fsys fs.FS) Option {
+	return func(o *options) {
+		o.fsys = fsys
+	}
+}
+
+// DecodeWithProfile decodes a CAFE file with the sections of a profile
+// applied over the defaults, so one file can describe many environments
+func DecodeWithProfile(filename string, profile string, opts ...Option) (*Parser, error) {
+	return Decode(filename, append(opts, WithProfile(profile))...)
+}
+
+// Convert the contents of a CAFE file to a Go struct
+func DecodeBytes(src []byte, opts ...Option) (*Parser, error) {
+	input, err := readRunes(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	var p *Parser
+	err = recoverParseError(func() {
+		p = newParser(input, opts...)
+		p.source = append([]byte{}, src...)
+		p.parseItems(p.opts.logger)
+	})
+	if err != nil {
+		return nil, stoppedError(p, err)
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p, nil
+}
+
+// DecodeReader works like DecodeBytes, but reads the document from r in
+// chunks, so very large generated documents are never held in memory as a
+// whole while they're lexed
+// With WithProgress, the total is -1 until the whole input is read, and
+// with WithPipeline, the input is lexed while it's parsed
+func DecodeReader(r io.Reader, opts ...Option) (p *Parser, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			switch failure := recovered.(type) {
+			case *readError:
+				p, err = nil, failure.err
+			case *ParseError:
+				p, err = nil, stoppedError(p, failure)
+			default:
+				panic(recovered)
+			}
+		}
+	}()
+
+	o := newOptions(opts)
+	lx := &lexer{items: []item{}, lines: []string{}}
+	p = newParserFromLexer(lx, o)
+	if o.stats != nil {
+		p.reader = &countingReader{r: r}
+		p.started, r = time.Now(), p.reader
+	}
+
+	if o.pipeline {
+		stop := make(chan struct{})
+		defer close(stop)
+		p.pending = lexPipeline(r, o, stop)
+	} else {
+		err = lexBatches(r, o, func(batch lexBatch) error {
+			lx.items = append(lx.items, batch.items...)
+			lx.lines = append(lx.lines, batch.lines...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.atLastItem = !p.hasItem(0)
+	if !p.atLastItem {
+		p.currentItem = lx.items[0]
+	}
+
+	p.parseItems(o.logger)
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p, nil
+}
+
+// DecodeDir decodes every .cafe file of a directory, in the order of their
+// names, and merges them into one Parser
+// With WithAnyExtension, every file of the directory is decoded
+// Like in a conf.d directory, a file can refer to and override the
+// attributes of the files before it, but can't redefine its own
+// Override files are decoded right after the file they override
+func DecodeDir(dir string, opts ...Option) (*Parser, error) {
+	return decodeDirOverlay(newParser(nil, opts...), dir, opts)
+}
+
+// Decodes the .cafe files of a directory on top of a decoded document
+func decodeDirOverlay(merged *Parser, dir string, opts []Option) (*Parser, error) {
+	o := newOptions(opts)
+	entries, err := o.readDir(dir)
 	if err != nil {
 		return nil, err
 	}
-	p := newParser(input)
-	p.parseItems(false)
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || (!o.anyExtension && !strings.HasSuffix(name, ".cafe")) || strings.HasSuffix(name, overrideSuffix) {
+			continue
+		}
+
+		filename := o.joinPath(dir, name)
+		if merged, err = decodeOverlay(merged, filename, opts); err != nil {
+			return nil, err
+		}
+		if merged, err = decodeOverride(merged, filename, opts); err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
+
+// FileResult is the outcome of decoding one of the files of DecodeFiles
+type FileResult struct {
+	// Path of the file
+	Path string
+
+	// Decoded file, nil if it couldn't be decoded
+	Parser *Parser
+
+	// Why the file couldn't be decoded
+	Err error
+}
+
+// DecodeFiles decodes many independent files concurrently, such as when a
+// CI job validates every configuration of a repository
+// The results are in the order of the paths, and the returned error joins
+// the errors of all the files that couldn't be decoded, which already
+// name their files
+// Files are decoded like SafeDecode, so a malformed file can't bring the
+// others down, and a WithProgress callback may be called concurrently
+func DecodeFiles(paths []string, opts ...Option) ([]FileResult, error) {
+	results := make([]FileResult, len(paths))
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(paths) {
+		workers = len(paths)
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				p, err := SafeDecode(paths[index], opts...)
+				results[index] = FileResult{Path: paths[index], Parser: p, Err: err}
+			}
+		}()
+	}
+	for i := range paths {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	errs := []error{}
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// Decodes a file on top of the attributes and blocks of a decoded document
+// The file can override them, but can't redefine its own
+func decodeOverlay(base *Parser, filename string, opts []Option) (*Parser, error) {
+	input, err := newOptions(opts).readCAFEFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return overlayInput(base, filename, input, opts)
+}
+
+// Decodes a document on top of the attributes and blocks of another
+// Filename is empty for documents that don't come from files
+func overlayInput(base *Parser, filename string, input []rune, opts []Option) (*Parser, error) {
+	var p *Parser
+	err := recoverParseError(func() {
+		p = newParser(input, opts...)
+		p.filename = filename
+		p.Attributes, p.Blocks, p.definitions, p.expiries = base.Attributes, base.Blocks, base.definitions, base.expiries
+		p.definitionCount = base.definitionCount
+		p.secrets = base.secrets
+		p.includedFiles = base.includedFiles
+		p.readFiles = base.readFiles
+		p.layer = base.layer + 1
+		p.parseItems(p.opts.logger)
+	})
+	if err != nil {
+		return nil, stoppedError(p, fileError(err, filename))
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
 	return p, nil
 }
+
+// Decodes the override file of a decoded file on top of it, if there's one
+func decodeOverride(base *Parser, filename string, opts []Option) (*Parser, error) {
+	override, hasOverride := overrideFile(filename)
+	if !hasOverride {
+		return base, nil
+	}
+	if _, err := newOptions(opts).stat(override); errors.Is(err, fs.ErrNotExist) {
+		return base, nil
+	}
+	return decodeOverlay(base, override, opts)
+}
+
+// Returns the path of the override file of a file, which override files
+// themselves don't have
+func overrideFile(filename string) (string, bool) {
+	if strings.HasSuffix(filename, overrideSuffix) {
+		return "", false
+	}
+	return strings.TrimSuffix(filename, ".cafe") + overrideSuffix, true
+}
+
+// SafeDecode works like Decode, but recovers from any other panic raised
+// while lexing or parsing, such as a panic of a registered function, and
+// returns it as a *PanicError holding the stack trace.
+//
+// Services that decode files they don't control (multi-tenant servers,
+// upload endpoints, etc.) should use SafeDecode as their entry point, so a
+// malformed file can never bring the whole process down.
+func SafeDecode(filename string, opts ...Option) (*Parser, error) {
+	return safeDecode(filename, func() (*Parser, error) {
+		return Decode(filename, opts...)
+	})
+}
+
+// Runs fn, returning the *ParseError it panics with
+func recoverParseError(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			parseErr, isParseErr := r.(*ParseError)
+			if !isParseErr {
+				panic(r)
+			}
+			err = parseErr
+		}
+	}()
+	fn()
+	return nil
+}
+
+// Joins the error that stopped a Parser to the errors it recorded before,
+// if it was created
+func stoppedError(p *Parser, err error) error {
+	if p == nil {
+		return err
+	}
+	p.fail(err)
+	return p.err
+}
+
+// Names the file of a *ParseError of the lexer, which doesn't know it
+func fileError(err error, filename string) error {
+	if parseErr, isParseErr := err.(*ParseError); isParseErr && parseErr.File == "" {
+		parseErr.File = filename
+	}
+	return err
+}
+
+// Runs a decoding of a file, returning its panics like SafeDecode
+func safeDecode(filename string, decode func() (*Parser, error)) (p *Parser, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if parseErr, isParseErr := r.(*ParseError); isParseErr && parseErr.File == "" {
+				parseErr.File = filename
+			}
+			p = nil
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return decode()
+}
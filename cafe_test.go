@@ -15,8 +15,16 @@
 package cafe
 
 import (
+	"bytes"
 	"fmt"
+	"io/fs"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -26,3 +34,537 @@ func TestCAFE(t *testing.T) {
 	assert.NoError(t, err)
 	fmt.Println(b)
 }
+
+func TestSafeDecode(t *testing.T) {
+	p, err := SafeDecode("./test_data/test-functions.cafe")
+	assert.NoError(t, err)
+	assert.NotNil(t, p)
+
+	p, err = SafeDecode("./test_data/test-panic.cafe")
+	assert.Error(t, err)
+	assert.Nil(t, p)
+
+	_, err = SafeDecode("./test_data/missing.txt")
+	assert.Error(t, err)
+}
+
+func TestDecodeEnv(t *testing.T) {
+	t.Setenv("CAFE_TEST_HOME", "/home/cafe")
+	t.Setenv("CAFE_TEST_USER", "barista")
+
+	p, err := Decode("./test_data/test-env.cafe")
+	assert.NoError(t, err)
+	assert.Equal(t, "/home/cafe", p.Attributes["home"].Value)
+	assert.Equal(t, "8080", p.Attributes["port"].Value)
+	assert.Equal(t, "", p.Attributes["missing"].Value)
+	assert.Equal(t, "BARISTA", p.Attributes["greeting"].Value)
+
+	// Untrusted files can't read the environment
+	_, err = Decode("./test_data/test-env.cafe", WithoutEnv())
+	assert.ErrorIs(t, err, ErrFunctionNotAllowed)
+	_, err = DecodeBytes([]byte("home = env(\"CAFE_TEST_HOME\")\n"), WithoutEnv())
+	assert.ErrorIs(t, err, ErrFunctionNotAllowed)
+	assert.EqualError(t, err, "line 1, column 1: function env is not allowed")
+}
+
+func TestDecodeFiles(t *testing.T) {
+	p, err := Decode("./test_data/test-files.cafe")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello from a file\n", p.Attributes["greeting"].Value)
+	assert.Equal(t, "server web listens on 8080\n", p.Attributes["server_line"].Value)
+	assert.Equal(t, "owner: barista, server: web\n", p.Attributes["owner_line"].Value)
+
+	// Untrusted files can't read other files
+	_, err = Decode("./test_data/test-files.cafe", WithoutFiles())
+	assert.ErrorIs(t, err, ErrFunctionNotAllowed)
+	_, err = DecodeBytes([]byte("greeting = file(\"test_data/hello.txt\")\n"), WithoutFiles())
+	assert.ErrorIs(t, err, ErrFunctionNotAllowed)
+	assert.EqualError(t, err, "line 1, column 1: function file is not allowed")
+
+	// Nor include them, whatever their extension
+	_, err = Decode("./test_data/include/main.cafe", WithoutFiles())
+	assert.ErrorIs(t, err, ErrFunctionNotAllowed)
+	_, err = DecodeBytes([]byte("include \"test_data/hello.txt\"\n"), WithoutFiles(), WithAnyExtension())
+	assert.ErrorIs(t, err, ErrFunctionNotAllowed)
+	assert.EqualError(t, err, "line 1, column 1: include of test_data/hello.txt is not allowed")
+}
+
+func TestDecodeInclude(t *testing.T) {
+	p, err := Decode("./test_data/include/main.cafe")
+	assert.NoError(t, err)
+	assert.Equal(t, "cafe", p.Attributes["prefix"].Value)
+	assert.Equal(t, "Prefix of the names", p.Attributes["prefix"].Doc)
+	assert.Equal(t, "cafe-app", p.Attributes["name"].Value)
+	assert.Equal(t, "info", p.Blocks["logging"].Attributes["level"].Value)
+	assert.Equal(t, "localhost", p.Blocks["server"].Attributes["host"].Value)
+	assert.Equal(t, 8080, p.Blocks["server"].Attributes["port"].Value)
+	assert.Equal(t, true, p.Blocks["server"].Blocks["tls"].Attributes["enabled"].Value)
+
+	_, err = Decode("./test_data/include/cycle-a.cafe")
+	assert.ErrorContains(t, err, "circular include of")
+
+	// Included definitions can't redefine the ones of the including file
+	_, err = Decode("./test_data/include/redefined.cafe")
+	var redefinitionErr *RedefinitionError
+	assert.ErrorAs(t, err, &redefinitionErr)
+	assert.Equal(t, "prefix", redefinitionErr.Name)
+	assert.Equal(t, 2, redefinitionErr.Line)
+
+	_, err = DecodeBytes([]byte("include \"./test_data/include/missing.cafe\"\n"))
+	assert.ErrorContains(t, err, "line 1, column 1: ")
+}
+
+func TestDecodeOverride(t *testing.T) {
+	p, err := Decode("./test_data/override/app.cafe")
+	assert.NoError(t, err)
+	assert.Equal(t, "cafe", p.Attributes["name"].Value)
+	assert.Equal(t, 9090, p.Attributes["port"].Value)
+	assert.Equal(t, "localhost", p.Blocks["server"].Attributes["host"].Value)
+	assert.Equal(t, true, p.Blocks["server"].Attributes["debug"].Value)
+
+	// Appends and defaults
+	assert.Equal(t, []interface{}{"web", "debug"}, p.Attributes["tags"].Value)
+	assert.Equal(t, "cafe", p.Attributes["name"].Value)
+	assert.Equal(t, 4, p.Attributes["workers"].Value)
+
+	// Override files can also be decoded on their own
+	p, err = Decode("./test_data/override/app.override.cafe")
+	assert.NoError(t, err)
+	assert.Equal(t, 9090, p.Attributes["port"].Value)
+	assert.Equal(t, []interface{}{"debug"}, p.Attributes["tags"].Value)
+	assert.Equal(t, "other", p.Attributes["name"].Value)
+}
+
+func TestDecodeWithProfile(t *testing.T) {
+	p, err := Decode("./test_data/test-profiles.cafe")
+	assert.NoError(t, err)
+	assert.Equal(t, 8080, p.Attributes["port"].Value)
+	assert.Equal(t, "localhost", p.Blocks["server"].Attributes["host"].Value)
+	assert.NotContains(t, p.Blocks["server"].Blocks, "tls")
+	assert.Equal(t, "cafe.dev", p.Attributes["url"].Value)
+
+	p, err = DecodeWithProfile("./test_data/test-profiles.cafe", "prod")
+	assert.NoError(t, err)
+	assert.Equal(t, "cafe", p.Attributes["name"].Value)
+	assert.Equal(t, 443, p.Attributes["port"].Value)
+	assert.Equal(t, "cafe.example.com", p.Blocks["server"].Attributes["host"].Value)
+	assert.Equal(t, false, p.Blocks["server"].Attributes["debug"].Value)
+	assert.Equal(t, true, p.Blocks["server"].Blocks["tls"].Attributes["enabled"].Value)
+
+	p, err = DecodeWithProfile("./test_data/test-profiles.cafe", "staging")
+	assert.NoError(t, err)
+	assert.Equal(t, 8080, p.Attributes["port"].Value)
+	assert.Equal(t, "staging.example.com", p.Blocks["server"].Attributes["host"].Value)
+	assert.Equal(t, true, p.Blocks["server"].Attributes["debug"].Value)
+
+	// A profile can't redefine its own attributes
+	_, err = DecodeBytes([]byte("port = 1\nprofile \"prod\" {\n    port = 2\n    port = 3\n}\n"), WithProfile("prod"))
+	assert.EqualError(t, err, "line 4, column 5: attribute port redefined, previously defined at line 3")
+}
+
+func TestDecodeDir(t *testing.T) {
+	p, err := DecodeDir("./test_data/conf.d")
+	assert.NoError(t, err)
+	assert.Equal(t, "local", p.Attributes["name"].Value)
+	assert.Equal(t, 443, p.Attributes["port"].Value)
+	assert.Equal(t, "local.example.com", p.Blocks["server"].Attributes["host"].Value)
+	assert.Equal(t, true, p.Blocks["server"].Attributes["tls"].Value)
+
+	p, err = DecodeDir(t.TempDir())
+	assert.NoError(t, err)
+	assert.Empty(t, p.Attributes)
+
+	_, err = DecodeDir("./test_data/missing")
+	assert.Error(t, err)
+
+	// Directories of file systems
+	fsys := fstest.MapFS{
+		"conf.d/10-base.cafe":          {Data: []byte("port = 8080\nname = \"base\"\n")},
+		"conf.d/20-site.conf":          {Data: []byte("port = 9090\n")},
+		"conf.d/10-base.override.cafe": {Data: []byte("name = \"local\"\n")},
+	}
+	p, err = DecodeDir("conf.d", withFS(fsys))
+	assert.NoError(t, err)
+	assert.Equal(t, 8080, p.Attributes["port"].Value)
+	assert.Equal(t, "local", p.Attributes["name"].Value)
+
+	// With WithAnyExtension, every file is decoded
+	p, err = DecodeDir("conf.d", withFS(fsys), WithAnyExtension())
+	assert.NoError(t, err)
+	assert.Equal(t, 9090, p.Attributes["port"].Value)
+}
+
+func TestDecodeMultipleFiles(t *testing.T) {
+	paths := []string{
+		"./test_data/test-functions.cafe",
+		"./test_data/test-panic.cafe",
+		"./test_data/test-k8s-deployment.cafe",
+		"./test_data/missing.cafe",
+		"./test_data/test-redefinition.cafe",
+	}
+	results, err := DecodeFiles(paths)
+	assert.Error(t, err)
+	assert.Len(t, results, len(paths))
+	for i, result := range results {
+		assert.Equal(t, paths[i], result.Path)
+	}
+
+	assert.NoError(t, results[0].Err)
+	assert.NotNil(t, results[0].Parser)
+	assert.NoError(t, results[2].Err)
+	assert.Equal(t, "apps/v1", results[2].Parser.Attributes["apiVersion"].Value)
+
+	var parseErr *ParseError
+	assert.ErrorAs(t, results[1].Err, &parseErr)
+	assert.Equal(t, paths[1], parseErr.File)
+	assert.Nil(t, results[1].Parser)
+	assert.ErrorIs(t, results[3].Err, fs.ErrNotExist)
+	assert.ErrorIs(t, results[4].Err, ErrRedefined)
+	assert.ErrorIs(t, err, ErrRedefined)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	results, err = DecodeFiles(paths[:1])
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+
+	results, err = DecodeFiles(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestDecodeLineEndings(t *testing.T) {
+	src := "name = \"cafe\"\nserver {\n    // Port\n    port = 8080\n    hosts = [\n        \"a\",\n        \"b\"\n    ]\n}\n"
+	expected, err := DecodeBytes([]byte(src))
+	assert.NoError(t, err)
+
+	// Windows line endings and byte order marks don't change the document
+	windows := "\uFEFF" + strings.ReplaceAll(src, "\n", "\r\n")
+	p, err := DecodeBytes([]byte(windows))
+	assert.NoError(t, err)
+	assert.Equal(t, expected.Attributes, p.Attributes)
+	assert.Equal(t, expected.Blocks, p.Blocks)
+
+	p, err = DecodeReader(strings.NewReader(windows))
+	assert.NoError(t, err)
+	assert.Equal(t, expected.Blocks, p.Blocks)
+
+	tokens, err := Lex([]byte(windows))
+	assert.NoError(t, err)
+	expectedTokens, err := Lex([]byte(src))
+	assert.NoError(t, err)
+	assert.Equal(t, expectedTokens, tokens)
+
+	_, err = decodeBytesSafely([]byte("a = \"x\r\nb = 1\r\n"))
+	var parseErr *ParseError
+	assert.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, "a = \"x", parseErr.Snippet)
+}
+
+func TestDecodeReader(t *testing.T) {
+	src, err := os.ReadFile("./test_data/test-k8s-deployment.cafe")
+	assert.NoError(t, err)
+	expected, err := DecodeBytes(src)
+	assert.NoError(t, err)
+	p, err := DecodeReader(bytes.NewReader(src))
+	assert.NoError(t, err)
+	assert.Equal(t, expected.Attributes, p.Attributes)
+	assert.Equal(t, expected.Blocks, p.Blocks)
+
+	// Inputs read in many chunks
+	src = generateServers(1000)
+	expected, err = DecodeBytes(src, WithInterning())
+	assert.NoError(t, err)
+	lastTotal := 0
+	p, err = DecodeReader(bytes.NewReader(src), WithInterning(), WithProgress(func(done, total int) {
+		lastTotal = total
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, expected.Blocks, p.Blocks)
+	assert.Equal(t, utf8.RuneCount(src), lastTotal)
+
+	// Errors point at the line of the whole input
+	_, err = DecodeReader(bytes.NewReader(append(generateServers(1000), "port = 1\nport = 2\n"...)))
+	var parseErr *ParseError
+	assert.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, "port = 2", parseErr.Snippet)
+	assert.Equal(t, bytes.Count(src, []byte("\n"))+2, parseErr.Line)
+
+	p, err = DecodeReader(strings.NewReader(""))
+	assert.NoError(t, err)
+	assert.Empty(t, p.Attributes)
+}
+
+func TestDecodeProgress(t *testing.T) {
+	calls := 0
+	lastDone := 0
+	lastTotal := 0
+	_, err := Decode("./test_data/test-k8s-deployment.cafe", WithProgress(func(done, total int) {
+		calls++
+		assert.GreaterOrEqual(t, done, lastDone)
+		lastDone, lastTotal = done, total
+	}))
+	assert.NoError(t, err)
+	assert.Greater(t, calls, 1)
+	assert.LessOrEqual(t, calls, 1001)
+	assert.Greater(t, lastTotal, 0)
+	assert.Equal(t, lastTotal, lastDone)
+}
+
+func TestDecodeOptions(t *testing.T) {
+	// Strict mode
+	src := []byte("port = 8080\n%\n")
+	_, err := DecodeBytes(src)
+	assert.NoError(t, err)
+	_, err = DecodeBytes(src, WithStrictMode())
+	assert.EqualError(t, err, `line 2, column 1: unexpected "%"`)
+
+	// Max depth
+	src = []byte("a {\n    b {\n        c {\n        }\n    }\n}\n")
+	_, err = DecodeBytes(src, WithMaxDepth(3))
+	assert.NoError(t, err)
+	_, err = DecodeBytes(src, WithMaxDepth(2))
+	assert.EqualError(t, err, "line 3, column 9: block a.b.c is nested deeper than 2 levels")
+
+	// Functions and variables of a single decoding
+	double := func(args []Value) (Value, error) {
+		return args[0].(int) * 2, nil
+	}
+	p, err := DecodeBytes([]byte("port = double(base_port)\nregion = region\nhost = append(region, \".example.com\")\n"),
+		WithFunctions(map[string]Function{"double": double}),
+		WithVariables(map[string]Value{"base_port": 4040, "region": "eu"}))
+	assert.NoError(t, err)
+	assert.Equal(t, 8080, p.Attributes["port"].Value)
+	assert.Equal(t, "eu.example.com", p.Attributes["host"].Value)
+	assert.NotContains(t, p.Attributes, "base_port")
+
+	// Attributes take precedence over the variables
+	assert.Equal(t, "eu", p.Attributes["region"].Value)
+	p, err = DecodeBytes([]byte("region = \"us\"\nhost = region\n"), WithVariables(map[string]Value{"region": "eu"}))
+	assert.NoError(t, err)
+	assert.Equal(t, "us", p.Attributes["host"].Value)
+
+	// Functions only exist for the decoding they're given to
+	_, err = DecodeBytes([]byte("port = double(2)\n"))
+	assert.ErrorIs(t, err, ErrUnknownFunction)
+}
+
+func TestDecodeReservedNames(t *testing.T) {
+	_, err := DecodeBytes([]byte("if = 1\n"))
+	var reservedErr *ReservedNameError
+	assert.ErrorAs(t, err, &reservedErr)
+	assert.EqualError(t, err, `line 1, column 1: attribute if uses the reserved word "if" as its name`)
+
+	_, err = DecodeBytes([]byte("server {\n    upper {\n    }\n}\n"))
+	assert.EqualError(t, err, `line 2, column 5: block server.upper uses the reserved word "upper" as its name`)
+
+	// Function names are reserved, including the ones of the decoding
+	_, err = DecodeBytes([]byte("upper = 1\n"))
+	assert.EqualError(t, err, `line 1, column 1: attribute upper uses the reserved word "upper" as its name`)
+	_, err = DecodeBytes([]byte("double = 1\n"), WithFunctions(map[string]Function{
+		"double": func(args []interface{}) (interface{}, error) { return args[0], nil },
+	}))
+	assert.ErrorIs(t, err, ErrReservedName)
+
+	_, err = DecodeBytes([]byte("null = true\n"))
+	assert.Error(t, err)
+
+	// Applications can reserve their own words
+	src := []byte("secret = \"hunter2\"\n")
+	_, err = DecodeBytes(src)
+	assert.NoError(t, err)
+	_, err = DecodeBytes(src, WithReservedWords("secret"))
+	assert.EqualError(t, err, `line 1, column 1: attribute secret uses the reserved word "secret" as its name`)
+}
+
+func TestDecodeRandom(t *testing.T) {
+	p, err := Decode("./test_data/test-random.cafe", WithSeed(42))
+	assert.NoError(t, err)
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, p.Attributes["id"].Value)
+	assert.GreaterOrEqual(t, p.Attributes["port"].Value, 8000)
+	assert.LessOrEqual(t, p.Attributes["port"].Value, 8099)
+	assert.Regexp(t, `^[A-Za-z0-9]{16}$`, p.Attributes["password"].Value)
+
+	// The same seed produces the same values
+	again, err := Decode("./test_data/test-random.cafe", WithSeed(42))
+	assert.NoError(t, err)
+	assert.Equal(t, p.Attributes, again.Attributes)
+
+	other, err := Decode("./test_data/test-random.cafe", WithSeed(7))
+	assert.NoError(t, err)
+	assert.NotEqual(t, p.Attributes["id"], other.Attributes["id"])
+
+	_, err = SafeDecode("./test_data/test-random.cafe", WithReservedWords("id"))
+	assert.Error(t, err)
+	_, err = DecodeBytes([]byte("port = randint(10, 1)\n"))
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+}
+
+// Generates a configuration with many similarly named blocks
+func generateServers(count int) []byte {
+	var sb strings.Builder
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(&sb, "server_%d {\n", i)
+		sb.WriteString("    hostname = \"internal.example.com\"\n")
+		sb.WriteString("    region = \"us-east-1\"\n")
+		sb.WriteString("    port = 8080\n")
+		sb.WriteString("    enabled = true\n")
+		sb.WriteString("}\n")
+	}
+	return []byte(sb.String())
+}
+
+// Generates a document of about the given size in bytes, with the kinds of
+// values and expressions of real configurations
+func generateConfig(size int) []byte {
+	var sb strings.Builder
+	sb.WriteString("// Generated configuration\nbase_port = 8000\ndomain = \"example.com\"\n")
+	for i := 0; sb.Len() < size; i++ {
+		fmt.Fprintf(&sb, "service_%d {\n", i)
+		fmt.Fprintf(&sb, "    name = \"service-%d\"\n", i)
+		sb.WriteString("    host = \"${name}.${domain}\"\n")
+		fmt.Fprintf(&sb, "    port = 8000 + %d\n", i%1000)
+		sb.WriteString("    replicas = 3\n")
+		sb.WriteString("    weight = 0.75\n")
+		sb.WriteString("    // Whether the service runs\n")
+		sb.WriteString("    enabled = true\n")
+		sb.WriteString("    label = upper(name)\n")
+		sb.WriteString("    tags = [\"web\", \"api\", \"internal\"]\n")
+		sb.WriteString("    limits {\n")
+		sb.WriteString("        memory = 512 * 2\n")
+		sb.WriteString("        cpu = max(1, 2)\n")
+		sb.WriteString("    }\n")
+		sb.WriteString("}\n")
+	}
+	return []byte(sb.String())
+}
+
+func BenchmarkDecodeLarge(b *testing.B) {
+	src := generateConfig(1 << 20)
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodeBytes(src); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDecodeInterning(t *testing.T) {
+	src := generateServers(20)
+	p, err := DecodeBytes(src)
+	assert.NoError(t, err)
+	interned, err := DecodeBytes(src, WithInterning())
+	assert.NoError(t, err)
+	assert.Equal(t, p.Blocks, interned.Blocks)
+
+	// The 20 block names, plus the names and values repeated in every block
+	assert.Len(t, interned.lx.strings, 29)
+}
+
+// Reports the memory kept by the decoded documents
+func BenchmarkDecodeMemory(b *testing.B) {
+	src := generateServers(30)
+	benchmarks := map[string][]Option{
+		"default":   nil,
+		"interning": {WithInterning()},
+	}
+	for name, opts := range benchmarks {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			decoded := make([]*Parser, b.N)
+
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			for i := 0; i < b.N; i++ {
+				p, err := DecodeBytes(src, opts...)
+				if err != nil {
+					b.Fatal(err)
+				}
+				decoded[i] = p
+			}
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+
+			b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/float64(b.N), "retained-B/op")
+			runtime.KeepAlive(decoded)
+		})
+	}
+}
+
+// Seeds a fuzz target with the documents of the test data
+func addFuzzSeeds(f *testing.F) {
+	paths, err := fs.Glob(os.DirFS("test_data"), "*.cafe")
+	assert.NoError(f, err)
+	for _, path := range paths {
+		src, err := os.ReadFile("test_data/" + path)
+		assert.NoError(f, err)
+		f.Add(src)
+	}
+	f.Add([]byte(""))
+	f.Add([]byte("\uFEFFname = \"cafe\"\r\n"))
+	f.Add([]byte("a = [1, \"two\"\nb {\n\tc = 1 + \n"))
+}
+
+func TestDecodeInvalidExpressions(t *testing.T) {
+	// Expressions that can't be evaluated are returned as errors by every
+	// way of decoding a document
+	for _, src := range []string{"a = \"", "a = b", "a = upper(1, 2)", "a = 5 % 0", "port = 1\nb = prot", "a = \"x\" \\\n"} {
+		var parseErr *ParseError
+		_, err := DecodeBytes([]byte(src))
+		assert.ErrorAs(t, err, &parseErr, src)
+		_, err = DecodeReader(strings.NewReader(src))
+		assert.ErrorAs(t, err, &parseErr, src)
+		_, err = DecodeReader(strings.NewReader(src), WithPipeline())
+		assert.ErrorAs(t, err, &parseErr, src)
+		_, err = DecodeFS(fstest.MapFS{"app.cafe": {Data: []byte(src)}}, "app.cafe")
+		if assert.ErrorAs(t, err, &parseErr, src) {
+			assert.Equal(t, "app.cafe", parseErr.File)
+		}
+	}
+
+	// Along with the errors found before them
+	_, err := DecodeBytes([]byte("a = 1\na = 2\nb = c\n"))
+	assert.ErrorIs(t, err, ErrRedefined)
+	assert.ErrorIs(t, err, ErrUndefined)
+}
+
+// Any input is either decoded or rejected with an error, without panicking
+func FuzzDecodeBytes(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, src []byte) {
+		defer func() {
+			if r := recover(); r != nil {
+				t.Fatalf("decoding %q panicked with %v\n%s", src, r, debug.Stack())
+			}
+		}()
+		_, _ = DecodeBytes(src)
+	})
+}
+
+func TestDecodeFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"config/app.cafe":          {Data: []byte("include \"common.cafe\"\nname = append(prefix, \"-app\")\nbanner = file(\"../files/banner.txt\")\nport = 8080\n")},
+		"config/app.override.cafe": {Data: []byte("port = 9090\n")},
+		"config/common.cafe":       {Data: []byte("prefix = \"cafe\"\n")},
+		"files/banner.txt":         {Data: []byte("Welcome")},
+		"app.conf":                 {Data: []byte("port = 80\n")},
+	}
+
+	p, err := DecodeFS(fsys, "config/app.cafe")
+	assert.NoError(t, err)
+	assert.Equal(t, "cafe-app", p.Attributes["name"].Value)
+	assert.Equal(t, "Welcome", p.Attributes["banner"].Value)
+	assert.Equal(t, 9090, p.Attributes["port"].Value)
+
+	// Only .cafe files are decoded, unless any extension is allowed
+	_, err = DecodeFS(fsys, "app.conf")
+	assert.ErrorContains(t, err, "app.conf is not a .cafe file")
+	p, err = DecodeFS(fsys, "app.conf", WithAnyExtension())
+	assert.NoError(t, err)
+	assert.Equal(t, 80, p.Attributes["port"].Value)
+
+	// The files of the OS aren't read
+	_, err = DecodeFS(fsys, "test_data/test-lexer.cafe")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
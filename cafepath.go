@@ -0,0 +1,336 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// A step of a path query, selecting the attributes and blocks matching a
+// name below the ones selected so far
+type pathStep struct {
+	// Name to match, "*" for any name
+	name string
+
+	// Match at any depth, not only in the blocks selected so far
+	recursive bool
+
+	// Conditions in brackets the matches must meet, all of them
+	predicates []pathPredicate
+}
+
+// The conditions in brackets of a step of a path query
+// Every condition of a group must be met, and at least one of the groups
+type pathPredicate [][]pathCondition
+
+// A condition of a path query on the value of a match, or on the attribute
+// or block at a path of it
+type pathCondition struct {
+	// Path of an attribute or block of the match, empty for the match
+	// itself
+	path []string
+
+	// Comparison operator, empty to only check the path exists
+	operator string
+
+	// Value the path is compared with
+	value interface{}
+}
+
+// An attribute or a block selected by a path query
+type pathNode struct {
+	path       Path
+	attributes map[string]Attribute
+	blocks     map[string]Block
+	isBlock    bool
+	match      Match
+}
+
+// Returns the steps of a path matching its names as they are, where a "*"
+// matches any name, like the paths of Lookup and Subscribe
+func literalSteps(path Path) []pathStep {
+	steps := make([]pathStep, len(path))
+	for i, name := range path {
+		steps[i] = pathStep{name: name}
+	}
+	return steps
+}
+
+// Reports whether a step selects the attributes and blocks of a name
+func (step pathStep) matchesName(name string) bool {
+	return step.name == "*" || step.name == name
+}
+
+// Reports whether the steps of a path query select a path, leaving aside
+// their conditions, which need the values of the document
+func matchesSteps(path Path, steps []pathStep) bool {
+	if len(steps) == 0 {
+		return len(path) == 0
+	}
+	for i := range path {
+		if steps[0].matchesName(path[i]) && matchesSteps(path[i+1:], steps[1:]) {
+			return true
+		}
+		if !steps[0].recursive {
+			return false
+		}
+	}
+	return false
+}
+
+// Query returns the attributes and blocks selected by a path query, in the
+// order they were defined in, as in
+//
+//	servers.*.port
+//	..port
+//	servers.*[port > 1000 and enabled == true].name
+//
+// Names are separated by dots and can be quoted, a "*" matches any name,
+// and a name after two dots matches at any depth, so "..port" selects
+// every port of the document
+// Conditions in brackets filter the selected attributes and blocks: they
+// compare an attribute of a block, or "." for the value itself, with a
+// literal, or only check that an attribute or block exists
+// Conditions are joined by "and", which takes precedence over "or"
+func (p *Parser) Query(query string) ([]Match, error) {
+	steps, err := parsePathQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := p.selectPath(steps)
+	matches := make([]Match, len(nodes))
+	for i, node := range nodes {
+		matches[i] = node.match
+	}
+	return matches, nil
+}
+
+// Selects the attributes and blocks of the steps of a path query, in the
+// order they were defined in
+func (p *Parser) selectPath(steps []pathStep) []pathNode {
+	nodes := []pathNode{{attributes: p.Attributes, blocks: p.Blocks, isBlock: true}}
+	for _, step := range steps {
+		selected := []pathNode{}
+		seen := map[string]bool{}
+		for _, node := range nodes {
+			p.selectStep(node, step, seen, &selected)
+		}
+		nodes = selected
+	}
+	return nodes
+}
+
+// Selects the attributes and blocks of a step below a node
+// The values of blocks are only built for the blocks the step names
+func (p *Parser) selectStep(node pathNode, step pathStep, seen map[string]bool, selected *[]pathNode) {
+	for _, name := range p.orderedNames(node.path, node.attributes, node.blocks) {
+		path := childPath(node.path, name)
+		child := pathNode{path: path}
+		attr, isAttribute := node.attributes[name]
+		b := node.blocks[name]
+		if !isAttribute {
+			child.attributes, child.blocks, child.isBlock = b.Attributes, b.Blocks, true
+		}
+
+		if step.matchesName(name) && !seen[path.String()] {
+			if isAttribute {
+				child.match = Match{Path: path, Value: attr.Value, Range: attr.Range, Doc: attr.Doc}
+			} else {
+				child.match = Match{Path: path, Value: bodyToJSON(b.Attributes, b.Blocks), Range: b.Range, Doc: b.Doc}
+			}
+			if child.meets(step.predicates) {
+				seen[path.String()] = true
+				*selected = append(*selected, child)
+			}
+		}
+		if step.recursive && child.isBlock {
+			p.selectStep(child, step, seen, selected)
+		}
+	}
+}
+
+// Reports whether a node meets the conditions of a step
+func (node pathNode) meets(predicates []pathPredicate) bool {
+	for _, predicate := range predicates {
+		if !node.meetsPredicate(predicate) {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports whether a node meets every condition of one of the groups of a
+// predicate
+func (node pathNode) meetsPredicate(predicate pathPredicate) bool {
+	for _, group := range predicate {
+		met := true
+		for _, cond := range group {
+			if !node.meetsCondition(cond) {
+				met = false
+				break
+			}
+		}
+		if met {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether a node meets a condition
+func (node pathNode) meetsCondition(cond pathCondition) bool {
+	value, found := node.match.Value, true
+	if len(cond.path) > 0 {
+		if !node.isBlock {
+			return false
+		}
+		value, found = lookupPath(node.attributes, node.blocks, cond.path)
+	}
+	if !found {
+		return false
+	}
+	return cond.operator == "" || compareQueryValues(value, cond.operator, cond.value)
+}
+
+// Parses a path query into its steps
+func parsePathQuery(query string) ([]pathStep, error) {
+	runes := []rune(strings.TrimSpace(query))
+	if len(runes) == 0 {
+		return nil, fmt.Errorf("query: empty path")
+	}
+
+	steps := []pathStep{}
+	pos := 0
+	if runes[0] == '.' && !strings.HasPrefix(string(runes), "..") {
+		// Like in jq, the path can start with a dot
+		pos++
+	}
+	for pos < len(runes) {
+		step := pathStep{}
+		switch {
+		case strings.HasPrefix(string(runes[pos:]), ".."):
+			step.recursive = true
+			pos += 2
+		case runes[pos] == '.' && len(steps) > 0:
+			pos++
+		case len(steps) > 0:
+			return nil, fmt.Errorf("query: expected a dot at column %d", pos+1)
+		}
+
+		name, end, err := scanPathName(runes, pos)
+		if err != nil {
+			return nil, err
+		}
+		step.name, pos = name, end
+
+		for pos < len(runes) && runes[pos] == '[' {
+			end, quoted := pos+1, false
+			for end < len(runes) && (quoted || runes[end] != ']') {
+				if runes[end] == '"' {
+					quoted = !quoted
+				}
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("query: unclosed condition at column %d", pos+1)
+			}
+			predicate, err := parsePathPredicate(string(runes[pos+1 : end]))
+			if err != nil {
+				return nil, err
+			}
+			step.predicates = append(step.predicates, predicate)
+			pos = end + 1
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// Scans the name of a step, quoted or not, returning where it ends
+func scanPathName(runes []rune, pos int) (string, int, error) {
+	if pos < len(runes) && runes[pos] == '"' {
+		end := pos + 1
+		for end < len(runes) && runes[end] != '"' {
+			end++
+		}
+		if end == len(runes) {
+			return "", 0, fmt.Errorf("query: unterminated string at column %d", pos+1)
+		}
+		return string(runes[pos+1 : end]), end + 1, nil
+	}
+
+	end := pos
+	for end < len(runes) && (runes[end] == '*' || runes[end] == '_' || runes[end] == '-' || unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end])) {
+		end++
+	}
+	name := string(runes[pos:end])
+	if name == "" || (strings.Contains(name, "*") && name != "*") {
+		return "", 0, fmt.Errorf("query: expected a name at column %d", pos+1)
+	}
+	return name, end, nil
+}
+
+// Parses the conditions of a predicate, like "port > 1000 and enabled"
+func parsePathPredicate(src string) (pathPredicate, error) {
+	tokens, err := tokenizeQuery(src)
+	if err != nil {
+		return nil, err
+	}
+
+	predicate := pathPredicate{{}}
+	for pos := 0; ; {
+		if pos >= len(tokens) {
+			return nil, fmt.Errorf("query: expected a condition in [%s]", src)
+		}
+		cond := pathCondition{}
+		if tokens[pos] != "." {
+			if !isQueryIdentifier(tokens[pos]) || strings.Contains(tokens[pos], "*") {
+				return nil, fmt.Errorf("query: expected an attribute name in [%s], got %s", src, tokens[pos])
+			}
+			cond.path = strings.Split(tokens[pos], ".")
+		}
+		pos++
+
+		if pos < len(tokens) && equalsToMany(tokens[pos], queryOperators) {
+			if pos+1 >= len(tokens) {
+				return nil, fmt.Errorf("query: incomplete condition in [%s]", src)
+			}
+			cond.operator = tokens[pos]
+			if tokens[pos+1] != "null" {
+				if cond.value, err = parseQueryLiteral(tokens[pos+1]); err != nil {
+					return nil, err
+				}
+			}
+			pos += 2
+		}
+		last := len(predicate) - 1
+		predicate[last] = append(predicate[last], cond)
+
+		if pos == len(tokens) {
+			return predicate, nil
+		}
+		switch strings.ToLower(tokens[pos]) {
+		case "and":
+		case "or":
+			predicate = append(predicate, []pathCondition{})
+		default:
+			return nil, fmt.Errorf("query: expected \"and\" or \"or\" in [%s], got %s", src, tokens[pos])
+		}
+		pos++
+	}
+}
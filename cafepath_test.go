@@ -0,0 +1,111 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryPaths(t *testing.T) {
+	p, err := DecodeBytes([]byte(`port = 80
+servers {
+    api {
+        port = 8080
+        enabled = true
+        tls {
+            port = 8443
+        }
+    }
+    legacy {
+        port = 3000
+        enabled = false
+    }
+    web {
+        port = 443
+        enabled = true
+        name = "web]"
+        "listen address" = "0.0.0.0"
+    }
+}
+`))
+	assert.NoError(t, err)
+
+	paths := func(query string) []string {
+		matches, err := p.Query(query)
+		assert.NoError(t, err, query)
+		result := []string{}
+		for _, match := range matches {
+			result = append(result, match.Path.String())
+		}
+		return result
+	}
+
+	assert.Equal(t, []string{"servers.api.port", "servers.legacy.port", "servers.web.port"}, paths("servers.*.port"))
+	assert.Equal(t, []string{"port", "servers.api.port", "servers.api.tls.port", "servers.legacy.port", "servers.web.port"}, paths("..port"))
+	assert.Equal(t, []string{"servers.api.tls.port"}, paths("servers..tls.port"))
+	assert.Equal(t, []string{"servers.api.port", "servers.api.tls.port"}, paths(".servers.api..port"))
+	assert.Equal(t, []string{`servers.web."listen address"`}, paths(`servers.web."listen address"`))
+
+	// Conditions
+	assert.Equal(t, []string{"servers.api", "servers.web"}, paths("servers.*[enabled == true]"))
+	assert.Equal(t, []string{"servers.api.port"}, paths("servers.*[port > 1000 and enabled == true].port"))
+	assert.Equal(t, []string{"servers.api", "servers.legacy"}, paths("servers.*[port >= 8000 or enabled == false]"))
+	assert.Equal(t, []string{"servers.api"}, paths("servers.*[tls]"))
+	assert.Equal(t, []string{"servers.api"}, paths("servers.*[tls.port == 8443]"))
+	assert.Equal(t, []string{"servers.web"}, paths(`servers.*[name == "web]"]`))
+	assert.Equal(t, []string{"servers.api.tls.port", "servers.legacy.port"}, paths("servers..port[. > 1000][. != 8080]"))
+	assert.Empty(t, paths("servers.*[missing]"))
+
+	// Values and ranges, like Lookup
+	matches, err := p.Query("servers.legacy")
+	assert.NoError(t, err)
+	assert.Equal(t, []Match{{
+		Path:  Path{"servers", "legacy"},
+		Value: map[string]interface{}{"port": 3000, "enabled": false},
+		Range: Range{Start: Position{10, 5}, End: Position{13, 6}},
+	}}, matches)
+
+	for query, message := range map[string]string{
+		"":                       "query: empty path",
+		"servers port":           "query: expected a dot at column 8",
+		"servers.":               "query: expected a name at column 9",
+		"servers.a*":             "query: expected a name at column 9",
+		`servers."api`:           "query: unterminated string at column 9",
+		"servers.*[port > 1000":  "query: unclosed condition at column 10",
+		"servers.*[]":            "query: expected a condition in []",
+		"servers.*[port >]":      "query: incomplete condition in [port >]",
+		"servers.*[port ~ 1]":    "query: expected \"and\" or \"or\" in [port ~ 1], got ~",
+		"servers.*[port == abc]": "query: invalid value abc",
+	} {
+		_, err := p.Query(query)
+		assert.EqualError(t, err, message, query)
+	}
+}
+
+func TestMatchesSteps(t *testing.T) {
+	steps, err := parsePathQuery("servers..port")
+	assert.NoError(t, err)
+	assert.True(t, matchesSteps(Path{"servers", "port"}, steps))
+	assert.True(t, matchesSteps(Path{"servers", "api", "tls", "port"}, steps))
+	assert.False(t, matchesSteps(Path{"api", "port"}, steps))
+	assert.False(t, matchesSteps(Path{"servers", "port", "tls"}, steps))
+
+	steps = literalSteps(Path{"servers", "*", "port"})
+	assert.True(t, matchesSteps(Path{"servers", "api", "port"}, steps))
+	assert.False(t, matchesSteps(Path{"servers", "api", "tls", "port"}, steps))
+	assert.False(t, matchesSteps(Path{"servers", "port"}, steps))
+}
@@ -0,0 +1,163 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package cafetest checks that CAFE documents keep their meaning when
+// converted to JSON and back.
+//
+// Each document goes through CAFE -> JSON -> CAFE and both versions are
+// compared by their JSON semantics. Applications can run the same harness
+// against their own files as a compatibility gate:
+//
+//	func TestConfigs(t *testing.T) {
+//		cafetest.CheckFiles(t, "configs/app.cafe", "configs/db.cafe")
+//	}
+package cafetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ldatb/cafe"
+)
+
+// RoundTrip decodes a CAFE document, converts it to JSON, back to CAFE,
+// decodes it again and checks that both documents are equivalent
+func RoundTrip(src []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic decoding original document: %v", r)
+		}
+	}()
+
+	original, err := cafe.DecodeBytes(src)
+	if err != nil {
+		return fmt.Errorf("decoding original document: %w", err)
+	}
+	return roundTrip(original)
+}
+
+// RoundTripFile works like RoundTrip for the CAFE file at filename
+func RoundTripFile(filename string) error {
+	original, err := cafe.SafeDecode(filename)
+	if err != nil {
+		return fmt.Errorf("decoding %s: %w", filename, err)
+	}
+	if err := roundTrip(original); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	return nil
+}
+
+// CheckFiles runs RoundTripFile on every file, reporting failures to t
+func CheckFiles(t testing.TB, filenames ...string) {
+	t.Helper()
+	for _, filename := range filenames {
+		if err := RoundTripFile(filename); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// Does the CAFE -> JSON -> CAFE conversion of a decoded document
+func roundTrip(original *cafe.Parser) error {
+	originalJSON, err := original.ToJSON()
+	if err != nil {
+		return fmt.Errorf("converting to JSON: %w", err)
+	}
+
+	fromJSON, err := cafe.FromJSON(originalJSON)
+	if err != nil {
+		return fmt.Errorf("converting from JSON: %w", err)
+	}
+
+	var encoded bytes.Buffer
+	if err := cafe.NewEncoder(&encoded).Encode(fromJSON); err != nil {
+		return fmt.Errorf("encoding to CAFE: %w", err)
+	}
+
+	decoded, err := cafe.DecodeBytes(encoded.Bytes())
+	if err != nil {
+		return fmt.Errorf("decoding converted document: %w", err)
+	}
+
+	return Equivalent(original, decoded)
+}
+
+// Equivalent reports whether two documents have the same JSON semantics,
+// returning an error that describes the first difference found
+// Numbers are compared by value, so 2 and 2.0 are equivalent
+func Equivalent(a, b *cafe.Parser) error {
+	aValue, err := jsonValue(a)
+	if err != nil {
+		return err
+	}
+	bValue, err := jsonValue(b)
+	if err != nil {
+		return err
+	}
+	return compare("$", aValue, bValue)
+}
+
+// Gets the generic JSON value of a document
+func jsonValue(p *cafe.Parser) (interface{}, error) {
+	data, err := p.ToJSON()
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	err = json.Unmarshal(data, &value)
+	return value, err
+}
+
+// Compares two generic JSON values, describing where they differ
+func compare(path string, a, b interface{}) error {
+	aObj, aIsObj := a.(map[string]interface{})
+	bObj, bIsObj := b.(map[string]interface{})
+	if aIsObj && bIsObj {
+		keys := map[string]bool{}
+		for key := range aObj {
+			keys[key] = true
+		}
+		for key := range bObj {
+			keys[key] = true
+		}
+		sortedKeys := make([]string, 0, len(keys))
+		for key := range keys {
+			sortedKeys = append(sortedKeys, key)
+		}
+		sort.Strings(sortedKeys)
+
+		for _, key := range sortedKeys {
+			aMember, inA := aObj[key]
+			bMember, inB := bObj[key]
+			if !inA || !inB {
+				return fmt.Errorf("%s.%s: present in only one of the documents", path, key)
+			}
+			if err := compare(path+"."+key, aMember, bMember); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		return fmt.Errorf("%s: %v != %v", path, a, b)
+	}
+	return nil
+}
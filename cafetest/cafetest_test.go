@@ -0,0 +1,47 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafetest
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/ldatb/cafe"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeneratedCorpus(t *testing.T) {
+	r := rand.New(rand.NewSource(2023))
+	for i := 0; i < 300; i++ {
+		doc := GenerateDocument(r)
+		assert.NoError(t, RoundTrip(doc), string(doc))
+	}
+}
+
+func TestCorpusFiles(t *testing.T) {
+	CheckFiles(t, "./testdata/app.cafe", "./testdata/types.cafe")
+}
+
+func TestEquivalent(t *testing.T) {
+	a, err := cafe.DecodeBytes([]byte("a = 2\nb {\n    c = [1, 2.5]\n}\n"))
+	assert.NoError(t, err)
+	b, err := cafe.DecodeBytes([]byte("a = 2.0\nb {\n    c = [1.0, 2.5]\n}\n"))
+	assert.NoError(t, err)
+	c, err := cafe.DecodeBytes([]byte("a = 2\nb {\n    c = [1, 3]\n}\n"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, Equivalent(a, b))
+	assert.EqualError(t, Equivalent(a, c), "$.b.c: [1 2.5] != [1 3]")
+}
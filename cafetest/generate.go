@@ -0,0 +1,142 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafetest
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// Characters used for generated names, quoted names and strings
+const (
+	nameChars       = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_"
+	quotedNameChars = nameChars + " .:/-"
+	stringChars     = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 _.:/"
+)
+
+// Maximum depth of nested blocks in generated documents
+const maxGeneratedDepth = 3
+
+// GenerateDocument builds a random CAFE document from r
+// Documents are made of the constructs that have a JSON equivalent:
+// strings, numbers, booleans, nulls, arrays, (nested) blocks and quoted
+// names, with comments around them that the conversion drops
+func GenerateDocument(r *rand.Rand) []byte {
+	var sb strings.Builder
+	generateBody(r, &sb, 0)
+	return []byte(sb.String())
+}
+
+// Writes random attributes and blocks at the given depth
+func generateBody(r *rand.Rand, sb *strings.Builder, depth int) {
+	indent := strings.Repeat("    ", depth)
+	used := map[string]bool{}
+
+	for i := r.Intn(6); i > 0; i-- {
+		if r.Intn(4) == 0 {
+			sb.WriteString(indent + generateComment(r) + "\n")
+		}
+		sb.WriteString(fmt.Sprintf("%s%s = %s", indent, generateKey(r, used), generateValue(r, true)))
+		if r.Intn(4) == 0 {
+			sb.WriteString(" " + generateComment(r))
+		}
+		sb.WriteString("\n")
+	}
+
+	if depth == maxGeneratedDepth {
+		return
+	}
+	for i := r.Intn(4); i > 0; i-- {
+		sb.WriteString(fmt.Sprintf("%s%s {\n", indent, generateName(r, used)))
+		generateBody(r, sb, depth+1)
+		sb.WriteString(indent + "}\n")
+	}
+}
+
+// Generates a name not yet used in the current body
+func generateName(r *rand.Rand, used map[string]bool) string {
+	for {
+		name := "k" + randomString(r, nameChars, 1+r.Intn(8))
+		if !used[name] {
+			used[name] = true
+			return name
+		}
+	}
+}
+
+// Generates the name of an attribute not yet used in the current body,
+// quoted to hold spaces, dots and slashes now and then
+func generateKey(r *rand.Rand, used map[string]bool) string {
+	if r.Intn(4) != 0 {
+		return generateName(r, used)
+	}
+	for {
+		name := "k" + randomString(r, quotedNameChars, 1+r.Intn(12))
+		if !used[name] {
+			used[name] = true
+			return `"` + name + `"`
+		}
+	}
+}
+
+// Generates a comment in any of its forms
+func generateComment(r *rand.Rand) string {
+	text := randomString(r, stringChars, r.Intn(24))
+	switch r.Intn(3) {
+	case 0:
+		return "// " + text
+	case 1:
+		return "# " + text
+	default:
+		return "/* " + text + " */"
+	}
+}
+
+// Generates a random value, arrays are only generated if allowed
+func generateValue(r *rand.Rand, allowArrays bool) string {
+	kinds := 5
+	if allowArrays {
+		kinds = 6
+	}
+
+	switch r.Intn(kinds) {
+	case 0:
+		return `"` + randomString(r, stringChars, r.Intn(16)) + `"`
+	case 1:
+		return fmt.Sprint(r.Intn(1000000))
+	case 2:
+		return fmt.Sprintf("%d.%d", r.Intn(1000), 1+r.Intn(999))
+	case 3:
+		return fmt.Sprint(r.Intn(2) == 1)
+	case 4:
+		return "null"
+	default:
+		elems := make([]string, 1+r.Intn(5))
+		for i := range elems {
+			elems[i] = generateValue(r, false)
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	}
+}
+
+// Generates a random string of the given length from a set of characters
+func randomString(r *rand.Rand, chars string, length int) string {
+	b := make([]byte, length)
+	for i := range b {
+		b[i] = chars[r.Intn(len(chars))]
+	}
+	return string(b)
+}
@@ -0,0 +1,60 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/ldatb/cafe"
+)
+
+// cafe diff old.cafe new.cafe
+// Like diff, exits with 1 if the files differ and 2 if they can't be read
+func runDiff(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: cafe diff <old file> <new file>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	documents := make([]*cafe.Parser, 2)
+	for i, filename := range flags.Args() {
+		p, err := cafe.SafeDecode(filename)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", filename, err)
+			return 2
+		}
+		documents[i] = p
+	}
+
+	changes := cafe.Diff(documents[0], documents[1])
+	for _, change := range changes {
+		fmt.Fprintln(stdout, change)
+	}
+	if len(changes) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ldatb/cafe"
+)
+
+// cafe gen [-package name] [-type name] [-o file.go] file.cafe
+func runGen(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("gen", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	pkg := flags.String("package", "config", "package of the generated file")
+	typeName := flags.String("type", "Config", "name of the struct of the whole file")
+	output := flags.String("o", "", "write the generated code to a file instead of the standard output")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: cafe gen [flags] <file>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	p, err := cafe.SafeDecode(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", flags.Arg(0), err)
+		return 1
+	}
+	code, err := cafe.GenerateGo(p, cafe.GenerateOptions{Package: *pkg, Type: *typeName})
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if *output == "" {
+		stdout.Write(code)
+		return 0
+	}
+	if err := os.WriteFile(*output, code, 0o644); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,77 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+// Command cafe works with CAFE files from the command line
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// A command of the cafe tool
+type command struct {
+	// Short description shown in the usage
+	description string
+
+	// Runs the command with its arguments and returns the exit code
+	run func(args []string, stdout io.Writer, stderr io.Writer) int
+}
+
+var commands = map[string]command{
+	"diff":   {"report the attributes and blocks added, removed and modified between two CAFE files", runDiff},
+	"gen":    {"generate Go structs from an example CAFE file", runGen},
+	"lint":   {"report the problems of CAFE files that don't prevent decoding them", runLint},
+	"mv":     {"move a file and rewrite the CAFE references to it", runMv},
+	"query":  {"select attributes from the blocks of CAFE files", runQuery},
+	"schema": {"infer the JSON Schema of an example CAFE file", runSchema},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// Runs the command named by the first argument
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return 2
+	}
+
+	cmd, found := commands[args[0]]
+	if !found {
+		fmt.Fprintf(stderr, "cafe: unknown command %q\n", args[0])
+		usage(stderr)
+		return 2
+	}
+	return cmd.run(args[1:], stdout, stderr)
+}
+
+// Writes the usage of the tool
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: cafe <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "    %-10s %s\n", name, commands[name].description)
+	}
+}
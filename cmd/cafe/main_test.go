@@ -0,0 +1,242 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ldatb/cafe"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 2, run(nil, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "usage: cafe <command>")
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"brew"}, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), `unknown command "brew"`)
+}
+
+func TestQuery(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"query", "select name, port from block servers.* where port > 1000", "../../test_data/test-query.cafe"}, &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "BLOCK           NAME    PORT\nservers.api     api     8080\nservers.legacy  legacy  3000\n", stdout.String())
+
+	stderr.Reset()
+	code = run([]string{"query", "select name", "../../test_data/test-query.cafe"}, &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), `expected "from"`)
+
+	// Files with different attributes share one header
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.cafe")
+	second := filepath.Join(dir, "second.cafe")
+	assert.NoError(t, os.WriteFile(first, []byte("server {\n    port = 80\n}\n"), 0o600))
+	assert.NoError(t, os.WriteFile(second, []byte("server {\n    host = \"localhost\"\n}\n"), 0o600))
+	stdout.Reset()
+	stderr.Reset()
+	code = run([]string{"query", "select * from block server", first, second}, &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if assert.Len(t, lines, 3) {
+		assert.Equal(t, []string{"BLOCK", "HOST", "PORT"}, strings.Fields(lines[0]))
+		assert.Equal(t, []string{first + ":server", "80"}, strings.Fields(lines[1]))
+		assert.Equal(t, []string{second + ":server", "localhost"}, strings.Fields(lines[2]))
+		assert.Equal(t, strings.Index(lines[0], "PORT"), strings.LastIndex(lines[1], "80"))
+	}
+}
+
+func TestMvIncludes(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.cafe":         "/* include \"services/web.cafe\" */\ninclude \"services/web.cafe\"\nport = 80\n",
+		"services/web.cafe": "include \"../common.cafe\" // shared\nname = \"web\"\n",
+		"common.cafe":       "stage = \"prod\"\n",
+	}
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "services"), 0o755))
+	for name, src := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644))
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"mv", "-dir", dir, filepath.Join(dir, "services/web.cafe"), filepath.Join(dir, "web.cafe")}, &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.NoFileExists(t, filepath.Join(dir, "services/web.cafe"))
+
+	// The include directives of the other files and of the moved one still
+	// point to the same files, and the ones in comments are left alone
+	for name, expected := range map[string]string{
+		"main.cafe":   "/* include \"services/web.cafe\" */\ninclude \"web.cafe\"\nport = 80\n",
+		"web.cafe":    "include \"common.cafe\" // shared\nname = \"web\"\n",
+		"common.cafe": "stage = \"prod\"\n",
+	} {
+		src, err := os.ReadFile(filepath.Join(dir, name))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(src), name)
+	}
+	p, err := cafe.Decode(filepath.Join(dir, "main.cafe"))
+	assert.NoError(t, err)
+	assert.Equal(t, "prod", p.Attributes["stage"].Value)
+}
+
+func TestGen(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"gen", "-package", "docs", "../../test_data/test-docs.cafe"}, &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Contains(t, stdout.String(), "package docs\n")
+	assert.Contains(t, stdout.String(), "Database ConfigDatabase `cafe:\"database\"`")
+
+	output := filepath.Join(t.TempDir(), "config.go")
+	stdout.Reset()
+	code = run([]string{"gen", "-o", output, "../../test_data/test-docs.cafe"}, &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Empty(t, stdout.String())
+	assert.FileExists(t, output)
+
+	assert.Equal(t, 2, run([]string{"gen"}, &stdout, &stderr))
+}
+
+func TestLint(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "lint.cafe")
+	assert.NoError(t, os.WriteFile(filename, []byte("let unused = 1\nport = \"8080\"\n"), 0o600))
+	assert.Equal(t, 1, run([]string{"lint", filename}, &stdout, &stderr))
+	assert.Equal(t, filename+":1: local variable unused is never referenced\n"+
+		filename+":2: attribute port is never referenced\n"+
+		filename+`:2: attribute port is a string that looks like a number: "8080"`+"\n", stdout.String())
+	assert.Empty(t, stderr.String())
+
+	// Empty documents have nothing to report
+	filename = filepath.Join(dir, "empty.cafe")
+	assert.NoError(t, os.WriteFile(filename, []byte("// nothing here\n"), 0o600))
+	stdout.Reset()
+	assert.Equal(t, 0, run([]string{"lint", filename}, &stdout, &stderr), stderr.String())
+	assert.Empty(t, stdout.String())
+
+	assert.Equal(t, 2, run([]string{"lint"}, &stdout, &stderr))
+}
+
+func TestSchema(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"schema", "../../test_data/test-docs.cafe"}, &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Contains(t, stdout.String(), `"description": "Database connection"`)
+
+	assert.Equal(t, 1, run([]string{"schema", "../../test_data/missing.cafe"}, &stdout, &stderr))
+}
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	before, after := filepath.Join(dir, "before.cafe"), filepath.Join(dir, "after.cafe")
+	assert.NoError(t, os.WriteFile(before, []byte("name = \"cafe\"\nport = 8080\nserver {\n    host = \"localhost\"\n}\n"), 0o600))
+	assert.NoError(t, os.WriteFile(after, []byte("port = 9090\nserver {\n    host = \"localhost\"\n    debug = true\n}\n"), 0o600))
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 1, run([]string{"diff", before, after}, &stdout, &stderr), stderr.String())
+	assert.Equal(t, `- name = "cafe"
+~ port = 8080 -> 9090
++ server.debug = true
+`, stdout.String())
+
+	stdout.Reset()
+	assert.Equal(t, 0, run([]string{"diff", before, before}, &stdout, &stderr))
+	assert.Empty(t, stdout.String())
+
+	// Adding an empty block makes the files differ
+	empty := filepath.Join(dir, "empty.cafe")
+	assert.NoError(t, os.WriteFile(empty, []byte("name = \"cafe\"\nport = 8080\nserver {\n    host = \"localhost\"\n}\nc {\n}\n"), 0o600))
+	assert.Equal(t, 1, run([]string{"diff", before, empty}, &stdout, &stderr), stderr.String())
+	assert.Equal(t, "+ c {}\n", stdout.String())
+	stdout.Reset()
+
+	assert.Equal(t, 2, run([]string{"diff", "../../test_data/missing.cafe", before}, &stdout, &stderr))
+	assert.Equal(t, 2, run([]string{"diff"}, &stdout, &stderr))
+}
+
+func TestMv(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.cafe":        "// file(\"certs/server.pem\") is the certificate\ncert = file(\"certs/server.pem\") // tls\n",
+		"app/app.cafe":     "key = file( \"../certs/server.pem\" )\nscript = templatefile(\"init.sh\")\n",
+		"app/init.sh":      "echo init\n",
+		"certs/server.pem": "PEM\n",
+	}
+	for _, name := range []string{"app", "certs", "tls"} {
+		assert.NoError(t, os.Mkdir(filepath.Join(dir, name), 0o755))
+	}
+	for name, src := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644))
+	}
+	mv := func(from string, to string) (int, string) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"mv", "-dir", dir, filepath.Join(dir, from), filepath.Join(dir, to)}, &stdout, &stderr)
+		return code, stderr.String()
+	}
+	assertFiles := func(expected map[string]string) {
+		for name, src := range expected {
+			written, err := os.ReadFile(filepath.Join(dir, name))
+			assert.NoError(t, err)
+			assert.Equal(t, src, string(written), name)
+		}
+	}
+
+	// The references to a moved file point to its new path
+	code, stderr := mv("certs/server.pem", "tls/server.pem")
+	assert.Equal(t, 0, code, stderr)
+	assert.NoFileExists(t, filepath.Join(dir, "certs/server.pem"))
+	assertFiles(map[string]string{
+		"main.cafe":    "// file(\"certs/server.pem\") is the certificate\ncert = file(\"tls/server.pem\") // tls\n",
+		"app/app.cafe": "key = file( \"../tls/server.pem\" )\nscript = templatefile(\"init.sh\")\n",
+	})
+
+	// The relative references of a moved CAFE file still point to the same
+	// files
+	code, stderr = mv("app/app.cafe", "app.cafe")
+	assert.Equal(t, 0, code, stderr)
+	assertFiles(map[string]string{
+		"app.cafe": "key = file( \"tls/server.pem\" )\nscript = templatefile(\"app/init.sh\")\n",
+	})
+	p, err := cafe.Decode(filepath.Join(dir, "app.cafe"))
+	assert.NoError(t, err)
+	assert.Equal(t, "echo init\n", p.Attributes["script"].Value)
+
+	// A move that fails leaves every file as it was
+	code, stderr = mv("tls/server.pem", "missing/server.pem")
+	assert.Equal(t, 1, code)
+	assert.NotEmpty(t, stderr)
+	assert.FileExists(t, filepath.Join(dir, "tls/server.pem"))
+	assertFiles(map[string]string{"main.cafe": "// file(\"certs/server.pem\") is the certificate\ncert = file(\"tls/server.pem\") // tls\n"})
+	for _, pattern := range []string{".cafe-mv-*", "*/.cafe-mv-*"} {
+		temps, err := filepath.Glob(filepath.Join(dir, pattern))
+		assert.NoError(t, err)
+		assert.Empty(t, temps)
+	}
+
+	// Files aren't moved over others
+	code, stderr = mv("app.cafe", "main.cafe")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "already exists")
+
+	var stdout, stderrBuf bytes.Buffer
+	assert.Equal(t, 2, run([]string{"mv", "app.cafe"}, &stdout, &stderrBuf))
+}
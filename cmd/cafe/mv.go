@@ -0,0 +1,372 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cafe mv [-dir workspace] old new
+func runMv(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("mv", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	dir := flags.String("dir", ".", "directory whose CAFE files have their references rewritten")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: cafe mv [-dir <directory>] <old file> <new file>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	if err := moveFile(*dir, flags.Arg(0), flags.Arg(1), stdout); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// A CAFE file whose references were rewritten
+type rewrittenFile struct {
+	// Where the file is read from and written to, which differ for the
+	// moved file
+	from string
+	to   string
+
+	// Contents before and after rewriting the references
+	original  []byte
+	rewritten []byte
+
+	// Permissions of the file
+	mode fs.FileMode
+
+	// Temporary file holding the rewritten contents until it replaces the
+	// file
+	temp string
+}
+
+// Moves a file and rewrites the references of the CAFE files of a directory,
+// and of the moved file itself, so they point to the same files
+// Rewritten files are written to temporary files before anything is moved,
+// and the move is undone if a file can't be replaced, so a failure never
+// leaves references pointing to the wrong files
+func moveFile(dir string, oldPath string, newPath string, stdout io.Writer) error {
+	oldAbs, err := filepath.Abs(oldPath)
+	if err != nil {
+		return err
+	}
+	newAbs, err := filepath.Abs(newPath)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(oldAbs); err != nil {
+		return err
+	}
+	if _, err := os.Stat(newAbs); err == nil {
+		return fmt.Errorf("%s already exists", newPath)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	files, err := cafeFiles(dir)
+	if err != nil {
+		return err
+	}
+	if filepath.Ext(oldAbs) == ".cafe" && !contains(files, oldAbs) {
+		files = append(files, oldAbs)
+	}
+
+	var rewritten []*rewrittenFile
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		target := file
+		if file == oldAbs {
+			target = newAbs
+		}
+		out, err := rewriteReferences(src, func(path string) string {
+			return referencePath(file, target, path, oldAbs, newAbs)
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		if string(out) != string(src) {
+			rewritten = append(rewritten, &rewrittenFile{from: file, to: target, original: src, rewritten: out, mode: info.Mode().Perm()})
+		}
+	}
+
+	if err := writeTemps(rewritten); err != nil {
+		removeTemps(rewritten)
+		return err
+	}
+	if err := os.Rename(oldAbs, newAbs); err != nil {
+		removeTemps(rewritten)
+		return err
+	}
+	for i, file := range rewritten {
+		if err := os.Rename(file.temp, file.to); err != nil {
+			removeTemps(rewritten[i:])
+			return undoMove(rewritten[:i], oldAbs, newAbs, err)
+		}
+	}
+	for _, file := range rewritten {
+		fmt.Fprintln(stdout, file.to)
+	}
+	return nil
+}
+
+// Writes the rewritten files to temporary files next to them
+// The moved file is written next to its new path
+func writeTemps(files []*rewrittenFile) error {
+	for _, file := range files {
+		dir := filepath.Dir(file.to)
+		temp, err := os.CreateTemp(dir, ".cafe-mv-*")
+		if err != nil {
+			return err
+		}
+		file.temp = temp.Name()
+		_, err = temp.Write(file.rewritten)
+		if closeErr := temp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Chmod(file.temp, file.mode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Removes the temporary files that weren't moved over their files
+func removeTemps(files []*rewrittenFile) {
+	for _, file := range files {
+		if file.temp != "" {
+			os.Remove(file.temp)
+		}
+	}
+}
+
+// Writes back the files already replaced and moves the file back
+// Returns the error that stopped the move, along with the ones of undoing it
+func undoMove(replaced []*rewrittenFile, oldAbs string, newAbs string, cause error) error {
+	errs := []error{cause}
+	for _, file := range replaced {
+		if err := os.WriteFile(file.to, file.original, file.mode); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := os.Rename(newAbs, oldAbs); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Returns the path a reference of a file needs once the file is written at
+// target and the file at oldAbs is moved to newAbs
+// Paths that still point to the same file are kept as they are
+func referencePath(file string, target string, reference string, oldAbs string, newAbs string) string {
+	referenced := reference
+	if !filepath.IsAbs(referenced) {
+		referenced = filepath.Join(filepath.Dir(file), referenced)
+	}
+	referenced = filepath.Clean(referenced)
+
+	moved := referenced == oldAbs
+	if moved {
+		referenced = newAbs
+	}
+	if !moved && filepath.Dir(file) == filepath.Dir(target) {
+		return reference
+	}
+	if filepath.IsAbs(reference) {
+		return referenced
+	}
+	relative, err := filepath.Rel(filepath.Dir(target), referenced)
+	if err != nil {
+		return referenced
+	}
+	return filepath.ToSlash(relative)
+}
+
+// Functions whose first parameter is the path of a file
+var fileFunctions = []string{"file", "templatefile"}
+
+// Returns a source with the path of each include directive, file() call
+// and templatefile() call replaced by the one fn returns for it, keeping
+// the rest of its bytes as they are
+// Calls and directives in comments and strings aren't references, and are
+// left alone
+func rewriteReferences(src []byte, fn func(path string) string) ([]byte, error) {
+	out := make([]byte, 0, len(src))
+	for i := 0; i < len(src); {
+		switch {
+		case src[i] == '/' && i+1 < len(src) && src[i+1] == '/':
+			end := indexFrom(src, '\n', i)
+			out, i = append(out, src[i:end]...), end
+		case src[i] == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := len(src)
+			if closing := strings.Index(string(src[i+2:]), "*/"); closing >= 0 {
+				end = i + 2 + closing + 2
+			}
+			out, i = append(out, src[i:end]...), end
+		case src[i] == '"':
+			end := stringEnd(src, i)
+			out, i = append(out, src[i:end]...), end
+		case isNameByte(src[i]) && (i == 0 || !isNameByte(src[i-1])):
+			start := i
+			for i < len(src) && isNameByte(src[i]) {
+				i++
+			}
+			out = append(out, src[start:i]...)
+
+			// The path is the string opening the parameters of a call, or
+			// the one after the keyword of a directive
+			j := skipSpaces(src, i)
+			switch name := string(src[start:i]); {
+			case contains(fileFunctions, name):
+				if j == len(src) || src[j] != '(' {
+					continue
+				}
+				j = skipSpaces(src, j+1)
+			case name == "include" && atLineStart(src, start):
+				if j == i {
+					continue
+				}
+			default:
+				continue
+			}
+			if j == len(src) || src[j] != '"' {
+				continue
+			}
+			end := stringEnd(src, j)
+			if src[end-1] != '"' || end-j < 2 {
+				continue
+			}
+			// Paths with escapes are left alone, as fn takes them unescaped
+			path := string(src[j+1 : end-1])
+			if strings.Contains(path, "\\") {
+				continue
+			}
+			rewritten := fn(path)
+			if strings.ContainsAny(rewritten, "\"\\\n") {
+				return nil, fmt.Errorf("path %q can't be written in a string", rewritten)
+			}
+			out = append(out, src[i:j+1]...)
+			out = append(out, rewritten...)
+			out, i = append(out, '"'), end
+		default:
+			out, i = append(out, src[i]), i+1
+		}
+	}
+	return out, nil
+}
+
+// Returns the index after the string starting at start, or the end of its
+// line if it isn't closed
+func stringEnd(src []byte, start int) int {
+	for i := start + 1; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		case '\n':
+			return i
+		}
+	}
+	return len(src)
+}
+
+// Reports whether only spaces and tabs come before an index in its line
+func atLineStart(src []byte, index int) bool {
+	for i := index - 1; i >= 0 && src[i] != '\n'; i-- {
+		if src[i] != ' ' && src[i] != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the index of the first c from start, or the length of src
+func indexFrom(src []byte, c byte, start int) int {
+	for i := start; i < len(src); i++ {
+		if src[i] == c {
+			return i
+		}
+	}
+	return len(src)
+}
+
+// Returns the index of the first byte from start that isn't a space or a tab
+func skipSpaces(src []byte, start int) int {
+	for start < len(src) && (src[start] == ' ' || src[start] == '\t') {
+		start++
+	}
+	return start
+}
+
+// Reports whether a byte can be part of a name
+// Bytes of multi-byte characters are, so names in any language are read whole
+func isNameByte(c byte) bool {
+	return c == '_' || c >= 0x80 || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// Returns the absolute paths of the CAFE files under a directory
+func cafeFiles(dir string) ([]string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	err = filepath.WalkDir(abs, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && filepath.Ext(path) == ".cafe" {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// Reports whether a slice holds a string
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
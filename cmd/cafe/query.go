@@ -0,0 +1,117 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ldatb/cafe"
+)
+
+// cafe query 'select name, port from block server where port > 1000' files...
+func runQuery(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("query", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: cafe query 'select <attributes> from block <name> [where <conditions>]' <files...>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return 2
+	}
+
+	q, err := cafe.ParseTableQuery(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	files := flags.Args()[1:]
+	results := make([]*cafe.QueryResult, len(files))
+	for i, filename := range files {
+		p, err := cafe.SafeDecode(filename)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", filename, err)
+			return 1
+		}
+
+		results[i], err = q.Run(p)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+
+	columns := queryColumns(results)
+	out := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(out, "BLOCK\t%s\n", strings.ToUpper(strings.Join(columns, "\t")))
+	for i, result := range results {
+		// Columns of this file in the header
+		index := map[string]int{}
+		for j, column := range result.Columns {
+			index[column] = j
+		}
+
+		for _, row := range result.Rows {
+			path := row.Path
+			if len(files) > 1 {
+				path = files[i] + ":" + path
+			}
+
+			values := make([]string, len(columns))
+			for j, column := range columns {
+				k, found := index[column]
+				if found && row.Values[k] != nil {
+					values[j] = fmt.Sprint(row.Values[k])
+				}
+			}
+			fmt.Fprintf(out, "%s\t%s\n", path, strings.Join(values, "\t"))
+		}
+	}
+	out.Flush()
+	return 0
+}
+
+// Returns the columns of every result, so that files selected with
+// "select *" show all of their attributes under one header
+func queryColumns(results []*cafe.QueryResult) []string {
+	var columns []string
+	seen := map[string]bool{}
+	merged := false
+	for i, result := range results {
+		for _, column := range result.Columns {
+			if !seen[column] {
+				seen[column] = true
+				columns = append(columns, column)
+				merged = merged || i > 0
+			}
+		}
+	}
+
+	// Only "select *" gives different columns, and those are sorted
+	if merged {
+		sort.Strings(columns)
+	}
+	return columns
+}
@@ -0,0 +1,143 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+	"unicode"
+)
+
+// GenerateOptions configures the code written by GenerateGo
+type GenerateOptions struct {
+	// Package of the generated file, "config" by default
+	Package string
+
+	// Name of the struct of the whole document, "Config" by default
+	Type string
+}
+
+// Go initialisms written in upper case in the generated field names
+var goInitialisms = map[string]bool{
+	"api": true, "cpu": true, "dns": true, "http": true, "https": true,
+	"id": true, "ip": true, "json": true, "tcp": true, "tls": true,
+	"ttl": true, "udp": true, "uri": true, "url": true, "uuid": true,
+}
+
+// GenerateGo infers Go structs from an example document and writes them in
+// a formatted Go file, with a `cafe` tag holding the name of each attribute
+// and block
+// Blocks become nested structs named after their path, like ConfigServerTLS
+// for the block server.tls, and arrays holding values of different types
+// become []interface{}
+func GenerateGo(p *Parser, opts GenerateOptions) ([]byte, error) {
+	if opts.Package == "" {
+		opts.Package = "config"
+	}
+	if opts.Type == "" {
+		opts.Type = "Config"
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by cafe gen. DO NOT EDIT.\n\npackage %s\n", opts.Package)
+	generateStruct(&src, opts.Type, p.Attributes, p.Blocks)
+
+	out, err := format.Source(src.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generate: %w", err)
+	}
+	return out, nil
+}
+
+// Writes the struct of a body, followed by the structs of its blocks
+func generateStruct(src *bytes.Buffer, typeName string, attributes map[string]Attribute, blocks map[string]Block) {
+	fmt.Fprintf(src, "\ntype %s struct {\n", typeName)
+	for _, name := range sortedKeys(attributes) {
+		fmt.Fprintf(src, "%s %s `cafe:%q`\n", goFieldName(name), goType(attributes[name].Value), name)
+	}
+	for _, name := range sortedKeys(blocks) {
+		fmt.Fprintf(src, "%s %s `cafe:%q`\n", goFieldName(name), typeName+goFieldName(name), name)
+	}
+	src.WriteString("}\n")
+
+	for _, name := range sortedKeys(blocks) {
+		b := blocks[name]
+		generateStruct(src, typeName+goFieldName(name), b.Attributes, b.Blocks)
+	}
+}
+
+// Returns the Go type of a value
+func goType(value interface{}) string {
+	switch val := value.(type) {
+	case string:
+		return "string"
+	case int:
+		return "int"
+	case float64:
+		return "float64"
+	case bool:
+		return "bool"
+	case []interface{}:
+		return "[]" + goElemType(val)
+	}
+	return "interface{}"
+}
+
+// Returns the Go type of the elements of an array
+// Arrays of ints and floats are arrays of float64
+func goElemType(array []interface{}) string {
+	elemType := ""
+	for _, elem := range array {
+		t := goType(elem)
+		switch {
+		case elemType == "" || elemType == t:
+			elemType = t
+		case (elemType == "int" && t == "float64") || (elemType == "float64" && t == "int"):
+			elemType = "float64"
+		default:
+			return "interface{}"
+		}
+	}
+	if elemType == "" {
+		return "interface{}"
+	}
+	return elemType
+}
+
+// Converts a CAFE name, like max_conns or tls-config, to an exported Go name
+func goFieldName(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var sb strings.Builder
+	for _, word := range words {
+		if goInitialisms[strings.ToLower(word)] {
+			sb.WriteString(strings.ToUpper(word))
+			continue
+		}
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		sb.WriteString(string(runes))
+	}
+
+	field := sb.String()
+	if field == "" || !unicode.IsLetter([]rune(field)[0]) {
+		field = "X" + field
+	}
+	return field
+}
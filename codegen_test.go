@@ -0,0 +1,58 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateGo(t *testing.T) {
+	p, err := DecodeBytes([]byte(`name = "cafe"
+max_conns = 100
+ratio = 0.5
+ports = [80, 443]
+weights = [1, 0.5]
+tags = ["web", 1]
+server {
+    api_url = "http://localhost"
+    tls {
+        enabled = true
+    }
+}
+`))
+	assert.NoError(t, err)
+
+	out, err := GenerateGo(p, GenerateOptions{Package: "settings"})
+	assert.NoError(t, err)
+	assert.Equal(t, "// Code generated by cafe gen. DO NOT EDIT.\n\npackage settings\n\n"+
+		"type Config struct {\n"+
+		"\tMaxConns int           `cafe:\"max_conns\"`\n"+
+		"\tName     string        `cafe:\"name\"`\n"+
+		"\tPorts    []int         `cafe:\"ports\"`\n"+
+		"\tRatio    float64       `cafe:\"ratio\"`\n"+
+		"\tTags     []interface{} `cafe:\"tags\"`\n"+
+		"\tWeights  []float64     `cafe:\"weights\"`\n"+
+		"\tServer   ConfigServer  `cafe:\"server\"`\n"+
+		"}\n\n"+
+		"type ConfigServer struct {\n"+
+		"\tAPIURL string          `cafe:\"api_url\"`\n"+
+		"\tTLS    ConfigServerTLS `cafe:\"tls\"`\n"+
+		"}\n\n"+
+		"type ConfigServerTLS struct {\n"+
+		"\tEnabled bool `cafe:\"enabled\"`\n"+
+		"}\n", string(out))
+}
@@ -0,0 +1,315 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// CompletionKind is the kind of a completion suggestion
+type CompletionKind int
+
+const (
+	CompletionAttribute CompletionKind = iota // An attribute in scope
+	CompletionVariable                        // A local variable in scope
+	CompletionBlock                           // A block in scope
+	CompletionFunction                        // A builtin or registered function
+	CompletionKeyword                         // A keyword
+)
+
+func (k CompletionKind) String() string {
+	switch k {
+	case CompletionAttribute:
+		return "attribute"
+	case CompletionVariable:
+		return "variable"
+	case CompletionBlock:
+		return "block"
+	case CompletionFunction:
+		return "function"
+	case CompletionKeyword:
+		return "keyword"
+	default:
+		return "unknown"
+	}
+}
+
+// Completion is a suggestion for the word being typed
+type Completion struct {
+	// Text of the suggestion, which replaces the last name of the word
+	// being typed, as in "port" for "server.po"
+	Label string
+
+	// Kind of the suggestion
+	Kind CompletionKind
+
+	// Path of the attribute or block, empty for functions and keywords
+	Detail string
+}
+
+// Keywords suggested where a value is expected
+var valueKeywords = []string{"true", "false", "null", "if", "for"}
+
+// Keywords suggested where a definition is expected
+var definitionKeywords = []string{"let", "vars", "include", "template", "use"}
+
+// A definition found in the tokens of a document
+type outlineEntry struct {
+	// Name of the attribute, local variable or block
+	name string
+
+	// Kind of the definition
+	kind CompletionKind
+
+	// Path of the block defining it, as in "server.tls"
+	scope string
+
+	// Where it's defined
+	rng Range
+}
+
+// Collects the definitions of a document from its tokens, so they're found
+// even if the document can't be decoded
+// Tokens for which skip returns true aren't definitions
+func outlineTokens(tokens []Token, skip func(Token) bool) []outlineEntry {
+	entries := []outlineEntry{}
+	scopes := []string{}
+	transparent := []bool{}
+	inVars := false
+	current := func() string {
+		return strings.Join(scopes, ".")
+	}
+
+	for _, token := range tokens {
+		if skip(token) {
+			continue
+		}
+		switch token.Kind {
+		case TokenAttribute:
+			key, _ := splitAssignment(token.Value)
+			entry := outlineEntry{name: unquoteKey(key), kind: CompletionAttribute, scope: current(), rng: token.Range}
+			if name := strings.TrimPrefix(key, "let "); name != key || inVars {
+				entry.name, entry.kind = strings.TrimSpace(name), CompletionVariable
+				entry.rng.Start.Column = entry.rng.End.Column - len([]rune(entry.name))
+				entries = append(entries, entry)
+				continue
+			}
+
+			// Dotted keys define the blocks they name too
+			names := splitPath(key)
+			for i, name := range names[:len(names)-1] {
+				entries = append(entries, outlineEntry{name: name, kind: CompletionBlock, scope: strings.Join(append(append([]string{}, scopes...), names[:i]...), "."), rng: token.Range})
+			}
+			entry.name = names[len(names)-1]
+			entry.scope = strings.Join(append(append([]string{}, scopes...), names[:len(names)-1]...), ".")
+			entries = append(entries, entry)
+		case TokenBlockStart:
+			// Profiles define the blocks of the top level, and vars blocks
+			// the local variables of their own block
+			if token.Value == "vars" {
+				inVars = true
+				transparent = append(transparent, true)
+				continue
+			}
+			if profileRegexp.MatchString(token.Value) || forBlockRegexp.MatchString(token.Value) {
+				transparent = append(transparent, true)
+				continue
+			}
+
+			// The attributes of templates are defined where they're used,
+			// the names of blocks named by expressions are only known once
+			// decoded, and blocks of arrays have none
+			if templateRegexp.MatchString(token.Value) || dynamicBlockRegexp.MatchString(token.Value) || token.Value == "{" {
+				scopes = append(scopes, token.Value)
+				transparent = append(transparent, false)
+				continue
+			}
+			entries = append(entries, outlineEntry{name: token.Value, kind: CompletionBlock, scope: current(), rng: token.Range})
+			scopes = append(scopes, token.Value)
+			transparent = append(transparent, false)
+		case TokenBlockEnd:
+			if len(transparent) == 0 {
+				continue
+			}
+			if !transparent[len(transparent)-1] {
+				scopes = scopes[:len(scopes)-1]
+			}
+			transparent = transparent[:len(transparent)-1]
+			inVars = false
+		}
+	}
+	return entries
+}
+
+// Returns the path of the block enclosing a position, from the tokens
+// before it
+func scopeAt(tokens []Token, pos Position) string {
+	scopes := []string{}
+	transparent := []bool{}
+	for _, token := range tokens {
+		if !positionBefore(token.Range.Start, pos) {
+			break
+		}
+		switch token.Kind {
+		case TokenBlockStart:
+			isTransparent := token.Value == "vars" || profileRegexp.MatchString(token.Value) || forBlockRegexp.MatchString(token.Value)
+			if !isTransparent {
+				scopes = append(scopes, token.Value)
+			}
+			transparent = append(transparent, isTransparent)
+		case TokenBlockEnd:
+			if len(transparent) == 0 {
+				continue
+			}
+			if !transparent[len(transparent)-1] {
+				scopes = scopes[:len(scopes)-1]
+			}
+			transparent = transparent[:len(transparent)-1]
+		}
+	}
+	return strings.Join(scopes, ".")
+}
+
+// Returns the path of the block enclosing another, empty for the blocks of
+// the global scope
+func parentScope(scope string) string {
+	if dot := strings.LastIndex(scope, "."); dot >= 0 {
+		return scope[:dot]
+	}
+	return ""
+}
+
+// Reports if a position comes before another
+func positionBefore(a Position, b Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+}
+
+// Reports if a position is inside a range, or right at its end
+func rangeContains(r Range, pos Position) bool {
+	return !positionBefore(pos, r.Start) && !positionBefore(r.End, pos)
+}
+
+// Returns the position of a byte offset of a document
+func offsetPosition(src []byte, offset int) Position {
+	before := src[:offset]
+	line := 1 + strings.Count(string(before), "\n")
+	lineStart := strings.LastIndex(string(before), "\n") + 1
+	return Position{Line: line, Column: utf8.RuneCount(before[lineStart:]) + 1}
+}
+
+// Complete suggests how to complete the word before a byte offset of a
+// document, such as the cursor of an editor: the attributes, local
+// variables and blocks in scope, the functions and the keywords
+// A word with dots, like "server.po", is completed with the attributes and
+// blocks of the block it names
+// The document doesn't need to be valid, its definitions are found by Lex
+func Complete(src []byte, offset int) []Completion {
+	if offset < 0 || offset > len(src) {
+		return []Completion{}
+	}
+
+	// Word being typed
+	start := offset
+	for start > 0 {
+		r, size := utf8.DecodeLastRune(src[:start])
+		if r != '_' && r != '.' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		start -= size
+	}
+	word := string(src[start:offset])
+	lineStart := strings.LastIndex(string(src[:start]), "\n") + 1
+	isDefinition := strings.TrimSpace(string(src[lineStart:start])) == ""
+
+	cursor := offsetPosition(src, offset)
+	tokens, err := Lex(src)
+	if err != nil {
+		// The tokens before the cursor are enough
+		tokens, _ = Lex(src[:start])
+	}
+
+	// The word being typed isn't a definition yet
+	entries := outlineTokens(tokens, func(token Token) bool {
+		return rangeContains(token.Range, cursor)
+	})
+
+	completions := []Completion{}
+	seen := map[string]bool{}
+	suggest := func(label string, kind CompletionKind, detail string, prefix string) {
+		if !strings.HasPrefix(label, prefix) || seen[label] {
+			return
+		}
+		seen[label] = true
+		completions = append(completions, Completion{Label: label, Kind: kind, Detail: detail})
+	}
+
+	// Dotted names are searched from the global scope
+	if dot := strings.LastIndex(word, "."); dot >= 0 {
+		scope, prefix := word[:dot], word[dot+1:]
+		for _, entry := range entries {
+			if entry.scope == scope && entry.kind != CompletionVariable {
+				suggest(entry.name, entry.kind, childPath(strings.Split(scope, "."), entry.name).String(), prefix)
+			}
+		}
+		sortCompletions(completions)
+		return completions
+	}
+
+	// Names are searched from the innermost block up to the global scope
+	scope := scopeAt(tokens, cursor)
+	for {
+		for _, entry := range entries {
+			if entry.scope == scope {
+				path := entry.name
+				if scope != "" {
+					path = scope + "." + entry.name
+				}
+				suggest(entry.name, entry.kind, path, word)
+			}
+		}
+		if scope == "" {
+			break
+		}
+		scope = parentScope(scope)
+	}
+
+	if isDefinition {
+		for _, keyword := range definitionKeywords {
+			suggest(keyword, CompletionKeyword, "", word)
+		}
+	} else {
+		for _, name := range functionNames() {
+			suggest(name, CompletionFunction, "", word)
+		}
+		for _, keyword := range valueKeywords {
+			suggest(keyword, CompletionKeyword, "", word)
+		}
+	}
+	sortCompletions(completions)
+	return completions
+}
+
+// Sorts completions by kind, then by label
+func sortCompletions(completions []Completion) {
+	sort.Slice(completions, func(i, j int) bool {
+		if completions[i].Kind != completions[j].Kind {
+			return completions[i].Kind < completions[j].Kind
+		}
+		return completions[i].Label < completions[j].Label
+	})
+}
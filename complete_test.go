@@ -0,0 +1,80 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComplete(t *testing.T) {
+	src := `name = "cafe"
+let prefix = "v"
+server {
+    port = 80
+    tls {
+        enabled = true
+    }
+    url = po
+}
+other = server.
+x = up
+    
+`
+	// Returns the offset right after the first occurrence of text
+	after := func(text string) int {
+		return strings.Index(src, text) + len(text)
+	}
+
+	// Names in scope
+	assert.Equal(t, []Completion{
+		{Label: "port", Kind: CompletionAttribute, Detail: "server.port"},
+		{Label: "power", Kind: CompletionFunction},
+	}, Complete([]byte(src), after("url = po")))
+
+	// Names of a block
+	assert.Equal(t, []Completion{
+		{Label: "port", Kind: CompletionAttribute, Detail: "server.port"},
+		{Label: "url", Kind: CompletionAttribute, Detail: "server.url"},
+		{Label: "tls", Kind: CompletionBlock, Detail: "server.tls"},
+	}, Complete([]byte(src), after("server.")))
+
+	// Functions
+	assert.Equal(t, []Completion{{Label: "upper", Kind: CompletionFunction}}, Complete([]byte(src), after("x = up")))
+
+	// Definitions
+	assert.Equal(t, []Completion{
+		{Label: "name", Kind: CompletionAttribute, Detail: "name"},
+		{Label: "other", Kind: CompletionAttribute, Detail: "other"},
+		{Label: "x", Kind: CompletionAttribute, Detail: "x"},
+		{Label: "prefix", Kind: CompletionVariable, Detail: "prefix"},
+		{Label: "server", Kind: CompletionBlock, Detail: "server"},
+		{Label: "include", Kind: CompletionKeyword},
+		{Label: "let", Kind: CompletionKeyword},
+		{Label: "template", Kind: CompletionKeyword},
+		{Label: "use", Kind: CompletionKeyword},
+		{Label: "vars", Kind: CompletionKeyword},
+	}, Complete([]byte(src), after("x = up\n    ")))
+
+	assert.Empty(t, Complete([]byte(src), len(src)+1))
+
+	// Blocks of dotted keys
+	src = "database.primary.host = \"x\"\nref = database.pr"
+	assert.Equal(t, []Completion{
+		{Label: "primary", Kind: CompletionBlock, Detail: "database.primary"},
+	}, Complete([]byte(src), len(src)))
+}
@@ -0,0 +1,103 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ConversionWarning reports CAFE information an attribute or a block loses
+// when exported to a format with weaker typing, such as JSON
+type ConversionWarning struct {
+	// Path of the attribute or block
+	Path Path
+
+	// What is lost: "expression", "reference", "doc comment",
+	// "expiring value" or "float"
+	Construct string
+
+	// Human readable description of the loss
+	Detail string
+}
+
+func (w ConversionWarning) String() string {
+	return fmt.Sprintf("%s: %s", w.Path, w.Detail)
+}
+
+// ConversionWarnings lists what exporting the document loses: expressions
+// and references are flattened to their values, doc comments and TTLs are
+// dropped and floats with integral values become indistinguishable from
+// ints. Warnings are sorted by path
+func (p *Parser) ConversionWarnings() []ConversionWarning {
+	warnings := []ConversionWarning{}
+	p.collectConversionWarnings(p.Attributes, p.Blocks, nil, &warnings)
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return warnings[i].Path.String() < warnings[j].Path.String()
+	})
+	return warnings
+}
+
+// Collects the conversion warnings of the attributes and blocks of a body
+func (p *Parser) collectConversionWarnings(attributes map[string]Attribute, blocks map[string]Block, parents Path, warnings *[]ConversionWarning) {
+	for _, name := range sortedKeys(attributes) {
+		attr := attributes[name]
+		path := childPath(parents, name)
+		warn := func(construct string, format string, args ...interface{}) {
+			*warnings = append(*warnings, ConversionWarning{Path: path, Construct: construct, Detail: fmt.Sprintf(format, args...)})
+		}
+
+		switch {
+		case attr.kind == attrReference && attr.expr != "":
+			warn("reference", "reference to %s flattened to its value", attr.expr)
+		case attr.expr != "":
+			warn("expression", "expression %s flattened to its value", attr.expr)
+		}
+		if attr.Doc != "" {
+			warn("doc comment", "doc comment dropped")
+		}
+		if _, found := p.expiries[path.String()]; found {
+			warn("expiring value", "TTL of the value dropped")
+		}
+		if hasIntegralFloat(attr.Value) {
+			warn("float", "float with an integral value can be read back as an int")
+		}
+	}
+
+	for _, name := range sortedKeys(blocks) {
+		b := blocks[name]
+		path := childPath(parents, name)
+		if b.Doc != "" {
+			*warnings = append(*warnings, ConversionWarning{Path: path, Construct: "doc comment", Detail: "doc comment dropped"})
+		}
+		p.collectConversionWarnings(b.Attributes, b.Blocks, path, warnings)
+	}
+}
+
+// Reports whether a value is, or holds, a float with an integral value
+func hasIntegralFloat(value interface{}) bool {
+	switch val := value.(type) {
+	case float64:
+		return val == math.Trunc(val) && !math.IsInf(val, 0)
+	case []interface{}:
+		for _, elem := range val {
+			if hasIntegralFloat(elem) {
+				return true
+			}
+		}
+	}
+	return false
+}
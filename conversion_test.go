@@ -0,0 +1,47 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConversionWarnings(t *testing.T) {
+	p, err := DecodeBytes([]byte(`/// Name of the application
+name = "cafe"
+ratio = 2.0
+server {
+    label = upper(name)
+    alias = name
+    weights = [1, 0.5]
+    port = 8080
+}
+`))
+	assert.NoError(t, err)
+
+	assert.Equal(t, []ConversionWarning{
+		{Path: Path{"name"}, Construct: "doc comment", Detail: "doc comment dropped"},
+		{Path: Path{"ratio"}, Construct: "float", Detail: "float with an integral value can be read back as an int"},
+		{Path: Path{"server", "alias"}, Construct: "reference", Detail: "reference to name flattened to its value"},
+		{Path: Path{"server", "label"}, Construct: "expression", Detail: "expression upper(name) flattened to its value"},
+	}, p.ConversionWarnings())
+	assert.Equal(t, "server.label: expression upper(name) flattened to its value", p.ConversionWarnings()[3].String())
+
+	p, err = DecodeBytes([]byte("port = 8080\n"))
+	assert.NoError(t, err)
+	assert.Empty(t, p.ConversionWarnings())
+}
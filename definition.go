@@ -0,0 +1,77 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"strings"
+)
+
+// FindDefinition returns where the attribute, local variable or block
+// referenced at a byte offset of a document is defined, such as the one
+// under the cursor of an editor
+// References are resolved like the decoder does, from the innermost block
+// up to the global scope, and dotted names from the global scope
+// The range is the one of the name of the definition, and the document
+// doesn't need to be valid
+func FindDefinition(src []byte, offset int) (Range, bool) {
+	if offset < 0 || offset > len(src) {
+		return Range{}, false
+	}
+	tokens, err := Lex(src)
+	if err != nil {
+		return Range{}, false
+	}
+
+	// Name under the cursor
+	cursor := offsetPosition(src, offset)
+	name := ""
+	for _, token := range highlightTokens(tokens) {
+		if token.Type == SemanticVariable && rangeContains(token.Range, cursor) {
+			line := []rune(strings.Split(string(src), "\n")[token.Range.Start.Line-1])
+			name = string(line[token.Range.Start.Column-1 : token.Range.End.Column-1])
+			break
+		}
+	}
+	if name == "" {
+		return Range{}, false
+	}
+
+	entries := outlineTokens(tokens, func(Token) bool { return false })
+	find := func(scope string, name string, locals bool) (Range, bool) {
+		for _, entry := range entries {
+			if entry.scope == scope && entry.name == name && (locals || entry.kind != CompletionVariable) {
+				return entry.rng, true
+			}
+		}
+		return Range{}, false
+	}
+
+	// Dotted names are searched from the global scope
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		return find(name[:dot], name[dot+1:], false)
+	}
+
+	// Names are searched from the innermost block up to the global scope
+	scope := scopeAt(tokens, cursor)
+	for {
+		if rng, found := find(scope, name, true); found {
+			return rng, true
+		}
+		if scope == "" {
+			return Range{}, false
+		}
+		scope = parentScope(scope)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindDefinition(t *testing.T) {
+	src := `name = "cafe"
+server {
+    let host = "localhost"
+    name = "web"
+    address = append(host, ":80")
+    label = upper(name)
+    tls {
+        enabled = true
+    }
+}
+global = upper(name)
+tls = server.tls.enabled
+missing = undefined
+`
+	// Returns the offset of the second character of the n-th occurrence
+	// of text, as if the cursor was on it
+	at := func(text string, n int) int {
+		offset := -1
+		for i := 0; i < n; i++ {
+			offset += strings.Index(src[offset+1:], text) + 1
+		}
+		return offset + 1
+	}
+
+	// Local variables
+	rng, found := FindDefinition([]byte(src), at("host,", 1))
+	assert.True(t, found)
+	assert.Equal(t, Range{Position{3, 9}, Position{3, 13}}, rng)
+
+	// The innermost definition
+	rng, found = FindDefinition([]byte(src), at("name", 3))
+	assert.True(t, found)
+	assert.Equal(t, Range{Position{4, 5}, Position{4, 9}}, rng)
+	rng, found = FindDefinition([]byte(src), at("name", 4))
+	assert.True(t, found)
+	assert.Equal(t, Range{Position{1, 1}, Position{1, 5}}, rng)
+
+	// Dotted names
+	rng, found = FindDefinition([]byte(src), at("server.tls.enabled", 1))
+	assert.True(t, found)
+	assert.Equal(t, Range{Position{8, 9}, Position{8, 16}}, rng)
+
+	_, found = FindDefinition([]byte(src), at("undefined", 1))
+	assert.False(t, found)
+	_, found = FindDefinition([]byte(src), at("upper", 1))
+	assert.False(t, found)
+	_, found = FindDefinition([]byte(src), at(`"cafe"`, 1))
+	assert.False(t, found)
+}
@@ -0,0 +1,159 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// ChangeKind is the kind of a difference between two documents
+type ChangeKind int
+
+const (
+	ChangeAdded    ChangeKind = iota // The attribute or block is only in the new document
+	ChangeRemoved                    // The attribute or block is only in the old document
+	ChangeModified                   // The attribute has a different value
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeModified:
+		return "modified"
+	default:
+		return "unknown"
+	}
+}
+
+// Change is an attribute or a block that differs between two documents
+type Change struct {
+	// Kind of the difference, which tells added and removed attributes
+	// from the ones whose value is or was null
+	Kind ChangeKind
+
+	// Path of the attribute or the block
+	Path Path
+
+	// Whether a block was added or removed, along with the attributes and
+	// blocks it holds
+	Block bool
+
+	// Value of the attribute in the old document, nil if added
+	Old Value
+
+	// Value of the attribute in the new document, nil if removed
+	New Value
+}
+
+// Returns the change in a line, as in `~ server.port = 8080 -> 9090`,
+// with "+" for added and "-" for removed attributes, and blocks written as
+// in `+ server.tls {}`
+func (c Change) String() string {
+	switch {
+	case c.Block && c.Kind == ChangeAdded:
+		return "+ " + c.Path.String() + " {}"
+	case c.Block:
+		return "- " + c.Path.String() + " {}"
+	}
+	switch c.Kind {
+	case ChangeAdded:
+		return "+ " + c.Path.String() + " = " + diffValue(c.New)
+	case ChangeRemoved:
+		return "- " + c.Path.String() + " = " + diffValue(c.Old)
+	default:
+		return "~ " + c.Path.String() + " = " + diffValue(c.Old) + " -> " + diffValue(c.New)
+	}
+}
+
+// Diff returns the attributes added, removed and modified from a document
+// to another, along with the blocks added and removed, sorted by path, for
+// reviewing changes or detecting drift
+// The attributes and blocks of an added or removed block are changes too,
+// and an attribute replaced by a block with its name, or the other way
+// around, is removed and the block added
+// Both values of an attribute holding a secret in either document are
+// redacted, see Parser.IsSecret
+func Diff(a *Parser, b *Parser) []Change {
+	changes := []Change{}
+	diffBodies(a.Attributes, a.Blocks, b.Attributes, b.Blocks, nil, &changes)
+	for i, change := range changes {
+		if path := change.Path.String(); a.secrets[path] || b.secrets[path] {
+			changes[i].Old, changes[i].New = redactValue(change.Old), redactValue(change.New)
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Path.String() < changes[j].Path.String()
+	})
+	return changes
+}
+
+// Collects the changes between two bodies
+func diffBodies(oldAttributes map[string]Attribute, oldBlocks map[string]Block, newAttributes map[string]Attribute, newBlocks map[string]Block, parents Path, changes *[]Change) {
+	for name, old := range oldAttributes {
+		path := childPath(parents, name)
+		attr, found := newAttributes[name]
+		switch {
+		case !found:
+			*changes = append(*changes, Change{Kind: ChangeRemoved, Path: path, Old: old.Value})
+		case !reflect.DeepEqual(old.Value, attr.Value):
+			*changes = append(*changes, Change{Kind: ChangeModified, Path: path, Old: old.Value, New: attr.Value})
+		}
+	}
+	for name, attr := range newAttributes {
+		if _, found := oldAttributes[name]; !found {
+			*changes = append(*changes, Change{Kind: ChangeAdded, Path: childPath(parents, name), New: attr.Value})
+		}
+	}
+
+	// Blocks only in one of the documents are diffed against an empty one
+	for name, old := range oldBlocks {
+		path := childPath(parents, name)
+		b, found := newBlocks[name]
+		if !found {
+			*changes = append(*changes, Change{Kind: ChangeRemoved, Path: path, Block: true})
+		}
+		diffBodies(old.Attributes, old.Blocks, b.Attributes, b.Blocks, path, changes)
+	}
+	for name, b := range newBlocks {
+		if _, found := oldBlocks[name]; !found {
+			path := childPath(parents, name)
+			*changes = append(*changes, Change{Kind: ChangeAdded, Path: path, Block: true})
+			diffBodies(nil, nil, b.Attributes, b.Blocks, path, changes)
+		}
+	}
+}
+
+// Replaces a value by the redacted secret, keeping nil for the values of
+// added and removed attributes
+func redactValue(value Value) Value {
+	if value == nil {
+		return nil
+	}
+	return redactedSecret
+}
+
+// Returns a value as it would be written in a document, or as printed by
+// fmt if it can't be encoded
+func diffValue(value Value) string {
+	if encoded, err := encodeValue(value); err == nil {
+		return encoded
+	}
+	return fmt.Sprint(value)
+}
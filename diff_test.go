@@ -0,0 +1,94 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	a, err := DecodeBytes([]byte(`name = "cafe"
+tags = ["web"]
+server {
+    port = 8080
+    host = "localhost"
+}
+cache {
+    size = 64
+}
+debug = true
+`))
+	assert.NoError(t, err)
+	b, err := DecodeBytes([]byte(`name = "cafe"
+tags = ["web", "api"]
+server {
+    port = 9090
+    host = "localhost"
+    tls {
+        cert = "cert.pem"
+    }
+}
+debug {
+    level = 2
+}
+`))
+	assert.NoError(t, err)
+
+	changes := Diff(a, b)
+	assert.Equal(t, []Change{
+		{Kind: ChangeRemoved, Path: Path{"cache"}, Block: true},
+		{Kind: ChangeRemoved, Path: Path{"cache", "size"}, Old: 64},
+		{Kind: ChangeRemoved, Path: Path{"debug"}, Old: true},
+		{Kind: ChangeAdded, Path: Path{"debug"}, Block: true},
+		{Kind: ChangeAdded, Path: Path{"debug", "level"}, New: 2},
+		{Kind: ChangeModified, Path: Path{"server", "port"}, Old: 8080, New: 9090},
+		{Kind: ChangeAdded, Path: Path{"server", "tls"}, Block: true},
+		{Kind: ChangeAdded, Path: Path{"server", "tls", "cert"}, New: "cert.pem"},
+		{Kind: ChangeModified, Path: Path{"tags"}, Old: []interface{}{"web"}, New: []interface{}{"web", "api"}},
+	}, changes)
+
+	assert.Equal(t, "- cache {}", changes[0].String())
+	assert.Equal(t, "- cache.size = 64", changes[1].String())
+	assert.Equal(t, "+ debug {}", changes[3].String())
+	assert.Equal(t, "+ debug.level = 2", changes[4].String())
+	assert.Equal(t, "~ server.port = 8080 -> 9090", changes[5].String())
+	assert.Equal(t, `~ tags = ["web"] -> ["web", "api"]`, changes[8].String())
+
+	assert.Empty(t, Diff(a, a))
+}
+
+func TestDiffBlocksAndNull(t *testing.T) {
+	a, err := DecodeBytes([]byte("a = 1\nb {\n}\nvalue = null\n"))
+	assert.NoError(t, err)
+	b, err := DecodeBytes([]byte("a = 1\nc {\n}\n"))
+	assert.NoError(t, err)
+
+	// Empty blocks are changes too, and removing a null attribute is told
+	// apart from a value becoming null by the kind
+	assert.Equal(t, []Change{
+		{Kind: ChangeRemoved, Path: Path{"b"}, Block: true},
+		{Kind: ChangeAdded, Path: Path{"c"}, Block: true},
+		{Kind: ChangeRemoved, Path: Path{"value"}},
+	}, Diff(a, b))
+
+	c, err := DecodeBytes([]byte("a = null\nb {\n}\nvalue = null\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Change{
+		{Kind: ChangeModified, Path: Path{"a"}, Old: 1},
+	}, Diff(a, c))
+	assert.Equal(t, "modified", ChangeModified.String())
+}
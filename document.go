@@ -0,0 +1,219 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Document is a CAFE document kept in sync with the buffer of an editor
+// Edits only lex again the lines around them, so language servers stay
+// responsive on large files
+type Document struct {
+	// Current contents of the document
+	src []byte
+
+	// Tokens of the contents
+	tokens []Token
+
+	// Error lexing the contents, if any
+	err error
+}
+
+// NewDocument lexes a document to keep it in sync with its edits
+func NewDocument(src []byte) *Document {
+	d := &Document{src: append([]byte{}, src...)}
+	d.tokens, d.err = Lex(d.src)
+	return d
+}
+
+// Source returns the current contents of the document
+func (d *Document) Source() []byte {
+	return d.src
+}
+
+// Tokens returns the tokens of the current contents, like Lex
+func (d *Document) Tokens() ([]Token, error) {
+	return d.tokens, d.err
+}
+
+// Highlight classifies the parts of the current contents, like Highlight
+func (d *Document) Highlight() ([]SemanticToken, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	return highlightTokens(d.tokens), nil
+}
+
+// Edit replaces a range of the document with a text, as in the changes
+// sent by editors, and lexes again the lines the edit can affect
+// The end of the range is exclusive, so an empty range inserts the text
+func (d *Document) Edit(rng Range, text string) error {
+	start, validStart := positionOffset(d.src, rng.Start)
+	end, validEnd := positionOffset(d.src, rng.End)
+	if !validStart || !validEnd || end < start {
+		return fmt.Errorf("invalid range %d:%d-%d:%d", rng.Start.Line, rng.Start.Column, rng.End.Line, rng.End.Column)
+	}
+
+	src := make([]byte, 0, len(d.src)-(end-start)+len(text))
+	src = append(append(append(src, d.src[:start]...), text...), d.src[end:]...)
+	delta := bytes.Count([]byte(text), []byte("\n")) - (rng.End.Line - rng.Start.Line)
+
+	tokens, relexed := d.relex(src, rng, delta)
+	d.src = src
+	if relexed {
+		d.tokens, d.err = tokens, nil
+	} else {
+		d.tokens, d.err = Lex(src)
+	}
+	return nil
+}
+
+// Lexes the lines of the new contents affected by an edit, from the last
+// statement starting before it up to the first statement after it, and
+// reuses the tokens of the other lines
+// Reports false if the whole document must be lexed again, such as when
+// the edit changed how the statement after it is lexed
+func (d *Document) relex(src []byte, rng Range, delta int) ([]Token, bool) {
+	if d.err != nil {
+		return nil, false
+	}
+
+	// Lines of the statements around the edit, in the old contents
+	// The statements of the blocks of arrays are part of the array
+	first, resume, arrays := 1, 0, 0
+	for i, token := range d.tokens {
+		switch token.Kind {
+		case TokenArrayStart:
+			arrays += 1
+		case TokenArrayEnd:
+			arrays -= 1
+		}
+		if arrays > 0 || !startsStatement(d.tokens, i) {
+			continue
+		}
+		line := token.Range.Start.Line
+		if line <= rng.Start.Line {
+			first = line
+		} else if line > rng.End.Line {
+			resume = line
+			break
+		}
+	}
+
+	// The line of the statement after the edit is lexed too, to check it
+	// still starts a statement
+	last := len(src)
+	if resume != 0 {
+		last = lineOffset(src, resume+delta+1)
+	}
+	chunk, err := Lex(src[lineOffset(src, first):last])
+	if err != nil {
+		return nil, false
+	}
+	shiftTokens(chunk, first-1)
+
+	tokens := []Token{}
+	for _, token := range d.tokens {
+		if token.Range.Start.Line >= first {
+			break
+		}
+		tokens = append(tokens, token)
+	}
+	if resume == 0 {
+		return append(tokens, chunk...), true
+	}
+
+	reused := []Token{}
+	for _, token := range d.tokens {
+		if token.Range.Start.Line >= resume {
+			reused = append(reused, token)
+		}
+	}
+	shiftTokens(reused, delta)
+
+	// The tokens of the line after the edit must be the same as before
+	split := len(chunk)
+	for split > 0 && chunk[split-1].Range.Start.Line == resume+delta {
+		split--
+	}
+	checked := chunk[split:]
+	if len(checked) > len(reused) {
+		return nil, false
+	}
+	for i, token := range checked {
+		if token != reused[i] {
+			return nil, false
+		}
+	}
+	if len(checked) < len(reused) && reused[len(checked)].Range.Start.Line == resume+delta {
+		return nil, false
+	}
+	return append(append(tokens, chunk[:split]...), reused...), true
+}
+
+// Reports if a token starts a statement at the beginning of its line, so
+// the lexer can start from its line
+func startsStatement(tokens []Token, i int) bool {
+	switch tokens[i].Kind {
+	case TokenAttribute, TokenBlockStart, TokenBlockEnd, TokenInclude, TokenUse:
+		return i == 0 || tokens[i-1].Range.End.Line < tokens[i].Range.Start.Line
+	default:
+		return false
+	}
+}
+
+// Moves tokens some lines down, or up if lines is negative
+func shiftTokens(tokens []Token, lines int) {
+	for i := range tokens {
+		tokens[i].Range.Start.Line += lines
+		tokens[i].Range.End.Line += lines
+	}
+}
+
+// Returns the byte offset of the start of a line of a document, or the
+// length of the document if it has fewer lines
+func lineOffset(src []byte, line int) int {
+	offset := 0
+	for n := 1; n < line; n++ {
+		eol := bytes.IndexByte(src[offset:], '\n')
+		if eol < 0 {
+			return len(src)
+		}
+		offset += eol + 1
+	}
+	return offset
+}
+
+// Returns the byte offset of a position of a document, the inverse of
+// offsetPosition
+// Reports false if the position isn't in the document, although the
+// position right after the end of a line is
+func positionOffset(src []byte, pos Position) (int, bool) {
+	if pos.Line < 1 || pos.Column < 1 || pos.Line > bytes.Count(src, []byte("\n"))+1 {
+		return 0, false
+	}
+	offset := lineOffset(src, pos.Line)
+	for column := 1; column < pos.Column; column++ {
+		if offset == len(src) || src[offset] == '\n' {
+			return 0, false
+		}
+		_, size := utf8.DecodeRune(src[offset:])
+		offset += size
+	}
+	return offset, true
+}
@@ -0,0 +1,100 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocumentEdit(t *testing.T) {
+	src := `name = "cafe"
+// Servers
+server {
+    let host = "localhost"
+    port = 8080
+    tags = [
+        "web",
+        "api"
+    ]
+    address = append(host, ":80")
+}
+version = 1.5
+endpoints = [
+    {
+        host = "a"
+    }
+]
+`
+	tests := []struct {
+		name        string
+		rng         Range
+		text        string
+		expected    string
+		incremental bool
+	}{
+		{"replace value", Range{Position{5, 12}, Position{5, 16}}, "443", "    port = 443\n", true},
+		{"insert line", Range{Position{5, 1}, Position{5, 1}}, "    debug = true\n", "    debug = true\n", true},
+		{"delete line", Range{Position{5, 1}, Position{6, 1}}, "", "    tags = [\n", true},
+		{"edit array element", Range{Position{8, 9}, Position{8, 14}}, `"grpc"`, `        "grpc"` + "\n", true},
+		{"add block", Range{Position{12, 1}, Position{12, 1}}, "client {\n    retries = 3\n}\n", "client {\n", true},
+		{"rename block", Range{Position{3, 1}, Position{3, 7}}, "backend", "backend {\n", true},
+		{"last line", Range{Position{12, 11}, Position{12, 14}}, "2", "version = 2\n", true},
+		{"edit block of array", Range{Position{15, 16}, Position{15, 19}}, `"b"`, `        host = "b"` + "\n", true},
+		{"continue string", Range{Position{4, 27}, Position{4, 27}}, ` \`, `let host = "localhost" \`, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewDocument([]byte(src))
+			start, _ := positionOffset([]byte(src), test.rng.Start)
+			end, _ := positionOffset([]byte(src), test.rng.End)
+			edited := src[:start] + test.text + src[end:]
+
+			delta := strings.Count(test.text, "\n") - (test.rng.End.Line - test.rng.Start.Line)
+			_, incremental := d.relex([]byte(edited), test.rng, delta)
+			assert.Equal(t, test.incremental, incremental)
+
+			assert.NoError(t, d.Edit(test.rng, test.text))
+			assert.Equal(t, edited, string(d.Source()))
+			assert.Contains(t, edited, test.expected)
+
+			expected, expectedErr := Lex([]byte(edited))
+			tokens, err := d.Tokens()
+			assert.Equal(t, expected, tokens)
+			assert.Equal(t, expectedErr, err)
+		})
+	}
+
+	// Consecutive edits, as if typing
+	d := NewDocument([]byte(src))
+	for i, r := range "level = 3\n" {
+		assert.NoError(t, d.Edit(Range{Position{12, 1 + i}, Position{12, 1 + i}}, string(r)))
+		expected, expectedErr := Lex(d.Source())
+		tokens, err := d.Tokens()
+		assert.Equal(t, expected, tokens)
+		assert.Equal(t, expectedErr, err)
+	}
+
+	highlighted, err := d.Highlight()
+	assert.NoError(t, err)
+	expectedHighlight, _ := Highlight(d.Source())
+	assert.Equal(t, expectedHighlight, highlighted)
+
+	assert.Error(t, d.Edit(Range{Position{20, 1}, Position{20, 1}}, "x"))
+	assert.Error(t, d.Edit(Range{Position{1, 20}, Position{1, 20}}, "x"))
+	assert.Error(t, d.Edit(Range{Position{2, 1}, Position{1, 1}}, "x"))
+}
@@ -0,0 +1,113 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DumpAST writes the tree of a node for debugging, one node per line,
+// indented by depth, with its kind, its value and where it's defined
+// The node can be a decoded document (*Parser), a Block, an Attribute or
+// the tokens of Lex, whose blocks are indented too:
+//
+//	document
+//	  attribute name: string "cafe" @1:1-1:14
+//	  block server @2:1-4:2
+//	    attribute id: string "CAFE" (upper(name)) @3:5-3:21
+func DumpAST(w io.Writer, node interface{}) error {
+	var sb strings.Builder
+	switch n := node.(type) {
+	case *Parser:
+		sb.WriteString("document\n")
+		dumpBody(&sb, n, nil, n.Attributes, n.Blocks, 1)
+	case Block:
+		dumpBlock(&sb, nil, nil, n.Name, n, 0)
+	case Attribute:
+		dumpAttribute(&sb, nil, nil, n.Name, n, 0)
+	case []Token:
+		dumpTokens(&sb, n)
+	default:
+		return fmt.Errorf("cafe: DumpAST can't dump a %T", node)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Writes the attributes and blocks of the body at path, in the order they
+// were defined in if the document is known, or else sorted by name
+func dumpBody(sb *strings.Builder, p *Parser, path Path, attributes map[string]Attribute, blocks map[string]Block, depth int) {
+	names := append(sortedKeys(attributes), sortedKeys(blocks)...)
+	if p != nil {
+		names = p.orderedNames(path, attributes, blocks)
+	}
+	for _, name := range names {
+		if attr, isAttribute := attributes[name]; isAttribute {
+			dumpAttribute(sb, p, path, name, attr, depth)
+			continue
+		}
+		dumpBlock(sb, p, path, name, blocks[name], depth)
+	}
+}
+
+// Writes a block and its body
+func dumpBlock(sb *strings.Builder, p *Parser, path Path, name string, b Block, depth int) {
+	sb.WriteString(strings.Repeat("  ", depth) + "block " + quoteKey(name) + dumpRange(b.Range) + "\n")
+	dumpBody(sb, p, childPath(path, name), b.Attributes, b.Blocks, depth+1)
+}
+
+// Writes an attribute, with the expression it's computed from
+func dumpAttribute(sb *strings.Builder, p *Parser, path Path, name string, attr Attribute, depth int) {
+	kind, value := "null", "null"
+	if attr.Value != nil {
+		kind = valueKindName(attr.Value)
+		encoded, err := encodeValue(attr.Value)
+		if err != nil {
+			encoded = fmt.Sprintf("%v", attr.Value)
+		}
+		value = encoded
+	}
+	if p != nil && p.secrets[childPath(path, name).String()] {
+		value = `"` + redactedSecret + `"`
+	}
+	if attr.expr != "" {
+		value += " (" + attr.expr + ")"
+	}
+	sb.WriteString(strings.Repeat("  ", depth) + "attribute " + quoteKey(name) + ": " + kind + " " + value + dumpRange(attr.Range) + "\n")
+}
+
+// Writes tokens, indenting the ones between the braces of blocks
+func dumpTokens(sb *strings.Builder, tokens []Token) {
+	depth := 0
+	for _, token := range tokens {
+		if token.Kind == TokenBlockEnd && depth > 0 {
+			depth--
+		}
+		sb.WriteString(strings.Repeat("  ", depth) + token.Kind.String() + " " + token.Value + dumpRange(token.Range) + "\n")
+		if token.Kind == TokenBlockStart {
+			depth++
+		}
+	}
+}
+
+// Formats where a node is defined, nothing for nodes made in code
+func dumpRange(r Range) string {
+	if r == (Range{}) {
+		return ""
+	}
+	return fmt.Sprintf(" @%d:%d-%d:%d", r.Start.Line, r.Start.Column, r.End.Line, r.End.Column)
+}
@@ -0,0 +1,63 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDumpAST(t *testing.T) {
+	p, err := DecodeBytes([]byte(`name = "cafe"
+server {
+    id = upper(name)
+    tags = ["a", "b"]
+    tls {
+    }
+}
+backup = null
+`))
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	assert.NoError(t, DumpAST(&out, p))
+	assert.Equal(t, `document
+  attribute name: string "cafe" @1:1-1:14
+  block server @2:1-7:2
+    attribute id: string "CAFE" (upper(name)) @3:5-3:21
+    attribute tags: array ["a", "b"] @4:5-4:22
+    block tls @5:5-6:6
+  attribute backup: null null @8:1-8:14
+`, out.String())
+
+	out.Reset()
+	assert.NoError(t, DumpAST(&out, p.Blocks["server"].Blocks["tls"]))
+	assert.Equal(t, "block tls @5:5-6:6\n", out.String())
+
+	// Tokens are indented by block
+	tokens, err := Lex([]byte("server {\n    port = 80\n}\n"))
+	assert.NoError(t, err)
+	out.Reset()
+	assert.NoError(t, DumpAST(&out, tokens))
+	assert.Equal(t, `block start server @1:1-1:7
+  attribute port @2:5-2:9
+  int 80 @2:12-2:14
+block end } @3:1-3:2
+`, out.String())
+
+	assert.EqualError(t, DumpAST(&out, 1), "cafe: DumpAST can't dump a int")
+}
@@ -0,0 +1,366 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An Encoder writes parsed CAFE files back to an output stream
+type Encoder struct {
+	// Where the CAFE file is written to
+	w io.Writer
+
+	// Indentation of nested blocks
+	indent string
+
+	// Write expressions instead of their values
+	expressions bool
+
+	// Write attributes and blocks in the order they were defined
+	sourceOrder bool
+
+	// Write the byte-stable canonical form of the document
+	canonical bool
+
+	// Write blocks in a line
+	compact bool
+}
+
+// Creates an Encoder that writes to w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		w:      w,
+		indent: "    ",
+	}
+}
+
+// Sets the indentation of nested blocks, four spaces by default
+// An empty indentation writes every line at the start of the line
+func (e *Encoder) SetIndent(indent string) {
+	e.indent = indent
+}
+
+// Sets whether attributes computed from expressions are written as the
+// source text of the expressions, like "port = base_port + 1", instead of
+// their values, like "port = 8081". Values are written by default
+func (e *Encoder) SetExpressions(expressions bool) {
+	e.expressions = expressions
+}
+
+// Sets whether attributes and blocks are written in the order they were
+// defined in, instead of attributes first and both sorted by name
+// Expressions are always written in the order they were defined in
+func (e *Encoder) SetSourceOrder(sourceOrder bool) {
+	e.sourceOrder = sourceOrder
+}
+
+// Sets whether documents are written in their canonical form: attributes
+// and blocks sorted together by name, indented with four spaces, with
+// their values instead of their expressions and without comments
+// Documents with the same attributes and blocks are written with the same
+// bytes, so their encodings can be hashed or compared to detect drift
+// The other settings of the Encoder are ignored
+func (e *Encoder) SetCanonical(canonical bool) {
+	e.canonical = canonical
+}
+
+// Sets whether blocks are written in a line, with their attributes and
+// blocks separated by commas and no spaces, as in server{host="a",port=80},
+// for embedding documents in logs, annotations or command lines
+// Each attribute and block of the top level still takes a line, and
+// comments aren't written
+func (e *Encoder) SetCompact(compact bool) {
+	e.compact = compact
+}
+
+// Writes the CAFE encoding of p to the stream
+func (e *Encoder) Encode(p *Parser) error {
+	var sb strings.Builder
+	if e.compact {
+		entries, err := e.encodeCompactBody(p, p.Attributes, p.Blocks, nil)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			sb.WriteString(entry + "\n")
+		}
+	} else if err := e.encodeBody(&sb, p, p.Attributes, p.Blocks, nil); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, sb.String())
+	return err
+}
+
+// String returns the CAFE encoding of the document, with its secrets
+// redacted, for printing it while debugging
+func (p *Parser) String() string {
+	var sb strings.Builder
+	if err := NewEncoder(&sb).Encode(p); err != nil {
+		return fmt.Sprintf("cafe: %v", err)
+	}
+	return sb.String()
+}
+
+// Encodes the attributes and blocks of the body at path
+// Attributes come first and both are sorted by name, so the output is stable
+// When writing expressions, everything keeps the order it was defined in
+// instead, so expressions only refer to what's defined above them
+func (e *Encoder) encodeBody(sb *strings.Builder, p *Parser, attributes map[string]Attribute, blocks map[string]Block, path []string) error {
+	indent := strings.Repeat(e.indent, len(path))
+	if e.canonical {
+		indent = strings.Repeat("    ", len(path))
+	}
+
+	for _, name := range e.bodyNames(p, attributes, blocks, path) {
+		if attr, isAttribute := attributes[name]; isAttribute {
+			value, err := e.attributeValue(p, path, name, attr)
+			if err != nil {
+				return err
+			}
+			if e.canonical {
+				attr.Doc, attr.LineComment = "", ""
+			}
+			writeDoc(sb, indent, attr.Doc)
+			sb.WriteString(indent + quoteKey(name) + " = " + value + encodeLineComment(attr.LineComment) + "\n")
+			continue
+		}
+
+		b := blocks[name]
+		if e.canonical {
+			b.Doc = ""
+		}
+		writeDoc(sb, indent, b.Doc)
+		sb.WriteString(indent + name + " {\n")
+		if err := e.encodeBody(sb, p, b.Attributes, b.Blocks, append(path, name)); err != nil {
+			return err
+		}
+		sb.WriteString(indent + "}\n")
+	}
+	return nil
+}
+
+// Encodes the attributes and blocks of the body at path as the entries of
+// a line, like host="a" and tls{cert="c"}
+func (e *Encoder) encodeCompactBody(p *Parser, attributes map[string]Attribute, blocks map[string]Block, path []string) ([]string, error) {
+	entries := []string{}
+	for _, name := range e.bodyNames(p, attributes, blocks, path) {
+		if attr, isAttribute := attributes[name]; isAttribute {
+			value, err := e.attributeValue(p, path, name, attr)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, quoteKey(name)+"="+value)
+			continue
+		}
+
+		b := blocks[name]
+		body, err := e.encodeCompactBody(p, b.Attributes, b.Blocks, append(path, name))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, name+"{"+strings.Join(body, ",")+"}")
+	}
+	return entries, nil
+}
+
+// Returns the names of the attributes and blocks of the body at path in
+// the order they're written
+func (e *Encoder) bodyNames(p *Parser, attributes map[string]Attribute, blocks map[string]Block, path []string) []string {
+	names := append(sortedKeys(attributes), sortedKeys(blocks)...)
+	switch {
+	case e.canonical:
+		sort.Strings(names)
+	case e.expressions || e.sourceOrder:
+		names = p.orderedNames(path, attributes, blocks)
+	}
+	return names
+}
+
+// Encodes the value of the attribute of the body at path, or the
+// expression it's written as
+func (e *Encoder) attributeValue(p *Parser, path []string, name string, attr Attribute) (string, error) {
+	value := attr.Value
+	if e.canonical {
+		value = canonicalValue(value)
+	}
+	encoded, err := encodeValue(value)
+	if e.compact {
+		encoded, err = encodeCompactValue(value)
+	}
+	if e.expressions && !e.canonical && attr.expr != "" {
+		encoded, err = attr.expr, nil
+	}
+
+	// Secrets are written as the expressions resolving them
+	if p.secrets[childPath(path, name).String()] {
+		encoded, err = encodeValue(redactedSecret)
+		if attr.expr != "" {
+			encoded, err = attr.expr, nil
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot encode attribute %s: %w", name, err)
+	}
+	return encoded, nil
+}
+
+// Writes the documentation of an attribute or a block as doc comments
+func writeDoc(sb *strings.Builder, indent string, doc string) {
+	if doc == "" {
+		return
+	}
+	for _, line := range strings.Split(doc, "\n") {
+		sb.WriteString(strings.TrimRight(indent+"/// "+line, " ") + "\n")
+	}
+}
+
+// Encodes the comment written after the value of an attribute
+func encodeLineComment(comment string) string {
+	switch {
+	case comment == "":
+		return ""
+	case strings.Contains(comment, "\n"):
+		return " /* " + comment + " */"
+	default:
+		return " // " + comment
+	}
+}
+
+// Encodes a single value as it would be written in a CAFE file
+func encodeValue(value interface{}) (string, error) {
+	switch val := value.(type) {
+	case string:
+		if strings.ContainsAny(val, "\"\n") {
+			return "", fmt.Errorf("strings can't contain quotes or line breaks: %q", val)
+		}
+		return `"` + val + `"`, nil
+	case int:
+		return strconv.Itoa(val), nil
+	case float64:
+		// Floats always carry a decimal point, otherwise they would be read as ints
+		str := strconv.FormatFloat(val, 'f', -1, 64)
+		if !strings.Contains(str, ".") {
+			str += ".0"
+		}
+		return str, nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	case nil:
+		return "null", nil
+	case []interface{}:
+		elems := make([]string, len(val))
+		for i, elem := range val {
+			if _, isArray := elem.([]interface{}); isArray {
+				return "", fmt.Errorf("arrays can't be nested")
+			}
+			encodedElem, err := encodeValue(elem)
+			if err != nil {
+				return "", err
+			}
+			elems[i] = encodedElem
+		}
+		return "[" + strings.Join(elems, ", ") + "]", nil
+	case map[string]interface{}:
+		// Blocks of arrays are written in a line, so they can't hold blocks
+		fields := make([]string, 0, len(val))
+		for _, key := range sortedKeys(val) {
+			if _, isBlock := val[key].(map[string]interface{}); isBlock {
+				return "", fmt.Errorf("blocks of arrays can't hold blocks: %s", key)
+			}
+			encodedField, err := encodeValue(val[key])
+			if err != nil {
+				return "", err
+			}
+			fields = append(fields, quoteKey(key)+" = "+encodedField)
+		}
+		return "{ " + strings.Join(fields, ", ") + " }", nil
+	default:
+		return "", fmt.Errorf("unsupported value %v of type %T", value, value)
+	}
+}
+
+// Encodes a single value like encodeValue, without spaces around the
+// elements of arrays and the attributes of their blocks
+func encodeCompactValue(value interface{}) (string, error) {
+	switch val := value.(type) {
+	case []interface{}:
+		elems := make([]string, len(val))
+		for i, elem := range val {
+			if _, isArray := elem.([]interface{}); isArray {
+				return "", fmt.Errorf("arrays can't be nested")
+			}
+			encodedElem, err := encodeCompactValue(elem)
+			if err != nil {
+				return "", err
+			}
+			elems[i] = encodedElem
+		}
+		return "[" + strings.Join(elems, ",") + "]", nil
+	case map[string]interface{}:
+		fields := make([]string, 0, len(val))
+		for _, key := range sortedKeys(val) {
+			if _, isBlock := val[key].(map[string]interface{}); isBlock {
+				return "", fmt.Errorf("blocks of arrays can't hold blocks: %s", key)
+			}
+			encodedField, err := encodeCompactValue(val[key])
+			if err != nil {
+				return "", err
+			}
+			fields = append(fields, quoteKey(key)+"="+encodedField)
+		}
+		return "{" + strings.Join(fields, ",") + "}", nil
+	default:
+		return encodeValue(value)
+	}
+}
+
+// Returns a value with its numbers in their canonical form, which only
+// changes negative zeros
+func canonicalValue(value interface{}) interface{} {
+	switch val := value.(type) {
+	case float64:
+		if val == 0 {
+			return 0.0
+		}
+	case []interface{}:
+		elems := make([]interface{}, len(val))
+		for i, elem := range val {
+			elems[i] = canonicalValue(elem)
+		}
+		return elems
+	case map[string]interface{}:
+		fields := make(map[string]interface{}, len(val))
+		for key, field := range val {
+			fields[key] = canonicalValue(field)
+		}
+		return fields
+	}
+	return value
+}
+
+// Returns the keys of a map in alphabetical order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,250 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncode(t *testing.T) {
+	src := []byte(`name = "cafe"
+version = 1
+ratio = 2.0
+enabled = true
+tags = ["config", 10, 1.5, false]
+server {
+    port = 8080
+    tls {
+    }
+}
+`)
+	p, err := DecodeBytes(src)
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	assert.NoError(t, NewEncoder(&out).Encode(p))
+	assert.Equal(t, `enabled = true
+name = "cafe"
+ratio = 2.0
+tags = ["config", 10, 1.5, false]
+version = 1
+server {
+    port = 8080
+    tls {
+    }
+}
+`, out.String())
+
+	// Values that can't be written in a CAFE file
+	p.Attributes["quoted"] = Attribute{Name: "quoted", Value: `say "hi"`, kind: attrString}
+	assert.Error(t, NewEncoder(&out).Encode(p))
+}
+
+func TestEncodeDocs(t *testing.T) {
+	p, err := Decode("./test_data/test-docs.cafe")
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	assert.NoError(t, NewEncoder(&out).Encode(p))
+	assert.Equal(t, `/// Name of the application
+/// Shown in the dashboard
+name = "cafe"
+port = 8080
+timeout = 30
+/// Database connection
+database {
+    /// Host of the primary server
+    host = "localhost" // Not the documentation of user
+    user = "barista"
+}
+`, out.String())
+
+	// The documentation survives a round trip
+	decoded, err := DecodeBytes(out.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, p.Attributes["name"].Doc, decoded.Attributes["name"].Doc)
+	assert.Equal(t, p.Blocks["database"].Doc, decoded.Blocks["database"].Doc)
+}
+
+func TestEncodeExpressions(t *testing.T) {
+	src := `name = "cafe"
+server {
+    label = append(name, "-server")
+    upperName = upper(name)
+    bigger = 10 > 2
+}
+alias = server.label
+`
+	p, err := DecodeBytes([]byte(src))
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	assert.NoError(t, NewEncoder(&out).Encode(p))
+	assert.Equal(t, `alias = "cafe-server"
+name = "cafe"
+server {
+    bigger = true
+    label = "cafe-server"
+    upperName = "CAFE"
+}
+`, out.String())
+
+	// Expressions keep their definition order, so their references still resolve
+	out.Reset()
+	encoder := NewEncoder(&out)
+	encoder.SetExpressions(true)
+	assert.NoError(t, encoder.Encode(p))
+	assert.Equal(t, src, out.String())
+
+	decoded, err := DecodeBytes(out.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, p.Attributes, decoded.Attributes)
+	assert.Equal(t, p.Blocks, decoded.Blocks)
+}
+
+func TestEncodeSourceOrder(t *testing.T) {
+	src := `zone = "eu"
+server {
+    port = 8080
+    tls {
+        cert = "cert.pem"
+    }
+    host = "localhost"
+}
+app = "cafe"
+`
+	p, err := DecodeBytes([]byte(src))
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	encoder := NewEncoder(&out)
+	encoder.SetSourceOrder(true)
+	assert.NoError(t, encoder.Encode(p))
+	assert.Equal(t, src, out.String())
+}
+
+func TestEncodeCanonical(t *testing.T) {
+	a, err := DecodeBytes([]byte(`/// Server settings
+server {
+    port = 8081 // service port
+    host = lower("LOCALHOST")
+}
+base = 8080
+app = "cafe"
+ratio = -0.0
+limits = [1.5, 2.0]
+`))
+	assert.NoError(t, err)
+	b, err := DecodeBytes([]byte(`app = "cafe"
+base = 8080
+limits = [1.5, 2.0]
+ratio = 0.0
+server {
+  host = "localhost"
+  port = 8081
+}
+`))
+	assert.NoError(t, err)
+
+	encode := func(p *Parser) string {
+		var out bytes.Buffer
+		encoder := NewEncoder(&out)
+		encoder.SetIndent("\t")
+		encoder.SetExpressions(true)
+		encoder.SetCanonical(true)
+		assert.NoError(t, encoder.Encode(p))
+		return out.String()
+	}
+	expected := `app = "cafe"
+base = 8080
+limits = [1.5, 2.0]
+ratio = 0.0
+server {
+    host = "localhost"
+    port = 8081
+}
+`
+	assert.Equal(t, expected, encode(a))
+	assert.Equal(t, expected, encode(b))
+}
+
+func TestEncodeCompact(t *testing.T) {
+	p, err := DecodeBytes([]byte(`name = "cafe" // name
+/// Server settings
+server {
+    host = "0.0.0.0"
+    endpoints = [{ path = "/", weight = 1 }]
+    tls {
+        cert = "cert.pem"
+    }
+    empty {
+    }
+}
+`))
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	encoder := NewEncoder(&out)
+	encoder.SetCompact(true)
+	assert.NoError(t, encoder.Encode(p))
+	assert.Equal(t, `name="cafe"
+server{endpoints=[{path="/",weight=1}],host="0.0.0.0",empty{},tls{cert="cert.pem"}}
+`, out.String())
+
+	// Compact documents decode to the same values
+	compact, err := DecodeBytes(out.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, bodyToJSON(p.Attributes, p.Blocks), bodyToJSON(compact.Attributes, compact.Blocks))
+}
+
+func TestEncodeLineComments(t *testing.T) {
+	p, err := DecodeBytes([]byte("port = 8080 # Service port\nratio = 1.5 /* Spanning\n   lines */\n"))
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	assert.NoError(t, NewEncoder(&out).Encode(p))
+	assert.Equal(t, "port = 8080 // Service port\nratio = 1.5 /* Spanning\n   lines */\n", out.String())
+
+	// The comments are kept when decoding the encoded document
+	encoded, err := DecodeBytes(out.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, p.Attributes["ratio"].LineComment, encoded.Attributes["ratio"].LineComment)
+}
+
+func TestEncodeQuotedKeys(t *testing.T) {
+	p, err := DecodeBytes([]byte("\"my key.with/chars\" = 1\nplain-key_2 = 2\n"))
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	assert.NoError(t, NewEncoder(&out).Encode(p))
+	assert.Equal(t, "\"my key.with/chars\" = 1\nplain-key_2 = 2\n", out.String())
+}
+
+func TestEncodeArrayBlocks(t *testing.T) {
+	p, err := DecodeBytes([]byte("endpoints = [\n    { host = \"a\", port = 80 },\n    { host = \"b\", tags = [\"x\"] }\n]\n"))
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	assert.NoError(t, NewEncoder(&out).Encode(p))
+	assert.Equal(t, "endpoints = [{ host = \"a\", port = 80 }, { host = \"b\", tags = [\"x\"] }]\n", out.String())
+
+	// Blocks of arrays are written in a line, without blocks of their own
+	p, err = DecodeBytes([]byte("endpoints = [\n    {\n        tls {\n        }\n    }\n]\n"))
+	assert.NoError(t, err)
+	assert.EqualError(t, NewEncoder(&out).Encode(p), "cannot encode attribute endpoints: blocks of arrays can't hold blocks: tls")
+}
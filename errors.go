@@ -0,0 +1,266 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Kinds of the errors of a document, wrapped by the errors returned when
+// decoding it so they can be told apart with errors.Is
+var (
+	// A string or a multiline string doesn't have its closing quote
+	ErrUnclosedString = errors.New("unclosed string")
+
+	// A block or a profile section doesn't have its closing brace
+	ErrUnclosedBlock = errors.New("unclosed block")
+
+	// An array doesn't have its closing bracket
+	ErrUnclosedArray = errors.New("unclosed array")
+
+	// A block comment doesn't have its closing */
+	ErrUnclosedComment = errors.New("unclosed comment")
+
+	// Something that isn't valid where it's found, like a stray brace
+	ErrUnexpectedToken = errors.New("unexpected token")
+
+	// An expression is malformed, like an operation missing its values
+	ErrInvalidExpression = errors.New("invalid expression")
+
+	// A function isn't one of the builtin or registered functions
+	ErrUnknownFunction = errors.New("unknown function")
+
+	// An attribute or a variable is referenced, but not defined
+	ErrUndefined = errors.New("undefined name")
+
+	// A value doesn't have the type an operation or a function takes
+	ErrTypeMismatch = errors.New("type mismatch")
+
+	// A function is called with the wrong number of parameters, or with
+	// a value it can't work with, like the square root of -1
+	ErrInvalidArgument = errors.New("invalid argument")
+
+	// A function or an include is disabled by the options of the decoding,
+	// like env with WithoutEnv
+	ErrFunctionNotAllowed = errors.New("function not allowed")
+
+	// An attribute or a block is defined twice in the same block
+	ErrRedefined = errors.New("redefined name")
+
+	// An attribute or a block is named after a reserved word
+	ErrReservedName = errors.New("reserved name")
+
+	// Blocks are nested deeper than WithMaxDepth allows
+	ErrMaxDepth = errors.New("maximum depth exceeded")
+
+	// A file includes itself, directly or through other files
+	ErrCircularInclude = errors.New("circular include")
+
+	// An attribute or a block isn't stored by any field, with
+	// WithDisallowUnknownKeys
+	ErrUnknownKey = errors.New("unknown key")
+
+	// A document fetched from a URL doesn't have the checksum of
+	// WithChecksum
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// evalError is the value the parser panics with when it can't evaluate an
+// expression, wrapping the kind of the error
+type evalError struct {
+	// Kind of the error, such as ErrTypeMismatch
+	kind error
+
+	// Description of the error, which can wrap another error
+	err error
+}
+
+// Returns an evalError of a kind, formatted like fmt.Errorf
+func evalErrorf(kind error, format string, args ...interface{}) error {
+	return &evalError{kind: kind, err: fmt.Errorf(format, args...)}
+}
+
+func (e *evalError) Error() string {
+	return e.err.Error()
+}
+
+func (e *evalError) Unwrap() []error {
+	errs := []error{}
+	if e.kind != nil {
+		errs = append(errs, e.kind)
+	}
+	if wrapped := errors.Unwrap(e.err); wrapped != nil {
+		errs = append(errs, wrapped)
+	}
+	return errs
+}
+
+// ParseError is returned when a document can't be decoded, pointing at the
+// item of the source that caused it
+type ParseError struct {
+	// File where the error happened, if decoding a file
+	File string
+
+	// Line of the item, starting in 1
+	Line int
+
+	// Column of the first character of the item, starting in 1
+	Col int
+
+	// Number of characters of the item
+	Length int
+
+	// Description of the error
+	Message string
+
+	// Source line of the item
+	Snippet string
+
+	// Error that caused it, such as a *RedefinitionError, if any
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	msg := fmt.Sprintf("line %d, column %d: %s", e.Line, e.Col, e.Message)
+	if e.File != "" {
+		return e.File + ": " + msg
+	}
+	return msg
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Annotated returns the error followed by its source line, with the item
+// underlined by carets:
+//
+//	line 2, column 1: attribute port redefined, previously defined at line 1
+//	port = 443
+//	^^^^
+func (e *ParseError) Annotated() string {
+	if e.Snippet == "" || e.Col < 1 {
+		return e.Error()
+	}
+
+	// Tabs are kept, so the carets line up with the snippet
+	var underline strings.Builder
+	for i, r := range []rune(e.Snippet) {
+		if i >= e.Col-1 {
+			break
+		}
+		if r == '\t' {
+			underline.WriteRune('\t')
+		} else {
+			underline.WriteRune(' ')
+		}
+	}
+	length := e.Length
+	if length < 1 {
+		length = 1
+	}
+	underline.WriteString(strings.Repeat("^", length))
+	return e.Error() + "\n" + e.Snippet + "\n" + underline.String()
+}
+
+// RedefinitionError is returned when an attribute or a block is defined
+// twice in the same block
+// Only overlays (such as profiles) can shadow an existing definition
+type RedefinitionError struct {
+	// File where the redefinition happened, if decoding a file
+	File string
+
+	// Kind of what was redefined, "attribute" or "block"
+	Kind string
+
+	// Path of the attribute or block, as in "block.nested.name"
+	Name string
+
+	// Line of the redefinition
+	Line int
+
+	// Column of the redefinition
+	Column int
+
+	// Line of the previous definition
+	PreviousLine int
+}
+
+func (e *RedefinitionError) Error() string {
+	msg := fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.message())
+	if e.File != "" {
+		return e.File + ": " + msg
+	}
+	return msg
+}
+
+func (e *RedefinitionError) Is(target error) bool {
+	return target == ErrRedefined
+}
+
+// Description of the error, without its position
+func (e *RedefinitionError) message() string {
+	return fmt.Sprintf("%s %s redefined, previously defined at line %d", e.Kind, e.Name, e.PreviousLine)
+}
+
+// ReservedNameError is returned when an attribute or a block is named after
+// a keyword, a function or one of the words reserved by WithReservedWords
+type ReservedNameError struct {
+	// File where the definition happened, if decoding a file
+	File string
+
+	// Kind of what was defined, "attribute" or "block"
+	Kind string
+
+	// Path of the attribute or block, as in "block.nested.name"
+	Name string
+
+	// Line of the definition
+	Line int
+
+	// Column of the definition
+	Column int
+}
+
+func (e *ReservedNameError) Error() string {
+	msg := fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.message())
+	if e.File != "" {
+		return e.File + ": " + msg
+	}
+	return msg
+}
+
+func (e *ReservedNameError) Is(target error) bool {
+	return target == ErrReservedName
+}
+
+// Description of the error, without its position
+func (e *ReservedNameError) message() string {
+	names := strings.Split(e.Name, ".")
+	return fmt.Sprintf("%s %s uses the reserved word %q as its name", e.Kind, e.Name, names[len(names)-1])
+}
+
+// MergeConflictError is returned by Parser.Merge when both documents define
+// the same attribute and the strategy doesn't allow overriding it
+type MergeConflictError struct {
+	// Path of the attribute, as in "block.nested.name"
+	Name string
+}
+
+func (e *MergeConflictError) Error() string {
+	return fmt.Sprintf("merge conflict: %s is defined in both documents", e.Name)
+}
@@ -0,0 +1,177 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Path locates an attribute or a block in a document, by the names of its
+// enclosing blocks followed by its own name
+type Path []string
+
+// Returns the path in its "block.nested.name" form
+// Names that can't be written as bare keys are quoted, as in
+// server."listen address"
+func (path Path) String() string {
+	names := make([]string, len(path))
+	for i, name := range path {
+		names[i] = quoteKey(name)
+	}
+	return strings.Join(names, ".")
+}
+
+// Splits a path in its "block.nested.name" form into its names, which can
+// be quoted to hold dots
+func splitPath(path string) Path {
+	names := Path{}
+	start, quoted := 0, false
+	for i, r := range path {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == '.' && !quoted:
+			names = append(names, unquoteKey(strings.TrimSpace(path[start:i])))
+			start = i + 1
+		}
+	}
+	return append(names, unquoteKey(strings.TrimSpace(path[start:])))
+}
+
+// Expiring can be returned by user-defined functions whose values are only
+// valid for some time, such as the ones fetched from remote services or
+// secret stores. Attributes holding these values become stale once their
+// TTL has passed, and can then be resolved again with Parser.Refresh
+type Expiring struct {
+	// The value of the call
+	Value Value
+
+	// How long the value is valid for
+	TTL time.Duration
+}
+
+// expiry records when the value of an attribute expires and the expression
+// that resolves it again
+type expiry struct {
+	expiresAt time.Time
+	item      string
+	kind      keyKind
+}
+
+// Returns the current time, which tests can replace
+func (p *Parser) now() time.Time {
+	if p.clock != nil {
+		return p.clock()
+	}
+	return time.Now()
+}
+
+// Keeps the shortest TTL of the values of the expression being evaluated
+func (p *Parser) expireIn(ttl time.Duration) {
+	if p.ttl == 0 || ttl < p.ttl {
+		p.ttl = ttl
+	}
+}
+
+// Records the expiry of an attribute if its value came from an expiring call
+func (p *Parser) recordExpiry(name string, item string, kind keyKind) {
+	if p.ttl <= 0 {
+		if len(p.expiries) > 0 {
+			delete(p.expiries, p.currentPath(name))
+		}
+		return
+	}
+	p.expiries[p.currentPath(name)] = expiry{
+		expiresAt: p.now().Add(p.ttl),
+		item:      item,
+		kind:      kind,
+	}
+}
+
+// Stale returns the paths of the attributes whose values came from calls
+// returning an Expiring value and whose TTL has passed, sorted by path
+func (p *Parser) Stale() []Path {
+	now := p.now()
+	stale := []string{}
+	for path, exp := range p.expiries {
+		if !now.Before(exp.expiresAt) {
+			stale = append(stale, path)
+		}
+	}
+	sort.Strings(stale)
+
+	paths := make([]Path, len(stale))
+	for i, path := range stale {
+		paths[i] = splitPath(path)
+	}
+	return paths
+}
+
+// Refresh evaluates again the stale attributes and returns their paths
+// Attributes that refer to a refreshed attribute keep their previous value
+// If an evaluation fails, the attribute keeps its previous value and the
+// refresh stops with the error
+// Subscribers are notified of the attributes whose values changed
+func (p *Parser) Refresh() ([]Path, error) {
+	refreshed := []Path{}
+	for _, path := range p.Stale() {
+		previous, _ := lookupPath(p.Attributes, p.Blocks, path)
+		if err := p.refreshAttribute(path); err != nil {
+			return refreshed, err
+		}
+		refreshed = append(refreshed, path)
+
+		value, _ := lookupPath(p.Attributes, p.Blocks, path)
+		p.notifyChange(path, previous, value)
+	}
+	return refreshed, nil
+}
+
+// Evaluates again the expression of an attribute
+func (p *Parser) refreshAttribute(path Path) (err error) {
+	defer func() {
+		p.currentBlocks = []string{}
+		if r := recover(); r != nil {
+			if evalErr, isEvalError := r.(*evalError); isEvalError {
+				err = fmt.Errorf("refreshing %s: %w", path, evalErr)
+				return
+			}
+			err = fmt.Errorf("refreshing %s: %v", path, r)
+		}
+	}()
+
+	exp := p.expiries[path.String()]
+	name := path[len(path)-1]
+	p.currentBlocks = append([]string{}, path[:len(path)-1]...)
+
+	p.ttl = 0
+	p.usedSecret = false
+	value := p.transformItem(exp.item, exp.kind)
+
+	attributes := p.Attributes
+	if isBlock, currentBlock := p.getCurrentBlock(); isBlock {
+		attributes = currentBlock.Attributes
+	}
+	attr := attributes[name]
+	attr.Value = value
+	attributes[name] = attr
+
+	p.recordExpiry(name, exp.item, exp.kind)
+	p.recordSecret(name, false)
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiring(t *testing.T) {
+	fetches := 0
+	fail := false
+	registerTestFunction(t, "fetch_token", func(args []Value) (Value, error) {
+		if fail {
+			return nil, errors.New("service unavailable")
+		}
+		fetches++
+		return Expiring{Value: fmt.Sprintf("%v-%d", args[0], fetches), TTL: time.Minute}, nil
+	})
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := newParser(splitTestInput(`name = "api"
+service {
+    token = upper(fetch_token(name))
+    port = 8080
+}
+`))
+	p.clock = func() time.Time { return now }
+	p.parseItems(nil)
+	assert.NoError(t, p.err)
+	assert.Equal(t, "API-1", p.Blocks["service"].Attributes["token"].Value)
+	assert.Empty(t, p.Stale())
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, []Path{{"service", "token"}}, p.Stale())
+	assert.Equal(t, "service.token", p.Stale()[0].String())
+
+	// A failed refresh keeps the previous value
+	fail = true
+	refreshed, err := p.Refresh()
+	assert.Error(t, err)
+	assert.Empty(t, refreshed)
+	assert.Equal(t, "API-1", p.Blocks["service"].Attributes["token"].Value)
+
+	fail = false
+	refreshed, err = p.Refresh()
+	assert.NoError(t, err)
+	assert.Equal(t, []Path{{"service", "token"}}, refreshed)
+	assert.Equal(t, "API-2", p.Blocks["service"].Attributes["token"].Value)
+	assert.Equal(t, 8080, p.Blocks["service"].Attributes["port"].Value)
+	assert.Empty(t, p.Stale())
+}
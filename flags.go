@@ -0,0 +1,195 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// A flag bound to an attribute, holding a value of the kind of the
+// attribute
+type attributeFlag struct {
+	value Value
+
+	// The flag was given, even by a parser other than the flag package,
+	// like the one of pflag
+	set bool
+
+	// The value isn't shown in the usage of the flag
+	secret bool
+}
+
+func (f *attributeFlag) String() string {
+	if f == nil || f.value == nil {
+		return ""
+	}
+	if f.secret && !f.set {
+		return redactedSecret
+	}
+	return fmt.Sprint(f.value)
+}
+
+func (f *attributeFlag) Set(s string) error {
+	value, err := parseLike(f.value, s)
+	if err != nil {
+		return err
+	}
+	f.value, f.set = value, true
+	return nil
+}
+
+func (f *attributeFlag) Get() interface{} {
+	return f.value
+}
+
+// Bool flags can be given without a value, as in -debug
+func (f *attributeFlag) IsBoolFlag() bool {
+	_, isBool := f.value.(bool)
+	return isBool
+}
+
+// BindFlags defines a flag in fs for every attribute of the document
+// holding a string, a number or a bool, named by its path, as in
+// -server.port, with the value of the attribute as its default and its doc
+// comment as its usage
+// Flags already defined in fs are left as they are
+// Once the command line is parsed, ApplyFlags sets the attributes of the
+// flags given
+func (p *Parser) BindFlags(fs *flag.FlagSet) {
+	p.EachAttribute(func(path string, a Attribute) bool {
+		switch a.Value.(type) {
+		case string, int, float64, bool:
+		default:
+			return true
+		}
+		if fs.Lookup(path) == nil {
+			fs.Var(&attributeFlag{value: a.Value, secret: p.secrets[path]}, path, a.Doc)
+		}
+		return true
+	})
+}
+
+// ApplyFlags sets the attributes of the flags given on the command line
+// over the values of the document, so flags take precedence over files
+// Flag sets are applied in order, the last one winning when several set
+// the same attribute, as in p.ApplyFlags(defaults, overrides)
+// Besides the flags of BindFlags, flags named by the path of an attribute
+// of the document set it too, with the value of their flag.Getter, so
+// existing flags like -server.port defined by flag.Int can override it
+// Flags bound to a pflag FlagSet by AddGoFlagSet are applied as well
+func (p *Parser) ApplyFlags(sets ...*flag.FlagSet) error {
+	for _, fs := range sets {
+		given := map[string]bool{}
+		fs.Visit(func(f *flag.Flag) {
+			given[f.Name] = true
+		})
+
+		var err error
+		fs.VisitAll(func(f *flag.Flag) {
+			if err != nil {
+				return
+			}
+			if bound, isBound := f.Value.(*attributeFlag); isBound {
+				if bound.set {
+					err = p.applyFlag(f.Name, bound.value)
+				}
+				return
+			}
+			if given[f.Name] && p.definesAttribute(f.Name) {
+				err = p.applyFlag(f.Name, flagValue(f.Value))
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sets the attribute of a flag
+func (p *Parser) applyFlag(name string, value Value) error {
+	if err := p.Set(name, value); err != nil {
+		return fmt.Errorf("flag -%s: %w", name, err)
+	}
+	return nil
+}
+
+// Reports if the document has an attribute at a path
+func (p *Parser) definesAttribute(path string) bool {
+	names, err := mutationPath(path)
+	if err != nil {
+		return false
+	}
+	attributes, _, err := p.bodyAt(names[:len(names)-1], false)
+	if err != nil {
+		return false
+	}
+	_, found := attributes[names[len(names)-1]]
+	return found
+}
+
+// Converts the value of a flag to the value of an attribute
+func flagValue(value flag.Value) Value {
+	getter, isGetter := value.(flag.Getter)
+	if !isGetter {
+		return value.String()
+	}
+	switch v := getter.Get().(type) {
+	case time.Duration:
+		return v.String()
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case uint:
+		return int(v)
+	case uint64:
+		return int(v)
+	case float64, bool, string:
+		return v
+	default:
+		return value.String()
+	}
+}
+
+// Parses the text of a flag or an environment variable as a value of the
+// kind of another, or as a string
+func parseLike(value Value, s string) (Value, error) {
+	switch value.(type) {
+	case int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid int %q", s)
+		}
+		return n, nil
+	case float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float %q", s)
+		}
+		return n, nil
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid boolean %q", s)
+		}
+		return b, nil
+	default:
+		return s, nil
+	}
+}
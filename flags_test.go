@@ -0,0 +1,98 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlags(t *testing.T) {
+	p, err := DecodeBytes([]byte(`server {
+    /// Port the server listens on
+    port = 8080
+    host = "localhost"
+    debug = false
+    ratio = 0.5
+    tags = ["a", "b"]
+}
+`))
+	assert.NoError(t, err)
+
+	fs := flag.NewFlagSet("app", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	p.BindFlags(fs)
+	assert.Equal(t, "Port the server listens on", fs.Lookup("server.port").Usage)
+	assert.Equal(t, "8080", fs.Lookup("server.port").DefValue)
+	assert.Nil(t, fs.Lookup("server.tags"))
+
+	assert.NoError(t, fs.Parse([]string{"-server.port=9090", "-server.debug", "-server.ratio", "0.75"}))
+	assert.NoError(t, p.ApplyFlags(fs))
+	server := p.Blocks["server"].Attributes
+	assert.Equal(t, 9090, server["port"].Value)
+	assert.Equal(t, true, server["debug"].Value)
+	assert.Equal(t, 0.75, server["ratio"].Value)
+	assert.Equal(t, "localhost", server["host"].Value)
+
+	// Invalid values are reported by the flag set
+	assert.ErrorContains(t, fs.Parse([]string{"-server.port=http"}), `invalid int "http"`)
+}
+
+func TestFlagsPrecedence(t *testing.T) {
+	p, err := DecodeBytes([]byte("port = 8080\ntimeout = \"10s\"\nname = \"cafe\"\n"))
+	assert.NoError(t, err)
+
+	// Flags defined by the application override the attributes they name
+	defaults := flag.NewFlagSet("defaults", flag.ContinueOnError)
+	defaults.Int("port", 80, "")
+	defaults.Duration("timeout", time.Second, "")
+	defaults.String("config", "app.cafe", "")
+	assert.NoError(t, defaults.Parse([]string{"-port=8081", "-timeout=1m", "-config=other.cafe"}))
+
+	overrides := flag.NewFlagSet("overrides", flag.ContinueOnError)
+	p.BindFlags(overrides)
+	assert.NoError(t, overrides.Parse([]string{"-port=9090"}))
+
+	// The last flag set wins
+	assert.NoError(t, p.ApplyFlags(defaults, overrides))
+	assert.Equal(t, 9090, p.Attributes["port"].Value)
+	assert.Equal(t, "1m0s", p.Attributes["timeout"].Value)
+	assert.Equal(t, "cafe", p.Attributes["name"].Value)
+
+	// Flags not naming an attribute are ignored
+	_, found := p.Attributes["config"]
+	assert.False(t, found)
+}
+
+func TestFlagsSecrets(t *testing.T) {
+	resolver := SecretResolverFunc(func(path string) (string, error) {
+		return "hunter2", nil
+	})
+	p, err := DecodeBytes([]byte("password = secret(\"db/password\")\n"), WithSecretResolver(resolver))
+	assert.NoError(t, err)
+
+	fs := flag.NewFlagSet("app", flag.ContinueOnError)
+	var usage strings.Builder
+	fs.SetOutput(&usage)
+	p.BindFlags(fs)
+	fs.PrintDefaults()
+	assert.NotContains(t, usage.String(), "hunter2")
+	assert.Contains(t, usage.String(), redactedSecret)
+}
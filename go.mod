@@ -1,10 +1,15 @@
 module github.com/ldatb/cafe
 
-go 1.19
+go 1.20
+
+require (
+	github.com/fsnotify/fsnotify v1.8.0
+	github.com/stretchr/testify v1.8.2
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.8.2 // indirect
+	golang.org/x/sys v0.13.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
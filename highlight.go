@@ -0,0 +1,254 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Types of the semantic tokens, named like the ones of the Language Server
+// Protocol
+const (
+	SemanticComment   = "comment"
+	SemanticKeyword   = "keyword"
+	SemanticNamespace = "namespace" // Names of blocks
+	SemanticProperty  = "property"  // Names of attributes
+	SemanticVariable  = "variable"  // References and local variables
+	SemanticFunction  = "function"
+	SemanticString    = "string"
+	SemanticNumber    = "number"
+	SemanticOperator  = "operator"
+)
+
+// SemanticTokenTypes is the legend of the semantic token types, in the
+// order EncodeSemanticTokens numbers them
+var SemanticTokenTypes = []string{
+	SemanticComment,
+	SemanticKeyword,
+	SemanticNamespace,
+	SemanticProperty,
+	SemanticVariable,
+	SemanticFunction,
+	SemanticString,
+	SemanticNumber,
+	SemanticOperator,
+}
+
+// SemanticToken is a part of a document classified for an editor
+type SemanticToken struct {
+	// Where the token is, always within a line
+	Range Range
+
+	// One of the SemanticTokenTypes
+	Type string
+}
+
+// Highlight classifies the parts of a CAFE document for syntax
+// highlighting, in the order they appear
+// Unlike the tokens of Lex, expressions are split into their functions,
+// references, literals and operators
+func Highlight(src []byte) ([]SemanticToken, error) {
+	tokens, err := Lex(src)
+	if err != nil {
+		return nil, err
+	}
+	return highlightTokens(tokens), nil
+}
+
+// Classifies the parts of the tokens of a document
+func highlightTokens(tokens []Token) []SemanticToken {
+	semantic := []SemanticToken{}
+	for _, token := range tokens {
+		start := token.Range.Start
+		add := func(offset int, length int, tokenType string) {
+			semantic = append(semantic, SemanticToken{
+				Range: Range{
+					Start: Position{Line: start.Line, Column: start.Column + offset},
+					End:   Position{Line: start.Line, Column: start.Column + offset + length},
+				},
+				Type: tokenType,
+			})
+		}
+
+		switch token.Kind {
+		case TokenComment:
+			// Block comments are split in their lines, tokens can't span
+			// lines
+			for i, line := range strings.Split(token.Value, "\n") {
+				column := 1
+				if i == 0 {
+					column = start.Column
+				}
+				semantic = append(semantic, SemanticToken{
+					Range: Range{
+						Start: Position{Line: start.Line + i, Column: column},
+						End:   Position{Line: start.Line + i, Column: column + len([]rune(line))},
+					},
+					Type: SemanticComment,
+				})
+			}
+		case TokenAttribute:
+			if name := strings.TrimPrefix(token.Value, "let "); name != token.Value {
+				add(0, len("let"), SemanticKeyword)
+				add(len([]rune(token.Value))-len([]rune(name)), len([]rune(name)), SemanticVariable)
+				continue
+			}
+			key, operator := splitAssignment(token.Value)
+			add(0, len([]rune(key)), SemanticProperty)
+			if operator != "" {
+				add(len([]rune(token.Value))-1, 1, SemanticOperator)
+			}
+		case TokenBlockStart:
+			if token.Value == "{" {
+				// Blocks of arrays have no name
+				continue
+			}
+			if token.Value == "vars" {
+				add(0, len(token.Value), SemanticKeyword)
+				continue
+			}
+			if profileRegexp.MatchString(token.Value) {
+				highlightExpression(token.Value, add, map[string]bool{"profile": true})
+				continue
+			}
+			if templateRegexp.MatchString(token.Value) {
+				highlightExpression(token.Value, add, map[string]bool{"template": true})
+				continue
+			}
+			if forBlockRegexp.MatchString(token.Value) || dynamicBlockRegexp.MatchString(token.Value) {
+				highlightExpression(token.Value, add, map[string]bool{"in": true, "block": true})
+				continue
+			}
+			add(0, len([]rune(token.Value)), SemanticNamespace)
+		case TokenString:
+			add(0, len([]rune(token.Value)), SemanticString)
+		case TokenMultilineString:
+			// Only the first line, tokens can't span lines
+			add(0, len([]rune(strings.SplitN(token.Value, "\n", 2)[0])), SemanticString)
+		case TokenInt, TokenFloat:
+			add(0, len([]rune(token.Value)), SemanticNumber)
+		case TokenBool:
+			add(0, len([]rune(token.Value)), SemanticKeyword)
+		case TokenInclude, TokenReference, TokenArrayElement, TokenArithmetic, TokenComparison, TokenCondition, TokenFunction:
+			highlightExpression(token.Value, add, nil)
+		case TokenUse:
+			highlightExpression(token.Value, add, map[string]bool{"use": true})
+		}
+	}
+	return semantic
+}
+
+// Classifies the parts of an expression, calling add with their offsets
+// in runes
+// Names in extraKeywords are keywords too
+func highlightExpression(expr string, add func(offset int, length int, tokenType string), extraKeywords map[string]bool) {
+	runes := []rune(expr)
+	isOperator := func(r rune) bool {
+		return strings.ContainsRune("+-*/%=!<>&|?:", r)
+	}
+	isName := func(r rune) bool {
+		return r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+		switch {
+		case r == '"':
+			// Strings end at the next unescaped quote
+			for i++; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' {
+					i++
+				}
+			}
+			i++
+			if i > len(runes) {
+				i = len(runes)
+			}
+			add(start, i-start, SemanticString)
+		case unicode.IsDigit(r):
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			add(start, i-start, SemanticNumber)
+		case r == '_' || unicode.IsLetter(r):
+			for i < len(runes) && isName(runes[i]) {
+				i++
+			}
+			name := string(runes[start:i])
+			switch {
+			case equalsToMany(name, keywords) || extraKeywords[name]:
+				add(start, i-start, SemanticKeyword)
+			case i < len(runes) && runes[i] == '(':
+				add(start, i-start, SemanticFunction)
+			default:
+				add(start, i-start, SemanticVariable)
+			}
+		case isOperator(r):
+			for i < len(runes) && isOperator(runes[i]) {
+				i++
+			}
+			add(start, i-start, SemanticOperator)
+		default:
+			i++
+		}
+	}
+}
+
+// EncodeSemanticTokens encodes semantic tokens like the data of the
+// semantic tokens of the Language Server Protocol: five numbers per token,
+// the line and the start of the token relative to the previous one, its
+// length, the index of its type in SemanticTokenTypes and no modifiers
+// Lines and columns are counted from 0, and columns in characters, which
+// are UTF-16 code units for most documents
+func EncodeSemanticTokens(tokens []SemanticToken) []uint32 {
+	sorted := append([]SemanticToken{}, tokens...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].Range.Start, sorted[j].Range.Start
+		return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+	})
+
+	data := make([]uint32, 0, len(sorted)*5)
+	previous := Position{Line: 1, Column: 1}
+	for _, token := range sorted {
+		start := token.Range.Start
+		deltaLine := start.Line - previous.Line
+		deltaStart := start.Column - 1
+		if deltaLine == 0 {
+			deltaStart = start.Column - previous.Column
+		}
+		data = append(data,
+			uint32(deltaLine),
+			uint32(deltaStart),
+			uint32(token.Range.End.Column-start.Column),
+			uint32(semanticTypeIndex(token.Type)),
+			0,
+		)
+		previous = start
+	}
+	return data
+}
+
+// Index of a type in SemanticTokenTypes
+func semanticTypeIndex(tokenType string) int {
+	for i, t := range SemanticTokenTypes {
+		if t == tokenType {
+			return i
+		}
+	}
+	return 0
+}
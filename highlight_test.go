@@ -0,0 +1,125 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHighlight(t *testing.T) {
+	src := `// config
+let host = "localhost"
+port = 8080
+server {
+    up = upper(host)
+    ok = if port >= 80 ? true : false
+}
+`
+	tokens, err := Highlight([]byte(src))
+	assert.NoError(t, err)
+	assert.Equal(t, []SemanticToken{
+		{Range{Position{1, 1}, Position{1, 10}}, SemanticComment},
+		{Range{Position{2, 1}, Position{2, 4}}, SemanticKeyword},
+		{Range{Position{2, 5}, Position{2, 9}}, SemanticVariable},
+		{Range{Position{2, 12}, Position{2, 23}}, SemanticString},
+		{Range{Position{3, 1}, Position{3, 5}}, SemanticProperty},
+		{Range{Position{3, 8}, Position{3, 12}}, SemanticNumber},
+		{Range{Position{4, 1}, Position{4, 7}}, SemanticNamespace},
+		{Range{Position{5, 5}, Position{5, 7}}, SemanticProperty},
+		{Range{Position{5, 10}, Position{5, 15}}, SemanticFunction},
+		{Range{Position{5, 16}, Position{5, 20}}, SemanticVariable},
+		{Range{Position{6, 5}, Position{6, 7}}, SemanticProperty},
+		{Range{Position{6, 10}, Position{6, 12}}, SemanticKeyword},
+		{Range{Position{6, 13}, Position{6, 17}}, SemanticVariable},
+		{Range{Position{6, 18}, Position{6, 20}}, SemanticOperator},
+		{Range{Position{6, 21}, Position{6, 23}}, SemanticNumber},
+		{Range{Position{6, 24}, Position{6, 25}}, SemanticOperator},
+		{Range{Position{6, 26}, Position{6, 30}}, SemanticKeyword},
+		{Range{Position{6, 31}, Position{6, 32}}, SemanticOperator},
+		{Range{Position{6, 33}, Position{6, 38}}, SemanticKeyword},
+	}, tokens)
+
+	// Profiles and vars blocks start with keywords
+	tokens, err = Highlight([]byte("profile \"prod\" {\n    vars {\n    }\n}\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []SemanticToken{
+		{Range{Position{1, 1}, Position{1, 8}}, SemanticKeyword},
+		{Range{Position{1, 9}, Position{1, 15}}, SemanticString},
+		{Range{Position{2, 5}, Position{2, 9}}, SemanticKeyword},
+	}, tokens)
+
+	// Templates and their uses start with keywords
+	tokens, err = Highlight([]byte("template web(port) {\n}\na {\n    use web(80)\n}\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []SemanticToken{
+		{Range{Position{1, 1}, Position{1, 9}}, SemanticKeyword},
+		{Range{Position{1, 10}, Position{1, 13}}, SemanticFunction},
+		{Range{Position{1, 14}, Position{1, 18}}, SemanticVariable},
+		{Range{Position{3, 1}, Position{3, 2}}, SemanticNamespace},
+		{Range{Position{4, 5}, Position{4, 8}}, SemanticKeyword},
+		{Range{Position{4, 9}, Position{4, 12}}, SemanticFunction},
+		{Range{Position{4, 13}, Position{4, 15}}, SemanticNumber},
+	}, tokens)
+
+	// For blocks and blocks named by expressions
+	tokens, err = Highlight([]byte("for r in regions {\n    block r {\n    }\n}\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []SemanticToken{
+		{Range{Position{1, 1}, Position{1, 4}}, SemanticKeyword},
+		{Range{Position{1, 5}, Position{1, 6}}, SemanticVariable},
+		{Range{Position{1, 7}, Position{1, 9}}, SemanticKeyword},
+		{Range{Position{1, 10}, Position{1, 17}}, SemanticVariable},
+		{Range{Position{2, 5}, Position{2, 10}}, SemanticKeyword},
+		{Range{Position{2, 11}, Position{2, 12}}, SemanticVariable},
+	}, tokens)
+
+	// Append and default assignments
+	tokens, err = Highlight([]byte("tags += [\"a\"]\nport ?= 80\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []SemanticToken{
+		{Range{Position{1, 1}, Position{1, 5}}, SemanticProperty},
+		{Range{Position{1, 6}, Position{1, 7}}, SemanticOperator},
+		{Range{Position{1, 10}, Position{1, 13}}, SemanticString},
+		{Range{Position{2, 1}, Position{2, 5}}, SemanticProperty},
+		{Range{Position{2, 6}, Position{2, 7}}, SemanticOperator},
+		{Range{Position{2, 9}, Position{2, 11}}, SemanticNumber},
+	}, tokens)
+
+	// Block comments are split in their lines
+	tokens, err = Highlight([]byte("port = 80 # port\n/* a\n   b */\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []SemanticToken{
+		{Range{Position{1, 1}, Position{1, 5}}, SemanticProperty},
+		{Range{Position{1, 8}, Position{1, 10}}, SemanticNumber},
+		{Range{Position{1, 11}, Position{1, 17}}, SemanticComment},
+		{Range{Position{2, 1}, Position{2, 5}}, SemanticComment},
+		{Range{Position{3, 1}, Position{3, 8}}, SemanticComment},
+	}, tokens)
+}
+
+func TestEncodeSemanticTokens(t *testing.T) {
+	tokens := []SemanticToken{
+		{Range{Position{2, 5}, Position{2, 9}}, SemanticVariable},
+		{Range{Position{1, 1}, Position{1, 10}}, SemanticComment},
+		{Range{Position{2, 12}, Position{2, 23}}, SemanticString},
+	}
+	assert.Equal(t, []uint32{
+		0, 0, 9, 0, 0,
+		1, 4, 4, 4, 0,
+		0, 7, 11, 6, 0,
+	}, EncodeSemanticTokens(tokens))
+}
@@ -0,0 +1,154 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Converts the parsed CAFE file to JSON
+// Attributes become object members and blocks become nested objects
+// Secrets are redacted
+func (p *Parser) ToJSON() ([]byte, error) {
+	return json.Marshal(p.redactSecrets(bodyToJSON(p.Attributes, p.Blocks)))
+}
+
+// Converts the parsed CAFE file to plain nested data, for libraries
+// working on generic JSON-like structures
+// Blocks become map[string]interface{} values and arrays []interface{}
+// values, copied so changing them doesn't change the document
+func (p *Parser) ToMap() map[string]interface{} {
+	return copyValue(bodyToJSON(p.Attributes, p.Blocks)).(map[string]interface{})
+}
+
+// Returns a deep copy of the maps and arrays of a value
+func copyValue(value interface{}) interface{} {
+	switch val := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(val))
+		for key, elem := range val {
+			copied[key] = copyValue(elem)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(val))
+		for i, elem := range val {
+			copied[i] = copyValue(elem)
+		}
+		return copied
+	default:
+		return value
+	}
+}
+
+// Builds the JSON representation of the attributes and blocks of a body
+func bodyToJSON(attributes map[string]Attribute, blocks map[string]Block) map[string]interface{} {
+	obj := map[string]interface{}{}
+	for name, attr := range attributes {
+		obj[name] = attr.Value
+	}
+	for name, b := range blocks {
+		obj[name] = bodyToJSON(b.Attributes, b.Blocks)
+	}
+	return obj
+}
+
+// Creates a Parser from a JSON object
+// Nested objects become blocks and every other member becomes an attribute
+func FromJSON(data []byte) (*Parser, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var obj map[string]interface{}
+	if err := decoder.Decode(&obj); err != nil {
+		return nil, err
+	}
+
+	p := newParser(nil)
+	if err := bodyFromJSON(obj, p.Attributes, p.Blocks); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Fills the attributes and blocks of a body from a JSON object
+func bodyFromJSON(obj map[string]interface{}, attributes map[string]Attribute, blocks map[string]Block) error {
+	for name, member := range obj {
+		if nested, isObject := member.(map[string]interface{}); isObject {
+			newBlock := Block{
+				Name:       name,
+				Attributes: map[string]Attribute{},
+				Blocks:     map[string]Block{},
+			}
+			if err := bodyFromJSON(nested, newBlock.Attributes, newBlock.Blocks); err != nil {
+				return err
+			}
+			blocks[name] = newBlock
+			continue
+		}
+
+		value, kind, err := valueFromJSON(member)
+		if err != nil {
+			return fmt.Errorf("member %s: %w", name, err)
+		}
+		attributes[name] = Attribute{
+			Name:  name,
+			Value: value,
+			kind:  kind,
+		}
+	}
+	return nil
+}
+
+// Transforms a JSON value into an attribute value
+func valueFromJSON(member interface{}) (interface{}, attrKind, error) {
+	switch val := member.(type) {
+	case string:
+		return val, attrString, nil
+	case bool:
+		return val, attrBool, nil
+	case json.Number:
+		if valInt, err := strconv.Atoi(val.String()); err == nil {
+			return valInt, attrInt, nil
+		}
+		valFloat, err := val.Float64()
+		if err != nil {
+			return nil, attrNIL, err
+		}
+		return valFloat, attrFloat, nil
+	case []interface{}:
+		elems := make([]interface{}, len(val))
+		for i, elem := range val {
+			value, kind, err := valueFromJSON(elem)
+			if err != nil {
+				return nil, attrNIL, err
+			}
+			if kind == attrArray {
+				return nil, attrNIL, fmt.Errorf("arrays can't be nested")
+			}
+			elems[i] = value
+		}
+		return elems, attrArray, nil
+	case nil:
+		return nil, attrNIL, nil
+	case map[string]interface{}:
+		return nil, attrNIL, fmt.Errorf("objects inside arrays are not supported")
+	default:
+		return nil, attrNIL, fmt.Errorf("unsupported JSON value %v", member)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSON(t *testing.T) {
+	p, err := DecodeBytes([]byte("a = 1\nb = [\"x\", 2.5]\nc {\n    d = true\n}\n"))
+	assert.NoError(t, err)
+
+	data, err := p.ToJSON()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"a": 1, "b": ["x", 2.5], "c": {"d": true}}`, string(data))
+
+	fromJSON, err := FromJSON(data)
+	assert.NoError(t, err)
+	assert.Equal(t, Attribute{Name: "a", Value: 1, kind: attrInt}, fromJSON.Attributes["a"])
+	assert.Equal(t, Attribute{Name: "b", Value: []interface{}{"x", 2.5}, kind: attrArray}, fromJSON.Attributes["b"])
+	assert.Equal(t, Attribute{Name: "d", Value: true, kind: attrBool}, fromJSON.Blocks["c"].Attributes["d"])
+
+	_, err = FromJSON([]byte(`{"a": [{"b": 1}]}`))
+	assert.Error(t, err)
+
+	// Nulls
+	fromJSON, err = FromJSON([]byte(`{"a": null, "b": [1, null]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, Attribute{Name: "a", Value: nil, kind: attrNIL}, fromJSON.Attributes["a"])
+	var out bytes.Buffer
+	assert.NoError(t, NewEncoder(&out).Encode(fromJSON))
+	assert.Equal(t, "a = null\nb = [1, null]\n", out.String())
+}
+
+func TestToMap(t *testing.T) {
+	p, err := DecodeBytes([]byte(`name = "cafe"
+tags = ["web", "api"]
+servers = [{ host = "a" }]
+server {
+    port = 8080
+    tls {
+        enabled = true
+    }
+}
+`))
+	assert.NoError(t, err)
+
+	m := p.ToMap()
+	assert.Equal(t, map[string]interface{}{
+		"name":    "cafe",
+		"tags":    []interface{}{"web", "api"},
+		"servers": []interface{}{map[string]interface{}{"host": "a"}},
+		"server": map[string]interface{}{
+			"port": 8080,
+			"tls":  map[string]interface{}{"enabled": true},
+		},
+	}, m)
+
+	// The result is a copy
+	m["tags"].([]interface{})[0] = "changed"
+	m["servers"].([]interface{})[0].(map[string]interface{})["host"] = "changed"
+	assert.Equal(t, []interface{}{"web", "api"}, p.Attributes["tags"].Value)
+	assert.Equal(t, []interface{}{map[string]interface{}{"host": "a"}}, p.Attributes["servers"].Value)
+}
@@ -0,0 +1,117 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// Version of the JSON Schema documents written by JSONSchema
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// JSONSchema infers a JSON Schema from an example document, so editors and
+// validators can check the documents converted to JSON with ToJSON
+// Every attribute and block of the example is required, and doc comments
+// become descriptions
+func JSONSchema(p *Parser) ([]byte, error) {
+	schema := bodySchema("", p.Attributes, p.Blocks)
+	schema["$schema"] = jsonSchemaDraft
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+// Builds the schema of the object of a body
+func bodySchema(doc string, attributes map[string]Attribute, blocks map[string]Block) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for name, attr := range attributes {
+		property := valueSchema(attr.Value)
+		if attr.Doc != "" {
+			property["description"] = attr.Doc
+		}
+		properties[name] = property
+	}
+	for name, b := range blocks {
+		properties[name] = bodySchema(b.Doc, b.Attributes, b.Blocks)
+	}
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if required := sortedKeys(properties); len(required) > 0 {
+		schema["required"] = required
+	}
+	if doc != "" {
+		schema["description"] = doc
+	}
+	return schema
+}
+
+// Builds the schema of a value
+func valueSchema(value interface{}) map[string]interface{} {
+	switch val := value.(type) {
+	case string:
+		return map[string]interface{}{"type": "string"}
+	case int:
+		return map[string]interface{}{"type": "integer"}
+	case float64:
+		return map[string]interface{}{"type": "number"}
+	case bool:
+		return map[string]interface{}{"type": "boolean"}
+	case nil:
+		return map[string]interface{}{"type": "null"}
+	case []interface{}:
+		schema := map[string]interface{}{"type": "array"}
+		if items := itemsSchema(val); items != nil {
+			schema["items"] = items
+		}
+		return schema
+	case map[string]interface{}:
+		properties := map[string]interface{}{}
+		for name, field := range val {
+			properties[name] = valueSchema(field)
+		}
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if required := sortedKeys(properties); len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return map[string]interface{}{}
+}
+
+// Builds the schema shared by all the elements of an array, nil if they
+// don't share one
+// Arrays of integers and numbers are arrays of numbers
+func itemsSchema(array []interface{}) map[string]interface{} {
+	var items map[string]interface{}
+	for _, elem := range array {
+		schema := valueSchema(elem)
+		switch {
+		case items == nil || reflect.DeepEqual(items, schema):
+			items = schema
+		case isNumberSchema(items) && isNumberSchema(schema):
+			items = map[string]interface{}{"type": "number"}
+		default:
+			return nil
+		}
+	}
+	return items
+}
+
+// Reports whether a schema is the one of an integer or a number
+func isNumberSchema(schema map[string]interface{}) bool {
+	return schema["type"] == "integer" || schema["type"] == "number"
+}
@@ -0,0 +1,61 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONSchema(t *testing.T) {
+	p, err := DecodeBytes([]byte(`/// Name of the application
+name = "cafe"
+ratio = 0.5
+ports = [80, 443]
+weights = [1, 0.5]
+tags = ["web", 1]
+/// Web server
+server {
+    debug = false
+    fallback = null
+}
+`))
+	assert.NoError(t, err)
+
+	schema, err := JSONSchema(p)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "description": "Name of the application"},
+			"ratio": {"type": "number"},
+			"ports": {"type": "array", "items": {"type": "integer"}},
+			"weights": {"type": "array", "items": {"type": "number"}},
+			"tags": {"type": "array"},
+			"server": {
+				"type": "object",
+				"description": "Web server",
+				"properties": {
+					"debug": {"type": "boolean"},
+					"fallback": {"type": "null"}
+				},
+				"required": ["debug", "fallback"]
+			}
+		},
+		"required": ["name", "ports", "ratio", "server", "tags", "weights"]
+	}`, string(schema))
+}
@@ -15,9 +15,12 @@
 package cafe
 
 import (
-	"fmt"
+	"bufio"
+	"io"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 // keyKind defines all kinds of possible keys in an CAFE file
@@ -45,6 +48,8 @@ const (
 	keyComparison                 // 18
 	keyCondition                  // 19
 	keyFunction                   // 20
+	keyInclude                    // 21
+	keyUse                        // 22
 )
 
 // position is the position of the parser.
@@ -52,6 +57,9 @@ type position struct {
 	// Line number starting in 1
 	Line int
 
+	// Column of the first byte in its line, starting in 1
+	Column int
+
 	// First byte
 	Start int
 
@@ -60,13 +68,13 @@ type position struct {
 }
 
 // An item differs to a prototype because it requires the value to
-// be an array of strings, instead of the whole string
+// be a slice of runes, instead of the whole string
 type prototype struct {
 	// Kind of the item
 	kind keyKind
 
-	// Item value in array of string
-	value []string
+	// Runes of the item value
+	value []rune
 
 	// position of the item
 	position position
@@ -88,7 +96,7 @@ type item struct {
 // Lexer reads an array of bytes and decodes it to an array of items
 type lexer struct {
 	// The raw input corresponding to an CAFE file
-	input []string
+	input []rune
 
 	// All items of the CAFE file
 	items []item
@@ -103,18 +111,45 @@ type lexer struct {
 	currentByteIndex int
 
 	// Current byte of the lexer
-	currentByte string
+	currentByte rune
 
 	// Index of the last EOL
 	lastEOL int
 
 	// Emits true when in EOF
 	atEOF bool
+
+	// Reports the number of characters lexed, if not nil
+	progress func(done, total int)
+
+	// Shares the memory of repeated item values, if not nil
+	strings interner
+
+	// How calls to the functions start, as in "upper("
+	callPrefixes []string
+
+	// Lines of the input, quoted by the errors
+	lines []string
+
+	// The input as a string if it's all ASCII, whose memory the values of
+	// the items share instead of copying their runes
+	text string
+
+	// Index of the item closing the last block element of an array, which
+	// more elements or the end of the array can follow
+	arrayBlockEnd int
 }
 
 // Creates a lexer
-func newLexer(input []string) *lexer {
-	return &lexer{
+// An input not ending in an EOL gets one, so its last line is lexed like
+// the others
+func newLexer(input []rune) *lexer {
+	input = normalizeInput(input)
+	if len(input) == 0 || input[len(input)-1] != '\n' {
+		input = append(input[:len(input):len(input)], '\n')
+	}
+	text := string(input)
+	l := &lexer{
 		input:            input,
 		items:            []item{},
 		proto:            prototype{kind: keyNIL},
@@ -123,7 +158,112 @@ func newLexer(input []string) *lexer {
 		currentByte:      input[0],
 		lastEOL:          0,
 		atEOF:            false,
+		lines:            strings.Split(text, "\n"),
+		arrayBlockEnd:    -1,
+	}
+	if len(text) == len(input) {
+		l.text = text
+	}
+	return l
+}
+
+// Returns the runes of a value of the input as a string
+// Values are slices of the input, so where they start is told by their
+// capacity, and an ASCII input gives them the memory of its string
+func (l *lexer) valueString(value []rune) string {
+	if l.strings != nil || l.text == "" {
+		return l.strings.internRunes(value)
+	}
+	start := cap(l.input) - cap(value)
+	return l.text[start : start+len(value)]
+}
+
+// Byte order mark some editors start UTF-8 files with
+const byteOrderMark = '\uFEFF'
+
+// Returns an input without its byte order mark, and with its Windows line
+// endings (CRLF) turned into EOLs, so files saved on any platform are lexed
+// alike
+func normalizeInput(input []rune) []rune {
+	if len(input) > 0 && input[0] == byteOrderMark {
+		input = input[1:]
+	}
+	crlf := false
+	for i := 0; i+1 < len(input) && !crlf; i++ {
+		crlf = input[i] == '\r' && input[i+1] == '\n'
+	}
+	if !crlf {
+		return input
+	}
+
+	normalized := make([]rune, 0, len(input))
+	for i, r := range input {
+		if r == '\r' && i+1 < len(input) && input[i+1] == '\n' {
+			continue
+		}
+		normalized = append(normalized, r)
+	}
+	return normalized
+}
+
+// Returns a line read from an input normalized like normalizeInput
+func normalizeLine(line string, first bool) string {
+	if first {
+		line = strings.TrimPrefix(line, string(byteOrderMark))
+	}
+	if strings.HasSuffix(line, "\r\n") {
+		line = strings.TrimSuffix(line, "\r\n") + "\n"
 	}
+	return line
+}
+
+// Adds the position of the current byte to the panics of the lexer, which
+// are then *ParseError values
+func (l *lexer) positionPanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	err, isEvalError := r.(*evalError)
+	if !isEvalError {
+		panic(r)
+	}
+	panic(&ParseError{
+		Line:    l.currentLine,
+		Col:     l.column(l.currentByteIndex),
+		Length:  1,
+		Message: err.Error(),
+		Snippet: l.line(l.currentLine),
+		Err:     err,
+	})
+}
+
+// Number of whitespace characters at the start of a value, which aren't
+// part of its item
+func leadingSpace(value []rune) int {
+	n := 0
+	for n < len(value) && unicode.IsSpace(value[n]) {
+		n++
+	}
+	return n
+}
+
+// Returns a value without the whitespace around it
+func trimSpace(value []rune) []rune {
+	value = value[leadingSpace(value):]
+	end := len(value)
+	for end > 0 && unicode.IsSpace(value[end-1]) {
+		end--
+	}
+	return value[:end]
+}
+
+// Returns a line of the input, starting in 1
+func (l *lexer) line(n int) string {
+	if n < 1 || n > len(l.lines) {
+		return ""
+	}
+	return strings.TrimSuffix(l.lines[n-1], "\r")
 }
 
 // Gets the start of the next item of the input in a lexer
@@ -135,11 +275,12 @@ func (l *lexer) next(whitespace bool, eol bool) {
 		// Add required values to proto's position
 		l.proto.position.Line = l.currentLine
 		l.proto.position.Start = l.currentByteIndex
+		l.proto.position.Column = l.column(l.proto.position.Start) + leadingSpace(l.proto.value)
 
 		// Create new item based on the prototype
 		newItem := item{
 			kind:     l.proto.kind,
-			value:    strings.TrimSpace(strings.Join(l.proto.value, "")),
+			value:    l.valueString(trimSpace(l.proto.value)),
 			position: l.proto.position,
 		}
 
@@ -147,16 +288,20 @@ func (l *lexer) next(whitespace bool, eol bool) {
 		l.proto = prototype{kind: keyNIL}
 	}
 
-	lastItem := l.items[len(l.items)-1]
-	lastItemLastByte := lastItem.position.Start + lastItem.position.Length
-	if lastItemLastByte == len(l.input)+1 {
-		panic("ERROR in lexer: next called at EOF")
+	// Whitespace can come before the first item
+	lastItemLastByte := l.currentByteIndex
+	if len(l.items) > 0 {
+		lastItem := l.items[len(l.items)-1]
+		lastItemLastByte = lastItem.position.Start + lastItem.position.Length
+		if lastItemLastByte == len(l.input)+1 {
+			panic("ERROR in lexer: next called at EOF")
+		}
 	}
 
 	// Check EOL
 	if eol {
 		l.currentLine += 1
-		_, lastEOLIndex := l.peekAndFind("\n")
+		_, lastEOLIndex := l.peekAndFind('\n')
 		l.lastEOL = lastEOLIndex
 	}
 
@@ -181,63 +326,56 @@ func (l *lexer) next(whitespace bool, eol bool) {
 	}
 }
 
+// Column of a byte of the input, counting from the last EOL before it
+func (l *lexer) column(index int) int {
+	lineStart := index
+	for lineStart > 0 && lineStart <= len(l.input) && l.input[lineStart-1] != '\n' {
+		lineStart--
+	}
+	return index - lineStart + 1
+}
+
 // Gets the start of the next item of the input in a lexer
 // DOES NOT MOVE THE LEXER
 // Returns the first byte of the next item
-func (l *lexer) peek() string {
+func (l *lexer) peek() rune {
 	return l.input[l.currentByteIndex+1]
 }
 
 // Peek and search for a key until EOL
-// Here the argument is a simple string since
+// Here the argument is a simple rune since
 // not necessarily a key needs to be found
 // If the key was not found, returns (false, 0)
 // If the key was found, returns (true, index)
-func (l *lexer) peekAndFind(key string) (bool, int) {
-	for i, v := range l.input {
-		if i > l.currentByteIndex {
-			if v == "\n" && key != "\n" {
-				return false, 0
-			}
-			if v == key {
-				return true, i
-			}
-		}
-	}
-	return false, 0
+func (l *lexer) peekAndFind(key rune) (bool, int) {
+	return l.peekAndFindAfter(key, l.currentByteIndex)
 }
 
 // Peek and search for a key until EOL
 // Only start looking after the given index
 // If the key was not found, returns (false, 0)
 // If the key was found, returns (true, index)
-func (l *lexer) peekAndFindAfter(key string, startLookingAfter int) (bool, int) {
-	for i, v := range l.input {
-		if i > l.currentByteIndex && i > startLookingAfter {
-			if v == "\n" && key != "\n" {
-				return false, 0
-			}
-			if v == key {
-				return true, i
-			}
+func (l *lexer) peekAndFindAfter(key rune, startLookingAfter int) (bool, int) {
+	for i := l.searchStart(startLookingAfter); i < len(l.input); i++ {
+		v := l.input[i]
+		if v == '\n' && key != '\n' {
+			return false, 0
+		}
+		if v == key {
+			return true, i
 		}
 	}
 	return false, 0
 }
 
 // Similar to peekAndFind, this will search for multiple keys
-// until EOL
-// For this function, a check for EOL is not made
-func (l *lexer) peekAndFindMany(keys []string) bool {
-	for i, v := range l.input {
-		if i > l.currentByteIndex {
-			if v == "\n" {
-				return false
-			}
-			for _, key := range keys {
-				if v == key {
-					return true
-				}
+// until EOL or a trailing comment, whose text isn't part of the value
+func (l *lexer) peekAndFindMany(keys []rune) bool {
+	for i, end := l.currentByteIndex+1, l.valueEnd(); i < end; i++ {
+		v := l.input[i]
+		for _, key := range keys {
+			if v == key {
+				return true
 			}
 		}
 	}
@@ -248,75 +386,131 @@ func (l *lexer) peekAndFindMany(keys []string) bool {
 // Do not use peekAndFind here as EOL can be next to EOF,
 // which would cause errors if peekAndFind was used
 func (l *lexer) peekEOL() int {
-	for i, v := range l.input {
-		if i > l.currentByteIndex {
-			if v == "\n" {
-				return i
-			}
-			if i == len(l.input)-1 {
-				return i
-			}
+	for i := l.currentByteIndex + 1; i < len(l.input); i++ {
+		if l.input[i] == '\n' || i == len(l.input)-1 {
+			return i
 		}
 	}
 	return 0
 }
 
 // Find next comment before EOL
+// Comment markers inside quotes are part of a string, as in "#fff"
 func (l *lexer) peekComment(startLookingAfter int) (bool, int) {
-	for i, v := range l.input {
-		if i > l.currentByteIndex && i > startLookingAfter {
-			if v == "\n" {
-				return false, 0
-			}
-			if v == "/" {
-				if l.input[i+1] == "/" {
-					return true, i
-				}
-			}
+	start := l.searchStart(startLookingAfter)
+	inString := false
+	for i := l.currentByteIndex; i < len(l.input); i++ {
+		switch {
+		case l.input[i] == '\n':
+			return false, 0
+		case l.input[i] == '"' && (i == 0 || l.input[i-1] != '\\'):
+			inString = !inString
+		case !inString && i >= start && l.commentStart(i):
+			return true, i
 		}
 	}
 	return false, 0
 }
 
+// Index where the value at the current byte ends: the start of its
+// trailing comment, or the next EOL
+func (l *lexer) valueEnd() int {
+	if hasComment, commentIndex := l.peekComment(l.currentByteIndex); hasComment {
+		return commentIndex
+	}
+	return l.peekEOL()
+}
+
+// Reports if a comment starts at an index of the input, with the //, /*
+// or # sequences
+func (l *lexer) commentStart(i int) bool {
+	switch l.input[i] {
+	case '#':
+		return true
+	case '/':
+		return i+1 < len(l.input) && (l.input[i+1] == '/' || l.input[i+1] == '*')
+	}
+	return false
+}
+
+// First index a search after both the current byte and another index
+// looks at
+func (l *lexer) searchStart(startLookingAfter int) int {
+	if startLookingAfter < l.currentByteIndex {
+		startLookingAfter = l.currentByteIndex
+	}
+	return startLookingAfter + 1
+}
+
 // Checks the previous byte on the input
-func (l *lexer) previousByte() string {
+func (l *lexer) previousByte() rune {
 	if l.currentByteIndex == 0 {
-		return "\n"
+		return '\n'
 	}
 	return l.input[l.currentByteIndex-1]
 }
 
 // Checks the previous item of the byte
 // Returns an item
+// Before the first item, it's an item of no kind
 func (l *lexer) previousItem() *item {
+	if len(l.items) == 0 {
+		return &item{kind: keyNIL}
+	}
 	return &l.items[len(l.items)-1]
 }
 
+// Reports if the previous item, besides comments, is the start or an
+// element of an array
+func (l *lexer) inArray() bool {
+	last := len(l.items) - 1
+	for last >= 0 && l.items[last].kind == keyComment {
+		last--
+	}
+	if last < 0 {
+		return false
+	}
+	kind := l.items[last].kind
+	return kind == keyArrayStart || kind == keyArrayElem || last == l.arrayBlockEnd
+}
+
+// Reports if only whitespaces come between the last EOL and the current
+// byte
+func (l *lexer) atLineStart() bool {
+	if l.previousByte() == '\n' {
+		return true
+	}
+	for i := l.lastEOL + 1; i < l.currentByteIndex; i++ {
+		if !isBlank(l.input[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 // Searches valid characters between an index and an EOL in the lexer
 func (l *lexer) searchNonWhitespacedValue() (string, int, int) {
 	firstIndex := 0
 	lastIndex := 0
-	for i, v := range l.input {
-		if i >= l.currentByteIndex {
-			// Get first non-whitespace
-			if firstIndex == 0 && (v != " " && v != "\n") {
-				if firstIndex == 0 {
-					firstIndex = i
-				}
-			}
+	for i := l.currentByteIndex; i < len(l.input); i++ {
+		v := l.input[i]
 
-			// Find last whitespace and set the lastIndex to the
-			// previous index
-			if (firstIndex != 0 && lastIndex == 0) && (v == " " || v == "\n") {
-				lastIndex = i
-				break
-			}
+		// Get first non-whitespace
+		if firstIndex == 0 && (!isBlank(v) && v != '\n') {
+			firstIndex = i
+		}
 
-			// Last rune in the input
-			if i == len(l.input)-1 {
-				lastIndex = i + 1
-				break
-			}
+		// Find last whitespace and set the lastIndex to the
+		// previous index
+		if (firstIndex != 0 && lastIndex == 0) && (isBlank(v) || v == '\n') {
+			lastIndex = i
+			break
+		}
+
+		// Last rune in the input
+		if i == len(l.input)-1 {
+			lastIndex = i + 1
+			break
 		}
 	}
 
@@ -326,13 +520,15 @@ func (l *lexer) searchNonWhitespacedValue() (string, int, int) {
 	}
 
 	// Return concatenate value
-	return strings.TrimSpace(strings.Join(l.input[firstIndex:lastIndex], "")), firstIndex, lastIndex
+	return l.valueString(trimSpace(l.input[firstIndex:lastIndex])), firstIndex, lastIndex
 }
 
 // End of line (Unicode U+000A)
 func (l *lexer) lexEOL() bool {
-	if l.currentByte == "\n" && l.currentByteIndex != 0 {
+	if l.currentByte == '\n' && l.currentByteIndex != 0 {
+		eolIndex := l.currentByteIndex
 		l.next(true, true)
+		l.lastEOL = eolIndex
 		return true
 	}
 	return false
@@ -340,12 +536,8 @@ func (l *lexer) lexEOL() bool {
 
 // Tab (Unicode U+0009)
 func (l *lexer) lexTab() bool {
-	next4Characters := strings.Join(l.input[l.currentByteIndex:l.currentByteIndex+3], "")
-	if next4Characters == "    " {
-		// Call next 4 times to skip the tab
-		for i := 0; i < 4; i++ {
-			l.next(true, false)
-		}
+	if l.currentByte == '\t' {
+		l.next(true, false)
 		return true
 	}
 	return false
@@ -359,9 +551,15 @@ func (l *lexer) lexEOF() bool {
 	return false
 }
 
+// Reports if a rune is a space or a tab, which can separate the items of
+// a line and indent it
+func isBlank(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
 // Whitespace
 func (l *lexer) lexWhitespace() bool {
-	if l.currentByte == " " {
+	if l.currentByte == ' ' {
 		l.next(true, false)
 		return true
 	}
@@ -369,15 +567,15 @@ func (l *lexer) lexWhitespace() bool {
 }
 
 // Comment definition
-// Comments don't have to be preceded by an EOL,
-// but must be finished by one
+// Line comments start with // or #. They don't have to be preceded by
+// an EOL, but must be finished by one
 func (l *lexer) lexComment() bool {
-	if l.currentByte != "/" {
+	if !l.commentStart(l.currentByteIndex) {
 		return false
 	}
 
-	if l.peek() != "/" {
-		return false
+	if l.currentByte == '/' && l.peek() == '*' {
+		return l.lexBlockComment()
 	}
 
 	// Find EOL
@@ -396,8 +594,132 @@ func (l *lexer) lexComment() bool {
 	return true
 }
 
+// Block comment, from /* to the next */, which can span many lines
+// Statements can follow a block comment starting their line, as in
+// /* comment */ port = 80
+func (l *lexer) lexBlockComment() bool {
+	end := 0
+	for i := l.currentByteIndex + 2; i+1 < len(l.input); i++ {
+		if l.input[i] == '*' && l.input[i+1] == '/' {
+			end = i + 1
+			break
+		}
+	}
+	if end == 0 {
+		panic(evalErrorf(ErrUnclosedComment, "block comment is not closed"))
+	}
+
+	lines, lastEOL := 0, 0
+	for i := l.currentByteIndex; i < end; i++ {
+		if l.input[i] == '\n' {
+			lines, lastEOL = lines+1, i
+		}
+	}
+	startsLine := l.atLineStart()
+
+	// Create item as a prototype and call next
+	l.proto = prototype{
+		kind:  keyComment,
+		value: l.input[l.currentByteIndex : end+1],
+		position: position{
+			Length: end - l.currentByteIndex,
+		},
+	}
+	l.next(false, false)
+
+	// The EOLs of the comment are counted here
+	if lines > 0 {
+		l.currentLine += lines
+		l.lastEOL = lastEOL
+	}
+	if startsLine {
+		l.lastEOL = end
+	}
+	return true
+}
+
+// Keyword starting an include directive
+const includeKeyword = "include "
+
+// Include directive, as in include "common.cafe"
+// It has to be preceeded by an EOL or whitespaces only
+func (l *lexer) lexInclude() bool {
+	if l.currentByte != 'i' || l.currentByteIndex+len(includeKeyword) > len(l.input) {
+		return false
+	}
+	if string(l.input[l.currentByteIndex:l.currentByteIndex+len(includeKeyword)]) != includeKeyword {
+		return false
+	}
+
+	// Has to be proceeded by EOL or whitespaces
+	if !l.atLineStart() {
+		return false
+	}
+
+	// The path is the quoted string after the keyword
+	hasOpenQuote, openQuoteIndex := l.peekAndFind('"')
+	if !hasOpenQuote || strings.TrimSpace(string(l.input[l.currentByteIndex+len(includeKeyword):openQuoteIndex])) != "" {
+		return false
+	}
+	hasCloseQuote, closeQuoteIndex := l.peekAndFindAfter('"', openQuoteIndex)
+	if !hasCloseQuote {
+		return false
+	}
+
+	// Create prototype and call next
+	l.proto = prototype{
+		kind:  keyInclude,
+		value: l.input[l.currentByteIndex : closeQuoteIndex+1],
+		position: position{
+			Length: closeQuoteIndex - l.currentByteIndex,
+		},
+	}
+	l.next(false, false)
+	return true
+}
+
+// Keyword starting the use of a template
+const useKeyword = "use "
+
+// Use of a template, as in use service("web", 8080)
+// It has to be preceeded by an EOL or whitespaces only
+func (l *lexer) lexUse() bool {
+	if l.currentByte != 'u' || l.currentByteIndex+len(useKeyword) > len(l.input) {
+		return false
+	}
+	if string(l.input[l.currentByteIndex:l.currentByteIndex+len(useKeyword)]) != useKeyword {
+		return false
+	}
+
+	// Has to be proceeded by EOL or whitespaces
+	if !l.atLineStart() {
+		return false
+	}
+
+	// The call to the template comes after the keyword, up to a comment
+	// or the EOL
+	end := l.valueEnd()
+	call := strings.TrimSpace(string(l.input[l.currentByteIndex+len(useKeyword) : end]))
+	if !useCallRegexp.MatchString(call) {
+		return false
+	}
+
+	// Create prototype and call next
+	l.proto = prototype{
+		kind:  keyUse,
+		value: l.input[l.currentByteIndex:end],
+		position: position{
+			Length: end - 1 - l.currentByteIndex,
+		},
+	}
+	l.next(false, false)
+	return true
+}
+
 // Attribute definition
 // It has to preceeded by an EOL or whitespaces only
+// Keys can be quoted to hold any character but quotes, as in
+// "my key.with/chars" = 1
 func (l *lexer) lexAttributeDef() bool {
 	// Can't be proceeded by keyAttrDef
 	if len(l.items) != 0 {
@@ -407,17 +729,19 @@ func (l *lexer) lexAttributeDef() bool {
 	}
 
 	// Has to be proceeded by EOL or whitespaces
-	if l.previousByte() != "\n" {
-		for i, c := range l.input {
-			if i > l.lastEOL && i < l.currentByteIndex {
-				if c != " " {
-					return false
-				}
-			}
-		}
+	if !l.atLineStart() {
+		return false
 	}
 
-	hasEqual, equalIndex := l.peekAndFind("=")
+	keyEnd := l.currentByteIndex
+	if l.currentByte == '"' {
+		hasCloseQuote, closeQuoteIndex := l.peekAndFindAfter('"', l.currentByteIndex)
+		if !hasCloseQuote {
+			return false
+		}
+		keyEnd = closeQuoteIndex
+	}
+	hasEqual, equalIndex := l.peekAndFindAfter('=', keyEnd)
 	if !hasEqual {
 		return false
 	}
@@ -443,7 +767,7 @@ func (l *lexer) lexArrayStart() bool {
 	}
 
 	// Check if this isn't actually a string
-	if l.currentByte == `"` {
+	if l.currentByte == '"' {
 		return false
 	}
 
@@ -452,8 +776,8 @@ func (l *lexer) lexArrayStart() bool {
 	// any opening bracket is found
 	hasOpenBracket := true
 	openBracketIndex := l.currentByteIndex
-	if l.currentByte != `[` {
-		hasOpenBracket, openBracketIndex = l.peekAndFind(`[`)
+	if l.currentByte != '[' {
+		hasOpenBracket, openBracketIndex = l.peekAndFind('[')
 	}
 	if !hasOpenBracket {
 		return false
@@ -477,17 +801,17 @@ func (l *lexer) lexArrayStart() bool {
 func (l *lexer) lexArrayEnd() bool {
 	// by a keyArrayElem
 	// Has to be proceeded by keyArrayStart or keyArrayElem
-	if l.previousItem().kind != keyArrayStart && l.previousItem().kind != keyArrayElem {
+	if !l.inArray() {
 		return false
 	}
 
 	// Check if this isn't actually a string
-	if l.currentByte == `"` {
+	if l.currentByte == '"' {
 		return false
 	}
 
 	// Check if there's no comma, meaning that there's still elements left in the array
-	hasComma, _ := l.peekAndFind(",")
+	hasComma, _ := l.peekAndFind(',')
 	if hasComma {
 		return false
 	}
@@ -497,13 +821,19 @@ func (l *lexer) lexArrayEnd() bool {
 	// any closing bracket is found
 	hasCloseBracket := true
 	closeIndex := l.currentByteIndex
-	if l.currentByte != `]` {
-		hasCloseBracket, closeIndex = l.peekAndFind(`]`)
+	if l.currentByte != ']' {
+		hasCloseBracket, closeIndex = l.peekAndFind(']')
 	}
 	if !hasCloseBracket {
 		return false
 	}
 
+	// Only whitespaces can come before the closing bracket, otherwise
+	// this is the last element of the array
+	if strings.TrimSpace(string(l.input[l.currentByteIndex:closeIndex])) != "" {
+		return false
+	}
+
 	// Check if EOL comes before the closing bracket
 	// If that's true, this is probably the last element
 	// in a multiline array
@@ -529,16 +859,16 @@ func (l *lexer) lexArrayEnd() bool {
 // and ended by a comma (,) or a closing bracket
 func (l *lexer) lexArrayElem() bool {
 	// Has to be proceeded by keyArrayStart or keyArrayElem
-	if l.previousItem().kind != keyArrayStart && l.previousItem().kind != keyArrayElem {
+	if !l.inArray() {
 		return false
 	}
 
 	// Search for next comma or end of array
 	isEOL := false
 	lengthModifier := 0
-	hasEndOfElem, endOfArrayElem := l.peekAndFind(",")
+	hasEndOfElem, endOfArrayElem := l.peekAndFind(',')
 	if !hasEndOfElem {
-		hasEndOfElem, endOfArrayElem = l.peekAndFind("]")
+		hasEndOfElem, endOfArrayElem = l.peekAndFind(']')
 		lengthModifier = 1
 	}
 
@@ -546,6 +876,7 @@ func (l *lexer) lexArrayElem() bool {
 	// element of a multiline array
 	if !hasEndOfElem {
 		endOfArrayElem = l.peekEOL()
+		lengthModifier = 0
 		isEOL = true
 	}
 
@@ -561,6 +892,210 @@ func (l *lexer) lexArrayElem() bool {
 	return true
 }
 
+// Block element of an array, as in endpoints = [{ host = "a" }]
+// Its body is lexed like a document of its own, where commas separate the
+// definitions of a line, and its items come between the ones of its braces
+func (l *lexer) lexArrayBlock() bool {
+	if l.currentByte != '{' || !l.inArray() {
+		return false
+	}
+
+	open := l.currentByteIndex
+	end := l.closingBraceIndex(open)
+	if end < 0 {
+		panic(evalErrorf(ErrUnclosedBlock, "block of array is not closed"))
+	}
+	l.items = append(l.items, item{kind: keyBlockStart, value: "{", position: l.positionAt(open)})
+	l.lexBlockBody(open, end)
+	l.arrayBlockEnd = len(l.items) - 1
+
+	// The EOLs of the body are counted here, and the comma after the
+	// closing brace is skipped
+	for i := open; i < end; i++ {
+		if l.input[i] == '\n' {
+			l.currentLine, l.lastEOL = l.currentLine+1, i
+		}
+	}
+	next := end + 1
+	for next < len(l.input) && (l.input[next] == ' ' || l.input[next] == '\t') {
+		next++
+	}
+	if next < len(l.input) && l.input[next] == ',' {
+		end = next
+	}
+	l.moveAfter(end)
+	return true
+}
+
+// Block written in a line, as in server { host = "a", port = 80 }
+// Its body is lexed like the one of a block element of an array
+func (l *lexer) lexInlineBlock() bool {
+	if !l.atLineStart() || l.inArray() || (len(l.items) != 0 && l.previousItem().kind == keyAttrDef) {
+		return false
+	}
+
+	// The name comes before the opening brace, without an equal sign
+	open := -1
+	for i := l.currentByteIndex; i < len(l.input) && open < 0; i++ {
+		switch {
+		case l.input[i] == '"':
+			for i++; i < len(l.input) && l.input[i] != '"' && l.input[i] != '\n'; i++ {
+			}
+		case l.input[i] == '{':
+			open = i
+		case l.input[i] == '=' || l.input[i] == '\n' || l.commentStart(i):
+			return false
+		}
+	}
+	if open <= l.currentByteIndex {
+		return false
+	}
+	end := l.closingBraceIndex(open)
+	if end < 0 {
+		return false
+	}
+	for i := open; i < end; i++ {
+		if l.input[i] == '\n' {
+			return false
+		}
+	}
+
+	name := l.input[l.currentByteIndex:open]
+	l.items = append(l.items, item{
+		kind:  keyBlockStart,
+		value: l.valueString(trimSpace(name)),
+		position: position{
+			Line:   l.currentLine,
+			Column: l.column(l.currentByteIndex) + leadingSpace(name),
+			Start:  l.currentByteIndex,
+			Length: open - l.currentByteIndex,
+		},
+	})
+	l.lexBlockBody(open, end)
+	l.moveAfter(end)
+	return true
+}
+
+// Appends the items of the body of a block between the braces at open and
+// end, lexed like a document of its own where commas separate the
+// definitions of a line, with their positions in the input
+func (l *lexer) lexBlockBody(open int, end int) {
+	body, origins := arrayBlockBody(l.input[open+1:end], open+1)
+	sub := newLexer(body)
+	sub.strings, sub.callPrefixes = l.strings, l.callPrefixes
+	for len(trimSpace(body)) > 0 && !sub.atEOF {
+		sub.lexByte(nil)
+	}
+
+	// The items of the body get their positions in the input
+	for _, it := range sub.items {
+		start := it.position.Start + it.position.Column - sub.column(it.position.Start)
+		if start >= len(origins) {
+			start = len(origins) - 1
+		}
+		length := it.position.Length
+		it.position = l.positionAt(origins[start])
+		it.position.Length = length
+		l.items = append(l.items, it)
+	}
+	l.items = append(l.items, item{kind: keyBlockEnd, position: l.positionAt(end)})
+}
+
+// Moves the lexer to the byte after an index of the input
+func (l *lexer) moveAfter(end int) {
+	l.currentByteIndex = end + 1
+	if l.currentByteIndex >= len(l.input) {
+		l.atEOF = true
+	} else {
+		l.currentByte = l.input[l.currentByteIndex]
+	}
+}
+
+// Returns the position of an index of the input after the current byte
+func (l *lexer) positionAt(index int) position {
+	line := l.currentLine
+	for i := l.currentByteIndex; i < index; i++ {
+		if l.input[i] == '\n' {
+			line++
+		}
+	}
+	return position{Line: line, Column: l.column(index), Start: index}
+}
+
+// Returns the index of the brace closing the one at an index of the
+// input, skipping strings and comments, or -1 if it isn't closed
+func (l *lexer) closingBraceIndex(open int) int {
+	depth := 0
+	for i := open; i < len(l.input); i++ {
+		switch {
+		case l.input[i] == '"':
+			for i++; i < len(l.input) && l.input[i] != '"'; i++ {
+				if l.input[i] == '\\' {
+					i++
+				}
+			}
+		case l.input[i] == '/' && i+1 < len(l.input) && l.input[i+1] == '*':
+			for i += 2; i+1 < len(l.input) && !(l.input[i] == '*' && l.input[i+1] == '/'); i++ {
+			}
+			i++
+		case l.commentStart(i):
+			for i < len(l.input) && l.input[i] != '\n' {
+				i++
+			}
+		case l.input[i] == '{':
+			depth += 1
+		case l.input[i] == '}':
+			depth -= 1
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Returns the body of a block element of an array as a document, with
+// the commas outside strings, arrays, calls and comments turned into EOLs,
+// and the index of the input every rune of the document comes from
+func arrayBlockBody(body []rune, offset int) ([]rune, []int) {
+	doc := make([]rune, len(body))
+	origins := make([]int, len(body)+1)
+	depth := 0
+	inString, escaped, inComment, inBlockComment := false, false, false, false
+	for i, r := range body {
+		doc[i], origins[i] = r, offset+i
+		switch {
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case r == '\\':
+				escaped = true
+			case r == '"':
+				inString = false
+			}
+		case inComment:
+			inComment = r != '\n'
+		case inBlockComment:
+			inBlockComment = !(r == '/' && i > 0 && body[i-1] == '*')
+		case r == '"':
+			inString = true
+		case r == '/' && i+1 < len(body) && body[i+1] == '*':
+			inBlockComment = true
+		case r == '#' || (r == '/' && i+1 < len(body) && body[i+1] == '/'):
+			inComment = true
+		case r == '(' || r == '[' || r == '{':
+			depth += 1
+		case r == ')' || r == ']' || r == '}':
+			depth -= 1
+		case r == ',' && depth == 0:
+			doc[i] = '\n'
+		}
+	}
+	origins[len(body)] = offset + len(body)
+	return doc, origins
+}
+
 // ATTRIBUTE TYPES
 // These have to be preceeded by a keyAttrDef
 
@@ -582,7 +1117,7 @@ func (l *lexer) lexAttrCall() bool {
 		kind:  keyAttrCall,
 		value: l.input[firstIndex:lastIndex],
 		position: position{
-			Length: lastIndex - l.currentByteIndex,
+			Length: (lastIndex - 1) - l.currentByteIndex,
 		},
 	}
 	l.next(false, false)
@@ -601,8 +1136,8 @@ func (l *lexer) lexAttrString() bool {
 	// any opening quote is found
 	hasOpenQuote := true
 	openQuoteIndex := l.currentByteIndex
-	if l.currentByte != `"` {
-		hasOpenQuote, openQuoteQuoteSearch := l.peekAndFind(`"`)
+	if l.currentByte != '"' {
+		hasOpenQuote, openQuoteQuoteSearch := l.peekAndFind('"')
 		if hasOpenQuote {
 			openQuoteIndex = openQuoteQuoteSearch
 		}
@@ -612,7 +1147,7 @@ func (l *lexer) lexAttrString() bool {
 	}
 
 	// Discover if there's any closing quote between the first quote and EOL
-	hasCloseQuote, closeQuoteIndex := l.peekAndFindAfter(`"`, openQuoteIndex)
+	hasCloseQuote, closeQuoteIndex := l.peekAndFindAfter('"', openQuoteIndex)
 	if !hasCloseQuote {
 		return false
 	}
@@ -641,8 +1176,8 @@ func (l *lexer) lexAttrMultiString() bool {
 	// any opening quote is found
 	hasOpenQuote := true
 	openQuoteIndex := l.currentByteIndex
-	if l.currentByte != `"` {
-		hasOpenQuote, openQuoteQuoteSearch := l.peekAndFind(`"`)
+	if l.currentByte != '"' {
+		hasOpenQuote, openQuoteQuoteSearch := l.peekAndFind('"')
 		if hasOpenQuote {
 			openQuoteIndex = openQuoteQuoteSearch
 		}
@@ -652,13 +1187,13 @@ func (l *lexer) lexAttrMultiString() bool {
 	}
 
 	// Discover if there's any closing quote between the first quote and EOL
-	hasCloseQuote, _ := l.peekAndFindAfter(`"`, openQuoteIndex)
+	hasCloseQuote, _ := l.peekAndFindAfter('"', openQuoteIndex)
 	if !hasCloseQuote {
 		return false
 	}
 
 	// Search for "\", meaning it's a multiline string
-	hasBackslash, _ := l.peekAndFindAfter(`\`, openQuoteIndex)
+	hasBackslash, _ := l.peekAndFindAfter('\\', openQuoteIndex)
 	if !hasBackslash {
 		return false
 	}
@@ -668,65 +1203,49 @@ func (l *lexer) lexAttrMultiString() bool {
 	finalEOLIndex := 0
 	lastLineStartCharIndex := l.currentByteIndex + 1
 	lines := 0
-	for i, v := range l.input {
-		if i > l.currentByteIndex {
-			if v == "\n" && finalEOLIndex == 0 {
-				lines += 1
-
-				// Search backwards until the start of the line
-				// to find a `\`. If none is found, this is the
-				// last line of the multiline string
-				lastLine := true
-				for j, c := range l.input {
-					if j > lastLineStartCharIndex && j < i {
-						if c == `\` {
-							lastLine = false
-						}
-					}
-				}
-
-				// Last line was found
-				if lastLine {
-					finalEOLIndex = i
-				}
-
-				// Update the start line of the new line
-				lastLineStartCharIndex = i + 1
+	for i := l.currentByteIndex + 1; i < len(l.input) && finalEOLIndex == 0; i++ {
+		if l.input[i] != '\n' {
+			continue
+		}
+		lines += 1
+
+		// Search backwards until the start of the line
+		// to find a `\`. If none is found, this is the
+		// last line of the multiline string
+		lastLine := true
+		for j := lastLineStartCharIndex + 1; j < i; j++ {
+			if l.input[j] == '\\' {
+				lastLine = false
+				break
 			}
 		}
+
+		// Last line was found
+		if lastLine {
+			finalEOLIndex = i
+		}
+
+		// Update the start line of the new line
+		lastLineStartCharIndex = i + 1
 	}
 
 	// Multiline string was not properly finished, panic
 	if finalEOLIndex == 0 {
-		panic("ERROR in lexer: multiline string is not closed")
-	}
-
-	// Change 4 spaces into a "\t"
-	newMultilineStringValue := make([]string, len(l.input[l.currentByteIndex:finalEOLIndex]))
-	copy(newMultilineStringValue, l.input[l.currentByteIndex:finalEOLIndex])
-	for i, v := range newMultilineStringValue {
-		if i <= len(newMultilineStringValue)-4 && v != "\n" {
-			next4Characters := strings.Join(newMultilineStringValue[i:i+3], "")
-			if len(strings.TrimSpace(next4Characters)) == 0 {
-				arrayLeft := newMultilineStringValue[:i-1]
-				arrayRight := newMultilineStringValue[i+3:]
-				insertTab := append(arrayLeft, "\t")
-				newMultilineStringValue = append(insertTab, arrayRight...)
-			}
-		}
+		panic(evalErrorf(ErrUnclosedString, "multiline string is not closed"))
 	}
 
-	// Create prototype and add all lines of the multiline string
+	// Create prototype and add all lines of the multiline string, as they
+	// are in the input
 	l.proto = prototype{
 		kind:  keyMultiString,
-		value: newMultilineStringValue,
+		value: l.input[l.currentByteIndex:finalEOLIndex],
 		position: position{
-			Length: finalEOLIndex - l.currentByteIndex, // This is not len(newMultilineStringValue)
-			// Because the string is not being modified in the original l.input, but in a copy of it
+			Length: finalEOLIndex - l.currentByteIndex,
 		},
 	}
-	l.currentLine += lines
+	// The last EOL is counted by next
 	l.next(false, true)
+	l.currentLine += lines - 1
 	return true
 }
 
@@ -739,7 +1258,7 @@ func (l *lexer) lexAttrInt() bool {
 
 	// Check if this range is a possible int
 	checkIntAsStr, firstIndex, lastIndex := l.searchNonWhitespacedValue()
-	if checkIntAsStr == "" {
+	if !mayBeInt(checkIntAsStr) {
 		return false
 	}
 	_, checkInt := strconv.Atoi(checkIntAsStr)
@@ -752,7 +1271,7 @@ func (l *lexer) lexAttrInt() bool {
 		kind:  keyInt,
 		value: l.input[firstIndex:lastIndex],
 		position: position{
-			Length: lastIndex - l.currentByteIndex,
+			Length: (lastIndex - 1) - l.currentByteIndex,
 		},
 	}
 	l.next(false, false)
@@ -768,7 +1287,7 @@ func (l *lexer) lexAttrFloat() bool {
 
 	// Check if this range is a possible float
 	checkFloatAsStr, firstIndex, lastIndex := l.searchNonWhitespacedValue()
-	if checkFloatAsStr == "" {
+	if !mayBeNumber(checkFloatAsStr) {
 		return false
 	}
 	_, checkInt := strconv.ParseFloat(checkFloatAsStr, 32)
@@ -797,7 +1316,7 @@ func (l *lexer) lexAttrBool() bool {
 
 	// Check if this range is a possible float
 	checkBoolAsStr, firstIndex, lastIndex := l.searchNonWhitespacedValue()
-	if checkBoolAsStr == "" {
+	if !mayBeBool(checkBoolAsStr) {
 		return false
 	}
 	_, checkInt := strconv.ParseBool(checkBoolAsStr)
@@ -828,13 +1347,13 @@ func (l *lexer) lexAttrArithmetic() bool {
 	// Possible symbols are + - * / %
 	// Exponentiation (*) is not checked here because the multiplication
 	// symbol will also find it
-	hasArithmeticSymbol := l.peekAndFindMany([]string{"+", "-", "*", "/", "%"})
+	hasArithmeticSymbol := l.peekAndFindMany([]rune{'+', '-', '*', '/', '%'})
 	if !hasArithmeticSymbol {
 		return false
 	}
 
 	// Search for next comma, comment or EOL
-	hasEndOfElem, endOfElem := l.peekAndFind(",")
+	hasEndOfElem, endOfElem := l.peekAndFind(',')
 	if !hasEndOfElem {
 		hasEndOfElem, endOfElem = l.peekComment(l.currentByteIndex)
 	}
@@ -865,13 +1384,13 @@ func (l *lexer) lexAttrComparison() bool {
 	// Possible symbols are == != > >= < <=
 	// By checking for > and <, it automatically checks for >= and <=
 	// The same goes to checking = for == and !=
-	hasComparisonSymbol := l.peekAndFindMany([]string{"=", ">", "<"})
+	hasComparisonSymbol := l.peekAndFindMany([]rune{'=', '>', '<'})
 	if !hasComparisonSymbol {
 		return false
 	}
 
 	// Search for next comma, comment or EOL
-	hasEndOfElem, endOfElem := l.peekAndFind(",")
+	hasEndOfElem, endOfElem := l.peekAndFind(',')
 	if !hasEndOfElem {
 		hasEndOfElem, endOfElem = l.peekComment(l.currentByteIndex)
 	}
@@ -903,22 +1422,16 @@ func (l *lexer) lexAttrCondition() bool {
 	// Don't use peekAndFindMany here as it can only search for
 	// single characters and not words
 	hasConditionSymbol := false
-	for i, v := range l.input {
-		if i >= l.currentByteIndex && i < l.peekEOL() {
-			// IF
-			if v == "i" {
-				if l.input[i+1] == "f" {
-					hasConditionSymbol = true
-					break
-				}
-			}
-			// FOR
-			if v == "f" {
-				if l.input[i+1] == "o" && l.input[i+2] == "r" {
-					hasConditionSymbol = true
-					break
-				}
-			}
+	for i, end := l.currentByteIndex, l.valueEnd(); i < end; i++ {
+		// IF
+		if l.input[i] == 'i' && l.input[i+1] == 'f' {
+			hasConditionSymbol = true
+			break
+		}
+		// FOR
+		if l.input[i] == 'f' && l.input[i+1] == 'o' && l.input[i+2] == 'r' {
+			hasConditionSymbol = true
+			break
 		}
 	}
 	if !hasConditionSymbol {
@@ -926,7 +1439,7 @@ func (l *lexer) lexAttrCondition() bool {
 	}
 
 	// Search for next comma, comment or EOL
-	hasEndOfElem, endOfElem := l.peekAndFind(",")
+	hasEndOfElem, endOfElem := l.peekAndFind(',')
 	if !hasEndOfElem {
 		hasEndOfElem, endOfElem = l.peekComment(l.currentByteIndex)
 	}
@@ -959,14 +1472,20 @@ func (l *lexer) lexAttrFunction() bool {
 		endOfElem = l.peekEOL()
 	}
 
+	// Values without parentheses aren't calls, which spares converting
+	// most of them to strings
+	if !containsRune(l.input[l.currentByteIndex:endOfElem], '(') {
+		return false
+	}
+
 	// Search if there's any call to a function in this range
-	searchFunctionCall := strings.Join(l.input[l.currentByteIndex:endOfElem], "")
-	stringFunctionNames := []string{"upper(", "lower(", "append(", "concat(", "contains(", "length("}
-	numericalFunctionNames := []string{"power(", "floor(", "remainder("}
-	gateLogicFunctionNames := []string{"and(", "or(", "nand(", "nor(", "xor(", "xnor("}
-	allFunctionNames := append(stringFunctionNames, numericalFunctionNames...)
-	allFunctionNames = append(allFunctionNames, gateLogicFunctionNames...)
-	if !hasPrefixToMany(searchFunctionCall, allFunctionNames) {
+	searchFunctionCall := l.valueString(l.input[l.currentByteIndex:endOfElem])
+	if l.callPrefixes == nil {
+		l.callPrefixes = functionCallPrefixes(nil)
+	}
+	// Calls to unknown functions are calls too, which the parser rejects,
+	// instead of values with garbage before them, like foo("x")
+	if !hasPrefixToMany(searchFunctionCall, l.callPrefixes) && !callStartRegexp.MatchString(searchFunctionCall) {
 		return false
 	}
 	// Create prototype and call next
@@ -984,13 +1503,18 @@ func (l *lexer) lexAttrFunction() bool {
 // BLOCKS
 // Start of block
 func (l *lexer) lexBlockStart() bool {
+	// Can't be the value of an attribute
+	if len(l.items) != 0 && l.previousItem().kind == keyAttrDef {
+		return false
+	}
+
 	// Assume current index is an opening brace ({)
 	// If it's not, peekAndFind will look and return if
 	// any opening brace is found
 	hasOpenBrace := true
 	openBraceIndex := l.currentByteIndex
-	if l.currentByte != `{` {
-		hasOpenBrace, openBraceIndex = l.peekAndFind(`{`)
+	if l.currentByte != '{' {
+		hasOpenBrace, openBraceIndex = l.peekAndFind('{')
 	}
 	if !hasOpenBrace {
 		return false
@@ -1010,13 +1534,18 @@ func (l *lexer) lexBlockStart() bool {
 
 // End of block
 func (l *lexer) lexBlockEnd() bool {
+	// Can't be the value of an attribute
+	if len(l.items) != 0 && l.previousItem().kind == keyAttrDef {
+		return false
+	}
+
 	// Assume current index is a closing brace (})
 	// If it's not, peekAndFind will look and return if
 	// any closing brace is found
 	hasCloseBrace := true
 	closeBraceIndex := l.currentByteIndex
-	if l.currentByte != `}` {
-		hasCloseBrace, closeBraceIndex = l.peekAndFind(`}`)
+	if l.currentByte != '}' {
+		hasCloseBrace, closeBraceIndex = l.peekAndFind('}')
 	}
 	if !hasCloseBrace {
 		return false
@@ -1042,7 +1571,9 @@ func (l *lexer) lexError() bool {
 		kind:  keyError,
 		value: l.input[l.currentByteIndex : l.currentByteIndex+1],
 		position: position{
-			Length: 1,
+			// Only the unexpected byte, so the next one (such as an EOL)
+			// is still lexed
+			Length: 0,
 		},
 	}
 	l.next(false, false)
@@ -1051,168 +1582,274 @@ func (l *lexer) lexError() bool {
 
 // CALL LEXERS
 // Parses a CAFE file through the lexer
-func (l *lexer) lexInput(debug bool) {
+func (l *lexer) lexInput(log Logger) {
+	defer l.positionPanic()
+	total := len(l.input)
+	step := total / 1000
+	if step == 0 {
+		step = 1
+	}
+	reported := 0
+
 	for !l.atEOF {
-		if debug {
-			fmt.Println("DEBUG lexInput: byte: ", l.currentByte)
+		if log != nil {
+			log.Debug("lexInput", "byte", string(l.currentByte), "line", l.currentLine)
+		}
+		l.lexByte(log)
+
+		if l.progress != nil && !l.atEOF && l.currentByteIndex-reported >= step {
+			reported = l.currentByteIndex
+			l.progress(reported, total)
+		}
+	}
+
+	if l.progress != nil {
+		l.progress(total, total)
+	}
+}
+
+// Size of the chunks a reader is lexed in, in bytes
+const readChunkSize = 64 * 1024
+
+// Lexes a reader in chunks of whole lines of about chunkSize bytes, so
+// large inputs are never held in memory at once
+// Each chunk is cut before the last statement starting in it, which is
+// lexed again with the next chunk in case it continues there, and chunks
+// ending in the middle of a statement are grown until they don't
+// setup configures the lexer of each chunk, and emit receives the items of
+// the chunks, with their positions in the whole input, along with the lines
+// they were lexed from
+// Like lexInput, the lexer errors are panics
+func lexReader(r io.Reader, chunkSize int, setup func(*lexer), emit func(items []item, lines []string) error, log Logger) error {
+	reader := bufio.NewReader(r)
+	pending := []string{}
+	pendingSize := 0
+	firstLine, offset := 1, 0
+	size := chunkSize
+	atEOF := false
+
+	for !atEOF || len(pending) > 0 {
+		for !atEOF && pendingSize < size {
+			line, err := reader.ReadString('\n')
+			if err == io.EOF {
+				atEOF = true
+			} else if err != nil {
+				return err
+			}
+			line = normalizeLine(line, firstLine == 1 && len(pending) == 0)
+			if line != "" {
+				pending = append(pending, line)
+				pendingSize += len(line)
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+
+		items, failure := lexChunk(strings.Join(pending, ""), setup, log)
+		if failure != nil && !atEOF {
+			size = 2 * pendingSize
+			continue
+		}
+		if failure != nil {
+			if parseErr, isParseErr := failure.(*ParseError); isParseErr {
+				parseErr.Line += firstLine - 1
+			}
+			panic(failure)
+		}
+
+		// Every statement but the last one is complete, unless the chunk
+		// is the end of the input
+		cut := len(items)
+		lines := len(pending)
+		if !atEOF {
+			cut = lastStatement(items)
+			if cut < 0 {
+				size = 2 * pendingSize
+				continue
+			}
+			lines = items[cut].position.Line - 1
+		}
+		for i := range items[:cut] {
+			items[i].position.Line += firstLine - 1
+			items[i].position.Start += offset
+		}
+		if err := emit(items[:cut], pending[:lines]); err != nil {
+			return err
 		}
-		l.lexByte(debug)
+
+		for _, line := range pending[:lines] {
+			offset += utf8.RuneCountInString(line)
+			pendingSize -= len(line)
+		}
+		firstLine += lines
+		pending = pending[lines:]
+		size = chunkSize
 	}
+	return nil
+}
+
+// Lexes a chunk of an input, returning what the lexer panicked with, if it
+// did
+func lexChunk(chunk string, setup func(*lexer), log Logger) (items []item, failure interface{}) {
+	defer func() {
+		failure = recover()
+	}()
+	lx := newLexer([]rune(chunk))
+	setup(lx)
+	lx.lexInput(log)
+	return lx.items, nil
+}
+
+// Returns the index of the last item starting a statement at the beginning
+// of a line after the first one, or -1 if there's none
+func lastStatement(items []item) int {
+	last, arrays := -1, 0
+	for i, it := range items {
+		switch it.kind {
+		case keyArrayStart:
+			arrays += 1
+		case keyArrayEnd:
+			arrays -= 1
+		case keyAttrDef, keyBlockStart, keyBlockEnd, keyInclude, keyUse:
+			// The statements of the blocks of arrays are part of the array
+			if i > 0 && arrays == 0 && it.position.Line > 1 && items[i-1].position.Line < it.position.Line {
+				last = i
+			}
+		}
+	}
+	return last
 }
 
 // Calls all lexers in a specific order to decode the
 // next couple of bytes
-func (l *lexer) lexByte(debug bool) {
-	if debug {
-		fmt.Println("DEBUG lexByte: lexEOF")
-	}
+func (l *lexer) lexByte(log Logger) {
+	logStep(log, "lexByte", "lexEOF")
 	if l.lexEOF() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG lexByte: lexTab")
-	}
+	logStep(log, "lexByte", "lexTab")
 	if l.lexTab() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG lexByte: lexEOL")
-	}
+	logStep(log, "lexByte", "lexEOL")
 	if l.lexEOL() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG lexByte: lexWhitespace")
-	}
+	logStep(log, "lexByte", "lexWhitespace")
 	if l.lexWhitespace() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG lexByte: lexComment")
-	}
+	logStep(log, "lexByte", "lexComment")
 	if l.lexComment() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG lexByte: lexAttributeDef")
+	logStep(log, "lexByte", "lexInclude")
+	if l.lexInclude() {
+		return
 	}
-	if l.lexAttributeDef() {
+
+	logStep(log, "lexByte", "lexUse")
+	if l.lexUse() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG lexByte: lexBlockStart")
+	logStep(log, "lexByte", "lexArrayBlock")
+	if l.lexArrayBlock() {
+		return
 	}
-	if l.lexBlockStart() {
+
+	logStep(log, "lexByte", "lexInlineBlock")
+	if l.lexInlineBlock() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG lexByte: lexBlockEnd")
+	logStep(log, "lexByte", "lexAttributeDef")
+	if l.lexAttributeDef() {
+		return
 	}
-	if l.lexBlockEnd() {
+
+	logStep(log, "lexByte", "lexBlockStart")
+	if l.lexBlockStart() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG lexByte: lexAttrFunction")
+	logStep(log, "lexByte", "lexBlockEnd")
+	if l.lexBlockEnd() {
+		return
 	}
+
+	logStep(log, "lexByte", "lexAttrFunction")
 	if l.lexAttrFunction() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG lexByte: lexArrayStart")
-	}
+	logStep(log, "lexByte", "lexArrayStart")
 	if l.lexArrayStart() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG lexByte: lexArrayEnd")
-	}
+	logStep(log, "lexByte", "lexArrayEnd")
 	if l.lexArrayEnd() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG lexByte: lexArrayElem")
-	}
+	logStep(log, "lexByte", "lexArrayElem")
 	if l.lexArrayElem() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG lexByte: lexAttrMultiString")
-	}
+	logStep(log, "lexByte", "lexAttrMultiString")
 	if l.lexAttrMultiString() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG lexByte: lexAttrString")
-	}
+	logStep(log, "lexByte", "lexAttrString")
 	if l.lexAttrString() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG lexByte: lexAttrCondition")
-	}
+	logStep(log, "lexByte", "lexAttrCondition")
 	if l.lexAttrCondition() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG lexByte: lexAttrArithmetic")
-	}
+	logStep(log, "lexByte", "lexAttrArithmetic")
 	if l.lexAttrArithmetic() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG lexByte: lexAttrComparison")
-	}
+	logStep(log, "lexByte", "lexAttrComparison")
 	if l.lexAttrComparison() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG lexByte: lexAttrInt")
-	}
+	logStep(log, "lexByte", "lexAttrInt")
 	if l.lexAttrInt() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG lexByte: lexAttrFloat")
-	}
+	logStep(log, "lexByte", "lexAttrFloat")
 	if l.lexAttrFloat() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG lexByte: lexAttrBool")
-	}
+	logStep(log, "lexByte", "lexAttrBool")
 	if l.lexAttrBool() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG lexByte: lexAttrCall")
-	}
+	logStep(log, "lexByte", "lexAttrCall")
 	if l.lexAttrCall() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG lexByte: lexError")
-	}
+	logStep(log, "lexByte", "lexError")
 	if l.lexError() {
 		return
 	}
@@ -1231,6 +1868,10 @@ func keyKindStr(kind keyKind) string {
 		return "ERROR"
 	case keyComment:
 		return "comment"
+	case keyInclude:
+		return "include"
+	case keyUse:
+		return "use"
 	case keyAttrDef:
 		return "attribute definition"
 	case keyBlockStart:
@@ -15,6 +15,11 @@
 package cafe
 
 import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -25,13 +30,13 @@ func TestLex(t *testing.T) {
 	assert.NoError(t, err)
 
 	lx := newLexer(input)
-	lx.lexInput(false)
+	lx.lexInput(nil)
 
 	expectedNames := []string{
 		"// This is a comment",
 		"str", `"string"`,
 		"// Inline comment",
-		"multistr", `"multi" \			"line" \			"string"`,
+		"multistr", "\"multi\" \\\n           \"line\" \\\n           \"string\"",
 		"number1", "2023",
 		"number2", "3.14159",
 		"trickNumber1", `"2023"`,
@@ -92,12 +97,45 @@ func TestLex(t *testing.T) {
 	}
 }
 
+func TestLexComments(t *testing.T) {
+	input, err := readCAFEFile("./test_data/test-comments.cafe")
+	assert.NoError(t, err)
+
+	lx := newLexer(input)
+	lx.lexInput(nil)
+
+	comments, lines := []string{}, []int{}
+	for _, it := range lx.items {
+		if it.kind == keyComment {
+			comments = append(comments, it.value)
+			lines = append(lines, it.position.Line)
+		}
+	}
+	assert.Equal(t, []string{
+		"# Hash comment",
+		"# Trailing hash comment",
+		"/* Block comment\n   spanning lines */",
+		"/* Inline */",
+		"# Not in the string",
+		"/* Trailing block comment */",
+	}, comments)
+	assert.Equal(t, []int{1, 2, 3, 6, 7, 8}, lines)
+
+	// Lines after a block comment are counted
+	last := lx.items[len(lx.items)-2]
+	assert.Equal(t, "enabled", last.value)
+	assert.Equal(t, 10, last.position.Line)
+
+	_, err = Lex([]byte("a = 1\n/* open\nb = 2\n"))
+	assert.ErrorIs(t, err, ErrUnclosedComment)
+}
+
 func TestLexFunctions(t *testing.T) {
 	input, err := readCAFEFile("./test_data/test-functions.cafe")
 	assert.NoError(t, err)
 
 	lx := newLexer(input)
-	lx.lexInput(false)
+	lx.lexInput(nil)
 
 	expectedNames := []string{
 		"// Strings",
@@ -112,6 +150,14 @@ func TestLexFunctions(t *testing.T) {
 		"testFuncNumerical1", "power(5, 2)",
 		"testFuncNumerical2", "floor(25, 7)",
 		"testFuncNumerical3", "remainder(10, 3)",
+		"testFuncNumerical4", "abs(-3)",
+		"testFuncNumerical5", "abs(-2.5)",
+		"testFuncNumerical6", "min(4, 2, 9)",
+		"testFuncNumerical7", "max(4, 2.5, 9)",
+		"testFuncNumerical8", "ceil(2.1)",
+		"testFuncNumerical9", "round(2.5)",
+		"testFuncNumerical10", "sqrt(16)",
+		"testFuncNumerical11", "log(8, 2)",
 		"// Gate Logic",
 		"testFuncGateLogic1", "and(true, true)",
 		"testFuncGateLogic2", "or(true, false)",
@@ -119,8 +165,62 @@ func TestLexFunctions(t *testing.T) {
 		"testFuncGateLogic4", "nor(true, true)",
 		"testFuncGateLogic5", "xor(true, false)",
 		"testFuncGateLogic6", "xnor(true, true)",
+		"// Arrays",
+		"arr2", "", // Array open
+		"3", "1", "2", "3", "", // Array close
+		"testFuncArray1", "sum(arr2)",
+		"testFuncArray2", "sort(arr2)",
+		"testFuncArray3", "unique(arr2)",
+		"testFuncArray4", `reverse(["a", "b", "c"])`,
+		"testFuncArray5", "slice(arr2, 1, 3)",
+		"testFuncArray6", "index(arr2, 2)",
+		"testFuncArray7", "flatten([[1, 2], [3], 4])",
+		"testFuncArray8", "element(arr2, -1)",
 	}
 	for i, ev := range expectedNames {
 		assert.EqualValues(t, ev, lx.items[i].value)
 	}
 }
+
+func BenchmarkLex(b *testing.B) {
+	for _, count := range []int{100, 1000} {
+		input := []rune(string(generateServers(count)))
+		b.Run(fmt.Sprintf("servers=%d", count), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				newLexer(input).lexInput(nil)
+			}
+		})
+	}
+}
+
+func TestLexReaderChunks(t *testing.T) {
+	paths, err := filepath.Glob("./test_data/*.cafe")
+	assert.NoError(t, err)
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		assert.NoError(t, err)
+
+		lx := newLexer([]rune(string(src)))
+		lx.lexInput(nil)
+
+		// Even chunks of a single line are grown into whole statements
+		for _, chunkSize := range []int{1, 16, 256, readChunkSize} {
+			items := []item{}
+			lines := []string{}
+			err := lexReader(bytes.NewReader(src), chunkSize, func(*lexer) {}, func(chunk []item, chunkLines []string) error {
+				items = append(items, chunk...)
+				lines = append(lines, chunkLines...)
+				return nil
+			}, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, lx.items, items, "%s in chunks of %d bytes", path, chunkSize)
+			assert.Equal(t, string(src), strings.Join(lines, ""))
+		}
+	}
+
+	// Errors are in the line of the whole input
+	src := []byte("a = 1\nb = 2\nc = \"x\" \\\n")
+	assert.PanicsWithError(t, "line 3, column 5: multiline string is not closed", func() {
+		_ = lexReader(bytes.NewReader(src), 1, func(*lexer) {}, func([]item, []string) error { return nil }, nil)
+	})
+}
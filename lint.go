@@ -0,0 +1,149 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Deepest level of nested blocks Lint accepts without a warning
+const lintMaxDepth = 5
+
+// Diagnostic is a problem Lint found in a document that doesn't prevent it
+// from being decoded
+type Diagnostic struct {
+	// Line of the definition the problem is about
+	Line int
+
+	// Path of the attribute or block, as in "block.nested.name"
+	Path string
+
+	// Description of the problem
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("line %d: %s", d.Line, d.Message)
+}
+
+// Lint decodes a document and reports the problems it has, sorted by line:
+// attributes and local variables that are never referenced, attributes
+// shadowing the ones of an enclosing block, strings that look like numbers
+// or booleans and blocks nested too deep
+// Referencing a block counts as referencing all of its attributes
+// Like SafeDecode, a document that panics the parser is returned as a
+// PanicError
+func Lint(src []byte, opts ...Option) (diagnostics []Diagnostic, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			diagnostics = nil
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+
+	p, err := DecodeBytes(src, append(opts[:len(opts):len(opts)], withLinting())...)
+	if err != nil {
+		return nil, err
+	}
+	return p.lint(), nil
+}
+
+// Records the paths a decoded document references, for Lint
+func withLinting() Option {
+	return func(o *options) {
+		o.linting = true
+	}
+}
+
+// Reports the problems of a decoded document
+func (p *Parser) lint() []Diagnostic {
+	diagnostics := []Diagnostic{}
+	report := func(path Path, format string, args ...interface{}) {
+		diagnostics = append(diagnostics, Diagnostic{
+			Line:    p.definitionLine(path[:len(path)-1], path[len(path)-1]),
+			Path:    path.String(),
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	// Unused local variables
+	for scope, locals := range p.locals {
+		for name := range locals {
+			path := Path{name}
+			if scope != "" {
+				path = append(strings.Split(scope, "."), name)
+			}
+			if !p.referencedLocals[path.String()] {
+				report(path, "local variable %s is never referenced", path)
+			}
+		}
+	}
+
+	// Whether the attribute or block at path, or a block enclosing it, is
+	// referenced by the document
+	referenced := func(path Path) bool {
+		for i := 1; i <= len(path); i++ {
+			if p.referencedPaths[path[:i].String()] {
+				return true
+			}
+		}
+		return false
+	}
+
+	var lintBody func(attributes map[string]Attribute, blocks map[string]Block, parents Path, scopes []map[string]Attribute)
+	lintBody = func(attributes map[string]Attribute, blocks map[string]Block, parents Path, scopes []map[string]Attribute) {
+		for name, attr := range attributes {
+			path := childPath(parents, name)
+			if !referenced(path) {
+				report(path, "attribute %s is never referenced", path)
+			}
+			for i := len(scopes) - 1; i >= 0; i-- {
+				if _, found := scopes[i][name]; found {
+					report(path, "attribute %s shadows %s", path, childPath(parents[:i], name))
+					break
+				}
+			}
+
+			if str, isString := attr.Value.(string); isString && attr.kind == attrString && attr.expr == "" {
+				if _, err := strconv.ParseFloat(str, 64); err == nil {
+					report(path, "attribute %s is a string that looks like a number: %q", path, str)
+				} else if _, err := strconv.ParseBool(str); err == nil {
+					report(path, "attribute %s is a string that looks like a boolean: %q", path, str)
+				}
+			}
+		}
+
+		for name, b := range blocks {
+			path := childPath(parents, name)
+			if len(path) == lintMaxDepth+1 {
+				report(path, "block %s is nested deeper than %d levels", path, lintMaxDepth)
+			}
+			lintBody(b.Attributes, b.Blocks, path, append(append([]map[string]Attribute{}, scopes...), attributes))
+		}
+	}
+	lintBody(p.Attributes, p.Blocks, nil, nil)
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		if diagnostics[i].Line != diagnostics[j].Line {
+			return diagnostics[i].Line < diagnostics[j].Line
+		}
+		return diagnostics[i].Path < diagnostics[j].Path
+	})
+	return diagnostics
+}
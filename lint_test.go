@@ -0,0 +1,86 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+	src := `name = "cafe"
+let unused = 1
+let prefix = "v"
+version = append(prefix, "1")
+port = "8080"
+server {
+    name = "web"
+    enabled = "true"
+    let host = "localhost"
+    a {
+        b {
+            c {
+                d {
+                    e {
+                        f = 1
+                    }
+                }
+            }
+        }
+    }
+}
+`
+	diagnostics, err := Lint([]byte(src))
+	assert.NoError(t, err)
+	assert.Equal(t, []Diagnostic{
+		{Line: 1, Path: "name", Message: "attribute name is never referenced"},
+		{Line: 2, Path: "unused", Message: "local variable unused is never referenced"},
+		{Line: 4, Path: "version", Message: "attribute version is never referenced"},
+		{Line: 5, Path: "port", Message: "attribute port is never referenced"},
+		{Line: 5, Path: "port", Message: `attribute port is a string that looks like a number: "8080"`},
+		{Line: 7, Path: "server.name", Message: "attribute server.name is never referenced"},
+		{Line: 7, Path: "server.name", Message: "attribute server.name shadows name"},
+		{Line: 8, Path: "server.enabled", Message: "attribute server.enabled is never referenced"},
+		{Line: 8, Path: "server.enabled", Message: `attribute server.enabled is a string that looks like a boolean: "true"`},
+		{Line: 9, Path: "server.host", Message: "local variable server.host is never referenced"},
+		{Line: 14, Path: "server.a.b.c.d.e", Message: "block server.a.b.c.d.e is nested deeper than 5 levels"},
+		{Line: 15, Path: "server.a.b.c.d.e.f", Message: "attribute server.a.b.c.d.e.f is never referenced"},
+	}, diagnostics)
+	assert.Equal(t, "line 1: attribute name is never referenced", diagnostics[0].String())
+
+	// Attributes referenced by name, by path or through their block
+	src = `host = "localhost"
+server {
+    port = 8080
+    address = host
+}
+listen = server.port
+copy = server
+`
+	diagnostics, err = Lint([]byte(src))
+	assert.NoError(t, err)
+	assert.Equal(t, []Diagnostic{
+		{Line: 6, Path: "listen", Message: "attribute listen is never referenced"},
+		{Line: 7, Path: "copy", Message: "attribute copy is never referenced"},
+	}, diagnostics)
+
+	// Invalid documents
+	_, err = Lint([]byte("port = 8080\nport = 80\n"))
+	assert.Error(t, err)
+	_, err = Lint([]byte("port = randint(10, 1)\n"))
+	var parseErr *ParseError
+	assert.ErrorAs(t, err, &parseErr)
+}
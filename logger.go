@@ -0,0 +1,46 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Logger receives the steps the lexer and the parser go through, at the
+// debug level, as a message and pairs of keys and values
+// A *slog.Logger is a Logger
+type Logger interface {
+	Debug(msg string, args ...interface{})
+}
+
+// Logs a step of the lexer or the parser, if there's a logger
+func logStep(log Logger, fn string, step string) {
+	if log != nil {
+		log.Debug(fn, "step", step)
+	}
+}
+
+// Logger printing to the standard output, for WithDebug
+type stdoutLogger struct{}
+
+func (stdoutLogger) Debug(msg string, args ...interface{}) {
+	var sb strings.Builder
+	sb.WriteString("DEBUG " + msg + ":")
+	for i := 0; i+1 < len(args); i += 2 {
+		sb.WriteString(fmt.Sprintf(" %v=%v", args[i], args[i+1]))
+	}
+	fmt.Println(sb.String())
+}
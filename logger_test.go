@@ -0,0 +1,56 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Logger collecting the messages and arguments it receives
+type recordLogger struct {
+	entries []string
+	args    [][]interface{}
+}
+
+func (r *recordLogger) Debug(msg string, args ...interface{}) {
+	r.entries = append(r.entries, msg)
+	r.args = append(r.args, args)
+}
+
+func TestWithLogger(t *testing.T) {
+	log := &recordLogger{}
+	_, err := DecodeBytes([]byte("name = \"cafe\"\nserver {\n    port = 80\n}\n"), WithLogger(log))
+	assert.NoError(t, err)
+	assert.Contains(t, log.entries, "lexInput")
+	assert.Contains(t, log.entries, "lexByte")
+	assert.Contains(t, log.entries, "parseItems")
+	assert.Contains(t, log.entries, "parseItem")
+
+	// Arguments come in pairs of keys and values
+	for i, args := range log.args {
+		assert.Zero(t, len(args)%2, log.entries[i])
+		for j := 0; j < len(args); j += 2 {
+			assert.IsType(t, "", args[j])
+		}
+	}
+	assert.Contains(t, log.args, []interface{}{"item", "name", "kind", "attribute definition", "line", 1})
+	assert.Contains(t, log.args, []interface{}{"step", "parseBlockStart"})
+
+	// Without a logger nothing is traced
+	_, err = DecodeBytes([]byte("name = \"cafe\"\n"))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,108 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Match is an attribute or a block found by Parser.Lookup
+type Match struct {
+	// Concrete path of the attribute or block
+	Path Path
+
+	// Value of the attribute, or the JSON-like map of the block
+	Value Value
+
+	// Where the attribute or block was defined
+	Range Range
+
+	// Documentation of the attribute or block, from its doc comments
+	Doc string
+}
+
+// subscription observes the attributes matching a path pattern
+type subscription struct {
+	steps  []pathStep
+	notify func(Path, Value)
+}
+
+// Lookup returns the attributes and blocks at a path, as in
+// "services.web.port", sorted by path
+// A "*" matches any name at its level, so "services.*.port" finds the port
+// of every block nested in services
+func (p *Parser) Lookup(path string) []Match {
+	matches := []Match{}
+	for _, node := range p.selectPath(literalSteps(splitPath(path))) {
+		matches = append(matches, node.match)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Path.String() < matches[j].Path.String()
+	})
+	return matches
+}
+
+// Names returns the names of the attributes and blocks of the block at a
+// path, as in "server.tls", in the order they were defined, or of the top
+// level of the document for an empty path
+// Attributes and blocks added after decoding by Set, AddBlock or Merge come
+// after the decoded ones
+func (p *Parser) Names(path string) []string {
+	names := Path{}
+	if path != "" {
+		names = splitPath(path)
+	}
+	attributes, blocks, err := p.bodyAt(names, false)
+	if err != nil {
+		return []string{}
+	}
+	return p.orderedNames(names, attributes, blocks)
+}
+
+// Returns a copy of a path with one more name
+func childPath(parents Path, name string) Path {
+	return append(append(Path{}, parents...), name)
+}
+
+// Subscribe calls fn with the path and the new value of the attributes
+// matching the path pattern whenever Refresh changes their values
+// The pattern accepts the same wildcards as Lookup
+// The returned function cancels the subscription
+func (p *Parser) Subscribe(pattern string, fn func(path Path, value Value)) func() {
+	sub := &subscription{steps: literalSteps(splitPath(pattern)), notify: fn}
+	p.subscriptions = append(p.subscriptions, sub)
+
+	return func() {
+		for i, s := range p.subscriptions {
+			if s == sub {
+				p.subscriptions = append(p.subscriptions[:i], p.subscriptions[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Notifies the subscribers of an attribute whose value changed
+func (p *Parser) notifyChange(path Path, previous Value, value Value) {
+	if reflect.DeepEqual(previous, value) {
+		return
+	}
+	for _, sub := range p.subscriptions {
+		if matchesSteps(path, sub.steps) {
+			sub.notify(path, value)
+		}
+	}
+}
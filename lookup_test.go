@@ -0,0 +1,144 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookup(t *testing.T) {
+	p, err := DecodeBytes([]byte(`name = "cafe"
+services {
+    web {
+        port = 8080
+    }
+    db {
+        port = 5432
+        replica {
+            port = 5433
+        }
+    }
+}
+`))
+	assert.NoError(t, err)
+
+	assert.Equal(t, []Match{{Path: Path{"name"}, Value: "cafe", Range: Range{Position{1, 1}, Position{1, 14}}}}, p.Lookup("name"))
+	assert.Equal(t, []Match{
+		{Path: Path{"services", "db", "port"}, Value: 5432, Range: Range{Position{7, 9}, Position{7, 20}}},
+		{Path: Path{"services", "web", "port"}, Value: 8080, Range: Range{Position{4, 9}, Position{4, 20}}},
+	}, p.Lookup("services.*.port"))
+	assert.Equal(t, []Match{
+		{Path: Path{"services", "db", "replica"}, Value: map[string]interface{}{"port": 5433}, Range: Range{Position{8, 9}, Position{10, 10}}},
+	}, p.Lookup("services.*.replica"))
+	assert.Len(t, p.Lookup("*"), 2)
+	assert.Empty(t, p.Lookup("services.*.host"))
+
+	// Quoted names can hold dots
+	p, err = DecodeBytes([]byte("server {\n    \"listen.address\" = \"0.0.0.0\"\n}\n"))
+	assert.NoError(t, err)
+	matches := p.Lookup(`server."listen.address"`)
+	assert.Len(t, matches, 1)
+	assert.Equal(t, Path{"server", "listen.address"}, matches[0].Path)
+	assert.Equal(t, `server."listen.address"`, matches[0].Path.String())
+
+	// Doc comments
+	p, err = DecodeBytes([]byte(`/// Server of the application
+server {
+    /// Port the server listens on
+    port = 8080
+}
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, "Server of the application", p.Lookup("server")[0].Doc)
+	assert.Equal(t, "Port the server listens on", p.Lookup("server.port")[0].Doc)
+}
+
+func TestNames(t *testing.T) {
+	p, err := DecodeBytes([]byte(`zone = "eu"
+server {
+    port ?= 80
+    tls {
+    }
+    host = "localhost"
+    port = 8080
+}
+app.name = "cafe"
+profile "prod" {
+    zone = "us"
+    cache {
+    }
+}
+`), WithProfile("prod"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"zone", "server", "app", "cache"}, p.Names(""))
+	assert.Equal(t, []string{"port", "tls", "host"}, p.Names("server"))
+	assert.Equal(t, []string{}, p.Names("server.tls"))
+	assert.Equal(t, []string{}, p.Names("missing"))
+
+	// New attributes and blocks come last
+	assert.NoError(t, p.Set("server.debug", true))
+	assert.NoError(t, p.Set("server.alpha", 1))
+	assert.Equal(t, []string{"port", "tls", "host", "debug", "alpha"}, p.Names("server"))
+
+	// Included definitions keep the order of their file
+	p, err = Decode("./test_data/include/main.cafe")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"prefix", "logging", "name", "server"}, p.Names(""))
+	assert.Equal(t, []string{"host", "tls", "port"}, p.Names("server"))
+}
+
+func TestSubscribe(t *testing.T) {
+	versions := map[string]int{"web": 1, "db": 1}
+	registerTestFunction(t, "fetch_image", func(args []Value) (Value, error) {
+		return Expiring{Value: fmt.Sprintf("%v:%d", args[0], versions[args[0].(string)]), TTL: time.Minute}, nil
+	})
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := newParser(splitTestInput(`services {
+    web {
+        image = fetch_image("web")
+    }
+    db {
+        image = fetch_image("db")
+    }
+}
+`))
+	p.clock = func() time.Time { return now }
+	p.parseItems(nil)
+	assert.NoError(t, p.err)
+
+	changed := []Match{}
+	unsubscribe := p.Subscribe("services.*.image", func(path Path, value Value) {
+		changed = append(changed, Match{Path: path, Value: value})
+	})
+
+	// Only the attributes whose values changed are notified
+	versions["web"] = 2
+	now = now.Add(time.Minute)
+	_, err := p.Refresh()
+	assert.NoError(t, err)
+	assert.Equal(t, []Match{{Path: Path{"services", "web", "image"}, Value: "web:2"}}, changed)
+
+	unsubscribe()
+	versions["db"] = 2
+	now = now.Add(time.Minute)
+	_, err = p.Refresh()
+	assert.NoError(t, err)
+	assert.Len(t, changed, 1)
+}
@@ -0,0 +1,290 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"bytes"
+	"encoding"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"reflect"
+	"time"
+)
+
+// Marshal encodes the struct v, or the struct it points to, as a CAFE
+// document, the other way around of Unmarshal
+// Fields are written in the order they're declared in, with the names and
+// options of their `cafe` tags, and the key naming of the WithKeyNaming
+// option: structs and maps become blocks, and everything else attributes.
+// Durations, times, IPs, URLs and types implementing
+// encoding.TextMarshaler are written as strings
+// The `comment` tag of a field is written as its doc comment, as in
+// `comment:"Port the server listens on"`
+func Marshal(v interface{}, opts ...Option) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cafe: Marshal needs a struct or a pointer to one, got %T", v)
+	}
+
+	p := newParser(nil, opts...)
+	if err := p.marshalBody(nil, rv); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetSourceOrder(true)
+	if err := e.Encode(p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Defines the attributes and blocks of the fields of a struct, or of the
+// entries of a map, in the block at path
+func (p *Parser) marshalBody(path Path, rv reflect.Value) error {
+	if rv.Kind() == reflect.Map {
+		keys := rv.MapKeys()
+		names := make(map[string]reflect.Value, len(keys))
+		for _, key := range keys {
+			names[key.String()] = rv.MapIndex(key)
+		}
+		for _, name := range sortedKeys(names) {
+			if err := p.marshalField(childPath(path, name), names[name]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		tag, ok := parseFieldTag(field, p.opts.naming)
+		if !ok {
+			continue
+		}
+		if tag.omitEmpty && isEmptyField(rv.Field(i)) {
+			continue
+		}
+		if tag.remain {
+			if err := remainMap(field); err != nil {
+				return fmt.Errorf("cafe: %w", err)
+			}
+			if err := p.marshalBody(path, rv.Field(i)); err != nil {
+				return err
+			}
+			continue
+		}
+		if tag.squash {
+			fieldValue := rv.Field(i)
+			if fieldValue.Kind() == reflect.Pointer && fieldValue.IsNil() {
+				continue
+			}
+			fieldValue, err := squashedStruct(field, reflect.Indirect(fieldValue))
+			if err != nil {
+				return fmt.Errorf("cafe: %w", err)
+			}
+			if err := p.marshalBody(path, fieldValue); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := p.marshalField(childPath(path, tag.name), rv.Field(i)); err != nil {
+			return err
+		}
+		if doc := field.Tag.Get("comment"); doc != "" {
+			if err := p.SetDoc(childPath(path, tag.name).String(), doc); err != nil {
+				return fmt.Errorf("cafe: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Defines the attribute or the block of a field
+func (p *Parser) marshalField(path Path, rv reflect.Value) error {
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return p.Set(path.String(), nil)
+		}
+		rv = rv.Elem()
+	}
+
+	if isBlockValue(rv) {
+		if err := p.AddBlock(path.String()); err != nil {
+			return fmt.Errorf("cafe: %w", err)
+		}
+		return p.marshalBody(path, rv)
+	}
+
+	value, err := p.marshalValue(rv)
+	if err != nil {
+		return fmt.Errorf("cafe: %s: %w", path, err)
+	}
+	if err := p.Set(path.String(), value); err != nil {
+		return fmt.Errorf("cafe: %w", err)
+	}
+	return nil
+}
+
+// Reports if a value is written as a block
+func isBlockValue(rv reflect.Value) bool {
+	if _, isText, _ := marshalText(rv); isText {
+		return false
+	}
+	switch rv.Kind() {
+	case reflect.Struct:
+		return true
+	case reflect.Map:
+		return rv.Type().Key().Kind() == reflect.String && !rv.IsNil()
+	}
+	return false
+}
+
+// Returns the value of an attribute, as the values of decoded documents
+func (p *Parser) marshalValue(rv reflect.Value) (interface{}, error) {
+	if text, isText, err := marshalText(rv); isText {
+		return text, err
+	}
+
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return p.marshalValue(rv.Elem())
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if rv.Uint() > math.MaxInt {
+			return nil, fmt.Errorf("%d overflows an int", rv.Uint())
+		}
+		return int(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil, nil
+		}
+		elems := make([]interface{}, rv.Len())
+		for i := range elems {
+			elem, err := p.marshalValue(rv.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			elems[i] = elem
+		}
+		return elems, nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			break
+		}
+		if rv.IsNil() {
+			return nil, nil
+		}
+		obj := map[string]interface{}{}
+		for _, key := range rv.MapKeys() {
+			elem, err := p.marshalValue(rv.MapIndex(key))
+			if err != nil {
+				return nil, err
+			}
+			obj[key.String()] = elem
+		}
+		return obj, nil
+	case reflect.Struct:
+		obj := map[string]interface{}{}
+		rt := rv.Type()
+		for i := 0; i < rt.NumField(); i++ {
+			field := rt.Field(i)
+			tag, ok := parseFieldTag(field, p.opts.naming)
+			if !ok || (tag.omitEmpty && isEmptyField(rv.Field(i))) {
+				continue
+			}
+			if tag.remain {
+				if err := remainMap(field); err != nil {
+					return nil, err
+				}
+			}
+			elem, err := p.marshalValue(rv.Field(i))
+			if err != nil {
+				return nil, err
+			}
+			squashed, isBlock := elem.(map[string]interface{})
+			if !tag.squash && !tag.remain {
+				obj[tag.name] = elem
+				continue
+			}
+			if elem == nil {
+				continue
+			}
+			if !isBlock {
+				return nil, fmt.Errorf("field %s can't be squashed, it's a %s and not a struct", field.Name, field.Type)
+			}
+			for name, member := range squashed {
+				obj[name] = member
+			}
+		}
+		return obj, nil
+	}
+	return nil, fmt.Errorf("can't marshal a %s", rv.Type())
+}
+
+// Returns the string of the values written as strings: durations, times,
+// IPs, URLs and encoding.TextMarshaler types
+func marshalText(rv reflect.Value) (string, bool, error) {
+	if !rv.IsValid() || !rv.CanInterface() {
+		return "", false, nil
+	}
+	switch val := rv.Interface().(type) {
+	case time.Duration:
+		return val.String(), true, nil
+	case time.Time:
+		return val.Format(time.RFC3339Nano), true, nil
+	case net.IP:
+		return val.String(), len(val) > 0, nil
+	case url.URL:
+		return val.String(), true, nil
+	}
+
+	marshaler, isMarshaler := rv.Interface().(encoding.TextMarshaler)
+	if !isMarshaler && rv.CanAddr() {
+		marshaler, isMarshaler = rv.Addr().Interface().(encoding.TextMarshaler)
+	}
+	if !isMarshaler || (rv.Kind() == reflect.Pointer && rv.IsNil()) {
+		return "", false, nil
+	}
+	text, err := marshaler.MarshalText()
+	return string(text), true, err
+}
+
+// Reports if a field is empty for the omitempty option: false, 0, nil, an
+// empty string, array, slice or map, or a zero struct
+func isEmptyField(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.String:
+		return rv.Len() == 0
+	}
+	return rv.IsZero()
+}
@@ -0,0 +1,127 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testMarshalCommon struct {
+	Region string `cafe:"region"`
+	Zone   string `cafe:"zone,omitempty"`
+}
+
+type testMarshalServer struct {
+	Host    string         `cafe:"host"`
+	Port    int            `cafe:"port" comment:"Port the server listens on"`
+	Timeout time.Duration  `cafe:"timeout"`
+	Labels  map[string]int `cafe:"labels,omitempty"`
+}
+
+type testMarshalConfig struct {
+	testMarshalCommon `cafe:",squash"`
+	Name              string
+	Ratio             float64
+	Tags              []string
+	Level             testLevel `cafe:"level"`
+	Address           net.IP
+	Backup            *testMarshalServer
+	Server            testMarshalServer `comment:"Web server"`
+	Routes            []testMarshalServer
+	Debug             bool   `cafe:"debug,omitempty"`
+	Note              string `cafe:"note,omitempty"`
+	Ignored           string `cafe:"-"`
+}
+
+func (l testLevel) MarshalText() ([]byte, error) {
+	return []byte([]string{"debug", "info", "error"}[l]), nil
+}
+
+func TestMarshal(t *testing.T) {
+	config := testMarshalConfig{
+		testMarshalCommon: testMarshalCommon{Region: "eu"},
+		Name:              "cafe",
+		Ratio:             1.5,
+		Tags:              []string{"web", "api"},
+		Level:             1,
+		Address:           net.ParseIP("10.0.0.1"),
+		Server: testMarshalServer{
+			Host:    "localhost",
+			Port:    8080,
+			Timeout: 90 * time.Second,
+			Labels:  map[string]int{"b": 2, "a": 1},
+		},
+		Routes:  []testMarshalServer{{Host: "a", Port: 1}},
+		Ignored: "ignored",
+	}
+
+	data, err := Marshal(&config, WithKeyNaming(NamingSnakeCase))
+	assert.NoError(t, err)
+	assert.Equal(t, `region = "eu"
+name = "cafe"
+ratio = 1.5
+tags = ["web", "api"]
+level = "info"
+address = "10.0.0.1"
+backup = null
+/// Web server
+server {
+    host = "localhost"
+    /// Port the server listens on
+    port = 8080
+    timeout = "1m30s"
+    labels {
+        a = 1
+        b = 2
+    }
+}
+routes = [{ host = "a", port = 1, timeout = "0s" }]
+`, string(data))
+
+	// The document decodes back to the struct
+	var decoded testMarshalConfig
+	assert.NoError(t, Unmarshal(data, &decoded, WithKeyNaming(NamingSnakeCase)))
+	config.Ignored = ""
+	assert.Equal(t, config, decoded)
+
+	_, err = Marshal("cafe")
+	assert.EqualError(t, err, "cafe: Marshal needs a struct or a pointer to one, got string")
+	_, err = Marshal(struct{ Limits map[int]int }{Limits: map[int]int{1: 2}})
+	assert.EqualError(t, err, "cafe: Limits: can't marshal a map[int]int")
+	_, err = Marshal(struct {
+		Name string `cafe:",squash"`
+	}{})
+	assert.EqualError(t, err, "cafe: field Name can't be squashed, it's a string and not a struct")
+}
+
+func TestFieldKey(t *testing.T) {
+	for name, keys := range map[string][2]string{
+		"Name":       {"name", "name"},
+		"MaxConns":   {"max_conns", "maxConns"},
+		"HTTPServer": {"http_server", "httpServer"},
+		"UserID":     {"user_id", "userId"},
+		"ID":         {"id", "id"},
+		"TLS2Cert":   {"tls2_cert", "tls2Cert"},
+		"Max_Conns":  {"max_conns", "maxConns"},
+	} {
+		assert.Equal(t, name, fieldKey(name, NamingFieldName))
+		assert.Equal(t, keys[0], fieldKey(name, NamingSnakeCase), name)
+		assert.Equal(t, keys[1], fieldKey(name, NamingCamelCase), name)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+// MergeStrategy defines how Parser.Merge resolves the attributes defined in
+// both documents
+type MergeStrategy int
+
+const (
+	// The attributes of the merged document override the existing ones
+	MergeOverride MergeStrategy = iota
+
+	// Attributes defined in both documents are a MergeConflictError
+	MergeErrorOnConflict
+
+	// Arrays defined in both documents are appended, and other attributes
+	// are overridden
+	MergeAppendArrays
+)
+
+// Merge adds the attributes and blocks of another document to this one,
+// such as an environment override on top of a base configuration
+// Blocks defined in both documents are merged recursively
+// On a conflict, the document is left unchanged
+func (p *Parser) Merge(other *Parser, strategy MergeStrategy) error {
+	if strategy == MergeErrorOnConflict {
+		if err := findMergeConflict(p.Attributes, p.Blocks, other.Attributes, other.Blocks, nil); err != nil {
+			return err
+		}
+	}
+	mergeBody(p.Attributes, p.Blocks, other.Attributes, other.Blocks, strategy)
+	return nil
+}
+
+// Returns the first attribute, in path order, defined in both bodies
+func findMergeConflict(attributes map[string]Attribute, blocks map[string]Block, otherAttributes map[string]Attribute, otherBlocks map[string]Block, parents Path) error {
+	for _, name := range sortedKeys(otherAttributes) {
+		_, isAttr := attributes[name]
+		_, isBlock := blocks[name]
+		if isAttr || isBlock {
+			return &MergeConflictError{Name: childPath(parents, name).String()}
+		}
+	}
+	for _, name := range sortedKeys(otherBlocks) {
+		if _, isAttr := attributes[name]; isAttr {
+			return &MergeConflictError{Name: childPath(parents, name).String()}
+		}
+		if b, isBlock := blocks[name]; isBlock {
+			other := otherBlocks[name]
+			if err := findMergeConflict(b.Attributes, b.Blocks, other.Attributes, other.Blocks, childPath(parents, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Merges a body into another one
+func mergeBody(attributes map[string]Attribute, blocks map[string]Block, otherAttributes map[string]Attribute, otherBlocks map[string]Block, strategy MergeStrategy) {
+	for name, attr := range otherAttributes {
+		delete(blocks, name)
+		existing, found := attributes[name]
+		existingArray, existingIsArray := existing.Value.([]interface{})
+		array, isArray := attr.Value.([]interface{})
+		if found && strategy == MergeAppendArrays && existingIsArray && isArray {
+			attr.Value = append(append([]interface{}{}, existingArray...), array...)
+			attr.expr = ""
+		}
+		attributes[name] = attr
+	}
+
+	for name, other := range otherBlocks {
+		delete(attributes, name)
+		b, found := blocks[name]
+		if !found {
+			b = Block{Name: other.Name, Attributes: map[string]Attribute{}, Blocks: map[string]Block{}}
+		}
+		if other.Doc != "" {
+			b.Doc = other.Doc
+		}
+		mergeBody(b.Attributes, b.Blocks, other.Attributes, other.Blocks, strategy)
+		blocks[name] = b
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge(t *testing.T) {
+	decode := func(src string) *Parser {
+		p, err := DecodeBytes([]byte(src))
+		assert.NoError(t, err)
+		return p
+	}
+	base := `name = "cafe"
+tags = ["base"]
+server {
+    port = 80
+    host = "localhost"
+}
+`
+	override := `tags = ["prod"]
+server {
+    port = 443
+    tls {
+        enabled = true
+    }
+}
+`
+
+	p := decode(base)
+	assert.NoError(t, p.Merge(decode(override), MergeOverride))
+	assert.Equal(t, "cafe", p.Attributes["name"].Value)
+	assert.Equal(t, []interface{}{"prod"}, p.Attributes["tags"].Value)
+	assert.Equal(t, 443, p.Blocks["server"].Attributes["port"].Value)
+	assert.Equal(t, "localhost", p.Blocks["server"].Attributes["host"].Value)
+	assert.Equal(t, true, p.Blocks["server"].Blocks["tls"].Attributes["enabled"].Value)
+
+	p = decode(base)
+	assert.NoError(t, p.Merge(decode(override), MergeAppendArrays))
+	assert.Equal(t, []interface{}{"base", "prod"}, p.Attributes["tags"].Value)
+	assert.Equal(t, 443, p.Blocks["server"].Attributes["port"].Value)
+
+	// A conflict leaves the document unchanged
+	p = decode(base)
+	err := p.Merge(decode(override), MergeErrorOnConflict)
+	assert.Equal(t, &MergeConflictError{Name: "tags"}, err)
+	assert.EqualError(t, err, "merge conflict: tags is defined in both documents")
+	assert.Equal(t, []interface{}{"base"}, p.Attributes["tags"].Value)
+
+	p = decode(base)
+	assert.NoError(t, p.Merge(decode("debug = true\nserver {\n    timeout = 30\n}\n"), MergeErrorOnConflict))
+	assert.Equal(t, true, p.Attributes["debug"].Value)
+	assert.Equal(t, 30, p.Blocks["server"].Attributes["timeout"].Value)
+}
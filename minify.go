@@ -0,0 +1,134 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Minify rewrites a CAFE document in its smallest valid form: comments,
+// indentation and blank lines are stripped, multiline strings are joined
+// and every statement is written on its own line with no extra spaces.
+// The tokens of the document are kept in their original order, and the
+// minified document decodes to exactly the same values as the original one
+func Minify(src []byte) ([]byte, error) {
+	original, err := decodeBytesSafely(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	minifyItems(&out, original.lx.items)
+
+	// The lexer accepts a wide range of layouts, make sure the minified
+	// one means the same as the original
+	minified, err := decodeBytesSafely(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("minify: invalid minified document: %w", err)
+	}
+	originalJSON, err := json.Marshal(bodyToJSON(original.Attributes, original.Blocks))
+	if err != nil {
+		return nil, err
+	}
+	minifiedJSON, err := json.Marshal(bodyToJSON(minified.Attributes, minified.Blocks))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(originalJSON, minifiedJSON) {
+		return nil, fmt.Errorf("minify: the minified document differs from the original")
+	}
+	return out.Bytes(), nil
+}
+
+// Writes the statements of items in their smallest form
+func minifyItems(out *bytes.Buffer, items []item) {
+	for i := 0; i < len(items); i++ {
+		it := items[i]
+		switch it.kind {
+		case keyComment:
+			continue
+		case keyAttrDef:
+			out.WriteString(it.value + "=")
+		case keyBlockStart:
+			out.WriteString(it.value + "{\n")
+		case keyBlockEnd:
+			out.WriteString("}\n")
+		case keyArrayStart:
+			elems := []string{}
+			for i+1 < len(items) && items[i+1].kind != keyArrayEnd {
+				i++
+				switch items[i].kind {
+				case keyComment:
+				case keyBlockStart:
+					// Blocks of arrays hold statements of their own
+					end := blockEndIndex(items, i)
+					var body bytes.Buffer
+					minifyItems(&body, items[i+1:end])
+					elems = append(elems, "{"+body.String()+"}")
+					i = end
+				default:
+					elems = append(elems, items[i].value)
+				}
+			}
+			i++
+			out.WriteString("[" + strings.Join(elems, ",") + "]\n")
+		case keyMultiString:
+			value := fmt.Sprint(transformItemMultiString(it.value))
+			if strings.ContainsAny(value, "\"\n") {
+				out.WriteString(it.value + "\n")
+				continue
+			}
+			out.WriteString(`"` + value + "\"\n")
+		default:
+			out.WriteString(it.value + "\n")
+		}
+	}
+}
+
+// Returns the index of the item closing the block started at an index
+func blockEndIndex(items []item, start int) int {
+	depth := 0
+	for i := start; i < len(items); i++ {
+		switch items[i].kind {
+		case keyBlockStart:
+			depth += 1
+		case keyBlockEnd:
+			depth -= 1
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(items) - 1
+}
+
+// Decodes a document, returning the panics of the lexer and the parser
+// as errors
+func decodeBytesSafely(src []byte, opts ...Option) (p *Parser, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			p = nil
+			if parseErr, isParseErr := r.(*ParseError); isParseErr {
+				err = parseErr
+				return
+			}
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return DecodeBytes(src, opts...)
+}
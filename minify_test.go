@@ -0,0 +1,83 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMinify(t *testing.T) {
+	files := []string{
+		"./test_data/test-lexer.cafe",
+		"./test_data/test-k8s-deployment.cafe",
+		"./test_data/test-functions.cafe",
+		"./test_data/test-references.cafe",
+		"./test_data/test-array-blocks.cafe",
+	}
+	for _, filename := range files {
+		src, err := os.ReadFile(filename)
+		assert.NoError(t, err)
+
+		minified, err := Minify(src)
+		assert.NoError(t, err, filename)
+		assert.Less(t, len(minified), len(src), filename)
+		assert.NotContains(t, string(minified), "//", filename)
+		assert.NotContains(t, string(minified), "    ", filename)
+
+		// Same values as the original document
+		original, err := DecodeBytes(src)
+		assert.NoError(t, err)
+		decoded, err := DecodeBytes(minified)
+		assert.NoError(t, err, filename)
+		originalJSON, _ := original.ToJSON()
+		decodedJSON, _ := decoded.ToJSON()
+		assert.JSONEq(t, string(originalJSON), string(decodedJSON), filename)
+
+		// Minifying twice changes nothing
+		again, err := Minify(minified)
+		assert.NoError(t, err)
+		assert.Equal(t, string(minified), string(again), filename)
+	}
+
+	minified, err := Minify([]byte(`// Server
+server {
+    name = "web" // Inline
+    tags = [
+        "a",
+        "b"
+    ]
+}
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		`server{`,
+		`name="web"`,
+		`tags=["a","b"]`,
+		`}`,
+		``,
+	}, "\n"), string(minified))
+
+	// Blocks of arrays keep their statements
+	minified, err = Minify([]byte("endpoints = [\n    { host = \"a\", port = 80 }, // first\n    { host = \"b\" }\n]\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "endpoints=[{host=\"a\"\nport=80\n},{host=\"b\"\n}]\n", string(minified))
+
+	_, err = Minify([]byte("value = undefined_reference\n"))
+	assert.Error(t, err)
+}
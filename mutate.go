@@ -0,0 +1,244 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// Set defines the attribute at a path, as in "server.port", or replaces its
+// value, creating the blocks of the path that don't exist yet
+// Values are the ones of decoded documents: strings, ints, float64s, bools,
+// nil and arrays of them
+// A replaced attribute keeps its documentation and comments
+func (p *Parser) Set(path string, value Value) error {
+	names, err := mutationPath(path)
+	if err != nil {
+		return err
+	}
+	if _, err := encodeValue(value); err != nil {
+		return fmt.Errorf("cannot set attribute %s: %w", names, err)
+	}
+	if err := p.checkNames("attribute", names); err != nil {
+		return err
+	}
+
+	attributes, blocks, err := p.bodyAt(names[:len(names)-1], true)
+	if err != nil {
+		return err
+	}
+	name := names[len(names)-1]
+	if _, isBlock := blocks[name]; isBlock {
+		return fmt.Errorf("cannot set %s, it's a block: %w", names, ErrTypeMismatch)
+	}
+	attr, found := attributes[name]
+	if !found {
+		p.definitions[pathKey(names)] = definition{order: p.newDefinitionOrder()}
+	}
+
+	// The value isn't computed from an expression anymore
+	attr.Name, attr.Value, attr.kind, attr.expr = name, value, valueKind(value), ""
+	attributes[name] = attr
+	delete(p.expiries, names.String())
+	delete(p.secrets, names.String())
+	p.edits = append(p.edits, edit{kind: editSet, path: names, value: value})
+	return nil
+}
+
+// Delete removes the attribute or the block at a path, with everything in it
+func (p *Parser) Delete(path string) error {
+	names, err := mutationPath(path)
+	if err != nil {
+		return err
+	}
+	attributes, blocks, err := p.bodyAt(names[:len(names)-1], false)
+	if err != nil {
+		return err
+	}
+
+	name := names[len(names)-1]
+	_, isAttr := attributes[name]
+	_, isBlock := blocks[name]
+	if !isAttr && !isBlock {
+		return fmt.Errorf("%s is not defined: %w", names, ErrUndefined)
+	}
+	delete(attributes, name)
+	delete(blocks, name)
+	delete(p.expiries, names.String())
+	p.forgetPath(names.String())
+	p.edits = append(p.edits, edit{kind: editDelete, path: names})
+	return nil
+}
+
+// AddBlock defines an empty block at a path, as in "server.tls", creating
+// the blocks of the path that don't exist yet
+// Blocks that already exist are left as they are
+func (p *Parser) AddBlock(path string) error {
+	names, err := mutationPath(path)
+	if err != nil {
+		return err
+	}
+	if err := p.checkNames("block", names); err != nil {
+		return err
+	}
+	if _, _, err := p.bodyAt(names, true); err != nil {
+		return err
+	}
+	p.edits = append(p.edits, edit{kind: editAddBlock, path: names})
+	return nil
+}
+
+// SetDoc sets the doc comment of the attribute or the block at a path,
+// written as /// comments above it, so documents built in code can
+// document their settings
+// An empty doc removes it
+func (p *Parser) SetDoc(path string, doc string) error {
+	names, err := mutationPath(path)
+	if err != nil {
+		return err
+	}
+	attributes, blocks, err := p.bodyAt(names[:len(names)-1], false)
+	if err != nil {
+		return err
+	}
+
+	name := names[len(names)-1]
+	if attr, isAttr := attributes[name]; isAttr {
+		attr.Doc = doc
+		attributes[name] = attr
+	} else if b, isBlock := blocks[name]; isBlock {
+		b.Doc = doc
+		blocks[name] = b
+	} else {
+		return fmt.Errorf("%s is not defined: %w", names, ErrUndefined)
+	}
+	p.edits = append(p.edits, edit{kind: editDoc, path: names, text: doc})
+	return nil
+}
+
+// SetLineComment sets the comment written after the value of the attribute
+// at a path, as in port = 8080 // service port
+// An empty comment removes it
+func (p *Parser) SetLineComment(path string, comment string) error {
+	names, err := mutationPath(path)
+	if err != nil {
+		return err
+	}
+	attributes, blocks, err := p.bodyAt(names[:len(names)-1], false)
+	if err != nil {
+		return err
+	}
+
+	name := names[len(names)-1]
+	attr, isAttr := attributes[name]
+	if !isAttr {
+		if _, isBlock := blocks[name]; isBlock {
+			return fmt.Errorf("cannot set the comment of %s, it's a block: %w", names, ErrTypeMismatch)
+		}
+		return fmt.Errorf("%s is not defined: %w", names, ErrUndefined)
+	}
+	attr.LineComment = comment
+	attributes[name] = attr
+	p.edits = append(p.edits, edit{kind: editLineComment, path: names, text: comment})
+	return nil
+}
+
+// WriteFile writes the CAFE encoding of the document to a file, replacing
+// its content
+// The source of a document decoded from a single file or from bytes is
+// written back with only the attributes and blocks changed by Set, Delete
+// and AddBlock reprinted, keeping the comments, spacing and order of
+// everything else, as long as the edited source decodes to the document
+// Otherwise, the whole document is encoded
+// Nothing is written if the document can't be encoded
+func (p *Parser) WriteFile(filename string) error {
+	if src, rewritten := p.rewrite(); rewritten {
+		return os.WriteFile(filename, src, 0o644)
+	}
+
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(p); err != nil {
+		return err
+	}
+	return os.WriteFile(filename, buf.Bytes(), 0o644)
+}
+
+// Splits the path of a mutation into its names, none of which can be empty
+func mutationPath(path string) (Path, error) {
+	names := splitPath(path)
+	for _, name := range names {
+		if name == "" {
+			return nil, fmt.Errorf("invalid path %q: %w", path, ErrInvalidArgument)
+		}
+	}
+	return names, nil
+}
+
+// Returns the body of the block at a path, creating the blocks that don't
+// exist yet if create is true
+func (p *Parser) bodyAt(path Path, create bool) (map[string]Attribute, map[string]Block, error) {
+	attributes, blocks := p.Attributes, p.Blocks
+	for i, name := range path {
+		if _, isAttr := attributes[name]; isAttr {
+			return nil, nil, fmt.Errorf("%s is an attribute, not a block: %w", path[:i+1], ErrTypeMismatch)
+		}
+		b, found := blocks[name]
+		if !found {
+			if !create {
+				return nil, nil, fmt.Errorf("block %s is not defined: %w", path[:i+1], ErrUndefined)
+			}
+			b = Block{Name: name, Attributes: map[string]Attribute{}, Blocks: map[string]Block{}}
+			blocks[name] = b
+			p.definitions[pathKey(path[:i+1])] = definition{order: p.newDefinitionOrder()}
+		}
+		attributes, blocks = b.Attributes, b.Blocks
+	}
+	return attributes, blocks, nil
+}
+
+// Checks that none of the names of the path of an attribute or a block is
+// reserved, before anything is created
+func (p *Parser) checkNames(kind string, path Path) error {
+	for i, name := range path {
+		if !p.isReserved(name) {
+			continue
+		}
+		if i < len(path)-1 {
+			kind = "block"
+		}
+		return fmt.Errorf("%s %s uses the reserved word %q as its name: %w", kind, path[:i+1], name, ErrReservedName)
+	}
+	return nil
+}
+
+// Returns the kind of the attributes holding a value
+func valueKind(value Value) attrKind {
+	switch value.(type) {
+	case string:
+		return attrString
+	case int:
+		return attrInt
+	case float64:
+		return attrFloat
+	case bool:
+		return attrBool
+	case []interface{}:
+		return attrArray
+	default:
+		return attrNIL
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMutations(t *testing.T) {
+	p, err := DecodeBytes([]byte(`name = "cafe"
+server {
+    /// Port the server listens on
+    port = 8080 // default port
+    tls {
+        cert = "cert.pem"
+    }
+}
+`))
+	assert.NoError(t, err)
+
+	// Replaced attributes keep their comments
+	assert.NoError(t, p.Set("server.port", 9090))
+	assert.Equal(t, 9090, p.Blocks["server"].Attributes["port"].Value)
+	assert.Equal(t, "Port the server listens on", p.Blocks["server"].Attributes["port"].Doc)
+	assert.Equal(t, "default port", p.Blocks["server"].Attributes["port"].LineComment)
+
+	// Missing blocks are created
+	assert.NoError(t, p.Set(`database.primary."max connections"`, 100))
+	assert.Equal(t, 100, p.Blocks["database"].Blocks["primary"].Attributes["max connections"].Value)
+	assert.NoError(t, p.AddBlock("server.cache"))
+	assert.Contains(t, p.Blocks["server"].Blocks, "cache")
+	assert.NoError(t, p.AddBlock("server.tls"))
+	assert.Equal(t, "cert.pem", p.Blocks["server"].Blocks["tls"].Attributes["cert"].Value)
+
+	assert.NoError(t, p.Delete("server.tls"))
+	assert.NotContains(t, p.Blocks["server"].Blocks, "tls")
+	assert.NoError(t, p.Delete("name"))
+	assert.NotContains(t, p.Attributes, "name")
+
+	for _, test := range []struct {
+		err  error
+		kind error
+	}{
+		{p.Set("server", 1), ErrTypeMismatch},
+		{p.Set("server.port.number", 1), ErrTypeMismatch},
+		{p.Set("tags", []interface{}{[]interface{}{1}}), nil},
+		{p.Set("upper.port", 1), ErrReservedName},
+		{p.Set("server..port", 1), ErrInvalidArgument},
+		{p.Delete("server.tls"), ErrUndefined},
+		{p.Delete("cache.size"), ErrUndefined},
+		{p.AddBlock("server.port"), ErrTypeMismatch},
+	} {
+		assert.Error(t, test.err)
+		if test.kind != nil {
+			assert.True(t, errors.Is(test.err, test.kind), test.err.Error())
+		}
+	}
+	assert.NotContains(t, p.Blocks, "upper")
+
+	// The modified document is written back as valid CAFE
+	filename := filepath.Join(t.TempDir(), "app.cafe")
+	assert.NoError(t, p.WriteFile(filename))
+	written, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, `server {
+    /// Port the server listens on
+    port = 9090 // default port
+    cache {
+    }
+}
+database {
+    primary {
+        "max connections" = 100
+    }
+}
+`, string(written))
+
+	decoded, err := Decode(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, 9090, decoded.Blocks["server"].Attributes["port"].Value)
+}
+
+func TestSetComments(t *testing.T) {
+	p, err := DecodeBytes([]byte("port = 8080\nserver {\n}\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, p.SetDoc("port", "Port the server listens on"))
+	assert.NoError(t, p.SetLineComment("port", "default"))
+	assert.NoError(t, p.SetDoc("server", "Server settings"))
+	assert.ErrorIs(t, p.SetLineComment("server", "web"), ErrTypeMismatch)
+	assert.ErrorIs(t, p.SetDoc("missing", "doc"), ErrUndefined)
+
+	var out bytes.Buffer
+	assert.NoError(t, NewEncoder(&out).Encode(p))
+	assert.Equal(t, `/// Port the server listens on
+port = 8080 // default
+/// Server settings
+server {
+}
+`, out.String())
+}
+
+func TestSetExpiring(t *testing.T) {
+	registerTestFunction(t, "fetch_replicas", func(args []Value) (Value, error) {
+		return Expiring{Value: 3, TTL: time.Minute}, nil
+	})
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := newParser(splitTestInput("server {\n    replicas = fetch_replicas()\n}\n"))
+	p.clock = func() time.Time { return now }
+	p.parseItems(nil)
+	assert.NoError(t, p.err)
+
+	// Set values aren't computed again
+	assert.NoError(t, p.Set("server.replicas", 5))
+	now = now.Add(time.Minute)
+	_, err := p.Refresh()
+	assert.NoError(t, err)
+	assert.Equal(t, 5, p.Blocks["server"].Attributes["replicas"].Value)
+}
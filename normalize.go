@@ -0,0 +1,91 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"bytes"
+	"math"
+)
+
+// NormalizeOptions configures how Normalize writes a document
+type NormalizeOptions struct {
+	// Indentation of nested blocks
+	// Empty by default, which produces the smallest output
+	Indent string
+
+	// Write floats with integral values as ints, so 2 and 2.0 normalize
+	// the same way, like JSON numbers do
+	MergeNumberKinds bool
+}
+
+// Normalize writes a document semantically equivalent to p in a canonical
+// form: keys are sorted, expressions are replaced by their values, comments
+// are stripped and numbers are written in their shortest form.
+// Documents with the same content always normalize to the same bytes, so
+// the result can be hashed, deduplicated or stored for later comparison
+// Secrets are redacted, see Parser.IsSecret
+func Normalize(p *Parser, opts NormalizeOptions) ([]byte, error) {
+	normalized := newParser(nil)
+	normalizeBody(p.Attributes, p.Blocks, normalized.Attributes, normalized.Blocks, opts)
+	normalized.secrets = p.secrets
+
+	var out bytes.Buffer
+	encoder := NewEncoder(&out)
+	encoder.SetIndent(opts.Indent)
+	if err := encoder.Encode(normalized); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Copies a body into another one, normalizing its values
+func normalizeBody(attributes map[string]Attribute, blocks map[string]Block, dstAttributes map[string]Attribute, dstBlocks map[string]Block, opts NormalizeOptions) {
+	for name, attr := range attributes {
+		dstAttributes[name] = Attribute{
+			Name:  name,
+			Value: normalizeValue(attr.Value, opts),
+			kind:  attr.kind,
+		}
+	}
+
+	for name, b := range blocks {
+		newBlock := Block{
+			Name:       name,
+			Attributes: map[string]Attribute{},
+			Blocks:     map[string]Block{},
+		}
+		normalizeBody(b.Attributes, b.Blocks, newBlock.Attributes, newBlock.Blocks, opts)
+		dstBlocks[name] = newBlock
+	}
+}
+
+// Normalizes a single value
+func normalizeValue(value interface{}, opts NormalizeOptions) interface{} {
+	switch val := value.(type) {
+	case float64:
+		if opts.MergeNumberKinds && val == math.Trunc(val) && math.Abs(val) < 1<<53 {
+			return int(val)
+		}
+		return val
+	case []interface{}:
+		elems := make([]interface{}, len(val))
+		for i, elem := range val {
+			elems[i] = normalizeValue(elem, opts)
+		}
+		return elems
+	default:
+		return value
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	a, err := DecodeBytes([]byte(`// Service configuration
+server {
+    port = 4000 * 2
+    ratio = 10.10
+    name = upper("web")
+}
+tags = ["a", 2.0]
+`))
+	assert.NoError(t, err)
+
+	b, err := DecodeBytes([]byte(`tags = ["a", 2.00]
+server {
+        name = "WEB"
+        ratio = 10.1
+        port = 8000
+}
+`))
+	assert.NoError(t, err)
+
+	normalizedA, err := Normalize(a, NormalizeOptions{})
+	assert.NoError(t, err)
+	normalizedB, err := Normalize(b, NormalizeOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, normalizedA, normalizedB)
+	assert.Equal(t, `tags = ["a", 2.0]
+server {
+name = "WEB"
+port = 8000
+ratio = 10.1
+}
+`, string(normalizedA))
+
+	// The normalized document is still a valid one
+	decoded, err := DecodeBytes(normalizedA)
+	assert.NoError(t, err)
+	assert.Equal(t, 8000, decoded.Blocks["server"].Attributes["port"].Value)
+
+	normalized, err := Normalize(a, NormalizeOptions{Indent: "  ", MergeNumberKinds: true})
+	assert.NoError(t, err)
+	assert.Equal(t, `tags = ["a", 2]
+server {
+  name = "WEB"
+  port = 8000
+  ratio = 10.1
+}
+`, string(normalized))
+}
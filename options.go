@@ -0,0 +1,427 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// An Option configures how a CAFE file is decoded
+type Option func(*options)
+
+// DuplicatePolicy defines what happens to an attribute defined twice in the
+// same block
+type DuplicatePolicy int
+
+const (
+	// The decoding fails with a RedefinitionError
+	DuplicateError DuplicatePolicy = iota
+
+	// The first definition is kept and the others are ignored
+	DuplicateKeepFirst
+
+	// Each definition replaces the previous one
+	DuplicateKeepLast
+)
+
+// KeyNaming defines the names of the attributes and blocks stored in the
+// fields of structs without a name in their `cafe` tag
+type KeyNaming int
+
+const (
+	// The name of the field, matched case insensitively
+	NamingFieldName KeyNaming = iota
+
+	// The name of the field in snake case, as in "max_conns" for MaxConns
+	NamingSnakeCase
+
+	// The name of the field in camel case, as in "maxConns" for MaxConns
+	NamingCamelCase
+)
+
+// options holds the configuration of a decoding
+// Its zero value is the default configuration
+type options struct {
+	// env() can't read environment variables
+	disallowEnv bool
+
+	// file() and templatefile() can't read files
+	disallowFiles bool
+
+	// Called as the lexer goes through the input
+	progress func(done, total int)
+
+	// Names attributes and blocks can't have, besides the keywords and
+	// the function names
+	reservedWords []string
+
+	// Repeated names and short strings share their memory
+	interning bool
+
+	// Random functions use a fixed seed
+	seeded bool
+	seed   int64
+
+	// Name of the profile applied over the defaults
+	profile string
+
+	// What to do with attributes defined twice in the same block
+	duplicates DuplicatePolicy
+
+	// Unexpected characters are errors instead of being ignored
+	strict bool
+
+	// Deepest level of nested blocks, zero for no limit
+	maxDepth int
+
+	// Functions callable only from this decoding
+	functions map[string]Function
+
+	// Values the expressions can refer to by name
+	variables map[string]Value
+
+	// Receives the steps of the lexer and the parser, nil for none
+	logger Logger
+
+	// Called with the stats of every document decoded
+	stats func(ParseStats)
+
+	// Called with the first token of every statement parsed
+	parseHook func(Token)
+
+	// Statements that can't be decoded are skipped, for DecodePartial
+	partial bool
+
+	// The input is lexed while it's parsed
+	pipeline bool
+
+	// Names of the attributes and blocks stored in untagged fields
+	naming KeyNaming
+
+	// Transform the values Unmarshal stores, in order
+	decodeHooks []DecodeHook
+
+	// Unmarshal fails on attributes and blocks no field stores
+	disallowUnknownKeys bool
+
+	// Filled by Unmarshal with the keys it used and ignored
+	metadata *Metadata
+
+	// Receives the documents a Watcher decodes without errors
+	store *Store
+
+	// Top level blocks decoded, nil for all of them
+	onlyBlocks map[string]bool
+
+	// Client fetching documents from URLs, nil for http.DefaultClient
+	httpClient *http.Client
+
+	// How long fetching a document from a URL can take, zero for the
+	// default
+	fetchTimeout time.Duration
+
+	// Largest document fetched from a URL, zero for the default
+	fetchLimit int64
+
+	// Documents fetched from URLs can read the environment and local files
+	localAccess bool
+
+	// The document was fetched from a URL, so relative paths can't be
+	// resolved
+	remote bool
+
+	// The document is decoded by Lint, which needs the paths it references
+	linting bool
+
+	// Hex SHA-256 of the documents fetched from URLs, if checked
+	checksum string
+
+	// File system the files are read from, nil for the one of the OS
+	fsys fs.FS
+
+	// Files don't need the .cafe extension
+	anyExtension bool
+}
+
+// Builds the configuration of a decoding from its options
+func newOptions(opts []Option) options {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithoutEnv makes env() calls fail, so files coming from untrusted sources
+// can't read the environment variables of the process
+func WithoutEnv() Option {
+	return func(o *options) {
+		o.disallowEnv = true
+	}
+}
+
+// WithoutFiles makes file() and templatefile() calls and include directives
+// fail, so files coming from untrusted sources can't read other files of
+// the system
+func WithoutFiles() Option {
+	return func(o *options) {
+		o.disallowFiles = true
+	}
+}
+
+// WithProgress calls fn as the input is lexed, with the number of characters
+// lexed so far and the total number of characters of the input, so callers
+// can report the progress of very large inputs
+// fn is called at most about a thousand times, and always once the whole
+// input is lexed
+func WithProgress(fn func(done, total int)) Option {
+	return func(o *options) {
+		o.progress = fn
+	}
+}
+
+// WithReservedWords forbids attributes and blocks named after any of the
+// given words, on top of the keywords and function names that are always
+// reserved
+func WithReservedWords(words ...string) Option {
+	return func(o *options) {
+		o.reservedWords = append(o.reservedWords, words...)
+	}
+}
+
+// WithSeed fixes the seed of the random functions, such as uuid() and
+// randint(), so decoding the same file always produces the same values.
+// Useful for tests and reproducible builds
+func WithSeed(seed int64) Option {
+	return func(o *options) {
+		o.seeded = true
+		o.seed = seed
+	}
+}
+
+// WithInterning keeps a single copy of the repeated names and short string
+// values of a document, which cuts the memory used by documents with
+// thousands of similarly named entries, such as generated configurations
+func WithInterning() Option {
+	return func(o *options) {
+		o.interning = true
+	}
+}
+
+// WithProfile applies the sections of a profile, like profile "prod" { },
+// over the rest of the file
+// Sections of other profiles are ignored
+func WithProfile(name string) Option {
+	return func(o *options) {
+		o.profile = name
+	}
+}
+
+// WithDuplicates sets what happens to the attributes defined twice in the
+// same block, which is a RedefinitionError by default
+func WithDuplicates(policy DuplicatePolicy) Option {
+	return func(o *options) {
+		o.duplicates = policy
+	}
+}
+
+// WithStrictMode makes the characters the lexer doesn't recognize errors,
+// instead of ignoring them
+func WithStrictMode() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// WithMaxDepth limits how deep blocks can be nested, so files coming from
+// untrusted sources can't build arbitrarily deep documents
+// A block at the top level has a depth of 1
+func WithMaxDepth(depth int) Option {
+	return func(o *options) {
+		o.maxDepth = depth
+	}
+}
+
+// WithFunctions makes functions callable from the decoded file only, unlike
+// RegisterFunction, which makes them callable from every file
+// They take precedence over the registered functions with the same name,
+// but not over the builtin ones
+func WithFunctions(fns map[string]Function) Option {
+	return func(o *options) {
+		if o.functions == nil {
+			o.functions = map[string]Function{}
+		}
+		for name, fn := range fns {
+			o.functions[name] = fn
+		}
+	}
+}
+
+// WithVariables defines values the expressions of the decoded file can refer
+// to by name, like attributes that aren't part of the decoded document
+// Attributes with the same name take precedence over them
+func WithVariables(variables map[string]Value) Option {
+	return func(o *options) {
+		if o.variables == nil {
+			o.variables = map[string]Value{}
+		}
+		for name, value := range variables {
+			o.variables[name] = value
+		}
+	}
+}
+
+// WithPipeline makes DecodeReader read and lex the input in a goroutine of
+// its own, which sends the items it lexes to the parser as they're ready,
+// so reading, lexing and parsing overlap on large inputs
+// The other decoding functions lex inputs they already read at once
+func WithPipeline() Option {
+	return func(o *options) {
+		o.pipeline = true
+	}
+}
+
+// WithKeyNaming sets the names Unmarshal and Marshal give the attributes and
+// blocks of the fields of structs without a name in their `cafe` tag, so Go
+// fields and configuration files can follow their own style
+func WithKeyNaming(naming KeyNaming) Option {
+	return func(o *options) {
+		o.naming = naming
+	}
+}
+
+// WithDecodeHook runs hooks on the values of the attributes and blocks before
+// Unmarshal stores them, so custom coercions like "10MB" to a number of
+// bytes don't need a type of their own
+// Hooks run in the order they're given, each one transforming the value
+// returned by the previous one
+func WithDecodeHook(hooks ...DecodeHook) Option {
+	return func(o *options) {
+		o.decodeHooks = append(o.decodeHooks, hooks...)
+	}
+}
+
+// WithDisallowUnknownKeys makes Unmarshal fail on the attributes and blocks
+// no field of the struct stores, catching typos like "prot = 8080" instead
+// of ignoring them
+// Blocks stored in maps or interfaces can hold any name
+func WithDisallowUnknownKeys() Option {
+	return func(o *options) {
+		o.disallowUnknownKeys = true
+	}
+}
+
+// WithMetadata makes Unmarshal fill md with the attributes and blocks it
+// stored, the ones no field stores and the values that don't fit their
+// fields, which don't stop the unmarshalling anymore
+// Unmarshal still returns the first type mismatch as its error
+func WithMetadata(md *Metadata) Option {
+	return func(o *options) {
+		o.metadata = md
+	}
+}
+
+// WithOnlyBlocks decodes only the given blocks of the top level, skipping
+// the others without evaluating them, which cuts the cost of decoding large
+// shared documents a program only uses a part of
+// Attributes of the top level are always decoded, and references to the
+// attributes of skipped blocks are undefined
+func WithOnlyBlocks(names ...string) Option {
+	return func(o *options) {
+		if o.onlyBlocks == nil {
+			o.onlyBlocks = map[string]bool{}
+		}
+		for _, name := range names {
+			o.onlyBlocks[name] = true
+		}
+	}
+}
+
+// WithSecretResolver defines the secret(path) function for the decoded
+// file, which resolves secrets with the resolver, as in
+// password = secret("db/password")
+// The attributes holding secrets, or values computed from them, are
+// redacted by ToJSON, String and the Encoder, see Parser.IsSecret
+func WithSecretResolver(resolver SecretResolver) Option {
+	return WithFunctions(map[string]Function{"secret": secretFunction(resolver)})
+}
+
+// WithHTTPClient fetches the documents of URLs with a client, such as one
+// with the certificates or the authentication of a config server
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = client
+	}
+}
+
+// WithFetchLimit limits the size of the documents fetched from URLs, 10 MiB
+// by default
+func WithFetchLimit(bytes int64) Option {
+	return func(o *options) {
+		o.fetchLimit = bytes
+	}
+}
+
+// WithLocalAccess lets documents fetched from URLs read the environment
+// variables and the files of the local host, which they can't by default,
+// as if decoded with WithoutEnv and WithoutFiles
+// Relative paths are still errors, as they can't be resolved from a URL
+func WithLocalAccess() Option {
+	return func(o *options) {
+		o.localAccess = true
+	}
+}
+
+// WithFetchTimeout limits how long fetching a document from a URL can
+// take, 30 seconds by default
+func WithFetchTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.fetchTimeout = timeout
+	}
+}
+
+// WithChecksum makes fetching a document from a URL fail with
+// ErrChecksumMismatch unless its SHA-256 has the hex value of sum, so
+// documents altered on their way aren't decoded
+func WithChecksum(sum string) Option {
+	return func(o *options) {
+		o.checksum = strings.ToLower(sum)
+	}
+}
+
+// WithAnyExtension decodes files whatever their extension, instead of
+// only .cafe files, such as config.conf or files without extension
+func WithAnyExtension() Option {
+	return func(o *options) {
+		o.anyExtension = true
+	}
+}
+
+// WithDebug prints the steps of the lexer and the parser to the standard
+// output, see WithLogger
+func WithDebug() Option {
+	return WithLogger(stdoutLogger{})
+}
+
+// WithLogger passes the steps of the lexer and the parser to a logger, such
+// as a *slog.Logger, to trace how a document is decoded
+func WithLogger(logger Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
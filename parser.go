@@ -15,8 +15,15 @@
 package cafe
 
 import (
+	"errors"
 	"fmt"
+	"math/rand"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 // attrKind  defines all kinds of possible Attributes of an CAFE file
@@ -33,6 +40,7 @@ const (
 	attrComparison                 // 7
 	attrCondition                  // 8
 	attrFunction                   // 9
+	attrReference                  // 10
 )
 
 // The Parser is called after the input goes through the lexer
@@ -49,64 +57,336 @@ type Parser struct {
 	currentItemIndex int
 
 	// List of global Attributes
-	Attributes map[string]attribute
+	Attributes map[string]Attribute
 
 	// List of the current nested Blocks names
 	currentBlocks []string
 
+	// Path of the current block, and the blocks it was joined from
+	scope       string
+	scopeBlocks []string
+
 	// List of Blocks of the file
-	Blocks map[string]block
+	Blocks map[string]Block
 
 	// Last item was reached
 	atLastItem bool
+
+	// Batches of items still being lexed, nil once they all were
+	pending <-chan lexBatch
+
+	// Name of the parsed file, empty if not parsing a file
+	filename string
+
+	// Where each attribute and block was defined, by their path
+	definitions map[definitionKey]definition
+
+	// Number of paths defined so far, the order of the next new one
+	definitionCount int
+
+	// Layer of the definitions being parsed
+	// A definition can shadow the ones from lower layers, such as the
+	// defaults below a profile, but never the ones from its own layer
+	layer int
+
+	// Errors found while parsing, joined
+	// The parsing goes on after most of them, so they can be fixed at once
+	err error
+
+	// Each of the errors found while parsing
+	errs []error
+
+	// Configuration of the decoding
+	opts options
+
+	// When the attributes holding expiring values expire, by their path
+	expiries map[string]expiry
+
+	// Shortest TTL of the expiring values of the expression being
+	// evaluated, zero if none of them expires
+	ttl time.Duration
+
+	// Returns the current time, time.Now if nil
+	clock func() time.Time
+
+	// When the input started to be lexed, for WithStats
+	started time.Time
+
+	// Size of the input in bytes, for WithStats
+	inputBytes int
+
+	// Reader of the input of DecodeReader, counting its bytes for
+	// WithStats
+	reader *countingReader
+
+	// Lines of the doc comments waiting for the next definition
+	doc []string
+
+	// Line of the last doc comment
+	docLine int
+
+	// Source of the random functions, created on their first call
+	rand *rand.Rand
+
+	// Values of the local variables, by the path of their block
+	locals map[string]map[string]interface{}
+
+	// The expression being evaluated refers to local variables
+	usedLocals bool
+
+	// Paths of the attributes and local variables holding secrets
+	secrets map[string]bool
+
+	// The expression being evaluated holds a secret
+	usedSecret bool
+
+	// Paths of the local variables referenced so far
+	referencedLocals map[string]bool
+
+	// Paths of the attributes and blocks referenced so far
+	referencedPaths map[string]bool
+
+	// Observers of the attributes changed by Refresh
+	subscriptions []*subscription
+
+	// Absolute paths of the files including the one being parsed
+	includes []string
+
+	// Absolute paths of the files included by the document, directly or
+	// through other included files
+	includedFiles []string
+
+	// Paths of the files read by file() and templatefile(), in the document
+	// or in the files it includes
+	readFiles []string
+
+	// Items of the sections of the selected profile
+	profileSections []itemRange
+
+	// Parsing a vars block, whose attributes are local variables
+	inVars bool
+
+	// Templates defined so far, by their names
+	templates map[string]template
+
+	// Values of the parameters of the templates being used and of the
+	// variables of the for blocks being generated, innermost last
+	bindings []map[string]interface{}
+
+	// Names of the templates being used, innermost last
+	usedTemplates []string
+
+	// Source of the decoded document, kept to write it back with only its
+	// edits reprinted, nil if the document has no single source
+	source []byte
+
+	// Changes made through the mutation API, in order
+	edits []edit
+}
+
+// template is a block body defined once and used in many blocks, with
+// the values of its parameters
+type template struct {
+	// Names of the parameters
+	params []string
+
+	// Items of the body
+	body itemRange
 }
 
-// attribute defines the variables of an CAFE file
-type attribute struct {
+// itemRange is a range of items of the lexer, end excluded
+type itemRange struct {
+	start int
+	end   int
+}
+
+// definition records where an attribute or a block was defined
+// Key of a definition: the path of the block it's in, in its
+// "block.nested" form, and its name
+// Keeping them apart spares joining a path for every definition
+type definitionKey struct {
+	scope string
+	name  string
+}
+
+// Returns the key of the definition at a path
+func pathKey(path Path) definitionKey {
+	return definitionKey{scope: path[:len(path)-1].String(), name: path[len(path)-1]}
+}
+
+// Returns the path of a definition in its "block.nested.name" form
+func (key definitionKey) String() string {
+	if key.scope == "" {
+		return quoteKey(key.name)
+	}
+	return key.scope + "." + quoteKey(key.name)
+}
+
+type definition struct {
+	// Position of the definition
+	position position
+
+	// Layer of the definition
+	layer int
+
+	// Whether the definition is a default assigned with ?=, which other
+	// definitions of the same layer can replace
+	isDefault bool
+
+	// Order of the first definition of the path among the ones of the
+	// document, kept by the definitions shadowing it
+	order int
+}
+
+// Position is a place in the source of a document
+type Position struct {
+	// Line number starting in 1
+	Line int
+
+	// Column number starting in 1
+	Column int
+}
+
+// Range is the part of the source of a document where something was
+// defined, from the first character of its name up to, but not including,
+// End
+type Range struct {
+	Start Position
+	End   Position
+}
+
+// Returns the range from the start of an item up to the end of another
+func itemsRange(first item, last item) Range {
+	end := Position{Line: last.position.Line, Column: last.position.Column}
+	value := last.value
+	if eol := strings.LastIndexByte(value, '\n'); eol >= 0 {
+		// Values spanning lines, like block comments, end in their last
+		// line
+		end.Line += strings.Count(value, "\n")
+		end.Column = 1
+		value = value[eol+1:]
+	}
+	length := utf8.RuneCountInString(value)
+	if length == 0 && end.Line == last.position.Line {
+		length = 1
+	}
+	end.Column += length
+	return Range{
+		Start: Position{Line: first.position.Line, Column: first.position.Column},
+		End:   end,
+	}
+}
+
+// Attribute defines the variables of an CAFE file
+type Attribute struct {
 	// Name of the attribute
 	Name string
 
 	// Attribute value
 	Value interface{}
 
+	// Documentation of the attribute, from the "///" comments right above it
+	Doc string
+
+	// Comment after the value of the attribute, in the line it ends, without
+	// its comment sequences
+	LineComment string
+
+	// Where the attribute was defined, in the file that defined it
+	Range Range
+
 	// Kind of the attribute
 	kind attrKind
+
+	// Source text of the expression the value comes from, empty for
+	// literal values
+	expr string
 }
 
 // Blocks are structures in an CAFE that can hold multiple
 // Attributes and even other Blocks
-type block struct {
+type Block struct {
 	// Name of the block
 	Name string
 
+	// Documentation of the block, from the "///" comments right above it
+	Doc string
+
+	// Where the block was defined, from its name to its closing brace, in
+	// the file that defined it
+	Range Range
+
 	// List of Attributes of the block
-	Attributes map[string]attribute
+	Attributes map[string]Attribute
 
 	// List of Blocks inside this block
-	Blocks map[string]block
+	Blocks map[string]Block
 }
 
 // Creates a Parser
-func newParser(input []string) *Parser {
+func newParser(input []rune, opts ...Option) *Parser {
+	return newParserWithOptions(input, newOptions(opts))
+}
+
+// Creates a Parser with the configuration of another decoding
+func newParserWithOptions(input []rune, o options) *Parser {
+	// Nothing to lex in an empty input
+	if len(input) == 0 {
+		return newParserFromLexer(&lexer{}, o)
+	}
+
+	started := time.Now()
+	lx := newLexerWithOptions(input, o)
+	lx.lexInput(o.logger)
+
+	// The input isn't needed once lexed, let it be collected
+	lx.input = nil
+
+	p := newParserFromLexer(lx, o)
+	if o.stats != nil {
+		p.started, p.inputBytes = started, inputSize(input)
+	}
+	return p
+}
+
+// Creates a lexer with the configuration of a decoding
+func newLexerWithOptions(input []rune, o options) *lexer {
 	lx := newLexer(input)
-	lx.lexInput(false)
+	lx.progress = o.progress
+	if o.interning {
+		lx.strings = interner{}
+	}
+	lx.callPrefixes = functionCallPrefixes(o.functions)
+	return lx
+}
 
-	return &Parser{
+// Creates a Parser for the items of a lexer
+func newParserFromLexer(lx *lexer, o options) *Parser {
+	p := &Parser{
 		lx:               lx,
-		currentItem:      lx.items[0],
 		currentItemIndex: 0,
-		Attributes:       map[string]attribute{},
+		Attributes:       map[string]Attribute{},
 		currentBlocks:    []string{},
-		Blocks:           map[string]block{},
-		atLastItem:       false,
+		Blocks:           map[string]Block{},
+		atLastItem:       len(lx.items) == 0,
+		definitions:      map[definitionKey]definition{},
+		opts:             o,
+		expiries:         map[string]expiry{},
+		locals:           map[string]map[string]interface{}{},
+		referencedLocals: map[string]bool{},
+		referencedPaths:  map[string]bool{},
+	}
+	if !p.atLastItem {
+		p.currentItem = lx.items[0]
 	}
+	return p
 }
 
 // Moves the Parser to the next item
 func (p *Parser) nextItem(nextCount int) {
 	p.currentItemIndex += nextCount
 
-	if p.currentItemIndex == len(p.lx.items) {
+	if !p.hasItem(p.currentItemIndex) {
 		p.atLastItem = true
 		return
 	}
@@ -117,17 +397,37 @@ func (p *Parser) nextItem(nextCount int) {
 // Returns the next item of the current one
 // Does not move the Parser to the next item
 func (p *Parser) peekNextItem() item {
-	if p.currentItemIndex == len(p.lx.items)-1 {
+	if !p.hasItem(p.currentItemIndex + 1) {
 		return item{}
 	}
 	return p.lx.items[p.currentItemIndex+1]
 }
 
+// Reports if there's an item at an index, waiting for the lexer if it's
+// still lexing the items in pipeline mode
+func (p *Parser) hasItem(index int) bool {
+	for index >= len(p.lx.items) && p.pending != nil {
+		batch, open := <-p.pending
+		switch {
+		case !open:
+			p.pending = nil
+		case batch.err != nil:
+			panic(&readError{err: batch.err})
+		case batch.failure != nil:
+			panic(batch.failure)
+		default:
+			p.lx.items = append(p.lx.items, batch.items...)
+			p.lx.lines = append(p.lx.lines, batch.lines...)
+		}
+	}
+	return index < len(p.lx.items)
+}
+
 // Goes recurssively into the nested Blocks until
 // it reaches the last one in the nest
 // Returns a boolean (is in block = true / is not in block = false)
 // Returns a pointer to the block (if any)
-func (p *Parser) getCurrentBlock() (bool, *block) {
+func (p *Parser) getCurrentBlock() (bool, *Block) {
 	if len(p.currentBlocks) == 0 {
 		return false, nil
 	}
@@ -146,6 +446,246 @@ func (p *Parser) getCurrentBlock() (bool, *block) {
 	return true, &lastBlock
 }
 
+// Returns all the Blocks the Parser is currently nested in,
+// from the outermost to the innermost
+func (p *Parser) getBlocksChain() []Block {
+	chain := []Block{}
+	for i, b := range p.currentBlocks {
+		if i == 0 {
+			chain = append(chain, p.Blocks[b])
+			continue
+		}
+		chain = append(chain, chain[i-1].Blocks[b])
+	}
+	return chain
+}
+
+// Finds the value an identifier refers to
+// Plain names are searched from the innermost block up to the global
+// Attributes, while dotted names (block.nested.attr) are always searched
+// from the global scope
+// Identifiers that name a block return its content as a map
+func (p *Parser) lookupIdentifier(name string) (interface{}, bool) {
+	if strings.Contains(name, ".") {
+		path := strings.Split(name, ".")
+		if p.tracksReferences() {
+			p.touchPath(Path(path).String())
+		}
+		return lookupPath(p.Attributes, p.Blocks, path)
+	}
+
+	// The parameters of templates and the variables of for blocks come
+	// first
+	for i := len(p.bindings) - 1; i >= 0; i-- {
+		if value, found := p.bindings[i][name]; found {
+			p.usedLocals = true
+			return value, true
+		}
+	}
+
+	chain := p.getBlocksChain()
+	for i := len(chain) - 1; i >= 0; i-- {
+		if len(p.locals) > 0 {
+			scope := Path(p.currentBlocks[:i+1]).String()
+			if value, found := p.locals[scope][name]; found {
+				p.usedLocals = true
+				p.referencedLocals[scope+"."+name] = true
+				p.touchSecret(scope + "." + quoteKey(name))
+				return value, true
+			}
+		}
+		if value, found := lookupPath(chain[i].Attributes, chain[i].Blocks, []string{name}); found {
+			if p.tracksReferences() {
+				p.touchPath(childPath(p.currentBlocks[:i+1], name).String())
+			}
+			return value, true
+		}
+	}
+	if value, found := p.locals[""][name]; found {
+		p.usedLocals = true
+		p.referencedLocals[name] = true
+		p.touchSecret(quoteKey(name))
+		return value, true
+	}
+	if value, found := lookupPath(p.Attributes, p.Blocks, []string{name}); found {
+		if p.tracksReferences() {
+			p.touchPath(quoteKey(name))
+		}
+		return value, true
+	}
+	if value, found := p.opts.variables[name]; found {
+		p.usedLocals = true
+		return value, true
+	}
+	return nil, false
+}
+
+// Whether the references to attributes and blocks are recorded, which only
+// Lint and the secrets need
+func (p *Parser) tracksReferences() bool {
+	return p.opts.linting || len(p.secrets) > 0
+}
+
+// Records a reference to the attribute or block at path, for Lint and for
+// the secrets
+func (p *Parser) touchPath(path string) {
+	if p.opts.linting {
+		p.referencedPaths[path] = true
+	}
+	p.touchSecret(path)
+}
+
+// Defines a local variable in the current block
+// Local variables can be referenced from their block and the blocks nested
+// in it, but they aren't part of the parsed file
+func (p *Parser) defineLocal(name string, value interface{}) {
+	if !functionNameRegexp.MatchString(name) {
+		panic(evalErrorf(ErrInvalidExpression, "invalid local variable name %q", name))
+	}
+	p.takeDoc(p.currentItem.position.Line)
+
+	// Locals share their names with the attributes and blocks of the block
+	if _, err := p.define("local variable", name); err != nil {
+		p.fail(err)
+		return
+	}
+
+	scope := p.currentScope()
+	if p.locals[scope] == nil {
+		p.locals[scope] = map[string]interface{}{}
+	}
+	p.locals[scope][name] = value
+	p.recordSecret(name, false)
+}
+
+// Follows a path of block names until the last element, which can be
+// either an attribute or a block
+func lookupPath(attributes map[string]Attribute, blocks map[string]Block, path []string) (interface{}, bool) {
+	if len(path) == 1 {
+		if attr, found := attributes[path[0]]; found {
+			return attr.Value, true
+		}
+		if b, found := blocks[path[0]]; found {
+			return bodyToJSON(b.Attributes, b.Blocks), true
+		}
+		return nil, false
+	}
+
+	b, found := blocks[path[0]]
+	if !found {
+		return nil, false
+	}
+	return lookupPath(b.Attributes, b.Blocks, path[1:])
+}
+
+// Words of the language that can't name attributes or blocks
+var keywords = []string{"if", "for", "true", "false", "null", "let", "vars", "include"}
+
+// Checks if a name is a keyword, a function name or one of the reserved
+// words added through the options
+func (p *Parser) isReserved(name string) bool {
+	return equalsToMany(name, keywords) ||
+		isFunctionName(name) ||
+		p.opts.functions[name] != nil ||
+		equalsToMany(name, p.opts.reservedWords)
+}
+
+// Returns the path of a name in the current block
+func (p *Parser) currentPath(name string) string {
+	if len(p.currentBlocks) == 0 {
+		return quoteKey(name)
+	}
+	return p.currentScope() + "." + quoteKey(name)
+}
+
+// Returns the key of the definition of a name in the current block
+func (p *Parser) currentKey(name string) definitionKey {
+	return definitionKey{scope: p.currentScope(), name: name}
+}
+
+// Returns the path of the current block, joined again only when the blocks
+// changed since the last time
+func (p *Parser) currentScope() string {
+	if !equalStrings(p.scopeBlocks, p.currentBlocks) {
+		p.scope = Path(p.currentBlocks).String()
+		p.scopeBlocks = append(p.scopeBlocks[:0], p.currentBlocks...)
+	}
+	return p.scope
+}
+
+// Returns the line an attribute or a block of the body at path was defined
+// in, or 0 if it wasn't decoded from a file
+func (p *Parser) definitionLine(path []string, name string) int {
+	return p.definitions[definitionKey{scope: Path(path).String(), name: name}].position.Line
+}
+
+// Records the definition of an attribute or a block in the current block
+// Redefining something in the same layer is an error, while lower layers
+// can be shadowed
+// Returns true if the name was already defined in a lower layer
+func (p *Parser) define(kind string, name string) (bool, error) {
+	key := p.currentKey(name)
+	if p.isReserved(name) {
+		reserved := &ReservedNameError{
+			File:   p.filename,
+			Kind:   kind,
+			Name:   key.String(),
+			Line:   p.currentItem.position.Line,
+			Column: p.currentItem.position.Column,
+		}
+		return false, p.parseError(reserved.message(), reserved)
+	}
+
+	previous, exists := p.definitions[key]
+	if exists && previous.layer >= p.layer && !previous.isDefault {
+		redefinition := &RedefinitionError{
+			File:         p.filename,
+			Kind:         kind,
+			Name:         key.String(),
+			Line:         p.currentItem.position.Line,
+			Column:       p.currentItem.position.Column,
+			PreviousLine: previous.position.Line,
+		}
+		return false, p.parseError(redefinition.message(), redefinition)
+	}
+
+	order := previous.order
+	if !exists {
+		order = p.newDefinitionOrder()
+	}
+	p.definitions[key] = definition{
+		position: p.currentItem.position,
+		layer:    p.layer,
+		order:    order,
+	}
+	return exists, nil
+}
+
+// Returns the order of a path defined for the first time
+func (p *Parser) newDefinitionOrder() int {
+	p.definitionCount++
+	return p.definitionCount - 1
+}
+
+// Returns the names of the attributes and blocks of a body at path in the
+// order they were defined
+// The ones added after decoding, as by a merge, come last, sorted by name
+func (p *Parser) orderedNames(path Path, attributes map[string]Attribute, blocks map[string]Block) []string {
+	names := append(sortedKeys(attributes), sortedKeys(blocks)...)
+	sort.Strings(names)
+	scope := path.String()
+	order := func(name string) int {
+		if defined, found := p.definitions[definitionKey{scope: scope, name: name}]; found {
+			return defined.order
+		}
+		return p.definitionCount
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return order(names[i]) < order(names[j])
+	})
+	return names
+}
+
 // Transforms a keyKind in an attrKind
 func keyKindToAttrKind(k keyKind) attrKind {
 	switch k {
@@ -171,6 +711,8 @@ func keyKindToAttrKind(k keyKind) attrKind {
 		return attrCondition
 	case keyFunction:
 		return attrFunction
+	case keyAttrCall:
+		return attrReference
 	default:
 		return attrNIL
 	}
@@ -188,45 +730,309 @@ func (p *Parser) parseAttribute() bool {
 	nextCount := 2
 
 	// Item is an array
+	// The items of its block elements are kept to parse them apart
+	var arrayItems []string
+	var arrayBlocks map[int]itemRange
 	if itemItem.kind == keyArrayStart {
 		itemvalue = ""
 		// Get items until keyArrayEnd
-		arrayItems := []string{}
-		for i, v := range p.lx.items {
-			if i > p.currentItemIndex {
-				if v.kind == keyArrayEnd {
-					break
+		arrayItems = []string{}
+		closed := false
+		depth, blockStart := 0, 0
+		for i := p.currentItemIndex + 1; p.hasItem(i); i++ {
+			v := p.lx.items[i]
+			switch {
+			case v.kind == keyBlockStart:
+				depth += 1
+				if depth == 1 {
+					blockStart = i + 1
 				}
+			case v.kind == keyBlockEnd:
+				depth -= 1
+				if depth == 0 {
+					if arrayBlocks == nil {
+						arrayBlocks = map[int]itemRange{}
+					}
+					arrayBlocks[len(arrayItems)] = itemRange{start: blockStart, end: i}
+					arrayItems = append(arrayItems, "{}")
+				}
+			case depth > 0 || v.kind == keyComment:
+			case v.kind == keyArrayEnd:
+				closed = true
+			default:
 				arrayItems = append(arrayItems, v.value)
-				nextCount += 1
 			}
+			if closed {
+				break
+			}
+			nextCount += 1
+		}
+		if !closed {
+			panic(evalErrorf(ErrUnclosedArray, "array of attribute %s is not closed", p.currentItem.value))
 		}
 		itemvalue = strings.Join(arrayItems, ", ")
 	}
 
 	// Transform value string into interface
-	attrvalue := transformItem(itemvalue, itemItem.kind)
+	p.ttl = 0
+	p.usedLocals = false
+	p.usedSecret = false
+	var attrvalue interface{}
+	if len(arrayBlocks) > 0 {
+		attrvalue = p.parseArrayBlocks(unquoteKey(p.currentItem.value), arrayItems, arrayBlocks)
+	} else {
+		attrvalue = p.transformItem(itemvalue, itemItem.kind)
+	}
+
+	// Local variables are only visible in their block, not added to it
+	key, operator := splitAssignment(p.currentItem.value)
+	name := unquoteKey(key)
+	if operator != "" && (strings.HasPrefix(key, "let ") || p.inVars) {
+		p.fail(p.parseError(fmt.Sprintf("local variables can't be assigned with %s", operator), ErrUnexpectedToken))
+		p.nextItem(nextCount)
+		return true
+	}
+	if strings.HasPrefix(key, "let ") {
+		p.defineLocal(strings.TrimSpace(strings.TrimPrefix(key, "let ")), attrvalue)
+		p.nextItem(nextCount)
+		return true
+	}
+	if p.inVars {
+		p.defineLocal(name, attrvalue)
+		p.nextItem(nextCount)
+		return true
+	}
+
+	// Dotted keys define their attribute in the nested blocks they name,
+	// as in database.primary.host = "x"
+	// Keys without dots, as most of them, aren't split
+	var names Path
+	if strings.ContainsRune(key, '.') {
+		names = splitPath(key)
+	}
+	if len(names) > 1 {
+		if equalsToMany("", names) {
+			p.fail(p.parseError(fmt.Sprintf("invalid key %s", key), ErrUnexpectedToken))
+			p.nextItem(nextCount)
+			return true
+		}
+		depth := len(p.currentBlocks)
+		defer func() {
+			p.currentBlocks = p.currentBlocks[:depth]
+		}()
+		rng := itemsRange(p.currentItem, p.lx.items[p.currentItemIndex+nextCount-1])
+		if err := p.enterKeyBlocks(names[:len(names)-1], rng); err != nil {
+			p.fail(err)
+			p.nextItem(nextCount)
+			return true
+		}
+		name = names[len(names)-1]
+	}
+
+	// Attributes already defined are kept by ?= and extended by +=
+	attributes := p.Attributes
+	if isBlock, currentBlock := p.getCurrentBlock(); isBlock {
+		attributes = currentBlock.Attributes
+	}
+	if previous, exists := attributes[name]; exists && operator != "" {
+		p.takeDoc(p.currentItem.position.Line)
+		if operator == "+=" {
+			previous.Value = p.appendValue(name, previous.Value, attrvalue)
+			previous.kind, previous.expr = attrArray, ""
+			attributes[name] = previous
+			p.ttl = 0
+			p.recordExpiry(name, itemvalue, itemItem.kind)
+			p.recordSecret(name, true)
+		}
+		p.nextItem(nextCount)
+		return true
+	}
+
+	// Check redefinitions
+	if _, err := p.define("attribute", name); err != nil {
+		var redefinition *RedefinitionError
+		switch {
+		case errors.As(err, &redefinition) && p.opts.duplicates == DuplicateKeepFirst:
+			p.nextItem(nextCount)
+			return true
+		case errors.As(err, &redefinition) && p.opts.duplicates == DuplicateKeepLast:
+			key := p.currentKey(name)
+			kept := p.definitions[key]
+			kept.position, kept.layer = p.currentItem.position, p.layer
+			p.definitions[key] = kept
+		default:
+			p.fail(err)
+			p.nextItem(nextCount)
+			return true
+		}
+	}
 
 	// Build attribute
-	newAttr := attribute{
-		Name:  p.currentItem.value,
+	newAttr := Attribute{
+		Name:  name,
 		Value: attrvalue,
+		Doc:   p.takeDoc(p.currentItem.position.Line),
 		kind:  keyKindToAttrKind(itemItem.kind),
+		expr:  expressionText(itemvalue, itemItem.kind),
+		Range: itemsRange(p.currentItem, p.lx.items[p.currentItemIndex+nextCount-1]),
+	}
+	newAttr.LineComment = p.lineComment(p.currentItemIndex+nextCount, newAttr.Range.End.Line)
+	if itemItem.kind == keyAttrCall && itemvalue == "null" {
+		newAttr.kind = attrNIL
+		newAttr.expr = ""
+	}
+	if p.usedLocals {
+		// Local variables are gone once parsed, only the value remains
+		newAttr.expr = ""
+	}
+	if operator == "+=" {
+		// Appending to nothing makes an array
+		newAttr.Value = p.appendValue(name, []interface{}{}, attrvalue)
+		newAttr.kind, newAttr.expr = attrArray, ""
+	}
+
+	// Values assigned with ?= are defaults, which other definitions replace
+	if operator == "?=" {
+		key := p.currentKey(name)
+		defaultDefinition := p.definitions[key]
+		defaultDefinition.isDefault = true
+		p.definitions[key] = defaultDefinition
 	}
 
 	// Add new attribute into global or nested block
-	isBlock, currentBlock := p.getCurrentBlock()
-	if isBlock {
-		currentBlock.Attributes[newAttr.Name] = newAttr
-	} else {
-		p.Attributes[newAttr.Name] = newAttr
+	// An attribute shadowing another one keeps its documentation if it has none
+	if newAttr.Doc == "" {
+		newAttr.Doc = attributes[newAttr.Name].Doc
 	}
+	attributes[newAttr.Name] = newAttr
+	p.recordExpiry(newAttr.Name, itemvalue, itemItem.kind)
+	p.recordSecret(newAttr.Name, false)
 
 	// Call next item and return
 	p.nextItem(nextCount)
 	return true
 }
 
+// Splits the key of an attribute from its assignment operator, += or ?=,
+// which is empty for =
+func splitAssignment(key string) (string, string) {
+	for _, operator := range []string{"+", "?"} {
+		if trimmed := strings.TrimSuffix(key, operator); trimmed != key {
+			return strings.TrimSpace(trimmed), operator + "="
+		}
+	}
+	return key, ""
+}
+
+// Appends a value to the array of an attribute, or the elements of an
+// array
+func (p *Parser) appendValue(name string, array interface{}, value interface{}) interface{} {
+	elements, isArray := array.([]interface{})
+	if !isArray {
+		panic(evalErrorf(ErrTypeMismatch, "attribute %s is not an array, it can't be appended to", p.currentPath(name)))
+	}
+	appended := append([]interface{}{}, elements...)
+	if values, isArray := value.([]interface{}); isArray {
+		return append(appended, values...)
+	}
+	return append(appended, value)
+}
+
+// Enters the blocks named by the first names of a dotted key, creating the
+// ones that don't exist yet with the range of the key
+// Blocks defined explicitly or by other dotted keys are extended, while
+// attributes and local variables with the same names are redefined
+func (p *Parser) enterKeyBlocks(names []string, rng Range) error {
+	for _, name := range names {
+		if p.opts.maxDepth > 0 && len(p.currentBlocks) >= p.opts.maxDepth {
+			return p.parseError(fmt.Sprintf("block %s is nested deeper than %d levels", p.currentPath(name), p.opts.maxDepth), ErrMaxDepth)
+		}
+
+		blocks := p.Blocks
+		if isBlock, currentBlock := p.getCurrentBlock(); isBlock {
+			blocks = currentBlock.Blocks
+		}
+		if _, exists := blocks[name]; !exists || p.definitions[p.currentKey(name)].layer < p.layer {
+			if _, err := p.define("block", name); err != nil {
+				return err
+			}
+		}
+		if _, exists := blocks[name]; !exists {
+			blocks[name] = Block{
+				Name:       name,
+				Range:      rng,
+				Attributes: map[string]Attribute{},
+				Blocks:     map[string]Block{},
+			}
+		}
+		p.currentBlocks = append(p.currentBlocks, name)
+	}
+	return nil
+}
+
+// Parses the elements of an array with blocks, which become maps
+// Every block is parsed in a block of its own named after the attribute
+// and its index, as in endpoints[0], which is removed once parsed
+func (p *Parser) parseArrayBlocks(name string, elements []string, blocks map[int]itemRange) []interface{} {
+	index, current := p.currentItemIndex, p.currentItem
+	values := make([]interface{}, len(elements)-1)
+	for i, elem := range elements[1:] {
+		body, isBlock := blocks[i+1]
+		if !isBlock {
+			values[i] = transformValue(elem)
+			continue
+		}
+
+		elemName := fmt.Sprintf("%s[%d]", name, i)
+		parent := p.Blocks
+		if isBlock, currentBlock := p.getCurrentBlock(); isBlock {
+			parent = currentBlock.Blocks
+		}
+		parent[elemName] = Block{Name: elemName, Attributes: map[string]Attribute{}, Blocks: map[string]Block{}}
+		depth := len(p.currentBlocks)
+		p.currentBlocks = append(p.currentBlocks, elemName)
+		path := p.currentScope()
+
+		p.currentItemIndex = body.start
+		p.currentItem = p.lx.items[body.start]
+		for p.currentItemIndex < body.end {
+			p.parseItem(p.opts.logger)
+		}
+
+		// Blocks nested in the element must be closed in it
+		if len(p.currentBlocks) > depth+1 {
+			panic(evalErrorf(ErrUnclosedBlock, "block %s is not closed", p.currentScope()))
+		}
+		p.currentBlocks = p.currentBlocks[:depth]
+		elemBlock := parent[elemName]
+		delete(parent, elemName)
+		p.forgetPath(path)
+		values[i] = bodyToJSON(elemBlock.Attributes, elemBlock.Blocks)
+	}
+	p.currentItemIndex, p.currentItem = index, current
+	return values
+}
+
+// Forgets the definitions and expiries of a path and the paths in it
+func (p *Parser) forgetPath(path string) {
+	forgotten := pathKey(splitPath(path))
+	for defined := range p.definitions {
+		if defined == forgotten || defined.scope == path || strings.HasPrefix(defined.scope, path+".") {
+			delete(p.definitions, defined)
+		}
+	}
+	for expiring := range p.expiries {
+		if strings.HasPrefix(expiring, path+".") {
+			delete(p.expiries, expiring)
+		}
+	}
+	for secret := range p.secrets {
+		if secret == path || strings.HasPrefix(secret, path+".") {
+			delete(p.secrets, secret)
+		}
+	}
+}
+
 // Skip array elements since these are already being checked
 // by parseAttribute
 func (p *Parser) parseArrayElement() bool {
@@ -243,19 +1049,84 @@ func (p *Parser) parseBlockStart() bool {
 		return false
 	}
 
+	// Profiles are parsed once the defaults are
+	if match := profileRegexp.FindStringSubmatch(p.currentItem.value); match != nil {
+		p.skipProfile(match[1])
+		return true
+	}
+
+	// Templates are parsed where they're used
+	if match := templateRegexp.FindStringSubmatch(p.currentItem.value); match != nil {
+		p.skipTemplate(match[1], match[2])
+		return true
+	}
+
+	// The attributes of a vars block are local variables of the block
+	// enclosing it
+	if p.inVars {
+		panic(evalErrorf(ErrUnexpectedToken, "block %s can't be defined in a vars block", p.currentItem.value))
+	}
+	if p.currentItem.value == "vars" {
+		p.takeDoc(p.currentItem.position.Line)
+		p.inVars = true
+		p.nextItem(1)
+		return true
+	}
+
+	// Blocks generated from the elements of an array
+	if match := forBlockRegexp.FindStringSubmatch(p.currentItem.value); match != nil {
+		p.parseForBlock(match[1], match[2])
+		return true
+	}
+
+	// Blocks named by an expression
+	name := p.currentItem.value
+	if match := dynamicBlockRegexp.FindStringSubmatch(name); match != nil {
+		name = p.blockName(match[1])
+	}
+
+	// Blocks left out by WithOnlyBlocks aren't evaluated
+	if p.opts.onlyBlocks != nil && len(p.currentBlocks) == 0 && !p.opts.onlyBlocks[name] {
+		p.takeDoc(p.currentItem.position.Line)
+		p.skipBlock()
+		return true
+	}
+
+	if p.opts.maxDepth > 0 && len(p.currentBlocks) >= p.opts.maxDepth {
+		p.fail(p.parseError(fmt.Sprintf("block %s is nested deeper than %d levels", p.currentPath(name), p.opts.maxDepth), ErrMaxDepth))
+		p.skipBlock()
+		return true
+	}
+
+	// Check redefinitions
+	shadowed, err := p.define("block", name)
+	if err != nil {
+		p.fail(err)
+		p.skipBlock()
+		return true
+	}
+
 	// Build block
-	newBlock := block{
-		Name:       p.currentItem.value,
-		Attributes: map[string]attribute{},
-		Blocks:     map[string]block{},
+	newBlock := Block{
+		Name:       name,
+		Doc:        p.takeDoc(p.currentItem.position.Line),
+		Attributes: map[string]Attribute{},
+		Blocks:     map[string]Block{},
+		Range:      Range{Start: itemsRange(p.currentItem, p.currentItem).Start},
 	}
 
 	// Add new block into global or nested block
-	isBlock, currentBlock := p.getCurrentBlock()
-	if isBlock {
-		currentBlock.Blocks[newBlock.Name] = newBlock
-	} else {
-		p.Blocks[newBlock.Name] = newBlock
+	// A block from a lower layer is extended instead of replaced
+	blocks := p.Blocks
+	if isBlock, currentBlock := p.getCurrentBlock(); isBlock {
+		blocks = currentBlock.Blocks
+	}
+	if !shadowed {
+		blocks[newBlock.Name] = newBlock
+	} else if newBlock.Doc != "" {
+		shadowedBlock := blocks[newBlock.Name]
+		shadowedBlock.Doc = newBlock.Doc
+		blocks[newBlock.Name] = shadowedBlock
 	}
 
 	// Add block name to the array of current Blocks
@@ -266,20 +1137,405 @@ func (p *Parser) parseBlockStart() bool {
 	return true
 }
 
+// Matches the start of blocks generated from an array, like
+// for region in regions
+var forBlockRegexp = regexp.MustCompile(`^for\s+(` + namePattern + `)\s+in\s+(.+)$`)
+
+// Matches the start of a block named by an expression, like block region
+var dynamicBlockRegexp = regexp.MustCompile(`^block\s+(.+)$`)
+
+// Parses the body of a for block once for every element of an array, with
+// the element as the value of the variable
+func (p *Parser) parseForBlock(name string, expr string) {
+	value := p.transformFunctionParam(strings.TrimSpace(expr))
+	elements, isArray := value.([]interface{})
+	if !isArray {
+		panic(evalErrorf(ErrTypeMismatch, "for blocks iterate over arrays, got %s", strings.TrimSpace(expr)))
+	}
+
+	start := p.currentItemIndex + 1
+	end := p.closingBrace("for " + name)
+	for _, element := range elements {
+		p.bindings = append(p.bindings, map[string]interface{}{name: element})
+		p.currentItemIndex = start
+		p.currentItem = p.lx.items[start]
+		for p.currentItemIndex < end {
+			p.parseItem(p.opts.logger)
+		}
+		p.bindings = p.bindings[:len(p.bindings)-1]
+	}
+
+	p.currentItemIndex = end
+	p.nextItem(1)
+}
+
+// Evaluates the name of a block named by an expression, which has to be a
+// string or a number
+func (p *Parser) blockName(expr string) string {
+	value := p.transformFunctionParam(strings.TrimSpace(expr))
+	switch value.(type) {
+	case string, int, float64:
+		return fmt.Sprint(value)
+	default:
+		panic(evalErrorf(ErrTypeMismatch, "block names are strings or numbers, got %s", strings.TrimSpace(expr)))
+	}
+}
+
 // Parses a block end
 func (p *Parser) parseBlockEnd() bool {
 	if p.currentItem.kind != keyBlockEnd {
 		return false
 	}
 
+	// End of a vars block, which isn't one of the current Blocks
+	if p.inVars {
+		p.inVars = false
+		p.nextItem(1)
+		return true
+	}
+
+	// A brace closing nothing
+	if len(p.currentBlocks) == 0 {
+		p.fail(p.parseError(`unexpected "}"`, ErrUnexpectedToken))
+		p.nextItem(1)
+		return true
+	}
+
 	// Remove last block name of the array of current Blocks
+	name := p.currentBlocks[len(p.currentBlocks)-1]
 	p.currentBlocks = p.currentBlocks[:len(p.currentBlocks)-1]
 
+	// The range of the block ends at its first closing brace, overlays
+	// extending it don't move it
+	blocks := p.Blocks
+	if isBlock, currentBlock := p.getCurrentBlock(); isBlock {
+		blocks = currentBlock.Blocks
+	}
+	if b := blocks[name]; b.Range.End == (Position{}) {
+		b.Range.End = itemsRange(p.currentItem, p.currentItem).End
+		blocks[name] = b
+	}
+
 	// Call next and return
 	p.nextItem(1)
 	return true
 }
 
+// Parses an include directive
+// The attributes and blocks of the included file are added to the current
+// block, as if they were defined in place of the directive
+func (p *Parser) parseInclude() bool {
+	if p.currentItem.kind != keyInclude {
+		return false
+	}
+
+	path := strings.Trim(strings.TrimSpace(strings.TrimPrefix(p.currentItem.value, "include")), `"`)
+	if err := p.includeFile(path); err != nil {
+		p.fail(err)
+	}
+
+	p.nextItem(1)
+	return true
+}
+
+// Parses a file and adds its definitions to the current block
+// Relative paths are resolved from the directory of the parsed file
+func (p *Parser) includeFile(path string) error {
+	if p.opts.disallowFiles {
+		return p.parseError(fmt.Sprintf("include of %s is not allowed", path), ErrFunctionNotAllowed)
+	}
+	if p.opts.remote && !filepath.IsAbs(path) {
+		return p.parseError(fmt.Sprintf("include of %s is not allowed, relative paths can't be resolved from a URL", path), ErrFunctionNotAllowed)
+	}
+
+	path, err := p.opts.absPath(p.opts.resolvePath(p.filename, path))
+	if err != nil {
+		return p.errorf("%w", err)
+	}
+
+	includes := p.includes
+	if p.filename != "" {
+		current, err := p.opts.absPath(p.filename)
+		if err != nil {
+			return p.errorf("%w", err)
+		}
+		includes = append(append([]string{}, includes...), current)
+	}
+	for _, including := range includes {
+		if including == path {
+			return p.parseError(fmt.Sprintf("circular include of %s", path), ErrCircularInclude)
+		}
+	}
+
+	input, err := p.opts.readCAFEFile(path)
+	if err != nil {
+		return p.errorf("%w", err)
+	}
+	started := time.Now()
+	included := newParser(input)
+	included.opts = p.opts
+	if p.opts.stats != nil {
+		included.started, included.inputBytes = started, inputSize(input)
+	}
+	if len(p.currentBlocks) > 0 {
+		// WithOnlyBlocks picks blocks of the top level only
+		included.opts.onlyBlocks = nil
+	}
+	included.clock = p.clock
+	included.filename = path
+	included.includes = includes
+	included.parseItems(included.opts.logger)
+	if included.err != nil {
+		return included.err
+	}
+	p.includedFiles = append(append(p.includedFiles, path), included.includedFiles...)
+	p.readFiles = append(p.readFiles, included.readFiles...)
+
+	return p.spliceBody(included, nil, included.Attributes, included.Blocks)
+}
+
+// Adds the attributes and blocks of the body at path of an included
+// document to the current block, in the order they were defined, checking
+// their names like the ones defined in the parsed file
+func (p *Parser) spliceBody(included *Parser, path Path, attributes map[string]Attribute, blocks map[string]Block) error {
+	targetAttributes, targetBlocks := p.Attributes, p.Blocks
+	if isBlock, currentBlock := p.getCurrentBlock(); isBlock {
+		targetAttributes, targetBlocks = currentBlock.Attributes, currentBlock.Blocks
+	}
+
+	for _, name := range included.orderedNames(path, attributes, blocks) {
+		if attr, isAttribute := attributes[name]; isAttribute {
+			if _, err := p.define("attribute", name); err != nil {
+				return err
+			}
+			targetAttributes[name] = attr
+			if included.secrets[childPath(path, name).String()] {
+				p.markSecret(p.currentPath(name))
+			}
+			continue
+		}
+
+		b := blocks[name]
+		shadowed, err := p.define("block", name)
+		if err != nil {
+			return err
+		}
+		if !shadowed {
+			targetBlocks[name] = Block{
+				Name:       b.Name,
+				Doc:        b.Doc,
+				Range:      b.Range,
+				Attributes: map[string]Attribute{},
+				Blocks:     map[string]Block{},
+			}
+		}
+
+		p.currentBlocks = append(p.currentBlocks, name)
+		err = p.spliceBody(included, childPath(path, name), b.Attributes, b.Blocks)
+		p.currentBlocks = p.currentBlocks[:len(p.currentBlocks)-1]
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Matches the start of a profile section, like profile "prod"
+var profileRegexp = regexp.MustCompile(`^profile\s+"([^"]*)"$`)
+
+// Moves the Parser past a profile section, keeping its items if it's the
+// selected profile
+func (p *Parser) skipProfile(name string) {
+	if len(p.currentBlocks) > 0 {
+		panic(evalErrorf(ErrUnexpectedToken, "profile %q must be defined outside of blocks", name))
+	}
+	p.takeDoc(p.currentItem.position.Line)
+
+	start := p.currentItemIndex + 1
+	end := p.closingBrace(fmt.Sprintf("profile %q", name))
+	if name == p.opts.profile {
+		p.profileSections = append(p.profileSections, itemRange{start: start, end: end})
+	}
+	p.nextItem(end + 1 - p.currentItemIndex)
+}
+
+// Returns the index of the brace closing the block starting at the
+// current item
+func (p *Parser) closingBrace(description string) int {
+	end := p.currentItemIndex + 1
+	for depth := 1; ; end++ {
+		if !p.hasItem(end) {
+			panic(evalErrorf(ErrUnclosedBlock, "%s is not closed", description))
+		}
+		switch p.lx.items[end].kind {
+		case keyBlockStart:
+			depth += 1
+		case keyBlockEnd:
+			depth -= 1
+		}
+		if depth == 0 {
+			return end
+		}
+	}
+}
+
+// Matches the start of a template, like template service(name, port)
+var templateRegexp = regexp.MustCompile(`^template\s+(` + namePattern + `)\s*\(([^()]*)\)$`)
+
+// Matches the call to a template after the use keyword, like
+// service("web", 8080)
+var useCallRegexp = regexp.MustCompile(`^(` + namePattern + `)\s*\((.*)\)$`)
+
+// Moves the Parser past the definition of a template, keeping its items
+// to parse them wherever it's used
+func (p *Parser) skipTemplate(name string, params string) {
+	if len(p.currentBlocks) > 0 {
+		panic(evalErrorf(ErrUnexpectedToken, "template %s must be defined outside of blocks", name))
+	}
+	p.takeDoc(p.currentItem.position.Line)
+
+	start := p.currentItemIndex + 1
+	end := p.closingBrace("template " + name)
+	names := splitFunctionParams(params)
+	for i, param := range names {
+		if !functionNameRegexp.MatchString(param) || equalsToMany(param, names[:i]) {
+			panic(evalErrorf(ErrInvalidExpression, "invalid parameter %q of template %s", param, name))
+		}
+	}
+
+	if _, exists := p.templates[name]; exists {
+		p.fail(p.parseError(fmt.Sprintf("template %s redefined", name), ErrRedefined))
+	} else {
+		if p.templates == nil {
+			p.templates = map[string]template{}
+		}
+		p.templates[name] = template{params: names, body: itemRange{start: start, end: end}}
+	}
+	p.nextItem(end + 1 - p.currentItemIndex)
+}
+
+// Parses the use of a template, defining the attributes and blocks of its
+// body in the current block, with the values of its parameters
+func (p *Parser) parseUse() bool {
+	if p.currentItem.kind != keyUse {
+		return false
+	}
+
+	match := useCallRegexp.FindStringSubmatch(strings.TrimSpace(strings.TrimPrefix(p.currentItem.value, "use")))
+	name := match[1]
+	tmpl, found := p.templates[name]
+	if !found {
+		panic(evalErrorf(ErrUndefined, "template %s is not defined", name))
+	}
+	if equalsToMany(name, p.usedTemplates) {
+		panic(evalErrorf(ErrInvalidExpression, "template %s uses itself", name))
+	}
+	rawArgs := splitFunctionParams(match[2])
+	if len(rawArgs) != len(tmpl.params) {
+		panic(evalErrorf(ErrInvalidArgument, "template %s takes %d parameters, got %d", name, len(tmpl.params), len(rawArgs)))
+	}
+	args := map[string]interface{}{}
+	for i, arg := range rawArgs {
+		args[tmpl.params[i]] = p.transformFunctionParam(arg)
+	}
+
+	// The body is parsed in place of the use
+	index := p.currentItemIndex
+	p.bindings = append(p.bindings, args)
+	p.usedTemplates = append(p.usedTemplates, name)
+	p.currentItemIndex = tmpl.body.start
+	p.currentItem = p.lx.items[tmpl.body.start]
+	for p.currentItemIndex < tmpl.body.end {
+		p.parseItem(p.opts.logger)
+	}
+	p.bindings = p.bindings[:len(p.bindings)-1]
+	p.usedTemplates = p.usedTemplates[:len(p.usedTemplates)-1]
+
+	p.currentItemIndex = index
+	p.nextItem(1)
+	return true
+}
+
+// Parses the sections of the selected profile over the defaults
+// Their definitions are in a layer of their own, so they can shadow the
+// defaults wherever they're defined in the file
+func (p *Parser) parseProfiles(log Logger) {
+	if len(p.profileSections) == 0 || p.err != nil {
+		return
+	}
+
+	p.layer += 1
+	for _, section := range p.profileSections {
+		p.currentItemIndex = section.start
+		p.currentItem = p.lx.items[section.start]
+		p.atLastItem = false
+		for p.currentItemIndex < section.end {
+			p.parseStatement(log)
+		}
+	}
+	p.profileSections = nil
+	p.atLastItem = true
+}
+
+// Records an error and goes on parsing
+// A single error is kept as is, many of them are joined
+func (p *Parser) fail(err error) {
+	p.errs = append(p.errs, err)
+	p.err = err
+	if len(p.errs) > 1 {
+		p.err = errors.Join(p.errs...)
+	}
+}
+
+// Moves the Parser past the block starting at the current item, so a
+// block that can't be defined doesn't hide the errors after it
+func (p *Parser) skipBlock() {
+	end := p.currentItemIndex + 1
+	for depth := 1; depth > 0 && p.hasItem(end); end++ {
+		switch p.lx.items[end].kind {
+		case keyBlockStart:
+			depth += 1
+		case keyBlockEnd:
+			depth -= 1
+		}
+	}
+	p.nextItem(end - p.currentItemIndex)
+}
+
+// Returns an error at the position of the current item
+// Like fmt.Errorf, a %w verb wraps its error
+func (p *Parser) errorf(format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	return p.parseError(err.Error(), errors.Unwrap(err))
+}
+
+// Returns a *ParseError at the position of the current item
+func (p *Parser) parseError(message string, err error) *ParseError {
+	return &ParseError{
+		File:    p.filename,
+		Line:    p.currentItem.position.Line,
+		Col:     p.currentItem.position.Column,
+		Length:  utf8.RuneCountInString(p.currentItem.value),
+		Message: message,
+		Snippet: p.lx.line(p.currentItem.position.Line),
+		Err:     err,
+	}
+}
+
+// Adds the position of the current item to the panics of the parser,
+// which are then *ParseError values
+func (p *Parser) positionPanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	err, isEvalError := r.(*evalError)
+	if !isEvalError {
+		panic(r)
+	}
+	panic(p.parseError(err.Error(), err))
+}
+
 // Parse EOF
 func (p *Parser) parseEOF() bool {
 	if p.currentItem.kind != keyEOF {
@@ -289,72 +1545,155 @@ func (p *Parser) parseEOF() bool {
 	return true
 }
 
+// Returns the source text of an item if it's an expression, or an empty
+// string if it's a literal value
+func expressionText(value string, kind keyKind) string {
+	switch kind {
+	case keyArithmetic, keyComparison, keyCondition, keyFunction, keyAttrCall:
+		return value
+	}
+	return ""
+}
+
 // Parse others: comment, EOL, NIL, ERROR
 func (p *Parser) parseOthers() bool {
 	if p.currentItem.kind != keyComment && p.currentItem.kind != keyNIL && p.currentItem.kind != keyError {
 		return false
 	}
+	if p.currentItem.kind == keyComment {
+		p.collectDoc()
+	}
+	if p.currentItem.kind == keyError && p.opts.strict {
+		p.fail(p.parseError(fmt.Sprintf("unexpected %q", p.currentItem.value), ErrUnexpectedToken))
+	}
 	p.nextItem(1)
 	return true
 }
 
+// Collects the current comment if it's a doc comment
+// Doc comments start with "///" and document the attribute or block
+// defined right below them, other comments discard the collected ones
+func (p *Parser) collectDoc() {
+	comment := p.currentItem
+	isTrailing := p.currentItemIndex > 0 && p.lx.items[p.currentItemIndex-1].position.Line == comment.position.Line
+	if !strings.HasPrefix(comment.value, "///") || isTrailing {
+		p.doc = nil
+		return
+	}
+
+	if len(p.doc) > 0 && comment.position.Line != p.docLine+1 {
+		p.doc = nil
+	}
+	p.doc = append(p.doc, strings.TrimSpace(strings.TrimPrefix(comment.value, "///")))
+	p.docLine = comment.position.Line
+}
+
+// Returns the text of the item at an index if it's a comment in the given
+// line, as the comment after a value
+func (p *Parser) lineComment(index int, line int) string {
+	if !p.hasItem(index) || p.lx.items[index].kind != keyComment || p.lx.items[index].position.Line != line {
+		return ""
+	}
+	return commentText(p.lx.items[index].value)
+}
+
+// Returns the text of a comment without its comment sequences
+func commentText(comment string) string {
+	if strings.HasPrefix(comment, "/*") {
+		comment = strings.TrimSuffix(strings.TrimPrefix(comment, "/*"), "*/")
+	} else {
+		comment = strings.TrimLeft(comment, "/#")
+	}
+	return strings.TrimSpace(comment)
+}
+
+// Returns the documentation of a definition in the given line and
+// discards the collected doc comments
+func (p *Parser) takeDoc(line int) string {
+	doc := ""
+	if len(p.doc) > 0 && p.docLine == line-1 {
+		doc = strings.Join(p.doc, "\n")
+	}
+	p.doc = nil
+	return doc
+}
+
 // Parse all items until the last one
-func (p *Parser) parseItems(debug bool) {
+func (p *Parser) parseItems(log Logger) {
+	parsed := false
+	if p.opts.stats != nil {
+		defer func() { p.reportStats(!parsed) }()
+	}
+	defer p.positionPanic()
 	for !p.atLastItem {
-		if debug {
-			fmt.Println("DEBUG ITEM:", p.currentItem.value)
+		if log != nil {
+			log.Debug("parseItems", "item", p.currentItem.value, "kind", keyKindStr(p.currentItem.kind), "line", p.currentItem.position.Line)
 		}
-		p.parseItem(debug)
+		p.parseStatement(log)
+	}
+	p.checkUnclosedBlocks()
+	p.parseProfiles(log)
+	parsed = true
+}
+
+// Fails if the blocks being parsed weren't closed at the end of the input
+func (p *Parser) checkUnclosedBlocks() {
+	for len(p.currentBlocks) > 0 {
+		path := p.currentScope()
+		key := pathKey(p.currentBlocks)
+		p.currentItem = item{kind: keyBlockStart, value: key.name, position: p.definitions[key].position}
+		p.fail(p.parseError(fmt.Sprintf("block %s is not closed", path), ErrUnclosedBlock))
+		p.currentBlocks = p.currentBlocks[:len(p.currentBlocks)-1]
 	}
 }
 
 // Calls all Parsers in a specific order to parse the next item
-func (p *Parser) parseItem(debug bool) {
-	if debug {
-		fmt.Println("DEBUG parseItem: parseEOF")
+func (p *Parser) parseItem(log Logger) {
+	if p.opts.parseHook != nil {
+		p.hookItem()
 	}
+
+	logStep(log, "parseItem", "parseEOF")
 	if p.parseEOF() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG parseItem: parseAttribute")
+	logStep(log, "parseItem", "parseInclude")
+	if p.parseInclude() {
+		return
 	}
-	if p.parseAttribute() {
+
+	logStep(log, "parseItem", "parseUse")
+	if p.parseUse() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG parseItem: parseArrayElement")
+	logStep(log, "parseItem", "parseAttribute")
+	if p.parseAttribute() {
+		return
 	}
+
+	logStep(log, "parseItem", "parseArrayElement")
 	if p.parseArrayElement() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG parseItem: parseBlockStart")
-	}
+	logStep(log, "parseItem", "parseBlockStart")
 	if p.parseBlockStart() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG parseItem: parseBlockEnd")
-	}
+	logStep(log, "parseItem", "parseBlockEnd")
 	if p.parseBlockEnd() {
 		return
 	}
 
-	if debug {
-		fmt.Println("DEBUG parseItem: parseOthers")
-	}
+	logStep(log, "parseItem", "parseOthers")
 	if p.parseOthers() {
 		return
 	}
 
 	// No value found so skip to next item
-	if debug {
-		fmt.Println("DEBUG parseItem: Skipping to next item")
-	}
+	logStep(log, "parseItem", "Skipping to next item")
 	p.nextItem(1)
 }
@@ -15,104 +15,409 @@
 package cafe
 
 import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// Value is any value an attribute can hold: string, int, float64,
+// bool or []interface{} (arrays)
+type Value = interface{}
+
+// Function is the signature of user-defined functions callable from a CAFE
+// file. It receives the already evaluated arguments of the call
+type Function func(args []Value) (Value, error)
+
+// Names of the functions that come by default with the CAFE interpreter
+var (
+	stringFunctionNames     = []string{"upper", "lower", "append", "concat", "contains", "length", "sha256", "md5", "base64encode", "base64decode"}
+	numericalFunctionNames  = []string{"power", "floor", "remainder", "abs", "min", "max", "ceil", "round", "sqrt", "log"}
+	gateLogicFunctionNames  = []string{"and", "or", "nand", "nor", "xor", "xnor"}
+	arrayFunctionNames      = []string{"sum", "sort", "unique", "reverse", "slice", "index", "flatten", "element"}
+	conversionFunctionNames = []string{"tostring", "tonumber", "tobool"}
+	timeFunctionNames       = []string{"now", "formatdate", "timeadd"}
+	randomFunctionNames     = []string{"uuid", "randint", "randstr"}
+	valueFunctionNames      = []string{"coalesce", "lookup"}
+	systemFunctionNames     = []string{"env", "file", "templatefile"}
+)
+
+// Functions registered through RegisterFunction
+var (
+	customFunctionsMu sync.RWMutex
+	customFunctions   = map[string]Function{}
+)
+
+// Names of attributes, blocks, local variables and functions, which can use
+// the letters and digits of any language
+const namePattern = `[\p{L}_][\p{L}\p{N}_]*`
+
+// Valid function names
+var functionNameRegexp = regexp.MustCompile(`^` + namePattern + `$`)
+
+// Matches a call to a function the lexer doesn't know, like foo(1)
+var unknownCallRegexp = regexp.MustCompile(`^(` + namePattern + `)\(.*\)$`)
+
+// Matches the start of a call to any function, like foo(
+var callStartRegexp = regexp.MustCompile(`^` + namePattern + `\(`)
+
+// Valid references to attributes, which can be nested in blocks
+var identifierRegexp = regexp.MustCompile(`^` + namePattern + `(\.` + namePattern + `)*$`)
+
+// RegisterFunction makes fn callable by name from every CAFE file decoded
+// afterwards, so applications can add domain-specific functions.
+// It panics if fn is nil, if the name is not a valid identifier, or if a
+// function (builtin or not) with the same name already exists.
+// RegisterFunction is meant to be called from init functions
+func RegisterFunction(name string, fn Function) {
+	if fn == nil {
+		panic("cafe: RegisterFunction function is nil")
+	}
+	if !functionNameRegexp.MatchString(name) {
+		panic(fmt.Sprintf("cafe: RegisterFunction invalid function name %q", name))
+	}
+	if isBuiltinFunction(name) {
+		panic(fmt.Sprintf("cafe: RegisterFunction cannot override builtin function %s", name))
+	}
+
+	customFunctionsMu.Lock()
+	defer customFunctionsMu.Unlock()
+	if _, exists := customFunctions[name]; exists {
+		panic(fmt.Sprintf("cafe: RegisterFunction called twice for function %s", name))
+	}
+	customFunctions[name] = fn
+}
+
+// Gets a function registered through RegisterFunction
+func lookupCustomFunction(name string) (Function, bool) {
+	customFunctionsMu.RLock()
+	defer customFunctionsMu.RUnlock()
+	fn, ok := customFunctions[name]
+	return fn, ok
+}
+
+// Checks if a function comes by default with the CAFE interpreter
+func isBuiltinFunction(name string) bool {
+	return equalsToMany(name, stringFunctionNames) ||
+		equalsToMany(name, numericalFunctionNames) ||
+		equalsToMany(name, gateLogicFunctionNames) ||
+		equalsToMany(name, arrayFunctionNames) ||
+		equalsToMany(name, conversionFunctionNames) ||
+		equalsToMany(name, timeFunctionNames) ||
+		equalsToMany(name, randomFunctionNames) ||
+		equalsToMany(name, valueFunctionNames) ||
+		equalsToMany(name, systemFunctionNames)
+}
+
+// Reports if a function is builtin or was registered through
+// RegisterFunction
+func isFunctionName(name string) bool {
+	name = strings.TrimSpace(name)
+	if isBuiltinFunction(name) {
+		return true
+	}
+	customFunctionsMu.RLock()
+	defer customFunctionsMu.RUnlock()
+	return customFunctions[name] != nil
+}
+
+// Returns the names of all functions that can be called, builtin or not
+func functionNames() []string {
+	names := []string{}
+	names = append(names, stringFunctionNames...)
+	names = append(names, numericalFunctionNames...)
+	names = append(names, gateLogicFunctionNames...)
+	names = append(names, arrayFunctionNames...)
+	names = append(names, conversionFunctionNames...)
+	names = append(names, timeFunctionNames...)
+	names = append(names, randomFunctionNames...)
+	names = append(names, valueFunctionNames...)
+	names = append(names, systemFunctionNames...)
+
+	customFunctionsMu.RLock()
+	defer customFunctionsMu.RUnlock()
+	for name := range customFunctions {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Returns how a call to each function starts, as in "upper(", including
+// the functions given to a single decoding
+func functionCallPrefixes(local map[string]Function) []string {
+	names := functionNames()
+	for name := range local {
+		names = append(names, name)
+	}
+	for i, name := range names {
+		names[i] = name + "("
+	}
+	return names
+}
+
+// Returns how calls to the functions of the decoding start, computed once
+// for all the expressions of the Parser
+func (p *Parser) callPrefixes() []string {
+	if p.lx.callPrefixes == nil {
+		p.lx.callPrefixes = functionCallPrefixes(p.opts.functions)
+	}
+	return p.lx.callPrefixes
+}
+
+// Calls a function by its name with already evaluated parameters
+func (p *Parser) callFunction(funcName string, funcParams []interface{}) interface{} {
+	// Strings
+	if equalsToMany(funcName, stringFunctionNames) {
+		return stringFunctions(funcName, funcParams)
+	}
+
+	// Numerical
+	if equalsToMany(funcName, numericalFunctionNames) {
+		return numericalFunctions(funcName, funcParams)
+	}
+
+	// Gate logic
+	if equalsToMany(funcName, gateLogicFunctionNames) {
+		return gateLogicFunctions(funcName, funcParams)
+	}
+
+	// Arrays
+	if equalsToMany(funcName, arrayFunctionNames) {
+		return arrayFunctions(funcName, funcParams)
+	}
+
+	// Conversions
+	if equalsToMany(funcName, conversionFunctionNames) {
+		return conversionFunctions(funcName, funcParams)
+	}
+
+	// Time
+	if equalsToMany(funcName, timeFunctionNames) {
+		return p.timeFunctions(funcName, funcParams)
+	}
+
+	// Random
+	if equalsToMany(funcName, randomFunctionNames) {
+		return p.randomFunctions(funcName, funcParams)
+	}
+
+	// Values
+	if equalsToMany(funcName, valueFunctionNames) {
+		return valueFunctions(funcName, funcParams)
+	}
+
+	// System
+	if equalsToMany(funcName, systemFunctionNames) {
+		return p.systemFunctions(funcName, funcParams)
+	}
+
+	// User-defined, the ones given to the decoding first
+	fn, ok := p.opts.functions[funcName]
+	if !ok {
+		fn, ok = lookupCustomFunction(funcName)
+	}
+	if ok {
+		result, err := fn(funcParams)
+		if err != nil {
+			panic(evalErrorf(nil, "function %s: %w", funcName, err))
+		}
+		for {
+			switch wrapped := result.(type) {
+			case Expiring:
+				p.expireIn(wrapped.TTL)
+				result = wrapped.Value
+			case Secret:
+				p.usedSecret = true
+				result = wrapped.Value
+			default:
+				return result
+			}
+		}
+	}
+
+	// Panic
+	panic(evalErrorf(ErrUnknownFunction, "function %s is not implemented%s", funcName, didYouMean(funcName, p.functionsInScope())))
+}
+
 // String functions
-func stringFunctions(funcName string, funcParams []string) interface{} {
-	// Trim string quotes
+func stringFunctions(funcName string, funcParams []interface{}) interface{} {
+	// Transform parameters into strings
 	stringValues := make([]string, len(funcParams))
 	for i, v := range funcParams {
-		stringValues[i] = strings.Trim(strings.TrimSpace(v), `"`)
+		if str, isString := v.(string); isString {
+			stringValues[i] = str
+		} else {
+			stringValues[i] = fmt.Sprint(v)
+		}
 	}
 
 	switch funcName {
 	case "upper":
+		checkParamsCount(funcName, funcParams, 1, 1)
 		return strings.ToUpper(stringValues[0])
 	case "lower":
+		checkParamsCount(funcName, funcParams, 1, 1)
 		return strings.ToLower(stringValues[0])
 	case "append":
+		checkParamsCount(funcName, funcParams, 2, 2)
 		return stringValues[0] + stringValues[1]
 	case "concat":
-		// Remove open and close brackets
-		// TO-DO
-		return nil
-		// stringValues[0] = strings.TrimLeft(stringValues[0], "[")
-		// stringValues[0] = strings.TrimRight(stringValues[0], "]")
-		// array := strings.Split(stringValues[0], ", ")[1:]
-		// return strings.Join(array, stringValues[1])
+		checkParamsCount(funcName, funcParams, 2, 2)
+		array, isArray := funcParams[0].([]interface{})
+		if !isArray {
+			panic(evalErrorf(ErrTypeMismatch, "parameter '%v' in function '%s' is not an array", funcParams[0], funcName))
+		}
+		elems := make([]string, len(array))
+		for i, elem := range array {
+			elems[i] = fmt.Sprint(elem)
+		}
+		return strings.Join(elems, stringValues[1])
 	case "contains":
+		checkParamsCount(funcName, funcParams, 2, 2)
 		return strings.Contains(stringValues[0], stringValues[1])
 	case "length":
+		checkParamsCount(funcName, funcParams, 1, 1)
 		return len(stringValues[0])
+	case "sha256":
+		checkParamsCount(funcName, funcParams, 1, 1)
+		sum := sha256.Sum256([]byte(stringValues[0]))
+		return hex.EncodeToString(sum[:])
+	case "md5":
+		checkParamsCount(funcName, funcParams, 1, 1)
+		sum := md5.Sum([]byte(stringValues[0]))
+		return hex.EncodeToString(sum[:])
+	case "base64encode":
+		checkParamsCount(funcName, funcParams, 1, 1)
+		return base64.StdEncoding.EncodeToString([]byte(stringValues[0]))
+	case "base64decode":
+		checkParamsCount(funcName, funcParams, 1, 1)
+		decoded, err := base64.StdEncoding.DecodeString(stringValues[0])
+		if err != nil {
+			panic(evalErrorf(ErrInvalidArgument, "function %s: %w", funcName, err))
+		}
+		return string(decoded)
 	default:
-		p := fmt.Sprintf("ERROR in parser: function %s is not implemented", funcName)
-		panic(p)
+		panic(evalErrorf(ErrUnknownFunction, "function %s is not implemented", funcName))
 	}
 }
 
 // Numerical functions
-func numericalFunctions(funcName string, funcParams []string) interface{} {
+func numericalFunctions(funcName string, funcParams []interface{}) interface{} {
 	// Transform parameters into float
 	floatParams := make([]float64, len(funcParams))
 	hasInt := false
+	hasFloat := false
 	for i, v := range funcParams {
-		// Int
-		_, err := strconv.Atoi(strings.TrimSpace(v))
-		if err == nil {
+		switch val := v.(type) {
+		case int:
 			hasInt = true
-		}
-
-		// Float
-		valFloat, err := strconv.ParseFloat(strings.TrimSpace(v), 32)
-		if err == nil {
-			precision := math.Pow(10, float64((len(strings.TrimSpace(v)) - 1)))
-			floatParams[i] = math.Round(valFloat*precision) / precision
-			continue
+			floatParams[i] = float64(val)
+		case float64:
+			hasFloat = true
+			floatParams[i] = val
+		default:
+			panic(evalErrorf(ErrTypeMismatch, "parameter '%v' in function '%s' is not a number", v, funcName))
 		}
 	}
-
 	switch funcName {
 	case "power":
+		checkParamsCount(funcName, funcParams, 2, 2)
 		result := math.Pow(floatParams[0], floatParams[1])
 		if hasInt {
 			return int(result)
 		}
 		return result
 	case "floor":
+		checkParamsCount(funcName, funcParams, 2, 2)
 		result := math.Floor(floatParams[0] / floatParams[1])
 		if hasInt {
 			return int(result)
 		}
 		return result
 	case "remainder":
+		checkParamsCount(funcName, funcParams, 2, 2)
 		result := math.Mod(floatParams[0], floatParams[1])
 		if hasInt {
 			return int(result)
 		}
 		return result
+	case "abs", "ceil", "round":
+		checkParamsCount(funcName, funcParams, 1, 1)
+		if !hasFloat {
+			// Integers are already whole numbers
+			if value := funcParams[0].(int); funcName == "abs" && value < 0 {
+				return -value
+			}
+			return funcParams[0]
+		}
+		switch funcName {
+		case "abs":
+			return math.Abs(floatParams[0])
+		case "ceil":
+			return math.Ceil(floatParams[0])
+		default:
+			return math.Round(floatParams[0])
+		}
+	case "min", "max":
+		checkParamsCount(funcName, funcParams, 1, -1)
+		result := 0
+		for i := range floatParams {
+			if (funcName == "min" && floatParams[i] < floatParams[result]) ||
+				(funcName == "max" && floatParams[i] > floatParams[result]) {
+				result = i
+			}
+		}
+		if hasFloat {
+			return floatParams[result]
+		}
+		return funcParams[result]
+	case "sqrt":
+		checkParamsCount(funcName, funcParams, 1, 1)
+		if floatParams[0] < 0 {
+			panic(evalErrorf(ErrInvalidArgument, "function sqrt of negative number %v", funcParams[0]))
+		}
+		return math.Sqrt(floatParams[0])
+	case "log":
+		checkParamsCount(funcName, funcParams, 1, 2)
+		if floatParams[0] <= 0 {
+			panic(evalErrorf(ErrInvalidArgument, "function log of non-positive number %v", funcParams[0]))
+		}
+		if len(floatParams) == 1 {
+			return math.Log(floatParams[0])
+		}
+		if floatParams[1] <= 0 || floatParams[1] == 1 {
+			panic(evalErrorf(ErrInvalidArgument, "function log with invalid base %v", funcParams[1]))
+		}
+		return math.Log(floatParams[0]) / math.Log(floatParams[1])
 	default:
-		p := fmt.Sprintf("ERROR in parser: function %s is not implemented", funcName)
-		panic(p)
+		panic(evalErrorf(ErrUnknownFunction, "function %s is not implemented", funcName))
 	}
 }
 
 // Gate logic functions
-func gateLogicFunctions(funcName string, funcParams []string) interface{} {
+func gateLogicFunctions(funcName string, funcParams []interface{}) interface{} {
 	// Transform parameters into boolean
 	boolParams := make([]bool, len(funcParams))
 	for i, v := range funcParams {
-		valBool, err := strconv.ParseBool(strings.TrimSpace(v))
-		if err != nil {
-			p := fmt.Sprintf("ERROR in parser: parameter '%s' in function '%s' is not a boolean", v, funcName)
-			panic(p)
+		valBool, ok := v.(bool)
+		if !ok {
+			panic(evalErrorf(ErrTypeMismatch, "parameter '%v' in function '%s' is not a boolean", v, funcName))
 		}
 		boolParams[i] = valBool
 	}
-
+	checkParamsCount(funcName, funcParams, 2, 2)
 	switch funcName {
 	case "and":
 		return boolParams[0] == boolParams[1]
@@ -127,7 +432,449 @@ func gateLogicFunctions(funcName string, funcParams []string) interface{} {
 	case "xnor":
 		return !(boolParams[0] != boolParams[1])
 	default:
-		p := fmt.Sprintf("ERROR in parser: function %s is not implemented", funcName)
-		panic(p)
+		panic(evalErrorf(ErrUnknownFunction, "function %s is not implemented", funcName))
+	}
+}
+
+// Functions over arrays
+// The first parameter of every one of them is the array
+func arrayFunctions(funcName string, funcParams []interface{}) interface{} {
+	if len(funcParams) == 0 {
+		panic(evalErrorf(ErrTypeMismatch, "function %s takes an array parameter", funcName))
+	}
+	array, isArray := funcParams[0].([]interface{})
+	if !isArray {
+		panic(evalErrorf(ErrTypeMismatch, "parameter '%v' in function '%s' is not an array", funcParams[0], funcName))
+	}
+
+	switch funcName {
+	case "sum":
+		checkParamsCount(funcName, funcParams, 1, 1)
+		if len(array) == 0 {
+			return 0
+		}
+		return numericalSum(array)
+	case "sort":
+		checkParamsCount(funcName, funcParams, 1, 1)
+		sorted := append([]interface{}{}, array...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return lessArrayElems(funcName, sorted[i], sorted[j])
+		})
+		return sorted
+	case "unique":
+		checkParamsCount(funcName, funcParams, 1, 1)
+		unique := []interface{}{}
+		for _, elem := range array {
+			if indexOf(unique, elem) < 0 {
+				unique = append(unique, elem)
+			}
+		}
+		return unique
+	case "reverse":
+		checkParamsCount(funcName, funcParams, 1, 1)
+		reversed := make([]interface{}, len(array))
+		for i, elem := range array {
+			reversed[len(array)-1-i] = elem
+		}
+		return reversed
+	case "slice":
+		// slice(array, start, end), with end excluded
+		checkParamsCount(funcName, funcParams, 3, 3)
+		start, end := arrayIndex(funcName, funcParams[1]), arrayIndex(funcName, funcParams[2])
+		if start < 0 || end > len(array) || start > end {
+			panic(evalErrorf(ErrInvalidArgument, "function slice bounds [%d:%d] out of range of an array of length %d", start, end, len(array)))
+		}
+		return append([]interface{}{}, array[start:end]...)
+	case "index":
+		// index(array, value), -1 if the value isn't in the array
+		checkParamsCount(funcName, funcParams, 2, 2)
+		return indexOf(array, funcParams[1])
+	case "flatten":
+		checkParamsCount(funcName, funcParams, 1, 1)
+		return flattenArray(array)
+	case "element":
+		// element(array, index), negative indexes count from the end
+		checkParamsCount(funcName, funcParams, 2, 2)
+		index := arrayIndex(funcName, funcParams[1])
+		if index < 0 {
+			index += len(array)
+		}
+		if index < 0 || index >= len(array) {
+			panic(evalErrorf(ErrInvalidArgument, "function element index %v out of range of an array of length %d", funcParams[1], len(array)))
+		}
+		return array[index]
+	default:
+		panic(evalErrorf(ErrUnknownFunction, "function %s is not implemented", funcName))
+	}
+}
+
+// Adds the numbers of an array
+// The result is an integer unless one of the numbers is a floating point
+func numericalSum(array []interface{}) interface{} {
+	intSum := 0
+	floatSum := 0.0
+	hasFloat := false
+	for _, elem := range array {
+		switch val := elem.(type) {
+		case int:
+			intSum += val
+		case float64:
+			hasFloat = true
+			floatSum += val
+		default:
+			panic(evalErrorf(ErrTypeMismatch, "element '%v' in function 'sum' is not a number", elem))
+		}
+	}
+	if hasFloat {
+		return floatSum + float64(intSum)
+	}
+	return intSum
+}
+
+// Compares two elements of an array being sorted
+// Numbers are sorted by value and strings alphabetically, they can't be mixed
+func lessArrayElems(funcName string, a interface{}, b interface{}) bool {
+	aNumber, aIsNumber := toFloat(a)
+	bNumber, bIsNumber := toFloat(b)
+	if aIsNumber && bIsNumber {
+		return aNumber < bNumber
+	}
+
+	aString, aIsString := a.(string)
+	bString, bIsString := b.(string)
+	if aIsString && bIsString {
+		return aString < bString
+	}
+
+	panic(evalErrorf(ErrTypeMismatch, "function %s can't compare '%v' and '%v'", funcName, a, b))
+}
+
+// Converts ints and floats to float64
+func toFloat(value interface{}) (float64, bool) {
+	switch val := value.(type) {
+	case int:
+		return float64(val), true
+	case float64:
+		return val, true
+	}
+	return 0, false
+}
+
+// Converts the index parameter of an array function
+func arrayIndex(funcName string, param interface{}) int {
+	index, isInt := param.(int)
+	if !isInt {
+		panic(evalErrorf(ErrTypeMismatch, "parameter '%v' in function '%s' is not an integer", param, funcName))
+	}
+	return index
+}
+
+// Returns the position of a value in an array, or -1 if it isn't there
+func indexOf(array []interface{}, value interface{}) int {
+	for i, elem := range array {
+		if reflect.DeepEqual(elem, value) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Moves the elements of nested arrays into a single array
+func flattenArray(array []interface{}) []interface{} {
+	flat := []interface{}{}
+	for _, elem := range array {
+		if nested, isArray := elem.([]interface{}); isArray {
+			flat = append(flat, flattenArray(nested)...)
+			continue
+		}
+		flat = append(flat, elem)
+	}
+	return flat
+}
+
+// Functions converting a value to another type
+func conversionFunctions(funcName string, funcParams []interface{}) interface{} {
+	checkParamsCount(funcName, funcParams, 1, 1)
+	value := funcParams[0]
+
+	switch funcName {
+	case "tostring":
+		switch val := value.(type) {
+		case string:
+			return val
+		case int:
+			return strconv.Itoa(val)
+		case float64:
+			return strconv.FormatFloat(val, 'f', -1, 64)
+		case bool:
+			return strconv.FormatBool(val)
+		}
+	case "tonumber":
+		switch val := value.(type) {
+		case int, float64:
+			return val
+		case string:
+			if number, err := strconv.Atoi(strings.TrimSpace(val)); err == nil {
+				return number
+			}
+			if number, err := strconv.ParseFloat(strings.TrimSpace(val), 64); err == nil {
+				return number
+			}
+		}
+	case "tobool":
+		switch val := value.(type) {
+		case bool:
+			return val
+		case string:
+			switch strings.TrimSpace(val) {
+			case "true":
+				return true
+			case "false":
+				return false
+			}
+		}
+	default:
+		panic(evalErrorf(ErrUnknownFunction, "function %s is not implemented", funcName))
+	}
+
+	panic(evalErrorf(ErrTypeMismatch, "function %s can't convert '%v'", funcName, value))
+}
+
+// Functions over timestamps
+// Timestamps are strings in the RFC 3339 format, like "2023-01-02T15:04:05Z"
+func (p *Parser) timeFunctions(funcName string, funcParams []interface{}) interface{} {
+	switch funcName {
+	case "now":
+		// now(), in UTC
+		checkParamsCount(funcName, funcParams, 0, 0)
+		return p.now().UTC().Format(time.RFC3339)
+	case "formatdate":
+		// formatdate(layout, timestamp), with a Go time layout
+		checkParamsCount(funcName, funcParams, 2, 2)
+		timestamp := parseTimestamp(funcName, funcParams[1])
+		return timestamp.Format(fmt.Sprint(funcParams[0]))
+	case "timeadd":
+		// timeadd(timestamp, duration), with a duration like "24h" or "-1h30m"
+		checkParamsCount(funcName, funcParams, 2, 2)
+		timestamp := parseTimestamp(funcName, funcParams[0])
+		duration, err := time.ParseDuration(fmt.Sprint(funcParams[1]))
+		if err != nil {
+			panic(evalErrorf(ErrInvalidArgument, "function %s: %w", funcName, err))
+		}
+		return timestamp.Add(duration).Format(time.RFC3339)
+	default:
+		panic(evalErrorf(ErrUnknownFunction, "function %s is not implemented", funcName))
+	}
+}
+
+// Parses the timestamp parameter of a time function
+func parseTimestamp(funcName string, param interface{}) time.Time {
+	timestamp, err := time.Parse(time.RFC3339, fmt.Sprint(param))
+	if err != nil {
+		panic(evalErrorf(ErrTypeMismatch, "parameter '%v' in function '%s' is not an RFC 3339 timestamp", param, funcName))
+	}
+	return timestamp
+}
+
+// Functions over values of any type
+func valueFunctions(funcName string, funcParams []interface{}) interface{} {
+	switch funcName {
+	case "coalesce":
+		// coalesce(values...), the first value that isn't null or empty
+		checkParamsCount(funcName, funcParams, 1, -1)
+		for _, value := range funcParams {
+			if !isEmptyValue(value) {
+				return value
+			}
+		}
+		return nil
+	case "lookup":
+		// lookup(block, key, default), the value of a key, or the default
+		// value if the block doesn't have it
+		checkParamsCount(funcName, funcParams, 3, 3)
+		body, ok := funcParams[0].(map[string]interface{})
+		if !ok {
+			panic(evalErrorf(ErrTypeMismatch, "function %s expects a block or map, got %v", funcName, funcParams[0]))
+		}
+		key, ok := funcParams[1].(string)
+		if !ok {
+			panic(evalErrorf(ErrTypeMismatch, "function %s expects a string key, got %v", funcName, funcParams[1]))
+		}
+		if value, found := body[key]; found {
+			return value
+		}
+		return funcParams[2]
+	default:
+		panic(evalErrorf(ErrUnknownFunction, "function %s is not implemented", funcName))
+	}
+}
+
+// Checks if a value is null, an empty string or an empty array
+func isEmptyValue(value interface{}) bool {
+	switch val := value.(type) {
+	case nil:
+		return true
+	case string:
+		return val == ""
+	case []interface{}:
+		return len(val) == 0
+	}
+	return false
+}
+
+// Characters of the strings generated by randstr
+const randomStringChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// Functions generating random values
+// Their values are the same on every decoding if the random seed is fixed
+func (p *Parser) randomFunctions(funcName string, funcParams []interface{}) interface{} {
+	if p.rand == nil {
+		seed := time.Now().UnixNano()
+		if p.opts.seeded {
+			seed = p.opts.seed
+		}
+		p.rand = rand.New(rand.NewSource(seed))
+	}
+
+	switch funcName {
+	case "uuid":
+		// uuid(), a version 4 UUID
+		checkParamsCount(funcName, funcParams, 0, 0)
+		var uuid [16]byte
+		p.rand.Read(uuid[:])
+		uuid[6] = (uuid[6] & 0x0f) | 0x40
+		uuid[8] = (uuid[8] & 0x3f) | 0x80
+		return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+	case "randint":
+		// randint(min, max), with both included
+		checkParamsCount(funcName, funcParams, 2, 2)
+		min, max := arrayIndex(funcName, funcParams[0]), arrayIndex(funcName, funcParams[1])
+		if min > max {
+			panic(evalErrorf(ErrInvalidArgument, "function randint minimum %d is greater than maximum %d", min, max))
+		}
+		return min + p.rand.Intn(max-min+1)
+	case "randstr":
+		// randstr(length), with letters and digits
+		checkParamsCount(funcName, funcParams, 1, 1)
+		length := arrayIndex(funcName, funcParams[0])
+		if length < 0 {
+			panic(evalErrorf(ErrInvalidArgument, "function randstr negative length %d", length))
+		}
+		chars := make([]byte, length)
+		for i := range chars {
+			chars[i] = randomStringChars[p.rand.Intn(len(randomStringChars))]
+		}
+		return string(chars)
+	default:
+		panic(evalErrorf(ErrUnknownFunction, "function %s is not implemented", funcName))
+	}
+}
+
+// System functions
+// These read from the environment the file is decoded in
+func (p *Parser) systemFunctions(funcName string, funcParams []interface{}) interface{} {
+	switch funcName {
+	case "env":
+		// env(name) or env(name, default)
+		if p.opts.disallowEnv {
+			panic(evalErrorf(ErrFunctionNotAllowed, "function env is not allowed"))
+		}
+		if len(funcParams) < 1 || len(funcParams) > 2 {
+			panic(evalErrorf(ErrInvalidArgument, "function env takes 1 or 2 parameters, got %d", len(funcParams)))
+		}
+		value, found := os.LookupEnv(fmt.Sprint(funcParams[0]))
+		if !found && len(funcParams) == 2 {
+			return funcParams[1]
+		}
+		return value
+	case "file":
+		// file(path)
+		if len(funcParams) != 1 {
+			panic(evalErrorf(ErrInvalidArgument, "function file takes 1 parameter, got %d", len(funcParams)))
+		}
+		return p.readFile(funcName, fmt.Sprint(funcParams[0]))
+	case "templatefile":
+		// templatefile(path) or templatefile(path, vars)
+		if len(funcParams) < 1 || len(funcParams) > 2 {
+			panic(evalErrorf(ErrInvalidArgument, "function templatefile takes 1 or 2 parameters, got %d", len(funcParams)))
+		}
+		path := fmt.Sprint(funcParams[0])
+		content := p.readFile(funcName, path)
+
+		lookup := p.lookupIdentifier
+		if len(funcParams) == 2 {
+			vars, ok := funcParams[1].(map[string]interface{})
+			if !ok {
+				panic(evalErrorf(ErrTypeMismatch, "the variables of function templatefile must be a block"))
+			}
+			lookup = func(name string) (interface{}, bool) {
+				return lookupJSONPath(vars, strings.Split(name, "."))
+			}
+		}
+
+		return templateVariableRegexp.ReplaceAllStringFunc(content, func(match string) string {
+			name := templateVariableRegexp.FindStringSubmatch(match)[1]
+			value, found := lookup(name)
+			if !found {
+				panic(evalErrorf(ErrUndefined, "variable %s of template %s is not defined", name, path))
+			}
+			return fmt.Sprint(value)
+		})
+	default:
+		panic(evalErrorf(ErrUnknownFunction, "function %s is not implemented", funcName))
+	}
+}
+
+// Matches the interpolations of a template, like ${server.port}
+var templateVariableRegexp = regexp.MustCompile(`\$\{\s*([\p{L}_][\p{L}\p{N}_.-]*)\s*\}`)
+
+// Reads a file for a function call
+// Relative paths are resolved from the directory of the decoded file
+func (p *Parser) readFile(funcName string, path string) string {
+	if p.opts.disallowFiles {
+		panic(evalErrorf(ErrFunctionNotAllowed, "function %s is not allowed", funcName))
+	}
+	if p.opts.remote && !filepath.IsAbs(path) {
+		panic(evalErrorf(ErrFunctionNotAllowed, "function %s can't read %s, relative paths can't be resolved from a URL", funcName, path))
+	}
+	resolved := p.opts.resolvePath(p.filename, path)
+	content, err := p.opts.readFile(resolved)
+	if err != nil {
+		panic(evalErrorf(nil, "function %s: %w", funcName, err))
+	}
+	p.readFiles = append(p.readFiles, resolved)
+	return string(content)
+}
+
+// Follows a path of keys in a decoded block
+func lookupJSONPath(body map[string]interface{}, path []string) (interface{}, bool) {
+	value, found := body[path[0]]
+	if !found || len(path) == 1 {
+		return value, found
+	}
+	nested, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	return lookupJSONPath(nested, path[1:])
+}
+
+// Panics if a function is called with too few or too many parameters
+// A negative max allows any number of parameters
+func checkParamsCount(funcName string, funcParams []interface{}, min int, max int) {
+	count := len(funcParams)
+	if count >= min && (max < 0 || count <= max) {
+		return
+	}
+
+	var expected string
+	switch {
+	case max < 0:
+		expected = fmt.Sprintf("at least %d", min)
+	case min == max:
+		expected = fmt.Sprint(min)
+	default:
+		expected = fmt.Sprintf("%d to %d", min, max)
 	}
+	panic(evalErrorf(ErrInvalidArgument, "function %s takes %s parameters, got %d", funcName, expected, count))
 }
@@ -22,33 +22,50 @@ import (
 )
 
 // Transforms an item with keyMultiString kind
+// Its lines are joined by a space, without their indentation and the
+// backslashes continuing them, while the spacing inside their quotes is kept
 func transformItemMultiString(item string) interface{} {
-	// Create an array for the items
-	multiStrItems := []string{}
-
-	// Iterate on the item value and remove all "\t" and "\n"
-	foundValidElement := false
-	validElemStartIndex := 0
-	for i, char := range item {
-		if foundValidElement && (string(char) == "\t" || string(char) == "\n") {
-			multiStrItems = append(multiStrItems, item[validElemStartIndex:i-2])
-			foundValidElement = false
-		} else if foundValidElement && i == len(item)-1 {
-			multiStrItems = append(multiStrItems, item[validElemStartIndex:i])
-			foundValidElement = false
-		} else if !foundValidElement && (string(char) != "\t" && string(char) != "\n") {
-			foundValidElement = true
-			validElemStartIndex = i
+	lines := strings.Split(item, "\n")
+	multiStrItems := make([]string, len(lines))
+	for i, line := range lines {
+		line = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(line), `\`))
+		multiStrItems[i] = strings.Trim(line, `"`)
+	}
+	return strings.Join(multiStrItems, " ")
+}
+
+// Transforms a single literal value (int, float, bool or string)
+// into an interface
+func transformValue(elem string) interface{} {
+	if mayBeNumber(elem) {
+		// Int
+		valInt, err := strconv.Atoi(elem)
+		if err == nil {
+			return valInt
+		}
+
+		// Float
+		valFloat, err := strconv.ParseFloat(elem, 64)
+		if err == nil {
+			return valFloat
 		}
 	}
 
-	// For each element in the array, remove the quote signs
-	for i, str := range multiStrItems {
-		multiStrItems[i] = strings.Trim(str, `"`)
+	// Boolean
+	if mayBeBool(elem) {
+		valBool, err := strconv.ParseBool(elem)
+		if err == nil {
+			return valBool
+		}
 	}
 
-	// Join the array and return
-	return strings.Join(multiStrItems, " ")
+	// Null
+	if elem == "null" {
+		return nil
+	}
+
+	// If none, item is a string
+	return strings.Trim(elem, `"`)
 }
 
 // Transforms an item with keyArrayStart or keyArrayElem kind
@@ -63,30 +80,7 @@ func transformItemArray(item string) interface{} {
 	// Transform int, float and bool elements
 	arrayElems := make([]interface{}, len(freeElems))
 	for i, elem := range freeElems {
-		// Int
-		valInt, err := strconv.Atoi(elem)
-		if err == nil {
-			arrayElems[i] = valInt
-			continue
-		}
-
-		// Float
-		valFloat, err := strconv.ParseFloat(elem, 32)
-		if err == nil {
-			precision := math.Pow(10, float64((len(elem) - 1)))
-			arrayElems[i] = math.Round(valFloat*precision) / precision
-			continue
-		}
-
-		// Boolean
-		valBool, err := strconv.ParseBool(elem)
-		if err == nil {
-			arrayElems[i] = valBool
-			continue
-		}
-
-		// If none, item is a string
-		arrayElems[i] = strings.Trim(elem, `"`)
+		arrayElems[i] = transformValue(elem)
 	}
 	return arrayElems
 }
@@ -94,7 +88,7 @@ func transformItemArray(item string) interface{} {
 // Transforms an item with keyArithmetic kind
 func transformItemArithmetic(item string) interface{} {
 	// Separate all arithmetic symbols and values into an array
-	arithmeticArrayOriginal := []interface{}{}
+	arithmeticArrayOriginal := make([]interface{}, 0, 3)
 	hasFloat := false
 	skipItem := 0
 	for i, v := range item {
@@ -105,20 +99,19 @@ func transformItemArithmetic(item string) interface{} {
 		}
 
 		// Item is whitespace
-		if string(v) == " " {
+		if v == ' ' || v == '\t' {
 			continue
 		}
 
 		// Arithmetic symbols
 		if equalsToMany(string(v), []string{"+", "-", "*", "/"}) {
 			// Check if previous item is a number
-			if len(arithmeticArrayOriginal) == 0 || equalsToMany(fmt.Sprint(arithmeticArrayOriginal[0]), []string{"+", "-", "*", "/"}) {
-				p := fmt.Sprintf("ERROR in parser: arithmetic operation is missing values: %s", item)
-				panic(p)
+			if len(arithmeticArrayOriginal) == 0 || isArithmeticSymbol(arithmeticArrayOriginal[len(arithmeticArrayOriginal)-1], "+-*/") {
+				panic(evalErrorf(ErrInvalidExpression, "arithmetic operation is missing values: %s", item))
 			}
 
 			// Append symbol to arraym
-			arithmeticArrayOriginal = append(arithmeticArrayOriginal, string(v))
+			arithmeticArrayOriginal = append(arithmeticArrayOriginal, arithmeticSymbols[v])
 			continue
 		}
 
@@ -137,6 +130,9 @@ func transformItemArithmetic(item string) interface{} {
 					}
 				}
 			}
+			if endOfElem == 0 {
+				endOfElem = len(item)
+			}
 			addItemNumber = item[i:endOfElem]
 			skipItem = len(item[i:endOfElem]) - 1
 		} else {
@@ -152,8 +148,7 @@ func transformItemArithmetic(item string) interface{} {
 		if err != nil {
 			valFloat, err := strconv.ParseFloat(addItemNumber, 32)
 			if err != nil {
-				p := fmt.Sprintf("ERROR in parser: value in arithmetic operation is not a number: %s", addItemNumber)
-				panic(p)
+				panic(evalErrorf(ErrTypeMismatch, "value in arithmetic operation is not a number: %s", addItemNumber))
 			}
 			numberVal = valFloat
 			hasFloat = true
@@ -164,9 +159,8 @@ func transformItemArithmetic(item string) interface{} {
 		// Check if last element is an arithmetic symbol
 		if len(arithmeticArrayOriginal) >= 2 {
 			index := len(arithmeticArrayOriginal) - 1
-			if !equalsToMany(fmt.Sprint(arithmeticArrayOriginal[index]), []string{"+", "-", "*", "/"}) {
-				p := fmt.Sprintf("ERROR in parser: arithmetic operation value must be preceeded by operation symbol: %s", item)
-				panic(p)
+			if !isArithmeticSymbol(arithmeticArrayOriginal[index], "+-*/") {
+				panic(evalErrorf(ErrInvalidExpression, "arithmetic operation value must be preceeded by operation symbol: %s", item))
 			}
 		}
 
@@ -174,23 +168,24 @@ func transformItemArithmetic(item string) interface{} {
 		arithmeticArrayOriginal = append(arithmeticArrayOriginal, numberVal)
 	}
 
+	// Operations must end in a number
+	if len(arithmeticArrayOriginal) == 0 || isArithmeticSymbol(arithmeticArrayOriginal[len(arithmeticArrayOriginal)-1], "+-*/") {
+		panic(evalErrorf(ErrInvalidExpression, "arithmetic operation is missing values: %s", item))
+	}
+
 	// Perform calculations
 	// All calculations are done in float32, even if they're all integers
 	// By the end, it will return either as int or float, depending on hasFloat
 
 	// Search for multiplication and division first
 	arithmeticArrayFirstOperations := make([]interface{}, len(arithmeticArrayOriginal))
-	for i, v := range arithmeticArrayOriginal {
-		arithmeticArrayFirstOperations[i] = fmt.Sprint(v)
-	}
+	_ = copy(arithmeticArrayFirstOperations, arithmeticArrayOriginal)
 
 	for i, v := range arithmeticArrayOriginal {
-		if equalsToMany(fmt.Sprint(v), []string{"*", "/"}) {
+		if isArithmeticSymbol(v, "*/") {
 			// Get values
-			val1String := fmt.Sprint(arithmeticArrayOriginal[i-1])
-			val2String := fmt.Sprint(arithmeticArrayOriginal[i+1])
-			val1, _ := strconv.ParseFloat(val1String, 32)
-			val2, _ := strconv.ParseFloat(val2String, 32)
+			val1 := arithmeticOperand(arithmeticArrayOriginal[i-1])
+			val2 := arithmeticOperand(arithmeticArrayOriginal[i+1])
 
 			// Do operation
 			var result float64
@@ -210,12 +205,10 @@ func transformItemArithmetic(item string) interface{} {
 	arithmeticArrayOperations := make([]interface{}, len(arithmeticArrayFirstOperations))
 	_ = copy(arithmeticArrayOperations, arithmeticArrayFirstOperations)
 	for i, v := range arithmeticArrayFirstOperations {
-		if equalsToMany(fmt.Sprint(v), []string{"+", "-"}) {
+		if isArithmeticSymbol(v, "+-") {
 			// Get values
-			val1String := fmt.Sprint(arithmeticArrayFirstOperations[i-1])
-			val2String := fmt.Sprint(arithmeticArrayFirstOperations[i+1])
-			val1, _ := strconv.ParseFloat(val1String, 32)
-			val2, _ := strconv.ParseFloat(val2String, 32)
+			val1 := arithmeticOperand(arithmeticArrayFirstOperations[i-1])
+			val2 := arithmeticOperand(arithmeticArrayFirstOperations[i+1])
 
 			// Do operation
 			var result float64
@@ -233,11 +226,14 @@ func transformItemArithmetic(item string) interface{} {
 
 	// Get final value of the operation
 	if len(arithmeticArrayOperations) > 1 {
-		p := fmt.Sprintf("ERROR in parser: extra values in arithmetic operation: %s", item)
-		panic(p)
+		panic(evalErrorf(ErrInvalidExpression, "extra values in arithmetic operation: %s", item))
 	}
 	var result interface{}
-	if !hasFloat {
+	if value, _ := toFloat(arithmeticArrayOperations[0]); !hasFloat && value == math.Trunc(value) && math.Abs(value) < 1e6 {
+		// Whole numbers formatted without an exponent become ints without
+		// the formatting
+		result = int(value)
+	} else if !hasFloat {
 		result, _ = strconv.Atoi(fmt.Sprint(arithmeticArrayOperations[0]))
 	} else {
 		result, _ = strconv.ParseFloat(fmt.Sprint(arithmeticArrayOperations[0]), 32)
@@ -246,13 +242,35 @@ func transformItemArithmetic(item string) interface{} {
 	return result
 }
 
+// Symbols of arithmetic operations, shared by all of them
+var arithmeticSymbols = map[rune]interface{}{'+': "+", '-': "-", '*': "*", '/': "/"}
+
+// Checks if an element of an arithmetic operation is one of the symbols
+func isArithmeticSymbol(elem interface{}, symbols string) bool {
+	symbol, isSymbol := elem.(string)
+	return isSymbol && len(symbol) == 1 && strings.Contains(symbols, symbol)
+}
+
+// Returns a number of an arithmetic operation with the precision of a
+// float32, as all calculations are done in it
+// Symbols have no value
+func arithmeticOperand(elem interface{}) float64 {
+	switch number := elem.(type) {
+	case int:
+		return float64(float32(number))
+	case float64:
+		return float64(float32(number))
+	}
+	return 0
+}
+
 // Transforms an item with keyComparison kind
 func transformItemComparison(item string) interface{} {
 	// Separate values and comparator to an array
 	comparisonArray := []interface{}{}
 	skipItem := false
 	for i, v := range item {
-		if string(v) == " " {
+		if v == ' ' || v == '\t' {
 			continue
 		}
 
@@ -266,15 +284,14 @@ func transformItemComparison(item string) interface{} {
 		if equalsToMany(string(v), []string{"=", "!", ">", "<"}) {
 			// Check if it's ==, >= or <=
 			itemRange := 1
-			if string(item[i+1]) == "=" {
+			if i+1 < len(item) && item[i+1] == '=' {
 				itemRange += 1
 				skipItem = true
 			}
 
 			// Check if the array already has an element
 			if len(comparisonArray) == 0 {
-				p := fmt.Sprintf("ERROR in parser: comparison operation is missing values: %s", item)
-				panic(p)
+				panic(evalErrorf(ErrInvalidExpression, "comparison operation is missing values: %s", item))
 			}
 
 			// Add element to array
@@ -293,6 +310,9 @@ func transformItemComparison(item string) interface{} {
 						}
 					}
 				}
+				if endOfElem == 0 {
+					endOfElem = len(item)
+				}
 				comparisonArray = append(comparisonArray, item[i:endOfElem])
 			} else if len(comparisonArray) > 1 && len(comparisonArray) < 3 {
 				// Last element, so get the entire value until the end of the string
@@ -303,9 +323,11 @@ func transformItemComparison(item string) interface{} {
 	}
 
 	// A comparison can only have 3 elements, the 2 values and the comparator
+	if len(comparisonArray) < 3 {
+		panic(evalErrorf(ErrInvalidExpression, "comparison operation is missing values: %s", item))
+	}
 	if len(comparisonArray) > 3 {
-		p := fmt.Sprintf("ERROR in parser: comparison attributes can only compare 2 items: %s", item)
-		panic(p)
+		panic(evalErrorf(ErrInvalidExpression, "comparison attributes can only compare 2 items: %s", item))
 	}
 
 	// Check if any of the values is a boolean, if it is, the comparator has to be
@@ -314,14 +336,12 @@ func transformItemComparison(item string) interface{} {
 	val2Bool, checkVal2Bool := strconv.ParseBool(fmt.Sprint(comparisonArray[2]))
 	if checkVal1Bool == nil || checkVal2Bool == nil {
 		if !equalsToMany(fmt.Sprint(comparisonArray[1]), []string{"==", "!="}) {
-			p := fmt.Sprintf("ERROR in parser: booleans cannot be compared by %s symbol", fmt.Sprint(comparisonArray[1]))
-			panic(p)
+			panic(evalErrorf(ErrTypeMismatch, "booleans cannot be compared by %s symbol", fmt.Sprint(comparisonArray[1])))
 		}
 
 		// Both elements have to be a boolean
 		if checkVal1Bool != nil || checkVal2Bool != nil {
-			p := fmt.Sprintf("ERROR in parser: cannot compare boolean value to numerical value: %s", item)
-			panic(p)
+			panic(evalErrorf(ErrTypeMismatch, "cannot compare boolean value to numerical value: %s", item))
 		}
 
 		// Compare and return
@@ -337,8 +357,7 @@ func transformItemComparison(item string) interface{} {
 	val1Float, checkVal1Float := strconv.ParseFloat(fmt.Sprint(comparisonArray[0]), 32)
 	val2Float, checkVal2Float := strconv.ParseFloat(fmt.Sprint(comparisonArray[2]), 32)
 	if checkVal1Float != nil || checkVal2Float != nil {
-		p := fmt.Sprintf("ERROR in parser: can only compare boolean or numerical values: %s", item)
-		panic(p)
+		panic(evalErrorf(ErrTypeMismatch, "can only compare boolean or numerical values: %s", item))
 	}
 
 	switch fmt.Sprint(comparisonArray[1]) {
@@ -358,67 +377,119 @@ func transformItemComparison(item string) interface{} {
 	return false
 }
 
-// Transforms an item with keyFunction kind
-func transformItemFunction(item string) interface{} {
-	// Get function name
-	funcNameIndex := strings.Index(item, "(")
-	funcName := strings.TrimSpace(item[:funcNameIndex])
+// Transforms an item with keyAttrCall kind into the value of the
+// attribute it refers to
+func (p *Parser) transformItemAttrCall(item string) interface{} {
+	if item == "null" {
+		return nil
+	}
 
-	// Get first attribute
+	value, found := p.lookupIdentifier(item)
+	if !found {
+		// Strings without their closing quote and calls to unknown
+		// functions end up here, as they look like references
+		if strings.HasPrefix(item, `"`) {
+			panic(evalErrorf(ErrUnclosedString, "string %s is not closed", item))
+		}
+		if match := unknownCallRegexp.FindStringSubmatch(item); match != nil {
+			p.unknownFunction(match[1])
+		}
+		panic(evalErrorf(ErrUndefined, "attribute %s is not defined%s", item, didYouMean(item, p.namesInScope(strings.Contains(item, ".")))))
+	}
+	return value
+}
+
+// Fails on a call to a function that isn't defined, suggesting the
+// closest one
+func (p *Parser) unknownFunction(name string) {
+	panic(evalErrorf(ErrUnknownFunction, "function %s is not defined%s", name, didYouMean(name, p.functionsInScope())))
+}
+
+// Splits the parameters of a function call by their commas
+// Commas inside strings, arrays or nested calls are ignored
+func splitFunctionParams(params string) []string {
 	funcParams := []string{}
+	if strings.TrimSpace(params) == "" {
+		return funcParams
+	}
 
-	// Single parameter functions
-	singleParamFunctions := []string{"upper", "lower", "length"}
-	if equalsToMany(funcName, singleParamFunctions) {
-		funcParams = append(funcParams, item[funcNameIndex+1:len(item)-1])
-	} else { // Multiple parameter functions
-		for i, v := range item {
-			if i < funcNameIndex {
-				continue
-			}
+	depth := 0
+	insideQuotes := false
+	paramStart := 0
+	for i, v := range params {
+		switch {
+		case v == '"':
+			insideQuotes = !insideQuotes
+		case insideQuotes:
+			continue
+		case v == '(' || v == '[':
+			depth += 1
+		case v == ')' || v == ']':
+			depth -= 1
+		case v == ',' && depth == 0:
+			funcParams = append(funcParams, strings.TrimSpace(params[paramStart:i]))
+			paramStart = i + 1
+		}
+	}
+	return append(funcParams, strings.TrimSpace(params[paramStart:]))
+}
 
-			// Ignore comma if it's inside brackets
-			insideBrackets := false
-			if string(v) == `"` && !insideBrackets {
-				insideBrackets = true
-			} else if string(v) == `"` && insideBrackets {
-				insideBrackets = false
-			}
+// Transforms a function parameter into an interface
+// Parameters can be literals, arrays or calls to other functions
+func (p *Parser) transformFunctionParam(param string) interface{} {
+	// String
+	if strings.HasPrefix(param, `"`) {
+		return strings.Trim(param, `"`)
+	}
 
-			// Check for comma
-			if string(v) == "," && !insideBrackets {
-				funcParams = append(funcParams, item[funcNameIndex+1:i])
-				funcParams = append(funcParams, item[i+1:len(item)-1])
-				break
-			}
+	// Array
+	if strings.HasPrefix(param, "[") && strings.HasSuffix(param, "]") {
+		arrayParams := splitFunctionParams(param[1 : len(param)-1])
+		arrayElems := make([]interface{}, len(arrayParams))
+		for i, elem := range arrayParams {
+			arrayElems[i] = p.transformFunctionParam(elem)
 		}
+		return arrayElems
+	}
+
+	// Nested function call, which fails for unknown functions
+	if (hasPrefixToMany(param, p.callPrefixes()) || callStartRegexp.MatchString(param)) && strings.HasSuffix(param, ")") {
+		return p.transformItemFunction(param)
 	}
 
-	// Strings
-	stringFunctionNames := []string{"upper", "lower", "append", "concat", "contains", "length"}
-	if equalsToMany(funcName, stringFunctionNames) {
-		return stringFunctions(funcName, funcParams)
+	// Call to another attribute, or null
+	if identifierRegexp.MatchString(param) && !equalsToMany(param, []string{"true", "false"}) {
+		return p.transformItemAttrCall(param)
 	}
 
-	// Numerical
-	numericalFunctionNames := []string{"power", "floor", "remainder"}
-	if equalsToMany(funcName, numericalFunctionNames) {
-		return numericalFunctions(funcName, funcParams)
+	return transformValue(param)
+}
+
+// Transforms an item with keyFunction kind
+func (p *Parser) transformItemFunction(item string) interface{} {
+	// Get function name
+	funcNameIndex := strings.Index(item, "(")
+	funcName := strings.TrimSpace(item[:funcNameIndex])
+	if !isFunctionName(funcName) && p.opts.functions[funcName] == nil {
+		p.unknownFunction(funcName)
 	}
 
-	// Gate logic
-	gateLogicFunctionNames := []string{"and", "or", "nand", "nor", "xor", "xnor"}
-	if equalsToMany(funcName, gateLogicFunctionNames) {
-		return gateLogicFunctions(funcName, funcParams)
+	// Get the parameters between the parenthesis
+	funcEndIndex := strings.LastIndex(item, ")")
+	if funcEndIndex < funcNameIndex {
+		panic(evalErrorf(ErrInvalidExpression, "function call is not closed: %s", item))
+	}
+	rawParams := splitFunctionParams(item[funcNameIndex+1 : funcEndIndex])
+	funcParams := make([]interface{}, len(rawParams))
+	for i, param := range rawParams {
+		funcParams[i] = p.transformFunctionParam(param)
 	}
 
-	// Panic
-	p := fmt.Sprintf("ERROR in parser: function %s is not implemented", funcName)
-	panic(p)
+	return p.callFunction(funcName, funcParams)
 }
 
 // Transforms an item's value string into an interface
-func transformItem(item string, kind keyKind) interface{} {
+func (p *Parser) transformItem(item string, kind keyKind) interface{} {
 	// String
 	if kind == keyString {
 		// Simply remove the quote signs and return
@@ -434,21 +505,18 @@ func transformItem(item string, kind keyKind) interface{} {
 	if kind == keyInt {
 		val, err := strconv.Atoi(item)
 		if err != nil {
-			p := fmt.Sprintf("ERROR in parser: non int item %s tried to be parsed as int", item)
-			panic(p)
+			panic(evalErrorf(ErrTypeMismatch, "non int item %s tried to be parsed as int", item))
 		}
 		return val
 	}
 
 	// Float
 	if kind == keyFloat {
-		val, err := strconv.ParseFloat(item, 32)
+		val, err := strconv.ParseFloat(item, 64)
 		if err != nil {
-			p := fmt.Sprintf("ERROR in parser: non float item %s tried to be parsed as float", item)
-			panic(p)
+			panic(evalErrorf(ErrTypeMismatch, "non float item %s tried to be parsed as float", item))
 		}
-		precision := math.Pow(10, float64((len(item) - 1)))
-		return math.Round(val*precision) / precision
+		return val
 	}
 
 	// Bool
@@ -456,8 +524,7 @@ func transformItem(item string, kind keyKind) interface{} {
 		val, err := strconv.ParseBool(item)
 		if err != nil {
 
-			p := fmt.Sprintf("ERROR in parser: non boolean item %s tried to be parsed as boolean", item)
-			panic(p)
+			panic(evalErrorf(ErrTypeMismatch, "non boolean item %s tried to be parsed as boolean", item))
 		}
 		return val
 	}
@@ -485,10 +552,14 @@ func transformItem(item string, kind keyKind) interface{} {
 
 	// Function
 	if kind == keyFunction {
-		return transformItemFunction(item)
+		return p.transformItemFunction(item)
+	}
+
+	// Call to another attribute
+	if kind == keyAttrCall {
+		return p.transformItemAttrCall(item)
 	}
 
 	// Unknown
-	p := fmt.Sprintf("ERROR in parser: unknown item kind: %s", keyKindStr(kind))
-	panic(p)
+	panic(evalErrorf(ErrInvalidExpression, "unknown item kind: %s", keyKindStr(kind)))
 }
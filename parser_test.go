@@ -15,23 +15,37 @@
 package cafe
 
 import (
+	"fmt"
+	"io/fs"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// Compares the attributes of the parser tests, leaving their ranges to
+// TestParseRanges
+func assertAttribute(t *testing.T, expected Attribute, actual Attribute) {
+	t.Helper()
+	assert.NotZero(t, actual.Range.Start.Line, actual.Name)
+	actual.Range = Range{}
+	assert.Equal(t, expected, actual)
+}
+
 func TestParserGlobalAttrributes(t *testing.T) {
 	input, err := readCAFEFile("./test_data/test-lexer.cafe")
 	assert.NoError(t, err)
 
 	p := newParser(input)
-	p.parseItems(false)
+	p.parseItems(nil)
 
-	expectedMap := map[string]attribute{
+	expectedMap := map[string]Attribute{
 		"str": {
-			Name:  "str",
-			Value: "string",
-			kind:  attrString,
+			Name:        "str",
+			Value:       "string",
+			LineComment: "Inline comment",
+			kind:        attrString,
 		},
 		"multistr": {
 			Name:  "multistr",
@@ -97,66 +111,78 @@ func TestParserGlobalAttrributes(t *testing.T) {
 			Name:  "arith1",
 			Value: 20,
 			kind:  attrArithmetic,
+			expr:  "10 + 10",
 		},
 		"arith2": {
 			Name:  "arith2",
 			Value: 2,
 			kind:  attrArithmetic,
+			expr:  "10-8",
 		},
 		"arith3": {
 			Name:  "arith3",
 			Value: 4,
 			kind:  attrArithmetic,
+			expr:  "1 * 4",
 		},
 		"arith4": {
 			Name:  "arith4",
 			Value: 1,
 			kind:  attrArithmetic,
+			expr:  "10/ 10",
 		},
 		"compare1": {
 			Name:  "compare1",
 			Value: false,
 			kind:  attrComparison,
+			expr:  "10 == 20",
 		},
 		"compare2": {
 			Name:  "compare2",
 			Value: true,
 			kind:  attrComparison,
+			expr:  "10 != 20",
 		},
 		"compare3": {
 			Name:  "compare3",
 			Value: false,
 			kind:  attrComparison,
+			expr:  "10 > 20",
 		},
 		"compare4": {
 			Name:  "compare4",
 			Value: false,
 			kind:  attrComparison,
+			expr:  "10 >= 20",
 		},
 		"compare5": {
 			Name:  "compare5",
 			Value: true,
 			kind:  attrComparison,
+			expr:  "10 < 20",
 		},
 		"compare6": {
 			Name:  "compare6",
 			Value: true,
 			kind:  attrComparison,
+			expr:  "10 <= 20",
 		},
 		"compare7": {
 			Name:  "compare7",
 			Value: true,
 			kind:  attrComparison,
+			expr:  "true == true",
 		},
 		"compare8": {
 			Name:  "compare8",
 			Value: false,
 			kind:  attrComparison,
+			expr:  "true != true",
 		},
 	}
 
 	for _, v := range expectedMap {
-		assert.Equal(t, v, p.Attributes[v.Name])
+		assertAttribute(t, v, p.Attributes[v.Name])
 	}
 }
 
@@ -165,9 +191,9 @@ func TestParserBlockAttributes(t *testing.T) {
 	assert.NoError(t, err)
 
 	p := newParser(input)
-	p.parseItems(false)
+	p.parseItems(nil)
 
-	expectedMap := map[string]attribute{
+	expectedMap := map[string]Attribute{
 		"blockString": {
 			Name:  "blockString",
 			Value: "block string",
@@ -197,10 +223,10 @@ func TestParserBlockAttributes(t *testing.T) {
 
 	// First block
 	for _, v := range expectedMap {
-		assert.Equal(t, v, p.Blocks["block2"].Attributes[v.Name])
+		assertAttribute(t, v, p.Blocks["block2"].Attributes[v.Name])
 	}
 
-	expectedNestedMap := map[string]attribute{
+	expectedNestedMap := map[string]Attribute{
 		"blockNestedString": {
 			Name:  "blockNestedString",
 			Value: "block string",
@@ -230,7 +256,7 @@ func TestParserBlockAttributes(t *testing.T) {
 
 	// Nested block
 	for _, v := range expectedNestedMap {
-		assert.Equal(t, v, p.Blocks["block4"].Blocks["nested1"].Attributes[v.Name])
+		assertAttribute(t, v, p.Blocks["block4"].Blocks["nested1"].Attributes[v.Name])
 	}
 }
 
@@ -239,85 +265,944 @@ func TestParseFunctions(t *testing.T) {
 	assert.NoError(t, err)
 
 	p := newParser(input)
-	p.parseItems(false)
+	p.parseItems(nil)
 
-	expectedMap := map[string]attribute{
+	expectedMap := map[string]Attribute{
 		// String functions
 		"testFuncString1": {
 			Name:  "testFuncString1",
 			Value: "TEST FUNCTION",
 			kind:  attrFunction,
+			expr:  `upper("test function")`,
 		},
 		"testFuncString2": {
 			Name:  "testFuncString2",
 			Value: "test function",
 			kind:  attrFunction,
+			expr:  `lower("TEST FUNCTION")`,
 		},
 		"testFuncString3": {
 			Name:  "testFuncString3",
 			Value: "test function",
 			kind:  attrFunction,
+			expr:  `append("test", " function")`,
+		},
+		"testFuncString4": {
+			Name:  "testFuncString4",
+			Value: "test string concat function",
+			kind:  attrFunction,
+			expr:  `concat(arr1, " ")`,
 		},
-		// "testFuncString4": {
-		// 	Name:  "testFuncString4",
-		// 	Value: "test string concat function",
-		// 	kind:  attrFunction,
-		// },
 		"testFuncString5": {
 			Name:  "testFuncString5",
 			Value: 13,
 			kind:  attrFunction,
+			expr:  `length("test function")`,
 		},
 		// Numeric functions
 		"testFuncNumerical1": {
 			Name:  "testFuncNumerical1",
 			Value: 25,
 			kind:  attrFunction,
+			expr:  "power(5, 2)",
 		},
 		"testFuncNumerical2": {
 			Name:  "testFuncNumerical2",
 			Value: 3,
 			kind:  attrFunction,
+			expr:  "floor(25, 7)",
 		},
 		"testFuncNumerical3": {
 			Name:  "testFuncNumerical3",
 			Value: 1,
 			kind:  attrFunction,
+			expr:  "remainder(10, 3)",
+		},
+		"testFuncNumerical4": {
+			Name:  "testFuncNumerical4",
+			Value: 3,
+			kind:  attrFunction,
+			expr:  "abs(-3)",
+		},
+		"testFuncNumerical5": {
+			Name:  "testFuncNumerical5",
+			Value: 2.5,
+			kind:  attrFunction,
+			expr:  "abs(-2.5)",
+		},
+		"testFuncNumerical6": {
+			Name:  "testFuncNumerical6",
+			Value: 2,
+			kind:  attrFunction,
+			expr:  "min(4, 2, 9)",
+		},
+		"testFuncNumerical7": {
+			Name:  "testFuncNumerical7",
+			Value: 9.0,
+			kind:  attrFunction,
+			expr:  "max(4, 2.5, 9)",
+		},
+		"testFuncNumerical8": {
+			Name:  "testFuncNumerical8",
+			Value: 3.0,
+			kind:  attrFunction,
+			expr:  "ceil(2.1)",
+		},
+		"testFuncNumerical9": {
+			Name:  "testFuncNumerical9",
+			Value: 3.0,
+			kind:  attrFunction,
+			expr:  "round(2.5)",
+		},
+		"testFuncNumerical10": {
+			Name:  "testFuncNumerical10",
+			Value: 4.0,
+			kind:  attrFunction,
+			expr:  "sqrt(16)",
+		},
+		"testFuncNumerical11": {
+			Name:  "testFuncNumerical11",
+			Value: 3.0,
+			kind:  attrFunction,
+			expr:  "log(8, 2)",
 		},
 		// Gate logic functions
 		"testFuncGateLogic1": {
 			Name:  "testFuncGateLogic1",
 			Value: true,
 			kind:  attrFunction,
+			expr:  "and(true, true)",
 		},
 		"testFuncGateLogic2": {
 			Name:  "testFuncGateLogic2",
 			Value: true,
 			kind:  attrFunction,
+			expr:  "or(true, false)",
 		},
 		"testFuncGateLogic3": {
 			Name:  "testFuncGateLogic3",
 			Value: false,
 			kind:  attrFunction,
+			expr:  "nand(true, true)",
 		},
 		"testFuncGateLogic4": {
 			Name:  "testFuncGateLogic4",
 			Value: false,
 			kind:  attrFunction,
+			expr:  "nor(true, true)",
 		},
 		"testFuncGateLogic5": {
 			Name:  "testFuncGateLogic5",
 			Value: true,
 			kind:  attrFunction,
+			expr:  "xor(true, false)",
 		},
 		"testFuncGateLogic6": {
 			Name:  "testFuncGateLogic6",
 			Value: true,
 			kind:  attrFunction,
+			expr:  "xnor(true, true)",
+		},
+		// Array functions
+		"testFuncArray1": {
+			Name:  "testFuncArray1",
+			Value: 9,
+			kind:  attrFunction,
+			expr:  "sum(arr2)",
+		},
+		"testFuncArray2": {
+			Name:  "testFuncArray2",
+			Value: []interface{}{1, 2, 3, 3},
+			kind:  attrFunction,
+			expr:  "sort(arr2)",
+		},
+		"testFuncArray3": {
+			Name:  "testFuncArray3",
+			Value: []interface{}{3, 1, 2},
+			kind:  attrFunction,
+			expr:  "unique(arr2)",
+		},
+		"testFuncArray4": {
+			Name:  "testFuncArray4",
+			Value: []interface{}{"c", "b", "a"},
+			kind:  attrFunction,
+			expr:  `reverse(["a", "b", "c"])`,
+		},
+		"testFuncArray5": {
+			Name:  "testFuncArray5",
+			Value: []interface{}{1, 2},
+			kind:  attrFunction,
+			expr:  "slice(arr2, 1, 3)",
+		},
+		"testFuncArray6": {
+			Name:  "testFuncArray6",
+			Value: 2,
+			kind:  attrFunction,
+			expr:  "index(arr2, 2)",
+		},
+		"testFuncArray7": {
+			Name:  "testFuncArray7",
+			Value: []interface{}{1, 2, 3, 4},
+			kind:  attrFunction,
+			expr:  "flatten([[1, 2], [3], 4])",
+		},
+		"testFuncArray8": {
+			Name:  "testFuncArray8",
+			Value: 3,
+			kind:  attrFunction,
+			expr:  "element(arr2, -1)",
 		},
 	}
 
 	for _, v := range expectedMap {
-		assert.Equal(t, v, p.Attributes[v.Name])
+		assertAttribute(t, v, p.Attributes[v.Name])
+	}
+}
+
+func TestParseEncodingFunctions(t *testing.T) {
+	input, err := readCAFEFile("./test_data/test-encoding.cafe")
+	assert.NoError(t, err)
+
+	p := newParser(input)
+	p.parseItems(nil)
+
+	assert.Equal(t, "a860b858265b22dad3aaf1165cfc2936daf1d3d86e0b7b77e3cc07f59f96858f", p.Attributes["digest"].Value)
+	assert.Equal(t, "d2626f412da748e711ca4f4ae9428664", p.Attributes["checksum"].Value)
+	assert.Equal(t, "Y2FmZQ==", p.Attributes["encoded"].Value)
+	assert.Equal(t, "cafe", p.Attributes["decoded"].Value)
+
+	assert.Panics(t, func() { newParser(splitTestInput(`value = base64decode("not base64!")`)).parseItems(nil) })
+}
+
+func TestParseNull(t *testing.T) {
+	t.Setenv("CAFE_TEST_PORT", "9090")
+
+	input, err := readCAFEFile("./test_data/test-null.cafe")
+	assert.NoError(t, err)
+
+	p := newParser(input)
+	p.parseItems(nil)
+	assert.NoError(t, p.err)
+
+	assert.Equal(t, Attribute{Name: "nothing", Value: nil, kind: attrNIL, Range: Range{Start: Position{1, 1}, End: Position{1, 15}}}, p.Attributes["nothing"])
+	assert.Equal(t, []interface{}{1, nil, "two"}, p.Attributes["values"].Value)
+	assert.Equal(t, "localhost", p.Attributes["host"].Value)
+	assert.Equal(t, "9090", p.Attributes["port"].Value)
+	assert.Nil(t, p.Attributes["empty"].Value)
+	assert.Equal(t, 2, p.Attributes["cpu"].Value)
+	assert.Equal(t, 512, p.Attributes["memory"].Value)
+
+	assert.Panics(t, func() {
+		newParser(splitTestInput("port = 80\nvalue = lookup(port, \"key\", 1)\n")).parseItems(nil)
+	})
+}
+
+func TestParseArithmetic(t *testing.T) {
+	p := newParser(splitTestInput(`port = 8000 + 3
+product = 512 * 2
+mixed = 1.5 * 2
+values = [1, -2, 1.5, true, "t", "inf", null]
+`))
+	p.parseItems(nil)
+	assert.NoError(t, p.err)
+
+	assert.Equal(t, 8003, p.Attributes["port"].Value)
+	assert.Equal(t, 1024, p.Attributes["product"].Value)
+	assert.Equal(t, 3.0, p.Attributes["mixed"].Value)
+	assert.Equal(t, []interface{}{1, -2, 1.5, true, "t", "inf", nil}, p.Attributes["values"].Value)
+}
+
+func TestParseConversions(t *testing.T) {
+	t.Setenv("CAFE_TEST_PORT", "9090")
+	t.Setenv("CAFE_TEST_DEBUG", "true")
+
+	input, err := readCAFEFile("./test_data/test-conversions.cafe")
+	assert.NoError(t, err)
+
+	p := newParser(input)
+	p.parseItems(nil)
+
+	assert.Equal(t, 9090, p.Attributes["port"].Value)
+	assert.Equal(t, 0.75, p.Attributes["ratio"].Value)
+	assert.Equal(t, true, p.Attributes["debug"].Value)
+	assert.Equal(t, "9090", p.Attributes["port_text"].Value)
+	assert.Equal(t, "2.5", p.Attributes["ratio_text"].Value)
+	assert.Equal(t, "true", p.Attributes["debug_text"].Value)
+
+	// Values that can't be converted
+	assert.Panics(t, func() { newParser(splitTestInput(`port = tonumber("eighty")`)).parseItems(nil) })
+	assert.Panics(t, func() { newParser(splitTestInput(`debug = tobool("yes")`)).parseItems(nil) })
+	assert.Panics(t, func() { newParser(splitTestInput(`text = tostring([1, 2])`)).parseItems(nil) })
+}
+
+func TestParseTimeFunctions(t *testing.T) {
+	input, err := readCAFEFile("./test_data/test-time.cafe")
+	assert.NoError(t, err)
+
+	p := newParser(input)
+	p.clock = func() time.Time {
+		return time.Date(2023, 1, 2, 15, 4, 5, 0, time.FixedZone("", -3*60*60))
+	}
+	p.parseItems(nil)
+
+	assert.Equal(t, "2023-01-02T18:04:05Z", p.Attributes["started_at"].Value)
+	assert.Equal(t, "2023-01-02", p.Attributes["started_on"].Value)
+	assert.Equal(t, "2023-02-01T18:04:05Z", p.Attributes["expires_at"].Value)
+	assert.Equal(t, "2023-03-01T10:30:00+02:00", p.Attributes["reminder_at"].Value)
+
+	assert.Panics(t, func() { newParser(splitTestInput(`at = timeadd("yesterday", "1h")`)).parseItems(nil) })
+	assert.Panics(t, func() { newParser(splitTestInput(`at = timeadd(now(), "1 day")`)).parseItems(nil) })
+}
+
+// Registers a function for the duration of a test
+func registerTestFunction(t *testing.T, name string, fn Function) {
+	RegisterFunction(name, fn)
+	t.Cleanup(func() {
+		customFunctionsMu.Lock()
+		defer customFunctionsMu.Unlock()
+		delete(customFunctions, name)
+	})
+}
+
+func TestParseCustomFunctions(t *testing.T) {
+	registerTestFunction(t, "double", func(args []Value) (Value, error) {
+		return args[0].(int) * 2, nil
+	})
+	registerTestFunction(t, "greet", func(args []Value) (Value, error) {
+		if len(args) != 3 {
+			return nil, fmt.Errorf("expected 3 arguments, got %d", len(args))
+		}
+		return fmt.Sprintf("%s %s %v", args...), nil
+	})
+
+	input, err := readCAFEFile("./test_data/test-custom-functions.cafe")
+	assert.NoError(t, err)
+
+	p := newParser(input)
+	p.parseItems(nil)
+
+	assert.Equal(t, 42, p.Attributes["doubled"].Value)
+	assert.Equal(t, "cafe LOVER 3", p.Attributes["greeting"].Value)
+	assert.Equal(t, "CAFE IS 1", p.Attributes["nested"].Value)
+	assert.Equal(t, attrFunction, p.Attributes["nested"].kind)
+
+	// Builtins and duplicates can't be registered
+	noop := func(args []Value) (Value, error) { return nil, nil }
+	assert.Panics(t, func() { RegisterFunction("upper", noop) })
+	assert.Panics(t, func() { RegisterFunction("double", noop) })
+	assert.Panics(t, func() { RegisterFunction("not valid", noop) })
+}
+
+func TestParseReferences(t *testing.T) {
+	input, err := readCAFEFile("./test_data/test-references.cafe")
+	assert.NoError(t, err)
+
+	p := newParser(input)
+	p.parseItems(nil)
+
+	server := p.Blocks["server"]
+	assert.Equal(t, "WEB", server.Attributes["upperName"].Value)
+	assert.Equal(t, Attribute{Name: "serverPort", Value: 8080, kind: attrReference, expr: "port", Range: Range{Start: Position{7, 5}, End: Position{7, 22}}}, server.Attributes["serverPort"])
+	assert.Equal(t, "web-nested", server.Blocks["nested"].Attributes["parentName"].Value)
+	assert.Equal(t, "web-server", p.Attributes["dottedName"].Value)
+	assert.Equal(t, "a, b, c", p.Attributes["joinedTags"].Value)
+
+	// Undefined attributes
+	assert.Panics(t, func() {
+		p := newParser(splitTestInput("value = upper(undefined)\n"))
+		p.parseItems(nil)
+	})
+}
+
+// Splits a test input into runes, as readCAFEFile does
+func splitTestInput(src string) []rune {
+	return []rune(src)
+}
+
+func TestParseTabs(t *testing.T) {
+	p := newParser(splitTestInput("server {\n\tname\t=\t\"web\"\n\tlimits {\n\t\tcpu = 1\t+\t1\n\t}\n}\nmessage = \"hello   world\" \\\n\t\"second\tline\"\n"))
+	p.parseItems(nil)
+	assert.NoError(t, p.err)
+
+	server := p.Blocks["server"]
+	assert.Equal(t, "web", server.Attributes["name"].Value)
+	assert.Equal(t, Range{Start: Position{2, 2}, End: Position{2, 14}}, server.Attributes["name"].Range)
+	assert.Equal(t, 2, server.Blocks["limits"].Attributes["cpu"].Value)
+
+	// The spacing inside the quotes of multiline strings is kept
+	assert.Equal(t, "hello   world second\tline", p.Attributes["message"].Value)
+}
+
+func TestParseUnicode(t *testing.T) {
+	p := newParser(splitTestInput("/// Serveur été\nserveur_été {\n    hôte = \"São Paulo\" // ville\n    let 名前 = \"値\"\n    réf = upper(hôte)\n    clé = 名前\n}\n"))
+	p.parseItems(nil)
+	assert.NoError(t, p.err)
+
+	server := p.Blocks["serveur_été"]
+	assert.Equal(t, "Serveur été", server.Doc)
+	assert.Equal(t, "São Paulo", server.Attributes["hôte"].Value)
+	assert.Equal(t, Range{Start: Position{3, 5}, End: Position{3, 23}}, server.Attributes["hôte"].Range)
+	assert.Equal(t, "SÃO PAULO", server.Attributes["réf"].Value)
+	assert.Equal(t, "値", server.Attributes["clé"].Value)
+
+	// Errors are positioned in runes too
+	_, err := DecodeBytes([]byte("名前 = 1\n名前 = 2\n"))
+	var parseErr *ParseError
+	assert.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, 1, parseErr.Col)
+	assert.Equal(t, 2, parseErr.Length)
+	assert.Equal(t, "line 2, column 1: attribute 名前 redefined, previously defined at line 1\n名前 = 2\n^^", parseErr.Annotated())
+}
+
+func TestParseComments(t *testing.T) {
+	p, err := Decode("./test_data/test-comments.cafe")
+	assert.NoError(t, err)
+
+	assert.Equal(t, 8080, p.Attributes["port"].Value)
+	assert.Equal(t, true, p.Attributes["enabled"].Value)
+	server := p.Blocks["server"]
+	assert.Equal(t, "localhost#1", server.Attributes["host"].Value)
+	assert.Equal(t, Range{Start: Position{6, 18}, End: Position{6, 38}}, server.Attributes["host"].Range)
+	assert.Equal(t, "#X /* Y", server.Attributes["up"].Value)
+	assert.Equal(t, 8, server.Attributes["workers"].Value)
+	assert.Equal(t, Range{Start: Position{5, 1}, End: Position{9, 2}}, server.Range)
+}
+
+func TestParseLineComments(t *testing.T) {
+	p, err := Decode("./test_data/test-comments.cafe")
+	assert.NoError(t, err)
+	assert.Equal(t, "Trailing hash comment", p.Attributes["port"].LineComment)
+	assert.Equal(t, "", p.Attributes["enabled"].LineComment)
+	assert.Equal(t, "Not in the string", p.Blocks["server"].Attributes["up"].LineComment)
+	assert.Equal(t, "Trailing block comment", p.Blocks["server"].Attributes["workers"].LineComment)
+
+	// Operators in comments aren't part of the values
+	p = newParser(splitTestInput("ratio = 1.5 // a-b, c=d\nenabled = true # x > y\nport = 8080 /* 80 * 101 */\nname = port // if any\n"))
+	p.parseItems(nil)
+	assert.NoError(t, p.err)
+	assert.Equal(t, 1.5, p.Attributes["ratio"].Value)
+	assert.Equal(t, "a-b, c=d", p.Attributes["ratio"].LineComment)
+	assert.Equal(t, true, p.Attributes["enabled"].Value)
+	assert.Equal(t, 8080, p.Attributes["port"].Value)
+	assert.Equal(t, "80 * 101", p.Attributes["port"].LineComment)
+	assert.Equal(t, 8080, p.Attributes["name"].Value)
+}
+
+func TestParseQuotedKeys(t *testing.T) {
+	p := newParser(splitTestInput("\"my key.with/chars\" = 1\nserver {\n    \"listen address\" = \"0.0.0.0:80\"\n    \"a=b\" = true\n}\n"))
+	p.parseItems(nil)
+	assert.NoError(t, p.err)
+	assert.Equal(t, 1, p.Attributes["my key.with/chars"].Value)
+	assert.Equal(t, Range{Start: Position{1, 1}, End: Position{1, 24}}, p.Attributes["my key.with/chars"].Range)
+	assert.Equal(t, "0.0.0.0:80", p.Blocks["server"].Attributes["listen address"].Value)
+	assert.Equal(t, true, p.Blocks["server"].Attributes["a=b"].Value)
+
+	// Quoted keys are told apart from the attributes of nested blocks
+	_, err := DecodeBytes([]byte("\"a.b\" = 1\na {\n    b = 2\n}\n"))
+	assert.NoError(t, err)
+	_, err = DecodeBytes([]byte("\"a b\" = 1\n\"a b\" = 2\n"))
+	assert.EqualError(t, err, `line 2, column 1: attribute "a b" redefined, previously defined at line 1`)
+}
+
+func TestParseDottedKeys(t *testing.T) {
+	p := newParser(splitTestInput(`database {
+    name = "db"
+}
+database.primary.host = "10.0.0.1"
+database.primary.port = 5432
+server {
+    tls.cert = "cert.pem"
+}
+"a.b" = 1
+a."b c" = 2
+host = database.primary.host
+`))
+	p.parseItems(nil)
+	assert.NoError(t, p.err)
+
+	// Dotted keys extend existing blocks and create the missing ones
+	database := p.Blocks["database"]
+	assert.Equal(t, "db", database.Attributes["name"].Value)
+	assert.Equal(t, Range{Start: Position{1, 1}, End: Position{3, 2}}, database.Range)
+	assert.Equal(t, "10.0.0.1", database.Blocks["primary"].Attributes["host"].Value)
+	assert.Equal(t, 5432, database.Blocks["primary"].Attributes["port"].Value)
+	assert.Equal(t, Range{Start: Position{4, 1}, End: Position{4, 35}}, database.Blocks["primary"].Range)
+	assert.Equal(t, "cert.pem", p.Blocks["server"].Blocks["tls"].Attributes["cert"].Value)
+	assert.Equal(t, "10.0.0.1", p.Attributes["host"].Value)
+
+	// Quoted names are never split
+	assert.Equal(t, 1, p.Attributes["a.b"].Value)
+	assert.Equal(t, 2, p.Blocks["a"].Attributes["b c"].Value)
+
+	// Conflicts with explicit blocks, attributes and other dotted keys
+	for src, message := range map[string]string{
+		"db.host = \"x\"\ndb {\n    port = 1\n}\n": "line 2, column 1: block db redefined, previously defined at line 1",
+		"db = 1\ndb.host = \"x\"\n":                "line 2, column 1: block db redefined, previously defined at line 1",
+		"db.host = \"x\"\ndb.host = \"y\"\n":       "line 2, column 1: attribute db.host redefined, previously defined at line 1",
+		"db..host = \"x\"\n":                       "line 1, column 1: invalid key db..host",
+	} {
+		_, err := DecodeBytes([]byte(src))
+		assert.EqualError(t, err, message, src)
+	}
+}
+
+func TestParseAssignmentOperators(t *testing.T) {
+	p := newParser(splitTestInput(`tags = ["web"]
+tags += ["api", "grpc"]
+tags += "admin"
+port ?= 8080
+port = 443
+host = "localhost"
+host ?= "0.0.0.0"
+server {
+    workers ?= 4
+    replicas += 2
+}
+server.workers ?= 8
+`))
+	p.parseItems(nil)
+	assert.NoError(t, p.err)
+
+	assert.Equal(t, []interface{}{"web", "api", "grpc", "admin"}, p.Attributes["tags"].Value)
+	assert.Equal(t, Range{Start: Position{1, 1}, End: Position{1, 15}}, p.Attributes["tags"].Range)
+
+	// Defaults are replaced by other definitions, and don't replace them
+	assert.Equal(t, 443, p.Attributes["port"].Value)
+	assert.Equal(t, "localhost", p.Attributes["host"].Value)
+	assert.Equal(t, 4, p.Blocks["server"].Attributes["workers"].Value)
+
+	// Appending to nothing makes an array
+	assert.Equal(t, []interface{}{2}, p.Blocks["server"].Attributes["replicas"].Value)
+
+	for src, message := range map[string]string{
+		"port = 80\nport += 1\n":           "line 2, column 1: attribute port is not an array, it can't be appended to",
+		"let tags += [\"a\"]\n":            "line 1, column 1: local variables can't be assigned with +=",
+		"tags = [\"a\"]\ntags = [\"b\"]\n": "line 2, column 1: attribute tags redefined, previously defined at line 1",
+	} {
+		_, err := decodeBytesSafely([]byte(src))
+		assert.ErrorContains(t, err, message, src)
+	}
+}
+
+func TestParseArrayBlocks(t *testing.T) {
+	p := newParser(splitTestInput(`port = 80
+endpoints = [
+    { host = "a", port = port }, // first
+    {
+        host = upper("b")
+        tls {
+            enabled = true
+        }
+        tags = ["x", "y"]
+    },
+    { host = "c", backups = [{ host = "d" }] }
+]
+after = 1
+`))
+	p.parseItems(nil)
+	assert.NoError(t, p.err)
+
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"host": "a", "port": 80},
+		map[string]interface{}{"host": "B", "tls": map[string]interface{}{"enabled": true}, "tags": []interface{}{"x", "y"}},
+		map[string]interface{}{"host": "c", "backups": []interface{}{map[string]interface{}{"host": "d"}}},
+	}, p.Attributes["endpoints"].Value)
+	assert.Equal(t, Range{Start: Position{2, 1}, End: Position{12, 2}}, p.Attributes["endpoints"].Range)
+	assert.Equal(t, 1, p.Attributes["after"].Value)
+
+	// The blocks of the elements are gone once parsed
+	assert.Empty(t, p.Blocks)
+	defined := []string{}
+	for key := range p.definitions {
+		defined = append(defined, key.String())
+	}
+	sort.Strings(defined)
+	assert.Equal(t, []string{"after", "endpoints", "port"}, defined)
+
+	type endpoint struct {
+		Host string `cafe:"host"`
 	}
+	var config struct {
+		Endpoints []endpoint `cafe:"endpoints"`
+	}
+	assert.NoError(t, p.Unmarshal(&config))
+	assert.Equal(t, []endpoint{{"a"}, {"B"}, {"c"}}, config.Endpoints)
+
+	_, err := DecodeBytes([]byte("endpoints = [{ host = \"a\", host = \"b\" }]\n"))
+	assert.EqualError(t, err, `line 1, column 28: attribute "endpoints[0]".host redefined, previously defined at line 1`)
+	_, err = decodeBytesSafely([]byte("endpoints = [{ host = \"a\" ]\n"))
+	assert.ErrorIs(t, err, ErrUnclosedBlock)
+}
+
+func TestParseInlineBlocks(t *testing.T) {
+	p, err := DecodeBytes([]byte(`port = 80
+server { host = "0.0.0.0", port = port, tls { cert = "cert.pem", key = "key.pem" } } // web
+empty {}
+after = 1
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"host": "0.0.0.0",
+		"port": 80,
+		"tls":  map[string]interface{}{"cert": "cert.pem", "key": "key.pem"},
+	}, bodyToJSON(p.Blocks["server"].Attributes, p.Blocks["server"].Blocks))
+	assert.Equal(t, Range{Start: Position{2, 1}, End: Position{2, 85}}, p.Blocks["server"].Range)
+	assert.Empty(t, p.Blocks["empty"].Attributes)
+	assert.Equal(t, 1, p.Attributes["after"].Value)
+
+	_, err = DecodeBytes([]byte("server { host = \"a\", host = \"b\" }\n"))
+	assert.EqualError(t, err, "line 1, column 22: attribute server.host redefined, previously defined at line 1")
+}
+
+func TestParseTemplates(t *testing.T) {
+	p := newParser(splitTestInput(`template service(name, port) {
+    host = name
+    port = port
+    tls {
+        name = upper(name)
+    }
+}
+web {
+    use service("web", 443)
+    replicas = 2
+}
+db {
+    use service("db", 5432)
+}
+`))
+	p.parseItems(nil)
+	assert.NoError(t, p.err)
+
+	// Templates define nothing until they're used
+	assert.Len(t, p.Blocks, 2)
+	assert.Equal(t, "web", p.Blocks["web"].Attributes["host"].Value)
+	assert.Equal(t, 443, p.Blocks["web"].Attributes["port"].Value)
+	assert.Equal(t, "WEB", p.Blocks["web"].Blocks["tls"].Attributes["name"].Value)
+	assert.Equal(t, 2, p.Blocks["web"].Attributes["replicas"].Value)
+	assert.Equal(t, "db", p.Blocks["db"].Attributes["host"].Value)
+	assert.Equal(t, 5432, p.Blocks["db"].Attributes["port"].Value)
+	assert.Equal(t, "DB", p.Blocks["db"].Blocks["tls"].Attributes["name"].Value)
+
+	for src, message := range map[string]string{
+		"web {\n    use service()\n}\n":                                    "line 2, column 5: template service is not defined",
+		"template a(x) {\n    y = x\n}\nb {\n    use a()\n}\n":             "line 5, column 5: template a takes 1 parameters, got 0",
+		"template a(x) {\n    use a(x)\n}\nb {\n    use a(1)\n}\n":         "line 2, column 5: template a uses itself",
+		"template a(x, x) {\n}\n":                                          "line 1, column 1: invalid parameter \"x\" of template a",
+		"b {\n    template a(x) {\n    }\n}\n":                             "line 2, column 5: template a must be defined outside of blocks",
+		"template a(x) {\n    y = x\n}\nb {\n    use a(1)\n    y = 2\n}\n": "line 6, column 5: attribute b.y redefined, previously defined at line 2",
+	} {
+		_, err := decodeBytesSafely([]byte(src))
+		assert.ErrorContains(t, err, message, src)
+	}
+
+	_, err := DecodeBytes([]byte("template a() {\n}\ntemplate a() {\n}\n"))
+	assert.EqualError(t, err, "line 3, column 1: template a redefined")
+}
+
+func TestParseForBlocks(t *testing.T) {
+	p := newParser(splitTestInput(`regions = ["us", "eu"]
+servers {
+    for region in regions {
+        block region {
+            name = upper(region)
+            for zone in [1, 2] {
+                block zone {
+                    region = region
+                }
+            }
+        }
+    }
+    default = "us"
+}
+`))
+	p.parseItems(nil)
+	assert.NoError(t, p.err)
+
+	// A block for every element, named by it
+	servers := p.Blocks["servers"]
+	assert.Len(t, servers.Blocks, 2)
+	assert.Equal(t, "US", servers.Blocks["us"].Attributes["name"].Value)
+	assert.Equal(t, "EU", servers.Blocks["eu"].Attributes["name"].Value)
+	assert.Equal(t, "eu", servers.Blocks["eu"].Blocks["2"].Attributes["region"].Value)
+	assert.Equal(t, "us", servers.Attributes["default"].Value)
+	assert.Equal(t, []string{"1", "2"}, sortedKeys(servers.Blocks["us"].Blocks))
+
+	for src, message := range map[string]string{
+		"x = 1\nfor r in x {\n}\n":                             "line 2, column 1: for blocks iterate over arrays, got x",
+		"for r in [[1]] {\n    block r {\n    }\n}\n":          "line 2, column 5: block names are strings or numbers, got r",
+		"for r in [\"a\", \"a\"] {\n    block r {\n    }\n}\n": "line 2, column 5: block a redefined, previously defined at line 2",
+		"for r in [\"a\", \"b\"] {\n    x = r\n}\n":            "line 2, column 5: attribute x redefined, previously defined at line 2",
+		"for r in [\"a\"] {\n":                                 "line 1, column 1: for r is not closed",
+	} {
+		_, err := decodeBytesSafely([]byte(src))
+		assert.ErrorContains(t, err, message, src)
+	}
+}
+
+func TestParseDocs(t *testing.T) {
+	input, err := readCAFEFile("./test_data/test-docs.cafe")
+	assert.NoError(t, err)
+
+	p := newParser(input)
+	p.parseItems(nil)
+	assert.NoError(t, p.err)
+
+	assert.Equal(t, "Name of the application\nShown in the dashboard", p.Attributes["name"].Doc)
+	assert.Equal(t, "", p.Attributes["port"].Doc)
+	assert.Equal(t, "", p.Attributes["timeout"].Doc)
+	assert.Equal(t, "Database connection", p.Blocks["database"].Doc)
+	assert.Equal(t, "Host of the primary server", p.Blocks["database"].Attributes["host"].Doc)
+	assert.Equal(t, "", p.Blocks["database"].Attributes["user"].Doc)
+}
+
+func TestParseLocals(t *testing.T) {
+	p, err := Decode("./test_data/test-locals.cafe")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "cafe-app", p.Attributes["name"].Value)
+	assert.Equal(t, "localhost:8080", p.Blocks["server"].Attributes["address"].Value)
+	assert.Equal(t, "cafelocalhost", p.Blocks["server"].Blocks["nested"].Attributes["url"].Value)
+	assert.Equal(t, "example.com", p.Blocks["client"].Attributes["address"].Value)
+	assert.Equal(t, "barista@db.local", p.Blocks["database"].Attributes["dsn"].Value)
+
+	// Locals aren't part of the parsed file
+	data, err := p.ToJSON()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"name": "cafe-app",
+		"server": {"address": "localhost:8080", "nested": {"url": "cafelocalhost"}},
+		"client": {"address": "example.com"},
+		"database": {"dsn": "barista@db.local"}
+	}`, string(data))
+
+	// Locals can't be seen from outside their block
+	assert.Panics(t, func() {
+		newParser(splitTestInput("server {\n    let host = \"localhost\"\n}\naddress = host\n")).parseItems(nil)
+	})
+	assert.Panics(t, func() {
+		newParser(splitTestInput("server {\n    let host = \"localhost\"\n}\naddress = server.host\n")).parseItems(nil)
+	})
+
+	// Nor share their name with an attribute of their block
+	_, err = DecodeBytes([]byte("let port = 80\nport = 8080\n"))
+	assert.EqualError(t, err, "line 2, column 1: attribute port redefined, previously defined at line 1")
+	_, err = DecodeBytes([]byte("vars {\n    port = 80\n}\nport = 8080\n"))
+	assert.EqualError(t, err, "line 4, column 1: attribute port redefined, previously defined at line 2")
+
+	// Vars blocks only hold attributes
+	assert.Panics(t, func() {
+		newParser(splitTestInput("vars {\n    nested {\n    }\n}\n")).parseItems(nil)
+	})
+}
+
+func TestParseRedefinitions(t *testing.T) {
+	_, err := Decode("./test_data/test-redefinition.cafe")
+	var redefinition *RedefinitionError
+	assert.ErrorAs(t, err, &redefinition)
+	assert.Equal(t, &RedefinitionError{
+		File:         "./test_data/test-redefinition.cafe",
+		Kind:         "attribute",
+		Name:         "server.port",
+		Line:         4,
+		Column:       5,
+		PreviousLine: 3,
+	}, redefinition)
+	assert.EqualError(t, err, "./test_data/test-redefinition.cafe: line 4, column 5: attribute server.port redefined, previously defined at line 3")
+
+	_, err = DecodeBytes([]byte("server {\n}\nserver {\n}\n"))
+	assert.EqualError(t, err, "line 3, column 1: block server redefined, previously defined at line 1")
+
+	// Repeated attributes can be accepted instead
+	src := []byte("port = 80\nport = 443\nserver {\n    host = \"a\"\n    host = \"b\"\n}\n")
+	p, err := DecodeBytes(src, WithDuplicates(DuplicateKeepFirst))
+	assert.NoError(t, err)
+	assert.Equal(t, 80, p.Attributes["port"].Value)
+	assert.Equal(t, "a", p.Blocks["server"].Attributes["host"].Value)
+
+	p, err = DecodeBytes(src, WithDuplicates(DuplicateKeepLast))
+	assert.NoError(t, err)
+	assert.Equal(t, 443, p.Attributes["port"].Value)
+	assert.Equal(t, "b", p.Blocks["server"].Attributes["host"].Value)
+	assert.Equal(t, 2, p.definitionLine(nil, "port"))
+
+	_, err = DecodeBytes([]byte("server {\n}\nserver {\n}\n"), WithDuplicates(DuplicateKeepLast))
+	assert.Error(t, err)
+
+	// Overlays can shadow the definitions of lower layers
+	parseOverlay := func(overlaySrc string) *Parser {
+		base := newParser(splitTestInput("port = 1\nserver {\n    host = \"a\"\n}\n"))
+		base.parseItems(nil)
+		assert.NoError(t, base.err)
+
+		overlay := newParser(splitTestInput(overlaySrc))
+		overlay.Attributes, overlay.Blocks, overlay.definitions = base.Attributes, base.Blocks, base.definitions
+		overlay.layer = 1
+		overlay.parseItems(nil)
+		return overlay
+	}
+
+	overlay := parseOverlay("port = 2\nserver {\n    tls = true\n}\n")
+	assert.NoError(t, overlay.err)
+	assert.Equal(t, 2, overlay.Attributes["port"].Value)
+	assert.Equal(t, "a", overlay.Blocks["server"].Attributes["host"].Value)
+	assert.Equal(t, true, overlay.Blocks["server"].Attributes["tls"].Value)
+
+	// But not their own
+	overlay = parseOverlay("port = 2\nport = 3\n")
+	assert.EqualError(t, overlay.err, "line 2, column 1: attribute port redefined, previously defined at line 1")
+}
+
+func TestParseRanges(t *testing.T) {
+	src := `name = "cafe"
+server {
+    ports = [
+        80,
+        443
+    ]
+    tls {
+        enabled = true
+    }
+}
+
+profile "prod" {
+    name = "prod"
+    server {
+        tls {
+            enabled = false
+        }
+    }
+}
+`
+	p, err := DecodeBytes([]byte(src), WithProfile("prod"))
+	assert.NoError(t, err)
+
+	// Attributes span from their name to the end of their value
+	assert.Equal(t, Range{Start: Position{13, 5}, End: Position{13, 18}}, p.Attributes["name"].Range)
+	assert.Equal(t, Range{Start: Position{3, 5}, End: Position{6, 6}}, p.Blocks["server"].Attributes["ports"].Range)
+	assert.Equal(t, Range{Start: Position{16, 13}, End: Position{16, 28}}, p.Blocks["server"].Blocks["tls"].Attributes["enabled"].Range)
+
+	// Blocks span from their name to their closing brace, where they were
+	// first defined
+	assert.Equal(t, Range{Start: Position{2, 1}, End: Position{10, 2}}, p.Blocks["server"].Range)
+	assert.Equal(t, Range{Start: Position{7, 5}, End: Position{9, 6}}, p.Blocks["server"].Blocks["tls"].Range)
+}
+
+func TestParseError(t *testing.T) {
+	_, err := DecodeBytes([]byte("server {\n\tport = 80\n\tport = 443\n}\n"))
+	var parseErr *ParseError
+	assert.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, 3, parseErr.Line)
+	assert.Equal(t, 2, parseErr.Col)
+	assert.Equal(t, 4, parseErr.Length)
+	assert.Equal(t, "attribute server.port redefined, previously defined at line 2", parseErr.Message)
+	assert.Equal(t, "\tport = 443", parseErr.Snippet)
+	assert.Equal(t, "line 3, column 2: attribute server.port redefined, previously defined at line 2\n"+
+		"\tport = 443\n"+
+		"\t^^^^", parseErr.Annotated())
+
+	// Expressions that can't be evaluated and errors of the lexer are
+	// positioned too
+	_, err = Decode("./test_data/test-panic.cafe")
+	assert.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, "./test_data/test-panic.cafe", parseErr.File)
+	assert.Equal(t, 1, parseErr.Line)
+	assert.Equal(t, "gate = and(1, 2)", parseErr.Snippet)
+
+	_, err = DecodeBytes([]byte("a = \"x\" \\\n"))
+	assert.EqualError(t, err, `line 1, column 5: multiline string is not closed`)
+}
+
+func TestParseErrorKinds(t *testing.T) {
+	failing := WithFunctions(map[string]Function{
+		"failing": func(args []interface{}) (interface{}, error) {
+			return nil, fs.ErrPermission
+		},
+	})
+
+	tests := []struct {
+		src  string
+		opts []Option
+		kind error
+	}{
+		{"a = \"x\n", nil, ErrUnclosedString},
+		{"a = \"x\" \\\n", nil, ErrUnclosedString},
+		{"server {\n    a = 1\n", nil, ErrUnclosedBlock},
+		{"profile \"prod\" {\n", nil, ErrUnclosedBlock},
+		{"template a(x) {\n", nil, ErrUnclosedBlock},
+		{"a {\n    use b(1)\n}\n", nil, ErrUndefined},
+		{"a = [1,\n    2\n", nil, ErrUnclosedArray},
+		{"/* a\nb = 1\n", nil, ErrUnclosedComment},
+		{"}\nb = 1\n", nil, ErrUnexpectedToken},
+		{"a = 1\n%\n", []Option{WithStrictMode()}, ErrUnexpectedToken},
+		{"a = foo(1)\n", nil, ErrUnknownFunction},
+		{"a = b\n", nil, ErrUndefined},
+		{"a = and(1, 2)\n", nil, ErrTypeMismatch},
+		{"a = tonumber(\"eighty\")\n", nil, ErrTypeMismatch},
+		{"a = sqrt(-1)\n", nil, ErrInvalidArgument},
+		{"a = base64encode(\"a\", \"b\")\n", nil, ErrInvalidArgument},
+		{"a = env(\"HOME\")\n", []Option{WithoutEnv()}, ErrFunctionNotAllowed},
+		{"a = 1\na = 2\n", nil, ErrRedefined},
+		{"if = 1\n", nil, ErrReservedName},
+		{"a {\n    b {\n    }\n}\n", []Option{WithMaxDepth(1)}, ErrMaxDepth},
+		{"a = failing()\n", []Option{failing}, fs.ErrPermission},
+	}
+	for _, test := range tests {
+		_, err := decodeBytesSafely([]byte(test.src), test.opts...)
+		assert.ErrorIs(t, err, test.kind, test.src)
+		var parseErr *ParseError
+		assert.ErrorAs(t, err, &parseErr, test.src)
+	}
+
+	_, err := Decode("./test_data/include/cycle-a.cafe")
+	assert.ErrorIs(t, err, ErrCircularInclude)
+}
+
+func TestParseErrors(t *testing.T) {
+	// Every error is reported, not only the first one
+	src := "port = 80\nport = 443\nserver {\n    if = true\n}\nserver {\n    host = \"a\"\n}\nname = \"cafe\"\nname = \"web\"\n"
+	_, err := DecodeBytes([]byte(src))
+	assert.EqualError(t, err, "line 2, column 1: attribute port redefined, previously defined at line 1\n"+
+		`line 4, column 5: attribute server.if uses the reserved word "if" as its name`+"\n"+
+		"line 6, column 1: block server redefined, previously defined at line 3\n"+
+		"line 10, column 1: attribute name redefined, previously defined at line 9")
+
+	var redefinition *RedefinitionError
+	assert.ErrorAs(t, err, &redefinition)
+	assert.Equal(t, "port", redefinition.Name)
+
+	_, err = DecodeBytes([]byte("a = 1\n%\nb = 2\n?\n"), WithStrictMode())
+	assert.EqualError(t, err, "line 2, column 1: unexpected \"%\"\nline 4, column 1: unexpected \"?\"")
+}
+
+func TestParseOnlyBlocks(t *testing.T) {
+	src := `name = "cafe"
+database {
+    host = "localhost"
+    replica {
+        host = "replica"
+    }
+}
+legacy {
+    url = missing
+}
+api {
+    host = database.host
+}
+`
+	p, err := decodeBytesSafely([]byte(src), WithOnlyBlocks("database"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name":     "cafe",
+		"database": map[string]interface{}{"host": "localhost", "replica": map[string]interface{}{"host": "replica"}},
+	}, p.ToMap())
+
+	// Skipped blocks can't be referenced
+	_, err = decodeBytesSafely([]byte(src), WithOnlyBlocks("api"))
+	assert.ErrorIs(t, err, ErrUndefined)
+
+	// Blocks of included files are only skipped at the top level
+	p, err = Decode("./test_data/include/main.cafe", WithOnlyBlocks("server"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"prefix", "name", "server"}, p.Names(""))
+	assert.Equal(t, []string{"host", "tls", "port"}, p.Names("server"))
 }
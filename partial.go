@@ -0,0 +1,110 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"bytes"
+)
+
+// DecodePartial decodes as much of a document as it can, for editors and
+// language servers working on documents as they're typed
+// A statement that can't be decoded is skipped up to the next line, or
+// past its body if it's a block, and its error is recorded before going on
+// with the next one
+// It returns the partial document along with its errors, in the order they
+// were found, nil if there are none
+// The lexer stops at the first string or comment that isn't closed, so the
+// document is decoded up to it and its error is the last one
+func DecodePartial(src []byte, opts ...Option) (*Parser, []error) {
+	o := newOptions(opts)
+	o.partial = true
+	input, err := readRunes(bytes.NewReader(src))
+	if err != nil {
+		return newParserWithOptions(nil, o), []error{err}
+	}
+
+	var lexErr error
+	lx := &lexer{}
+	if len(input) > 0 {
+		lx = newLexerWithOptions(input, o)
+		lexErr = recoverParseError(func() {
+			lx.lexInput(o.logger)
+		})
+		lx.input = nil
+	}
+	p := newParserFromLexer(lx, o)
+	p.source = append([]byte{}, src...)
+	if err := recoverParseError(func() { p.parseItems(o.logger) }); err != nil {
+		p.fail(err)
+	}
+	if lexErr != nil {
+		p.fail(lexErr)
+	}
+	return p, p.errs
+}
+
+// Parses the statement at the current item
+// With DecodePartial, a statement that can't be decoded is skipped and
+// its error recorded instead of stopping the parsing
+func (p *Parser) parseStatement(log Logger) {
+	if !p.opts.partial {
+		p.parseItem(log)
+		return
+	}
+
+	start, depth := p.currentItemIndex, len(p.currentBlocks)
+	err := recoverParseError(func() {
+		defer p.positionPanic()
+		p.parseItem(log)
+	})
+	if err == nil {
+		return
+	}
+	p.fail(err)
+	p.currentBlocks = p.currentBlocks[:depth]
+	if p.lx.items[start].kind == keyBlockStart {
+		p.currentItemIndex, p.currentItem = start, p.lx.items[start]
+		p.skipBlock()
+		return
+	}
+	p.skipStatement(start)
+}
+
+// Moves the Parser to the first statement after the one starting at an
+// index, in a line of its own or closing a block
+func (p *Parser) skipStatement(start int) {
+	line := p.lx.items[start].position.Line
+	end := start + 1
+	if end < p.currentItemIndex {
+		end = p.currentItemIndex
+	}
+	for ; p.hasItem(end); end++ {
+		it := p.lx.items[end]
+		if it.kind == keyBlockEnd || it.position.Line > line && startsItemStatement(it.kind) {
+			break
+		}
+	}
+	p.nextItem(end - p.currentItemIndex)
+}
+
+// Reports if items of a kind start statements
+func startsItemStatement(kind keyKind) bool {
+	switch kind {
+	case keyAttrDef, keyBlockStart, keyBlockEnd, keyComment, keyInclude, keyUse, keyEOF:
+		return true
+	default:
+		return false
+	}
+}
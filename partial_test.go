@@ -0,0 +1,74 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodePartial(t *testing.T) {
+	src := []byte(`name = "cafe"
+bad = upper(1, 2, 3)
+port = 80
+server {
+    host = missing
+    tls = true
+}
+server {
+    port = 81
+}
+after = lower(name)
+`)
+	p, errs := DecodePartial(src)
+	if assert.Len(t, errs, 3) {
+		assert.ErrorIs(t, errs[0], ErrInvalidArgument)
+		assert.ErrorIs(t, errs[1], ErrUndefined)
+		assert.ErrorIs(t, errs[2], ErrRedefined)
+		var parseErr *ParseError
+		if assert.True(t, errors.As(errs[1], &parseErr)) {
+			assert.Equal(t, 5, parseErr.Line)
+		}
+	}
+	assert.Equal(t, []string{"after", "name", "port"}, sortedKeys(p.Attributes))
+	assert.Equal(t, "cafe", p.Attributes["after"].Value)
+	assert.Equal(t, []string{"tls"}, sortedKeys(p.Blocks["server"].Attributes))
+
+	// Documents the lexer can't go through are decoded up to the error
+	p, errs = DecodePartial([]byte("a = 1\nb = [1, 2\nc = \"unclosed\nd = 3\n/* comment\ne = 4\n"))
+	if assert.Len(t, errs, 3) {
+		assert.ErrorIs(t, errs[0], ErrUnclosedArray)
+		assert.ErrorIs(t, errs[1], ErrUnclosedString)
+		assert.ErrorIs(t, errs[2], ErrUnclosedComment)
+	}
+	assert.Equal(t, []string{"a", "d"}, sortedKeys(p.Attributes))
+
+	// Blocks that aren't closed keep what's defined in them
+	p, errs = DecodePartial([]byte("server {\n    port = 80\n"))
+	if assert.Len(t, errs, 1) {
+		assert.ErrorIs(t, errs[0], ErrUnclosedBlock)
+	}
+	assert.Equal(t, 80, p.Blocks["server"].Attributes["port"].Value)
+
+	// Valid documents decode like with DecodeBytes
+	p, errs = DecodePartial([]byte("name = \"cafe\"\n"))
+	assert.Nil(t, errs)
+	assert.Equal(t, "cafe", p.Attributes["name"].Value)
+	p, errs = DecodePartial(nil)
+	assert.Nil(t, errs)
+	assert.Empty(t, p.Attributes)
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Number of batches of items the lexer can send ahead of the parser
+const pipelineBuffer = 4
+
+// Returned by the lexer of a pipeline whose parser stopped
+var errPipelineStopped = errors.New("pipeline stopped")
+
+// A batch of items lexed from a reader, or what stopped the lexing
+type lexBatch struct {
+	// Items of whole statements, with their positions in the whole input
+	items []item
+
+	// Lines the items were lexed from, without their EOL
+	lines []string
+
+	// Error reading the input
+	err error
+
+	// What the lexer panicked with
+	failure interface{}
+}
+
+// The parser of a pipeline panics with a readError when the input couldn't
+// be read, so it stops parsing the items read so far
+type readError struct {
+	err error
+}
+
+// Lexes a reader in chunks for a decoding, passing the batches of items to
+// emit as they're lexed
+func lexBatches(r io.Reader, o options, emit func(lexBatch) error) error {
+	var shared interner
+	if o.interning {
+		shared = interner{}
+	}
+	prefixes := functionCallPrefixes(o.functions)
+	setup := func(chunk *lexer) {
+		chunk.strings = shared
+		chunk.callPrefixes = prefixes
+	}
+
+	done := 0
+	err := lexReader(r, readChunkSize, setup, func(items []item, lines []string) error {
+		batch := lexBatch{items: items, lines: make([]string, len(lines))}
+		for i, line := range lines {
+			batch.lines[i] = strings.TrimSuffix(line, "\n")
+			done += utf8.RuneCountInString(line)
+		}
+		if o.progress != nil {
+			o.progress(done, -1)
+		}
+		return emit(batch)
+	}, o.logger)
+	if err != nil {
+		return err
+	}
+	if o.progress != nil {
+		o.progress(done, done)
+	}
+	return nil
+}
+
+// Lexes a reader in a goroutine of its own, sending the batches of items on
+// the returned channel as they're lexed, which is closed at the end of the
+// input
+// The lexing stops early once stop is closed
+func lexPipeline(r io.Reader, o options, stop <-chan struct{}) <-chan lexBatch {
+	batches := make(chan lexBatch, pipelineBuffer)
+	send := func(batch lexBatch) error {
+		select {
+		case batches <- batch:
+			return nil
+		case <-stop:
+			return errPipelineStopped
+		}
+	}
+
+	go func() {
+		defer close(batches)
+		defer func() {
+			if r := recover(); r != nil {
+				_ = send(lexBatch{failure: r})
+			}
+		}()
+		if err := lexBatches(r, o, send); err != nil && !errors.Is(err, errPipelineStopped) {
+			_ = send(lexBatch{err: err})
+		}
+	}()
+	return batches
+}
@@ -0,0 +1,75 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Reader failing after its contents
+type failingReader struct {
+	r io.Reader
+}
+
+func (f *failingReader) Read(b []byte) (int, error) {
+	n, err := f.r.Read(b)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func TestDecodeReaderPipeline(t *testing.T) {
+	// Profiles and blocks spanning many batches
+	var sb strings.Builder
+	sb.Write(generateServers(500))
+	sb.WriteString("profile \"prod\" {\n")
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&sb, "    setting_%d = %d\n", i, i)
+	}
+	sb.WriteString("}\n")
+	src := []byte(sb.String())
+
+	expected, err := DecodeBytes(src, WithProfile("prod"))
+	assert.NoError(t, err)
+	p, err := DecodeReader(bytes.NewReader(src), WithProfile("prod"), WithPipeline())
+	assert.NoError(t, err)
+	assert.Equal(t, expected.Attributes, p.Attributes)
+	assert.Equal(t, expected.Blocks, p.Blocks)
+	assert.Equal(t, 1999, p.Attributes["setting_1999"].Value)
+
+	// Errors of the parser
+	_, err = DecodeReader(bytes.NewReader(append(generateServers(500), "port = 1\nport = 2\n"...)), WithPipeline())
+	assert.ErrorIs(t, err, ErrRedefined)
+
+	// Errors reading the input
+	_, err = DecodeReader(&failingReader{r: bytes.NewReader(src)}, WithPipeline())
+	assert.EqualError(t, err, "connection reset")
+
+	// Errors of the lexer
+	_, err = DecodeReader(strings.NewReader("a = 1\nb = 2\nc = 3\nd = \"x\" \\\n"), WithPipeline())
+	assert.EqualError(t, err, "line 4, column 5: multiline string is not closed")
+
+	p, err = DecodeReader(strings.NewReader(""), WithPipeline())
+	assert.NoError(t, err)
+	assert.Empty(t, p.Attributes)
+}
@@ -0,0 +1,359 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// TableQuery selects attributes from the blocks of a parsed document, like
+//
+//	select name, port from block server where port > 1000
+//
+// It can be parsed from that syntax with ParseTableQuery or built with
+// NewTableQuery
+// The blocks are selected like the path queries of Parser.Query
+type TableQuery struct {
+	// Attributes to select, empty to select every attribute
+	columns []string
+
+	// Steps selecting the blocks to select from
+	from []pathStep
+
+	// Conditions the selected blocks must match
+	// Every condition of a group must match, and at least one group must match
+	where pathPredicate
+}
+
+// QueryResult holds the rows selected by a query
+type QueryResult struct {
+	// Names of the selected attributes
+	Columns []string
+
+	// Selected blocks, sorted by their paths
+	Rows []QueryRow
+}
+
+// QueryRow is a block selected by a query
+type QueryRow struct {
+	// Path of the block, as in "block.nested.name"
+	Path string
+
+	// Values of the selected attributes, nil for missing attributes
+	Values []interface{}
+}
+
+// Operators of the query conditions
+var queryOperators = []string{"==", "!=", ">=", "<=", ">", "<"}
+
+// NewTableQuery creates a query that selects the given attributes
+// Selecting no attributes selects every attribute of the blocks
+func NewTableQuery(columns ...string) *TableQuery {
+	return &TableQuery{columns: columns}
+}
+
+// From sets the path of the blocks to select
+// Blocks are matched at any depth, and a "*" matches any block name, so
+// "servers.*" selects every block nested in a block named servers
+func (q *TableQuery) From(path string) *TableQuery {
+	q.from = literalSteps(splitPath(path))
+	q.from[0].recursive = true
+	return q
+}
+
+// Where adds a condition every selected block must match
+// Conditions added after Or form a new alternative
+func (q *TableQuery) Where(attribute string, operator string, value interface{}) *TableQuery {
+	if len(q.where) == 0 {
+		q.where = append(q.where, nil)
+	}
+	last := len(q.where) - 1
+	q.where[last] = append(q.where[last], pathCondition{path: strings.Split(attribute, "."), operator: operator, value: value})
+	return q
+}
+
+// Or starts a new alternative of conditions
+func (q *TableQuery) Or() *TableQuery {
+	q.where = append(q.where, nil)
+	return q
+}
+
+// ParseTableQuery parses a query like
+//
+//	select name, port from block server where port > 1000 and enabled == true
+//
+// The columns can be "*" to select every attribute, and conditions are
+// joined by "and", which takes precedence over "or"
+func ParseTableQuery(src string) (*TableQuery, error) {
+	tokens, err := tokenizeQuery(src)
+	if err != nil {
+		return nil, err
+	}
+
+	q := NewTableQuery()
+	pos := 0
+	expect := func(word string) error {
+		if pos >= len(tokens) || !strings.EqualFold(tokens[pos], word) {
+			return fmt.Errorf("query: expected %q at token %d", word, pos+1)
+		}
+		pos++
+		return nil
+	}
+
+	if err := expect("select"); err != nil {
+		return nil, err
+	}
+	if pos < len(tokens) && tokens[pos] == "*" {
+		pos++
+	} else {
+		for {
+			if pos >= len(tokens) || !isQueryIdentifier(tokens[pos]) {
+				return nil, fmt.Errorf("query: expected an attribute name at token %d", pos+1)
+			}
+			q.columns = append(q.columns, tokens[pos])
+			pos++
+			if pos >= len(tokens) || tokens[pos] != "," {
+				break
+			}
+			pos++
+		}
+	}
+
+	if err := expect("from"); err != nil {
+		return nil, err
+	}
+	if pos < len(tokens) && strings.EqualFold(tokens[pos], "block") {
+		pos++
+	}
+	if pos >= len(tokens) || !isQueryIdentifier(tokens[pos]) {
+		return nil, fmt.Errorf("query: expected a block name at token %d", pos+1)
+	}
+	q.From(tokens[pos])
+	pos++
+
+	if pos == len(tokens) {
+		return q, nil
+	}
+	if err := expect("where"); err != nil {
+		return nil, err
+	}
+	for {
+		if pos+3 > len(tokens) {
+			return nil, fmt.Errorf("query: incomplete condition at token %d", pos+1)
+		}
+		attribute, operator, literal := tokens[pos], tokens[pos+1], tokens[pos+2]
+		if !isQueryIdentifier(attribute) {
+			return nil, fmt.Errorf("query: expected an attribute name at token %d", pos+1)
+		}
+		if !equalsToMany(operator, queryOperators) {
+			return nil, fmt.Errorf("query: unknown operator %q", operator)
+		}
+		value, err := parseQueryLiteral(literal)
+		if err != nil {
+			return nil, err
+		}
+		q.Where(attribute, operator, value)
+		pos += 3
+
+		if pos == len(tokens) {
+			return q, nil
+		}
+		switch strings.ToLower(tokens[pos]) {
+		case "and":
+		case "or":
+			q.Or()
+		default:
+			return nil, fmt.Errorf("query: expected \"and\" or \"or\" at token %d", pos+1)
+		}
+		pos++
+	}
+}
+
+// Splits a query into words, quoted strings, commas and operators
+func tokenizeQuery(src string) ([]string, error) {
+	tokens := []string{}
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == ',':
+			tokens = append(tokens, ",")
+			i++
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("query: unterminated string")
+			}
+			tokens = append(tokens, string(runes[i:end+1]))
+			i = end + 1
+		case strings.ContainsRune("=!<>", r):
+			end := i + 1
+			if end < len(runes) && runes[end] == '=' {
+				end++
+			}
+			tokens = append(tokens, string(runes[i:end]))
+			i = end
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune(",\"=!<>", runes[end]) {
+				end++
+			}
+			tokens = append(tokens, string(runes[i:end]))
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+// Reports whether a token can be an attribute or block path
+func isQueryIdentifier(token string) bool {
+	for _, name := range strings.Split(token, ".") {
+		if name != "*" && !identifierRegexp.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// Parses the value of a condition
+func parseQueryLiteral(literal string) (interface{}, error) {
+	if strings.HasPrefix(literal, `"`) {
+		return strings.Trim(literal, `"`), nil
+	}
+	if value, err := strconv.Atoi(literal); err == nil {
+		return value, nil
+	}
+	if value, err := strconv.ParseFloat(literal, 64); err == nil {
+		return value, nil
+	}
+	if value, err := strconv.ParseBool(literal); err == nil {
+		return value, nil
+	}
+	return nil, fmt.Errorf("query: invalid value %s", literal)
+}
+
+// Run executes the query over a parsed document
+func (q *TableQuery) Run(p *Parser) (*QueryResult, error) {
+	if len(q.from) == 0 {
+		return nil, fmt.Errorf("query: no block to select from")
+	}
+	for _, group := range q.where {
+		for _, cond := range group {
+			if !equalsToMany(cond.operator, queryOperators) {
+				return nil, fmt.Errorf("query: unknown operator %q", cond.operator)
+			}
+		}
+	}
+
+	// The conditions filter the blocks of the last step
+	steps := append([]pathStep{}, q.from...)
+	if len(q.where) > 0 {
+		last := &steps[len(steps)-1]
+		last.predicates = append(append([]pathPredicate{}, last.predicates...), q.where)
+	}
+	matches := map[string]pathNode{}
+	for _, node := range p.selectPath(steps) {
+		if node.isBlock {
+			matches[strings.Join(node.path, ".")] = node
+		}
+	}
+	paths := sortedKeys(matches)
+
+	result := &QueryResult{Columns: q.columns}
+	if len(q.columns) == 0 {
+		// Every attribute of the selected blocks
+		columns := map[string]bool{}
+		for _, path := range paths {
+			for name := range matches[path].attributes {
+				columns[name] = true
+			}
+		}
+		result.Columns = sortedKeys(columns)
+	}
+
+	for _, path := range paths {
+		node := matches[path]
+		row := QueryRow{Path: path, Values: make([]interface{}, len(result.Columns))}
+		for i, column := range result.Columns {
+			row.Values[i], _ = lookupPath(node.attributes, node.blocks, strings.Split(column, "."))
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	return result, nil
+}
+
+// Compares an attribute value with the value of a condition
+// Values of different types are only ever different
+func compareQueryValues(value interface{}, operator string, expected interface{}) bool {
+	var cmp int
+	switch val := value.(type) {
+	case int, float64:
+		expectedNumber, isNumber := toFloat(expected)
+		if !isNumber {
+			return operator == "!="
+		}
+		number, _ := toFloat(val)
+		switch {
+		case number < expectedNumber:
+			cmp = -1
+		case number > expectedNumber:
+			cmp = 1
+		}
+	case string:
+		expectedString, isString := expected.(string)
+		if !isString {
+			return operator == "!="
+		}
+		cmp = strings.Compare(val, expectedString)
+	default:
+		equal := fmt.Sprint(value) == fmt.Sprint(expected) && sameQueryKind(value, expected)
+		switch operator {
+		case "==":
+			return equal
+		case "!=":
+			return !equal
+		}
+		return false
+	}
+
+	switch operator {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+// Reports whether two values have the same type
+func sameQueryKind(a interface{}, b interface{}) bool {
+	return fmt.Sprintf("%T", a) == fmt.Sprintf("%T", b)
+}
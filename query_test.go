@@ -0,0 +1,73 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableQuery(t *testing.T) {
+	p, err := Decode("./test_data/test-query.cafe")
+	assert.NoError(t, err)
+
+	q, err := ParseTableQuery(`select name, port from block servers.* where port > 1000 and enabled == true`)
+	assert.NoError(t, err)
+	result, err := q.Run(p)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"name", "port"}, result.Columns)
+	assert.Equal(t, []QueryRow{
+		{Path: "servers.api", Values: []interface{}{"api", 8080}},
+	}, result.Rows)
+
+	// "or" alternatives and every attribute
+	q, err = ParseTableQuery(`SELECT * FROM servers.* WHERE name == "web" OR enabled == false`)
+	assert.NoError(t, err)
+	result, err = q.Run(p)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"enabled", "name", "port"}, result.Columns)
+	assert.Equal(t, []QueryRow{
+		{Path: "servers.legacy", Values: []interface{}{false, "legacy", 3000}},
+		{Path: "servers.web", Values: []interface{}{true, "web", 443}},
+	}, result.Rows)
+
+	// Blocks are matched at any depth
+	result, err = NewTableQuery("name", "missing").From("api").Run(p)
+	assert.NoError(t, err)
+	assert.Equal(t, []QueryRow{
+		{Path: "servers.api", Values: []interface{}{"api", nil}},
+	}, result.Rows)
+
+	result, err = NewTableQuery("name").From("server").Where("port", "<=", 80.0).Run(p)
+	assert.NoError(t, err)
+	assert.Equal(t, []QueryRow{
+		{Path: "server", Values: []interface{}{"main"}},
+	}, result.Rows)
+
+	invalidQueries := []string{
+		`name from server`,
+		`select from server`,
+		`select name server`,
+		`select name from server where port`,
+		`select name from server where port ~ 1`,
+		`select name from server where port > 1 nor port < 2`,
+		`select name from server where name == "unterminated`,
+	}
+	for _, src := range invalidQueries {
+		_, err := ParseTableQuery(src)
+		assert.Error(t, err, src)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// How long fetching a document from a URL takes without WithFetchTimeout
+const defaultFetchTimeout = 30 * time.Second
+
+// Largest document fetched from a URL without WithFetchLimit
+const defaultFetchLimit = 10 << 20
+
+// Remote is a document fetched from an HTTP or HTTPS URL, such as the
+// configuration a central server distributes to many services
+// The last document fetched is kept along with its ETag, so fetching it
+// again only downloads it if it changed
+// It's a Source of a Stack, and it's safe for concurrent use
+type Remote struct {
+	url  string
+	opts []Option
+
+	mu   sync.Mutex
+	etag string
+	body []byte
+}
+
+// RemoteSource is a layer fetched from a URL, decoded with the options
+// along with WithHTTPClient, WithFetchTimeout, WithFetchLimit and
+// WithChecksum
+// Fetched documents can't read the environment or local files, unless
+// decoded with WithLocalAccess, and can't include files by relative paths
+func RemoteSource(rawURL string, opts ...Option) *Remote {
+	return &Remote{url: rawURL, opts: opts}
+}
+
+// DecodeURL fetches a document from an HTTP or HTTPS URL and decodes it,
+// see Remote
+func DecodeURL(rawURL string, opts ...Option) (*Parser, error) {
+	return RemoteSource(rawURL, opts...).Load(newParser(nil, opts...))
+}
+
+// Load fetches the document and decodes it on top of the layers before it
+func (r *Remote) Load(base *Parser) (*Parser, error) {
+	src, err := r.Fetch()
+	if err != nil {
+		return nil, err
+	}
+	input, err := readRunes(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	opts := append([]Option{withRemote()}, r.opts...)
+	if !newOptions(r.opts).localAccess {
+		opts = append(opts, WithoutEnv(), WithoutFiles())
+	}
+	return overlayInput(base, r.url, input, opts)
+}
+
+// Marks the decoded document as fetched from a URL
+func withRemote() Option {
+	return func(o *options) {
+		o.remote = true
+	}
+}
+
+// Fetch returns the content of the document, downloading it only if it
+// changed since it was last fetched
+func (r *Remote) Fetch() ([]byte, error) {
+	u, err := url.Parse(r.url)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("cannot fetch %s, only http and https URLs are supported", r.url)
+	}
+
+	o := newOptions(r.opts)
+	client := o.httpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	timeout := o.fetchTimeout
+	if timeout == 0 {
+		timeout = defaultFetchTimeout
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.etag != "" {
+		req.Header.Set("If-None-Match", r.etag)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && r.body != nil:
+		return r.body, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("cannot fetch %s: %s", r.url, resp.Status)
+	}
+	limit := o.fetchLimit
+	if limit == 0 {
+		limit = defaultFetchLimit
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch %s: %w", r.url, err)
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("cannot fetch %s: the document is larger than %d bytes", r.url, limit)
+	}
+	if o.checksum != "" {
+		sum := sha256.Sum256(body)
+		if hex.EncodeToString(sum[:]) != o.checksum {
+			return nil, fmt.Errorf("%s: SHA-256 is %x, expected %s: %w", r.url, sum, o.checksum, ErrChecksumMismatch)
+		}
+	}
+	r.etag, r.body = resp.Header.Get("ETag"), body
+	return body, nil
+}
@@ -0,0 +1,147 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeURL(t *testing.T) {
+	doc := "name = \"cafe\"\nport = 8080\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/config.cafe" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, doc)
+	}))
+	defer server.Close()
+
+	p, err := DecodeURL(server.URL + "/config.cafe")
+	assert.NoError(t, err)
+	assert.Equal(t, 8080, p.Attributes["port"].Value)
+
+	_, err = DecodeURL(server.URL + "/missing.cafe")
+	assert.ErrorContains(t, err, "404 Not Found")
+	_, err = DecodeURL("file:///etc/config.cafe")
+	assert.ErrorContains(t, err, "only http and https URLs are supported")
+
+	// Checksums
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte(doc)))
+	_, err = DecodeURL(server.URL+"/config.cafe", WithChecksum(sum))
+	assert.NoError(t, err)
+	_, err = DecodeURL(server.URL+"/config.cafe", WithChecksum(fmt.Sprintf("%x", sha256.Sum256(nil))))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+}
+
+func TestRemoteLocalAccess(t *testing.T) {
+	local, err := filepath.Abs("test_data/files/greeting.txt")
+	assert.NoError(t, err)
+	docs := map[string]string{
+		"/env.cafe":      "home = env(\"HOME\")\n",
+		"/file.cafe":     fmt.Sprintf("hello = file(%q)\n", local),
+		"/relative.cafe": "hello = file(\"hello.txt\")\n",
+		"/include.cafe":  "include \"inc.cafe\"\n",
+		"/large.cafe":    "name = \"" + strings.Repeat("x", 100) + "\"\n",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, docs[r.URL.Path])
+	}))
+	defer server.Close()
+
+	// Fetched documents can't read the local host by default
+	_, err = DecodeURL(server.URL + "/env.cafe")
+	assert.ErrorIs(t, err, ErrFunctionNotAllowed)
+	_, err = DecodeURL(server.URL + "/file.cafe")
+	assert.ErrorIs(t, err, ErrFunctionNotAllowed)
+	_, err = DecodeURL(server.URL + "/include.cafe")
+	assert.ErrorIs(t, err, ErrFunctionNotAllowed)
+
+	p, err := DecodeURL(server.URL+"/env.cafe", WithLocalAccess())
+	assert.NoError(t, err)
+	assert.Equal(t, os.Getenv("HOME"), p.Attributes["home"].Value)
+	p, err = DecodeURL(server.URL+"/file.cafe", WithLocalAccess())
+	assert.NoError(t, err)
+	assert.NotEmpty(t, p.Attributes["hello"].Value)
+
+	// Relative paths can't be resolved from a URL
+	_, err = DecodeURL(server.URL+"/relative.cafe", WithLocalAccess())
+	assert.ErrorIs(t, err, ErrFunctionNotAllowed)
+	assert.ErrorContains(t, err, "function file can't read hello.txt, relative paths can't be resolved from a URL")
+	_, err = DecodeURL(server.URL+"/include.cafe", WithLocalAccess())
+	assert.ErrorIs(t, err, ErrFunctionNotAllowed)
+	assert.ErrorContains(t, err, "include of inc.cafe is not allowed, relative paths can't be resolved from a URL")
+
+	// Documents larger than the limit aren't read
+	_, err = DecodeURL(server.URL+"/large.cafe", WithFetchLimit(50))
+	assert.ErrorContains(t, err, "the document is larger than 50 bytes")
+	_, err = DecodeURL(server.URL + "/large.cafe")
+	assert.NoError(t, err)
+}
+
+func TestRemoteETag(t *testing.T) {
+	doc, etag := "port = 8080\n", `"v1"`
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, doc)
+	}))
+	defer server.Close()
+
+	remote := RemoteSource(server.URL + "/config.cafe")
+	stack, err := NewStack(BytesSource([]byte("port = 80\nhost = \"localhost\"\n")), remote)
+	assert.NoError(t, err)
+	assert.Equal(t, 8080, stack.Parser().Attributes["port"].Value)
+	assert.Equal(t, "localhost", stack.Parser().Attributes["host"].Value)
+
+	// Unchanged documents aren't downloaded again
+	assert.NoError(t, stack.Reload())
+	assert.Equal(t, 1, downloads)
+	assert.Equal(t, 8080, stack.Parser().Attributes["port"].Value)
+
+	doc, etag = "port = 9090\n", `"v2"`
+	assert.NoError(t, stack.Reload())
+	assert.Equal(t, 2, downloads)
+	assert.Equal(t, 9090, stack.Parser().Attributes["port"].Value)
+}
+
+func TestRemoteTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	_, err := DecodeURL(server.URL, WithFetchTimeout(10*time.Millisecond))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,340 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"reflect"
+	"strings"
+)
+
+// editKind is the kind of a change made through the mutation API
+type editKind int
+
+const (
+	editSet         editKind = iota // An attribute was defined or replaced
+	editDelete                      // An attribute or a block was removed
+	editAddBlock                    // A block was added
+	editDoc                         // The doc comment of an attribute or a block was set
+	editLineComment                 // The comment after an attribute was set
+)
+
+// edit is a change made through the mutation API
+type edit struct {
+	// Kind of the change
+	kind editKind
+
+	// Path of the changed attribute or block
+	path Path
+
+	// Value of a set attribute
+	value Value
+
+	// Text of a set comment
+	text string
+}
+
+// sourceNode is an attribute or a block written in a source
+type sourceNode struct {
+	// Whether it's a block
+	isBlock bool
+
+	// Indexes of its first and last tokens, the closing brace of blocks and
+	// the end of the value of attributes
+	first int
+	last  int
+}
+
+// Returns the source of the document with its edits applied, reprinting
+// only the edited attributes and blocks
+// False if an edit can't be found in the source, or if the edited source
+// doesn't decode to the document, as when an edited attribute is
+// shadowed by a profile or other attributes are computed from it
+func (p *Parser) rewrite() ([]byte, bool) {
+	if p.source == nil {
+		return nil, false
+	}
+	src := p.source
+	for _, e := range p.edits {
+		var applied bool
+		if src, applied = applyEdit(src, e); !applied {
+			return nil, false
+		}
+	}
+
+	decoded, err := safeDecode(p.filename, func() (*Parser, error) {
+		// Checking the edits isn't a decoding of its own
+		o := p.opts
+		o.stats, o.parseHook = nil, nil
+		decoded := newParserWithOptions([]rune(string(src)), o)
+		decoded.filename, decoded.clock = p.filename, p.clock
+		decoded.parseItems(nil)
+		return decoded, decoded.err
+	})
+	if err != nil || !reflect.DeepEqual(bodyToJSON(p.Attributes, p.Blocks), bodyToJSON(decoded.Attributes, decoded.Blocks)) {
+		return nil, false
+	}
+	return src, true
+}
+
+// Applies an edit to a source, false if it can't be found in it
+func applyEdit(src []byte, e edit) ([]byte, bool) {
+	tokens, err := Lex(src)
+	if err != nil {
+		return nil, false
+	}
+	nodes := sourceNodes(tokens)
+	// Positions of the tokens are in the source, so they're always found
+	offset := func(pos Position) int {
+		offset, _ := positionOffset(src, pos)
+		return offset
+	}
+
+	node, found := nodes[e.path.String()]
+	switch {
+	case e.kind == editSet && found && !node.isBlock:
+		value, err := encodeValue(e.value)
+		if err != nil || node.last == node.first {
+			return nil, false
+		}
+		start, end := offset(tokens[node.first+1].Range.Start), offset(tokens[node.last].Range.End)
+		return splice(src, start, end, value), true
+	case e.kind == editDelete && found:
+		start, end := offset(tokens[node.first].Range.Start), offset(tokens[node.last].Range.End)
+
+		// Along with its doc comments and the comment after it
+		for i := node.first - 1; i >= 0 && isDocComment(tokens[i]) && tokens[i].Range.End.Line == tokens[i+1].Range.Start.Line-1; i-- {
+			if lineStart := lineOffset(src, tokens[i].Range.Start.Line); strings.TrimSpace(string(src[lineStart:offset(tokens[i].Range.Start)])) != "" {
+				break
+			}
+			start = offset(tokens[i].Range.Start)
+		}
+		if next := node.last + 1; next < len(tokens) && tokens[next].Kind == TokenComment && tokens[next].Range.Start.Line == tokens[node.last].Range.End.Line {
+			end = offset(tokens[next].Range.End)
+		}
+
+		// Lines left empty are removed
+		lineStart := strings.LastIndexByte(string(src[:start]), '\n') + 1
+		lineEnd := len(src)
+		if eol := strings.IndexByte(string(src[end:]), '\n'); eol >= 0 {
+			lineEnd = end + eol + 1
+		}
+		if strings.TrimSpace(string(src[lineStart:start])) == "" && strings.TrimSpace(string(src[end:lineEnd])) == "" {
+			start, end = lineStart, lineEnd
+		}
+		return splice(src, start, end, ""), true
+	case e.kind == editAddBlock && found && node.isBlock:
+		return src, true
+	case e.kind == editDoc && found:
+		// The doc comments above it are replaced
+		lineStart := lineOffset(src, tokens[node.first].Range.Start.Line)
+		if strings.TrimSpace(string(src[lineStart:offset(tokens[node.first].Range.Start)])) != "" {
+			return nil, false
+		}
+		docStart := lineStart
+		for i := node.first - 1; i >= 0 && isDocComment(tokens[i]) && tokens[i].Range.End.Line == tokens[i+1].Range.Start.Line-1; i-- {
+			commentStart := lineOffset(src, tokens[i].Range.Start.Line)
+			if strings.TrimSpace(string(src[commentStart:offset(tokens[i].Range.Start)])) != "" {
+				break
+			}
+			docStart = commentStart
+		}
+		var doc strings.Builder
+		writeDoc(&doc, lineIndent(src, tokens[node.first].Range.Start.Line), e.text)
+		return splice(src, docStart, lineStart, doc.String()), true
+	case e.kind == editLineComment && found && !node.isBlock:
+		// An existing comment keeps the spacing before it
+		start := offset(tokens[node.last].Range.End)
+		if next := node.last + 1; next < len(tokens) && tokens[next].Kind == TokenComment && tokens[next].Range.Start.Line == tokens[node.last].Range.End.Line {
+			if e.text == "" {
+				return splice(src, start, offset(tokens[next].Range.End), ""), true
+			}
+			return splice(src, offset(tokens[next].Range.Start), offset(tokens[next].Range.End), strings.TrimPrefix(encodeLineComment(e.text), " ")), true
+		}
+		return splice(src, start, start, encodeLineComment(e.text)), true
+	case found:
+		// Attributes can't be replaced by blocks, and the other way around
+		return nil, false
+	case e.kind != editSet && e.kind != editAddBlock:
+		return nil, false
+	}
+
+	// The edit defines something new, in the innermost block of its path
+	// written in the source, along with the blocks missing in between
+	depth := len(e.path) - 1
+	for ; depth > 0; depth-- {
+		if parent, found := nodes[e.path[:depth].String()]; found {
+			if !parent.isBlock {
+				return nil, false
+			}
+			break
+		}
+	}
+	lines, err := definitionLines(e)
+	if err != nil {
+		return nil, false
+	}
+	unit := indentUnit(src, tokens)
+	for i := len(e.path) - 2; i >= depth; i-- {
+		for j := range lines {
+			lines[j] = unit + lines[j]
+		}
+		lines = append(append([]string{quoteKey(e.path[i]) + " {"}, lines...), "}")
+	}
+
+	if depth == 0 {
+		if len(src) > 0 && src[len(src)-1] != '\n' {
+			src = append(src, '\n')
+		}
+		return append(src, strings.Join(lines, "\n")+"\n"...), true
+	}
+
+	parent := nodes[e.path[:depth].String()]
+	blockIndent := lineIndent(src, tokens[parent.first].Range.Start.Line)
+	indent := blockIndent + unit
+	if child := parent.first + 1; child < parent.last && tokens[child].Range.Start.Line != tokens[parent.first].Range.Start.Line {
+		indent = lineIndent(src, tokens[child].Range.Start.Line)
+	}
+	for j := range lines {
+		lines[j] = indent + lines[j]
+	}
+
+	// Before the closing brace, in a line of its own
+	brace := offset(tokens[parent.last].Range.Start)
+	lineStart := lineOffset(src, tokens[parent.last].Range.Start.Line)
+	if strings.TrimSpace(string(src[lineStart:brace])) == "" {
+		return splice(src, lineStart, lineStart, strings.Join(lines, "\n")+"\n"), true
+	}
+	start := len(strings.TrimRight(string(src[:brace]), " \t"))
+	return splice(src, start, brace, "\n"+strings.Join(lines, "\n")+"\n"+blockIndent), true
+}
+
+// Returns the lines of the new attribute or block of an edit, without
+// their indentation
+func definitionLines(e edit) ([]string, error) {
+	name := quoteKey(e.path[len(e.path)-1])
+	if e.kind == editAddBlock {
+		return []string{name + " {", "}"}, nil
+	}
+	value, err := encodeValue(e.value)
+	if err != nil {
+		return nil, err
+	}
+	return []string{name + " = " + value}, nil
+}
+
+// Finds the attributes and blocks written in the tokens of a source, by
+// their paths
+// Only plain definitions are found, not the ones of dotted keys, local
+// variables, profiles, templates, for blocks or blocks of arrays, whose
+// paths don't match where they're written
+func sourceNodes(tokens []Token) map[string]sourceNode {
+	nodes := map[string]sourceNode{}
+	path := Path{}
+	plain := []bool{}
+	isPlain := func() bool {
+		return len(plain) == 0 || plain[len(plain)-1]
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		switch token.Kind {
+		case TokenAttribute:
+			last := valueEnd(tokens, i)
+			key, operator := splitAssignment(token.Value)
+			if isPlain() && operator == "" && !strings.HasPrefix(key, "let ") && len(splitPath(key)) == 1 {
+				nodes[childPath(path, unquoteKey(key)).String()] = sourceNode{first: i, last: last}
+			}
+			i = last
+		case TokenBlockStart:
+			name := token.Value
+			isBlockPlain := isPlain() && name != "vars" && name != "{" && !profileRegexp.MatchString(name) &&
+				!templateRegexp.MatchString(name) && !forBlockRegexp.MatchString(name) && !dynamicBlockRegexp.MatchString(name)
+			path = append(path, unquoteKey(name))
+			plain = append(plain, isBlockPlain)
+			if isBlockPlain {
+				nodes[path.String()] = sourceNode{isBlock: true, first: i}
+			}
+		case TokenBlockEnd:
+			if len(plain) == 0 {
+				continue
+			}
+			if plain[len(plain)-1] {
+				node := nodes[path.String()]
+				node.last = i
+				nodes[path.String()] = node
+			}
+			path, plain = path[:len(path)-1], plain[:len(plain)-1]
+		}
+	}
+	return nodes
+}
+
+// Returns the index of the last token of the value of the attribute at an
+// index, skipping the comments after it
+func valueEnd(tokens []Token, attribute int) int {
+	last, depth := attribute, 0
+	for i := attribute + 1; i < len(tokens); i++ {
+		switch tokens[i].Kind {
+		case TokenArrayStart:
+			depth++
+		case TokenArrayEnd:
+			depth--
+		case TokenAttribute, TokenBlockStart, TokenBlockEnd, TokenInclude, TokenUse:
+			if depth <= 0 {
+				return last
+			}
+		case TokenComment:
+			continue
+		}
+		last = i
+	}
+	return last
+}
+
+// Reports if a token is a doc comment
+func isDocComment(token Token) bool {
+	return token.Kind == TokenComment && strings.HasPrefix(token.Value, "///")
+}
+
+// Returns the whitespace a line of a source starts with
+func lineIndent(src []byte, line int) string {
+	text := src[lineOffset(src, line):]
+	return string(text[:len(text)-len(strings.TrimLeft(string(text), " \t"))])
+}
+
+// Returns the indentation of the attributes and blocks of a block relative
+// to it, as found in the first block of a source with a definition in a
+// line of its own, four spaces if none has
+func indentUnit(src []byte, tokens []Token) string {
+	for i := 0; i+1 < len(tokens); i++ {
+		block, child := tokens[i], tokens[i+1]
+		if block.Kind != TokenBlockStart || child.Kind == TokenBlockEnd || child.Range.Start.Line == block.Range.Start.Line {
+			continue
+		}
+		blockIndent, childIndent := lineIndent(src, block.Range.Start.Line), lineIndent(src, child.Range.Start.Line)
+		if len(childIndent) > len(blockIndent) && strings.HasPrefix(childIndent, blockIndent) {
+			return childIndent[len(blockIndent):]
+		}
+	}
+	return "    "
+}
+
+// Replaces the bytes of a source between two offsets
+func splice(src []byte, start int, end int, text string) []byte {
+	spliced := make([]byte, 0, len(src)-(end-start)+len(text))
+	spliced = append(spliced, src[:start]...)
+	spliced = append(spliced, text...)
+	return append(spliced, src[end:]...)
+}
@@ -0,0 +1,249 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewrite(t *testing.T) {
+	src := `// Application settings
+name    = "cafe"   // aligned
+tags = [
+    "web", // first
+    "api",
+]
+
+/// The server
+server {
+  port = 8080
+
+  /// Served over TLS
+  tls {
+    cert = "cert.pem"
+  }
+  limits { }
+}
+`
+	for _, test := range []struct {
+		name     string
+		edit     func(p *Parser) error
+		expected string
+	}{
+		{
+			"set",
+			func(p *Parser) error {
+				return p.Set("server.port", 9090)
+			},
+			`// Application settings
+name    = "cafe"   // aligned
+tags = [
+    "web", // first
+    "api",
+]
+
+/// The server
+server {
+  port = 9090
+
+  /// Served over TLS
+  tls {
+    cert = "cert.pem"
+  }
+  limits { }
+}
+`,
+		},
+		{
+			"set array",
+			func(p *Parser) error {
+				return p.Set("tags", []interface{}{"web"})
+			},
+			`// Application settings
+name    = "cafe"   // aligned
+tags = ["web"]
+
+/// The server
+server {
+  port = 8080
+
+  /// Served over TLS
+  tls {
+    cert = "cert.pem"
+  }
+  limits { }
+}
+`,
+		},
+		{
+			"add",
+			func(p *Parser) error {
+				if err := p.Set("server.host", "0.0.0.0"); err != nil {
+					return err
+				}
+				if err := p.Set("server.limits.rate", 10); err != nil {
+					return err
+				}
+				if err := p.Set("server.tls.ciphers.allowed", []interface{}{"aes"}); err != nil {
+					return err
+				}
+				return p.AddBlock("logging")
+			},
+			`// Application settings
+name    = "cafe"   // aligned
+tags = [
+    "web", // first
+    "api",
+]
+
+/// The server
+server {
+  port = 8080
+
+  /// Served over TLS
+  tls {
+    cert = "cert.pem"
+    ciphers {
+      allowed = ["aes"]
+    }
+  }
+  limits {
+    rate = 10
+  }
+  host = "0.0.0.0"
+}
+logging {
+}
+`,
+		},
+		{
+			"comments",
+			func(p *Parser) error {
+				if err := p.SetDoc("server", "The web server\nof the application"); err != nil {
+					return err
+				}
+				if err := p.SetDoc("server.tls", ""); err != nil {
+					return err
+				}
+				if err := p.SetLineComment("name", "renamed"); err != nil {
+					return err
+				}
+				if err := p.Set("server.workers", 4); err != nil {
+					return err
+				}
+				if err := p.SetDoc("server.workers", "Number of workers"); err != nil {
+					return err
+				}
+				return p.SetLineComment("server.port", "service port")
+			},
+			`// Application settings
+name    = "cafe"   // renamed
+tags = [
+    "web", // first
+    "api",
+]
+
+/// The web server
+/// of the application
+server {
+  port = 8080 // service port
+
+  tls {
+    cert = "cert.pem"
+  }
+  limits { }
+  /// Number of workers
+  workers = 4
+}
+`,
+		},
+		{
+			"delete",
+			func(p *Parser) error {
+				if err := p.Delete("name"); err != nil {
+					return err
+				}
+				if err := p.Delete("server.tls"); err != nil {
+					return err
+				}
+				return p.Delete("tags")
+			},
+			`// Application settings
+
+/// The server
+server {
+  port = 8080
+
+  limits { }
+}
+`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := DecodeBytes([]byte(src))
+			assert.NoError(t, err)
+			assert.NoError(t, test.edit(p))
+
+			rewritten, ok := p.rewrite()
+			assert.True(t, ok)
+			assert.Equal(t, test.expected, string(rewritten))
+		})
+	}
+}
+
+func TestRewriteFallback(t *testing.T) {
+	// Edits that don't decode to the document are encoded instead
+	for src, path := range map[string]string{
+		// Computed from the edited attribute
+		"name = \"cafe\"\nid = upper(name)\n": "name",
+		// Shadowed by the selected profile
+		"port = 8080\nprofile \"prod\" {\n    port = 443\n}\n": "port",
+		// Defined by a dotted key
+		"server.port = 8080\nport = 80\n": "server.port",
+	} {
+		p, err := DecodeBytes([]byte(src), WithProfile("prod"))
+		assert.NoError(t, err, src)
+		assert.NoError(t, p.Set(path, "edited"))
+		_, ok := p.rewrite()
+		assert.False(t, ok, src)
+
+		filename := filepath.Join(t.TempDir(), "app.cafe")
+		assert.NoError(t, p.WriteFile(filename))
+		decoded, err := Decode(filename)
+		assert.NoError(t, err, src)
+		assert.Equal(t, bodyToJSON(p.Attributes, p.Blocks), bodyToJSON(decoded.Attributes, decoded.Blocks), src)
+	}
+
+	// Documents without a single source are encoded too
+	p, err := Decode("./test_data/override/app.cafe")
+	assert.NoError(t, err)
+	_, ok := p.rewrite()
+	assert.False(t, ok)
+
+	// Decoded files keep their source, even without edits
+	filename := filepath.Join(t.TempDir(), "app.cafe")
+	src := "// comment\nport   =   8080\n"
+	assert.NoError(t, os.WriteFile(filename, []byte(src), 0o644))
+	p, err = Decode(filename)
+	assert.NoError(t, err)
+	assert.NoError(t, p.WriteFile(filename))
+	written, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, src, string(written))
+}
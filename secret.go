@@ -0,0 +1,121 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SecretResolver resolves the secrets of secret() calls from the store an
+// application keeps them in, like Vault, AWS Secrets Manager or SOPS files
+// Path is the parameter of the call, whose format is up to the resolver
+type SecretResolver interface {
+	ResolveSecret(path string) (string, error)
+}
+
+// SecretResolverFunc adapts a function to a SecretResolver
+type SecretResolverFunc func(path string) (string, error)
+
+func (f SecretResolverFunc) ResolveSecret(path string) (string, error) {
+	return f(path)
+}
+
+// Secret can be returned by user-defined functions whose values are secrets,
+// like the ones of secret(), so the attributes holding them are redacted
+// when the document is dumped
+type Secret struct {
+	// The value of the call
+	Value Value
+}
+
+// Text that replaces the values of secrets when dumping a document
+const redactedSecret = "<redacted>"
+
+// Returns the secret() function of a resolver
+func secretFunction(resolver SecretResolver) Function {
+	return func(args []Value) (Value, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("takes 1 parameter, got %d: %w", len(args), ErrInvalidArgument)
+		}
+		value, err := resolver.ResolveSecret(fmt.Sprint(args[0]))
+		if err != nil {
+			return nil, err
+		}
+		return Secret{Value: value}, nil
+	}
+}
+
+// Marks the expression being evaluated as holding a secret if it refers to
+// an attribute, a block or a local variable holding one
+func (p *Parser) touchSecret(path string) {
+	if len(p.secrets) == 0 || p.usedSecret {
+		return
+	}
+	for secret := range p.secrets {
+		if secret == path || strings.HasPrefix(secret, path+".") {
+			p.usedSecret = true
+			return
+		}
+	}
+}
+
+// Records whether the attribute or local variable of the current block
+// holds a secret
+// Appending to an attribute holding a secret keeps it secret
+func (p *Parser) recordSecret(name string, appended bool) {
+	switch {
+	case p.usedSecret:
+		p.markSecret(p.currentPath(name))
+	case !appended && len(p.secrets) > 0:
+		delete(p.secrets, p.currentPath(name))
+	}
+}
+
+// Marks the attribute or local variable at path as holding a secret
+func (p *Parser) markSecret(path string) {
+	if p.secrets == nil {
+		p.secrets = map[string]bool{}
+	}
+	p.secrets[path] = true
+}
+
+// IsSecret reports if the value of the attribute at a path, as in
+// "database.password", comes from a secret() call, directly or through the
+// attributes and local variables it refers to
+// Secrets are redacted from ToJSON, String and the Encoder
+func (p *Parser) IsSecret(path string) bool {
+	return p.secrets[splitPath(path).String()]
+}
+
+// Replaces the values of the secrets of a JSON-like body
+func (p *Parser) redactSecrets(obj map[string]interface{}) map[string]interface{} {
+	for secret := range p.secrets {
+		names := splitPath(secret)
+		body := obj
+		for _, name := range names[:len(names)-1] {
+			nested, isBlock := body[name].(map[string]interface{})
+			if !isBlock {
+				body = nil
+				break
+			}
+			body = nested
+		}
+		if _, found := body[names[len(names)-1]]; found {
+			body[names[len(names)-1]] = redactedSecret
+		}
+	}
+	return obj
+}
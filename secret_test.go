@@ -0,0 +1,128 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecrets(t *testing.T) {
+	store := map[string]string{"db/password": "hunter2", "api/token": "abc"}
+	resolver := SecretResolverFunc(func(path string) (string, error) {
+		value, found := store[path]
+		if !found {
+			return "", errors.New("secret not found")
+		}
+		return value, nil
+	})
+
+	src := `let token = secret("api/token")
+database {
+    user = "admin"
+    password = secret("db/password")
+    dsn = append("postgres://admin:", password)
+}
+api {
+    header = append("Bearer ", token)
+    timeout = 30
+}
+password_hash = sha256(database.password)
+`
+	p, err := DecodeBytes([]byte(src), WithSecretResolver(resolver))
+	assert.NoError(t, err)
+
+	// Secrets are resolved, and so are the values computed from them
+	assert.Equal(t, "hunter2", p.Blocks["database"].Attributes["password"].Value)
+	assert.Equal(t, "postgres://admin:hunter2", p.Blocks["database"].Attributes["dsn"].Value)
+	for _, path := range []string{"database.password", "database.dsn", "api.header", "password_hash"} {
+		assert.True(t, p.IsSecret(path), path)
+	}
+	for _, path := range []string{"database.user", "api.timeout", "missing"} {
+		assert.False(t, p.IsSecret(path), path)
+	}
+
+	// Dumps of the document redact them
+	data, err := p.ToJSON()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"api":{"header":"\u003credacted\u003e","timeout":30},"database":{"dsn":"\u003credacted\u003e","password":"\u003credacted\u003e","user":"admin"},"password_hash":"\u003credacted\u003e"}`, string(data))
+	assert.Equal(t, `password_hash = sha256(database.password)
+api {
+    header = "<redacted>"
+    timeout = 30
+}
+database {
+    dsn = append("postgres://admin:", password)
+    password = secret("db/password")
+    user = "admin"
+}
+`, p.String())
+	assert.NotContains(t, p.String(), "hunter2")
+	normalized, err := Normalize(p, NormalizeOptions{})
+	assert.NoError(t, err)
+	assert.NotContains(t, string(normalized), "hunter2")
+	assert.Contains(t, string(normalized), `password = "<redacted>"`)
+
+	// So do diffs, whichever document holds the secret
+	store["db/password"] = "hunter3"
+	rotated, err := DecodeBytes([]byte(src), WithSecretResolver(resolver))
+	assert.NoError(t, err)
+	plain, err := DecodeBytes([]byte("database {\n    password = \"hunter2\"\n}\n"))
+	assert.NoError(t, err)
+	assert.Contains(t, Diff(p, rotated), Change{Kind: ChangeModified, Path: Path{"database", "password"}, Old: "<redacted>", New: "<redacted>"})
+	assert.Contains(t, Diff(plain, p), Change{Kind: ChangeAdded, Path: Path{"api", "header"}, New: "<redacted>"})
+	for _, change := range append(Diff(p, rotated), Diff(plain, rotated)...) {
+		assert.NotContains(t, change.String(), "hunter")
+	}
+	store["db/password"] = "hunter2"
+
+	// Unmarshal gets the values
+	var config struct {
+		Database struct {
+			Password string `cafe:"password"`
+		} `cafe:"database"`
+	}
+	assert.NoError(t, p.Unmarshal(&config))
+	assert.Equal(t, "hunter2", config.Database.Password)
+
+	// Values set in place of secrets aren't secrets anymore
+	assert.NoError(t, p.Set("database.password", "changed"))
+	assert.False(t, p.IsSecret("database.password"))
+	assert.NoError(t, p.Delete("api"))
+	assert.False(t, p.IsSecret("api.header"))
+
+	// Failing resolutions fail the decoding
+	_, err = decodeBytesSafely([]byte("password = secret(\"missing\")\n"), WithSecretResolver(resolver))
+	assert.ErrorContains(t, err, "function secret: secret not found")
+
+	// secret is only reserved with a resolver
+	_, err = DecodeBytes([]byte("secret = \"hunter2\"\n"))
+	assert.NoError(t, err)
+}
+
+func TestSecretFunctions(t *testing.T) {
+	// User-defined functions mark their values as secrets with Secret
+	vault := func(args []Value) (Value, error) {
+		return Secret{Value: strings.ToUpper(args[0].(string))}, nil
+	}
+	p, err := DecodeBytes([]byte("key = vault(\"key\")\nname = \"cafe\"\n"), WithFunctions(map[string]Function{"vault": vault}))
+	assert.NoError(t, err)
+	assert.Equal(t, "KEY", p.Attributes["key"].Value)
+	assert.True(t, p.IsSecret("key"))
+	assert.False(t, p.IsSecret("name"))
+}
@@ -0,0 +1,237 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Source is a layer of a Stack, like defaults, a file or the environment
+// Load returns the document of the layers before it with its own applied
+// on top, so it takes precedence over them
+type Source interface {
+	Load(base *Parser) (*Parser, error)
+}
+
+// SourceFunc adapts a function to a Source
+type SourceFunc func(base *Parser) (*Parser, error)
+
+func (f SourceFunc) Load(base *Parser) (*Parser, error) {
+	return f(base)
+}
+
+// BytesSource is a layer decoded from a CAFE document, such as the
+// defaults of an application embedded in its binary
+// Like the files of DecodeDir, it can refer to and override the attributes
+// of the layers before it, but can't redefine its own
+func BytesSource(src []byte, opts ...Option) Source {
+	return SourceFunc(func(base *Parser) (*Parser, error) {
+		input, err := readRunes(strings.NewReader(string(src)))
+		if err != nil {
+			return nil, err
+		}
+		return overlayInput(base, "", input, opts)
+	})
+}
+
+// FileSource is a layer decoded from a CAFE file and its override file,
+// like BytesSource
+func FileSource(filename string, opts ...Option) Source {
+	return SourceFunc(func(base *Parser) (*Parser, error) {
+		p, err := decodeOverlay(base, filename, opts)
+		if err != nil {
+			return nil, err
+		}
+		return decodeOverride(p, filename, opts)
+	})
+}
+
+// DirSource is a layer decoded from the .cafe files of a directory, in the
+// order of their names, like DecodeDir
+func DirSource(dir string, opts ...Option) Source {
+	return SourceFunc(func(base *Parser) (*Parser, error) {
+		return decodeDirOverlay(base, dir, opts)
+	})
+}
+
+// EnvSource is a layer setting the attributes of the layers before it from
+// environment variables named by the prefix and their path in upper case,
+// with underscores for anything but letters and digits, like APP_SERVER_PORT
+// for server.port with the prefix "APP"
+// Variables are parsed as values of the kind of the attributes they set,
+// and only strings, numbers and bools can be set
+func EnvSource(prefix string) Source {
+	return SourceFunc(func(base *Parser) (*Parser, error) {
+		type override struct {
+			path  string
+			value Value
+		}
+		overrides := []override{}
+		var err error
+		base.EachAttribute(func(path string, a Attribute) bool {
+			switch a.Value.(type) {
+			case string, int, float64, bool:
+			default:
+				return true
+			}
+			name := envName(prefix, path)
+			text, found := os.LookupEnv(name)
+			if !found {
+				return true
+			}
+			value, parseErr := parseLike(a.Value, text)
+			if parseErr != nil {
+				err = fmt.Errorf("environment variable %s: %w", name, parseErr)
+				return false
+			}
+			overrides = append(overrides, override{path: path, value: value})
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range overrides {
+			if err := base.Set(o.path, o.value); err != nil {
+				return nil, err
+			}
+		}
+		return base, nil
+	})
+}
+
+// Returns the name of the environment variable of an attribute
+func envName(prefix string, path string) string {
+	name := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || (!unicode.IsLetter(r) && !unicode.IsDigit(r)) {
+			return '_'
+		}
+		return unicode.ToUpper(r)
+	}, strings.Join(splitPath(path), "."))
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
+
+// FlagSource is a layer setting the attributes of the layers before it
+// from the flags given on the command line, see Parser.ApplyFlags
+// The flag sets must be parsed before the Stack is loaded
+func FlagSource(sets ...*flag.FlagSet) Source {
+	return SourceFunc(func(base *Parser) (*Parser, error) {
+		if err := base.ApplyFlags(sets...); err != nil {
+			return nil, err
+		}
+		return base, nil
+	})
+}
+
+// Stack composes the configuration of an application from layers, like
+// defaults, files, the environment and flags, each one taking precedence
+// over the ones before it, and reads it as one document
+// It's safe for concurrent use
+type Stack struct {
+	mu      sync.RWMutex
+	sources []Source
+	doc     *Parser
+}
+
+// NewStack loads the layers of a configuration, in order of precedence,
+// the last one winning
+//
+//	stack, err := cafe.NewStack(
+//	    cafe.BytesSource(defaults),
+//	    cafe.FileSource("/etc/app/config.cafe"),
+//	    cafe.EnvSource("APP"),
+//	    cafe.FlagSource(flag.CommandLine),
+//	)
+func NewStack(sources ...Source) (*Stack, error) {
+	s := &Stack{sources: sources}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload loads the layers of the stack again, such as when one of its
+// files changed
+// If a layer can't be loaded, the stack keeps the document it had
+func (s *Stack) Reload() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			parseErr, isParseErr := r.(*ParseError)
+			if !isParseErr {
+				panic(r)
+			}
+			err = fmt.Errorf("cafe: %w", parseErr)
+		}
+	}()
+
+	doc := newParser(nil)
+	for i, source := range s.sources {
+		if doc, err = source.Load(doc); err != nil {
+			return fmt.Errorf("cafe: source %d: %w", i+1, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.doc = doc
+	return nil
+}
+
+// Parser returns the document of the stack, with all its layers applied
+// It must not be modified
+func (s *Stack) Parser() *Parser {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.doc
+}
+
+// Get stores the attribute or block at a path, as in "server.port", in
+// the value pointed to by v, converting it like Unmarshal does
+// Blocks are stored in structs, like with DecodeBlock
+func (s *Stack) Get(path string, v interface{}) error {
+	p := s.Parser()
+	names, err := mutationPath(path)
+	if err != nil {
+		return fmt.Errorf("cafe: %w", err)
+	}
+	attributes, _, err := p.bodyAt(names[:len(names)-1], false)
+	if err != nil {
+		return fmt.Errorf("cafe: %w", err)
+	}
+	attr, isAttr := attributes[names[len(names)-1]]
+	if !isAttr {
+		return p.DecodeBlock(path, v)
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("cafe: Get needs a non-nil pointer, got %T", v)
+	}
+	return p.unmarshalValue(names, attr.Value, rv.Elem())
+}
+
+// Unmarshal stores the document of the stack in the struct pointed to by
+// v, see Parser.Unmarshal
+func (s *Stack) Unmarshal(v interface{}) error {
+	return s.Parser().Unmarshal(v)
+}
@@ -0,0 +1,108 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStack(t *testing.T) {
+	defaults := []byte(`server {
+    host = "localhost"
+    port = 8080
+    workers = 1
+    timeout = "30s"
+}
+log_level = "warn"
+`)
+	t.Setenv("CAFE_TEST_SERVER_PORT", "9090")
+	flags := flag.NewFlagSet("app", flag.ContinueOnError)
+	flags.Int("server.workers", 1, "")
+	assert.NoError(t, flags.Parse([]string{"-server.workers=16"}))
+
+	stack, err := NewStack(
+		BytesSource(defaults),
+		FileSource("./test_data/stack/config.cafe"),
+		EnvSource("CAFE_TEST"),
+		DirSource("./test_data/stack/conf.d"),
+		FlagSource(flags),
+	)
+	assert.NoError(t, err)
+
+	var port int
+	assert.NoError(t, stack.Get("server.port", &port))
+	assert.Equal(t, 9090, port)
+
+	type Server struct {
+		Host    string        `cafe:"host"`
+		URL     string        `cafe:"url"`
+		Port    int           `cafe:"port"`
+		Workers int           `cafe:"workers"`
+		Timeout time.Duration `cafe:"timeout"`
+	}
+	var server Server
+	assert.NoError(t, stack.Get("server", &server))
+	assert.Equal(t, Server{Host: "0.0.0.0", URL: "http://0.0.0.0", Port: 9090, Workers: 16, Timeout: 30 * time.Second}, server)
+
+	var config struct {
+		LogLevel string `cafe:"log_level"`
+		Server   Server `cafe:"server"`
+	}
+	assert.NoError(t, stack.Unmarshal(&config))
+	assert.Equal(t, "debug", config.LogLevel)
+	assert.Equal(t, server, config.Server)
+
+	var missing string
+	assert.ErrorIs(t, stack.Get("server.missing", &missing), ErrUndefined)
+	assert.Error(t, stack.Get("server.port", port))
+}
+
+func TestStackErrors(t *testing.T) {
+	// Environment variables must fit the kind of their attributes
+	t.Setenv("CAFE_TEST_PORT", "http")
+	_, err := NewStack(BytesSource([]byte("port = 8080\n")), EnvSource("CAFE_TEST"))
+	assert.ErrorContains(t, err, `cafe: source 2: environment variable CAFE_TEST_PORT: invalid int "http"`)
+
+	// A failed reload keeps the document
+	t.Setenv("CAFE_TEST_PORT", "9090")
+	fail := false
+	stack, err := NewStack(
+		BytesSource([]byte("port = 8080\n")),
+		EnvSource("CAFE_TEST"),
+		SourceFunc(func(base *Parser) (*Parser, error) {
+			if fail {
+				return nil, ErrUndefined
+			}
+			return base, nil
+		}),
+	)
+	assert.NoError(t, err)
+	fail = true
+	assert.ErrorIs(t, stack.Reload(), ErrUndefined)
+	assert.Equal(t, 9090, stack.Parser().Attributes["port"].Value)
+
+	// Layers can't redefine their own attributes
+	_, err = NewStack(BytesSource([]byte("port = 1\nport = 2\n")))
+	assert.Error(t, err)
+}
+
+func TestEnvName(t *testing.T) {
+	assert.Equal(t, "APP_SERVER_MAX_CONNS", envName("APP", "server.max_conns"))
+	assert.Equal(t, "SERVER_LISTEN_ADDRESS", envName("", `server."listen.address"`))
+}
@@ -0,0 +1,115 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// ParseStats describes the work done to decode a document, for exporting
+// metrics like the number of decodings and how long they take
+type ParseStats struct {
+	// File decoded, empty for documents that don't come from files
+	File string
+
+	// Number of tokens the lexer produced
+	Tokens int
+
+	// Size of the document, in bytes
+	Bytes int
+
+	// How long lexing and parsing the document took
+	Duration time.Duration
+
+	// Number of errors found in the document
+	Errors int
+}
+
+// WithStats calls fn with the stats of every document decoded, once it's
+// parsed, even if it can't be decoded
+// Override files and included files are documents of their own, reported
+// before the document they override or that includes them
+func WithStats(fn func(ParseStats)) Option {
+	return func(o *options) {
+		o.stats = fn
+	}
+}
+
+// WithParseHook calls fn with the first token of every statement before
+// the parser goes through it, so the time between two calls tells how
+// long a statement took to decode, for profiling slow documents
+func WithParseHook(fn func(Token)) Option {
+	return func(o *options) {
+		o.parseHook = fn
+	}
+}
+
+// Reader counting the bytes read from it, which can be read as they're
+// read in pipeline mode
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// Returns the size of an input in bytes
+func inputSize(input []rune) int {
+	size := 0
+	for _, r := range input {
+		size += utf8.RuneLen(r)
+	}
+	return size
+}
+
+// Passes the stats of the parser to the stats function of its decoding
+// A parser that panicked failed with one more error
+func (p *Parser) reportStats(panicked bool) {
+	stats := ParseStats{
+		File:   p.filename,
+		Bytes:  p.inputBytes,
+		Errors: len(p.errs),
+	}
+	if p.reader != nil {
+		stats.Bytes = int(p.reader.n.Load())
+	}
+	if !p.started.IsZero() {
+		stats.Duration = time.Since(p.started)
+	}
+	for _, it := range p.lx.items {
+		if _, isToken := tokenKinds[it.kind]; isToken {
+			stats.Tokens += 1
+		}
+	}
+	if panicked {
+		stats.Errors += 1
+	}
+	p.opts.stats(stats)
+}
+
+// Passes the current item to the parse hook of the decoding, if it's a
+// token
+func (p *Parser) hookItem() {
+	if token, isToken := itemToken(p.currentItem); isToken {
+		p.opts.parseHook(token)
+	}
+}
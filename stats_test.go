@@ -0,0 +1,92 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithStats(t *testing.T) {
+	src := []byte("name = \"café\"\nserver {\n    port = 80\n}\n")
+	reported := []ParseStats{}
+	record := WithStats(func(stats ParseStats) {
+		reported = append(reported, stats)
+	})
+	_, err := DecodeBytes(src, record)
+	assert.NoError(t, err)
+	if assert.Len(t, reported, 1) {
+		assert.Equal(t, "", reported[0].File)
+		assert.Equal(t, len(src), reported[0].Bytes)
+		assert.Equal(t, 6, reported[0].Tokens)
+		assert.Zero(t, reported[0].Errors)
+		assert.Greater(t, int64(reported[0].Duration), int64(0))
+	}
+
+	// Documents read from readers, lexed while they're parsed or not
+	for _, opts := range [][]Option{{record}, {record, WithPipeline()}} {
+		reported = reported[:0]
+		_, err = DecodeReader(bytes.NewReader(src), opts...)
+		assert.NoError(t, err)
+		if assert.Len(t, reported, 1) {
+			assert.Equal(t, len(src), reported[0].Bytes)
+			assert.Equal(t, 6, reported[0].Tokens)
+		}
+	}
+
+	// Included files are reported before the file including them
+	reported = reported[:0]
+	_, err = Decode("./test_data/include/main.cafe", record)
+	assert.NoError(t, err)
+	files := []string{}
+	for _, stats := range reported {
+		files = append(files, filepath.Base(stats.File))
+	}
+	assert.Equal(t, []string{"common.cafe", "tls.cafe", "web.cafe", "main.cafe"}, files)
+
+	// Documents that can't be decoded are reported with their errors
+	reported = reported[:0]
+	_, err = DecodeBytes([]byte("a = 1\na = 2\nb = 1\nb = 2\n"), record)
+	assert.Error(t, err)
+	if assert.Len(t, reported, 1) {
+		assert.Equal(t, 2, reported[0].Errors)
+	}
+	reported = reported[:0]
+	_, err = DecodeBytes([]byte("a = upper(1, 2, 3)\n"), record)
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+	if assert.Len(t, reported, 1) {
+		assert.Equal(t, 1, reported[0].Errors)
+	}
+}
+
+func TestWithParseHook(t *testing.T) {
+	tokens := []Token{}
+	_, err := DecodeBytes([]byte("// Name\nname = \"cafe\"\nserver {\n    port = 80\n}\n"), WithParseHook(func(token Token) {
+		tokens = append(tokens, token)
+	}))
+	assert.NoError(t, err)
+	kinds := []TokenKind{}
+	values := []string{}
+	for _, token := range tokens {
+		kinds = append(kinds, token.Kind)
+		values = append(values, token.Value)
+	}
+	assert.Equal(t, []TokenKind{TokenComment, TokenAttribute, TokenBlockStart, TokenAttribute, TokenBlockEnd}, kinds)
+	assert.Equal(t, []string{"// Name", "name", "server", "port", "}"}, values)
+	assert.Equal(t, Position{Line: 4, Column: 5}, tokens[3].Range.Start)
+}
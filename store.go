@@ -0,0 +1,133 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Snapshot is a document kept by a Store
+type Snapshot struct {
+	// The decoded document
+	Parser *Parser
+
+	// When the document was added to the store
+	Time time.Time
+
+	// SHA-256 of the normalized document, equal for documents with the
+	// same content
+	Hash string
+}
+
+// Store keeps the last successfully decoded documents, so a configuration
+// can be reverted to a known-good one at runtime after a bad change
+// It's safe for concurrent use
+type Store struct {
+	mu        sync.Mutex
+	limit     int
+	snapshots []Snapshot
+
+	// Returns the current time, time.Now if nil
+	clock func() time.Time
+}
+
+// NewStore creates a store keeping the last limit documents
+func NewStore(limit int) *Store {
+	if limit < 1 {
+		limit = 1
+	}
+	return &Store{limit: limit}
+}
+
+// Push adds a decoded document as the current one
+// The oldest document is dropped once the store is full
+func (s *Store) Push(p *Parser) (Snapshot, error) {
+	normalized, err := Normalize(p, NormalizeOptions{})
+	if err != nil {
+		return Snapshot{}, err
+	}
+	sum := sha256.Sum256(normalized)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := Snapshot{Parser: p, Time: s.now(), Hash: hex.EncodeToString(sum[:])}
+	s.snapshots = append(s.snapshots, snapshot)
+	if len(s.snapshots) > s.limit {
+		s.snapshots = s.snapshots[len(s.snapshots)-s.limit:]
+	}
+	return snapshot, nil
+}
+
+// Decode decodes a file and pushes it to the store
+// A file that can't be decoded leaves the store unchanged
+func (s *Store) Decode(filename string, opts ...Option) (Snapshot, error) {
+	p, err := SafeDecode(filename, opts...)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return s.Push(p)
+}
+
+// Current returns the last pushed document, false if the store is empty
+func (s *Store) Current() (Snapshot, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.snapshots) == 0 {
+		return Snapshot{}, false
+	}
+	return s.snapshots[len(s.snapshots)-1], true
+}
+
+// Snapshots returns the kept documents, from the oldest to the current one
+func (s *Store) Snapshots() []Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Snapshot{}, s.snapshots...)
+}
+
+// Rollback drops the last n documents and returns the new current one
+// At least one document must be left in the store
+// Watchers pushing to the store serve the new current document until they
+// decode the file again
+func (s *Store) Rollback(n int) (Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n < 0 || n >= len(s.snapshots) {
+		return Snapshot{}, fmt.Errorf("can't roll back %d documents, the store keeps %d", n, len(s.snapshots))
+	}
+	s.snapshots = s.snapshots[:len(s.snapshots)-n]
+	return s.snapshots[len(s.snapshots)-1], nil
+}
+
+// WithStore makes Watch push every document it decodes without errors to a
+// store, from the first one to the last reload, so a bad change can be
+// rolled back to one of them
+func WithStore(s *Store) Option {
+	return func(o *options) {
+		o.store = s
+	}
+}
+
+// Returns the current time, which tests can replace
+func (s *Store) now() time.Time {
+	if s.clock != nil {
+		return s.clock()
+	}
+	return time.Now()
+}
@@ -0,0 +1,66 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewStore(2)
+	store.clock = func() time.Time { return now }
+
+	_, found := store.Current()
+	assert.False(t, found)
+
+	push := func(src string) Snapshot {
+		p, err := DecodeBytes([]byte(src))
+		assert.NoError(t, err)
+		snapshot, err := store.Push(p)
+		assert.NoError(t, err)
+		now = now.Add(time.Minute)
+		return snapshot
+	}
+	first := push("port = 80\n")
+	second := push("port = 8080\n")
+	third := push("// Same content\nport = 8080\n")
+	assert.Equal(t, second.Hash, third.Hash)
+	assert.NotEqual(t, first.Hash, second.Hash)
+	assert.Equal(t, time.Date(2023, 1, 1, 0, 2, 0, 0, time.UTC), third.Time)
+
+	// Only the last documents are kept
+	assert.Equal(t, []Snapshot{second, third}, store.Snapshots())
+
+	_, err := store.Rollback(2)
+	assert.Error(t, err)
+	current, err := store.Rollback(1)
+	assert.NoError(t, err)
+	assert.Equal(t, second, current)
+
+	// A document that can't be decoded leaves the store unchanged
+	_, err = store.Decode("./test_data/test-panic.cafe")
+	assert.Error(t, err)
+	current, _ = store.Current()
+	assert.Equal(t, second, current)
+
+	snapshot, err := store.Decode("./test_data/test-docs.cafe")
+	assert.NoError(t, err)
+	assert.Equal(t, "cafe", snapshot.Parser.Attributes["name"].Value)
+	assert.Len(t, store.Snapshots(), 2)
+}
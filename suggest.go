@@ -0,0 +1,132 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Returns ", did you mean ...?" with the candidate closest to a name that
+// isn't defined, for the errors about it, or an empty string if none of
+// them is close enough to be a typo of it
+// A name can be a third of its length of edits away from the candidate,
+// one for short names
+func didYouMean(name string, candidates []string) string {
+	maxDistance := len([]rune(name)) / 3
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+
+	sort.Strings(candidates)
+	best, bestDistance := "", maxDistance+1
+	for _, candidate := range candidates {
+		if candidate == name {
+			continue
+		}
+		if distance := editDistance(name, candidate); distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	if best == "" || bestDistance >= len([]rune(name)) {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+// Number of insertions, deletions, substitutions and transpositions of
+// adjacent characters turning a string into another
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	// Distances of the prefixes of a to the prefixes of b, in the last
+	// three rows
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(minInt(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = minInt(curr[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(rb)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Returns the names a reference can be resolved to from the current block:
+// the paths of all the attributes for dotted names, or the attributes,
+// local variables and variables in scope for plain names
+func (p *Parser) namesInScope(dotted bool) []string {
+	names := []string{}
+	if dotted {
+		p.walk(nil, p.Attributes, p.Blocks, func(path Path, _ Attribute) bool {
+			names = append(names, strings.Join(path, "."))
+			return true
+		}, nil)
+		return names
+	}
+
+	for _, bindings := range p.bindings {
+		for name := range bindings {
+			names = append(names, name)
+		}
+	}
+	for i, b := range p.getBlocksChain() {
+		for name := range p.locals[Path(p.currentBlocks[:i+1]).String()] {
+			names = append(names, name)
+		}
+		for name := range b.Attributes {
+			names = append(names, name)
+		}
+	}
+	for name := range p.locals[""] {
+		names = append(names, name)
+	}
+	for name := range p.Attributes {
+		names = append(names, name)
+	}
+	for name := range p.opts.variables {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Returns the names of the functions a call can be to
+func (p *Parser) functionsInScope() []string {
+	names := functionNames()
+	for name := range p.opts.functions {
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,67 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance("port", "port"))
+	assert.Equal(t, 1, editDistance("lenght", "length"))
+	assert.Equal(t, 3, editDistance("kitten", "sitting"))
+	assert.Equal(t, 3, editDistance("", "abc"))
+	assert.Equal(t, 1, editDistance("café", "cafe"))
+}
+
+func TestDidYouMean(t *testing.T) {
+	tests := map[string]string{
+		"s = \"ab\"\nn = lenght(s)\n":                             `line 2, column 1: function lenght is not defined, did you mean "length"?`,
+		"port = 80\nx = prot\n":                                   `line 2, column 1: attribute prot is not defined, did you mean "port"?`,
+		"server {\n    port = 80\n}\nx = server.prot\n":           `line 4, column 1: attribute server.prot is not defined, did you mean "server.port"?`,
+		"server {\n    let limit = 3\n    x = limt\n}\n":          `line 3, column 5: attribute limt is not defined, did you mean "limit"?`,
+		"server {\n    port = 80\n}\nclient {\n    x = prot\n}\n": "line 5, column 5: attribute prot is not defined",
+		"name = \"cafe\"\nx = zzz\n":                              "line 2, column 1: attribute zzz is not defined",
+	}
+	for src, expected := range tests {
+		_, errs := DecodePartial([]byte(src))
+		if assert.Len(t, errs, 1, src) {
+			assert.EqualError(t, errs[0], expected, src)
+		}
+	}
+
+	// Variables and the functions of the decoding are suggested too
+	_, errs := DecodePartial([]byte("x = regoin\ny = dobule(2)\n"), WithVariables(map[string]Value{"region": "eu"}), WithFunctions(map[string]Function{
+		"double": func(args []interface{}) (interface{}, error) { return args[0], nil },
+	}))
+	if assert.Len(t, errs, 2) {
+		assert.EqualError(t, errs[0], `line 1, column 1: attribute regoin is not defined, did you mean "region"?`)
+		assert.EqualError(t, errs[1], `line 2, column 1: function dobule is not defined, did you mean "double"?`)
+	}
+
+	// Unknown calls are reported whatever their arguments
+	for _, src := range []string{
+		"a = lenght(\"x\")\n",
+		"a = lenght(\"x\", \"y\")\n",
+		"a = lenght([1, 2])\n",
+		"a = upper(lenght(\"x\"))\n",
+	} {
+		_, err := DecodeBytes([]byte(src))
+		assert.ErrorIs(t, err, ErrUnknownFunction, src)
+		assert.EqualError(t, err, `line 1, column 1: function lenght is not defined, did you mean "length"?`, src)
+	}
+}
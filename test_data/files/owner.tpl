@@ -0,0 +1 @@
+owner: ${owner}, server: ${server.name}
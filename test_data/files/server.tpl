@@ -0,0 +1 @@
+server ${name} listens on ${port}
@@ -0,0 +1,222 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"bytes"
+	"io"
+)
+
+// TokenKind is the kind of a token of a CAFE document
+type TokenKind int
+
+const (
+	TokenError           TokenKind = iota // Something the lexer doesn't recognize
+	TokenComment                          // A comment, including its slashes
+	TokenInclude                          // An include directive
+	TokenAttribute                        // The name of an attribute, or of a let local variable
+	TokenBlockStart                       // The name of a block
+	TokenBlockEnd                         // The closing brace of a block
+	TokenReference                        // A reference to an attribute
+	TokenString                           // A string, including its quotes
+	TokenMultilineString                  // A string continued in the next lines
+	TokenInt                              // An integer
+	TokenFloat                            // A floating point
+	TokenBool                             // true or false
+	TokenArrayStart                       // The opening bracket of an array
+	TokenArrayEnd                         // The closing bracket of an array
+	TokenArrayElement                     // An element of an array
+	TokenArithmetic                       // An arithmetic operation
+	TokenComparison                       // A comparison
+	TokenCondition                        // An if condition
+	TokenFunction                         // A function call
+	TokenUse                              // The use of a template
+)
+
+// Token kinds by the kinds of the items of the lexer
+var tokenKinds = map[keyKind]TokenKind{
+	keyError:       TokenError,
+	keyComment:     TokenComment,
+	keyInclude:     TokenInclude,
+	keyAttrDef:     TokenAttribute,
+	keyBlockStart:  TokenBlockStart,
+	keyBlockEnd:    TokenBlockEnd,
+	keyAttrCall:    TokenReference,
+	keyString:      TokenString,
+	keyMultiString: TokenMultilineString,
+	keyInt:         TokenInt,
+	keyFloat:       TokenFloat,
+	keyBool:        TokenBool,
+	keyArrayStart:  TokenArrayStart,
+	keyArrayEnd:    TokenArrayEnd,
+	keyArrayElem:   TokenArrayElement,
+	keyArithmetic:  TokenArithmetic,
+	keyComparison:  TokenComparison,
+	keyCondition:   TokenCondition,
+	keyFunction:    TokenFunction,
+	keyUse:         TokenUse,
+}
+
+// Text of the tokens the lexer keeps no value for
+var delimiters = map[TokenKind]string{
+	TokenBlockEnd:   "}",
+	TokenArrayStart: "[",
+	TokenArrayEnd:   "]",
+}
+
+func (k TokenKind) String() string {
+	switch k {
+	case TokenError:
+		return "error"
+	case TokenComment:
+		return "comment"
+	case TokenInclude:
+		return "include"
+	case TokenAttribute:
+		return "attribute"
+	case TokenBlockStart:
+		return "block start"
+	case TokenBlockEnd:
+		return "block end"
+	case TokenReference:
+		return "reference"
+	case TokenString:
+		return "string"
+	case TokenMultilineString:
+		return "multiline string"
+	case TokenInt:
+		return "int"
+	case TokenFloat:
+		return "float"
+	case TokenBool:
+		return "boolean"
+	case TokenArrayStart:
+		return "array start"
+	case TokenArrayEnd:
+		return "array end"
+	case TokenArrayElement:
+		return "array element"
+	case TokenArithmetic:
+		return "arithmetic operation"
+	case TokenComparison:
+		return "comparison"
+	case TokenCondition:
+		return "condition"
+	case TokenFunction:
+		return "function"
+	case TokenUse:
+		return "use"
+	default:
+		return "unknown"
+	}
+}
+
+// Token is a piece of a CAFE document, as split by the lexer
+type Token struct {
+	// Kind of the token
+	Kind TokenKind
+
+	// Text of the token, without the whitespace around it
+	Value string
+
+	// Where the token is in the document
+	Range Range
+}
+
+// Lex splits a CAFE document into its tokens, in the order they appear,
+// without evaluating them
+// Syntax highlighters, formatters and editor plugins can work on the
+// tokens of documents that can't be decoded
+// Like SafeDecode, a document the lexer can't split is returned as a
+// *ParseError instead of a panic
+func Lex(src []byte) (tokens []Token, err error) {
+	input, err := readRunes(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	tokens = []Token{}
+	if len(input) == 0 {
+		return tokens, nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			parseErr, isParseErr := r.(*ParseError)
+			if !isParseErr {
+				panic(r)
+			}
+			tokens, err = nil, parseErr
+		}
+	}()
+
+	lx := newLexer(input)
+	lx.callPrefixes = functionCallPrefixes(nil)
+	lx.lexInput(nil)
+	return itemTokens(tokens, lx.items), nil
+}
+
+// LexReader works like Lex, but reads the document from r in chunks and
+// passes its tokens to fn as they're lexed, so very large documents are
+// never held in memory as a whole
+// Lexing stops at the first error returned by fn
+func LexReader(r io.Reader, fn func(Token) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			parseErr, isParseErr := r.(*ParseError)
+			if !isParseErr {
+				panic(r)
+			}
+			err = parseErr
+		}
+	}()
+
+	prefixes := functionCallPrefixes(nil)
+	setup := func(lx *lexer) {
+		lx.callPrefixes = prefixes
+	}
+	emit := func(items []item, _ []string) error {
+		for _, token := range itemTokens(nil, items) {
+			if err := fn(token); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return lexReader(r, readChunkSize, setup, emit, nil)
+}
+
+// Appends the tokens of the items of the lexer to a slice
+func itemTokens(tokens []Token, items []item) []Token {
+	for _, it := range items {
+		if token, isToken := itemToken(it); isToken {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// Returns the token of an item of the lexer, false for the items that
+// aren't tokens
+func itemToken(it item) (Token, bool) {
+	kind, isToken := tokenKinds[it.kind]
+	if !isToken {
+		return Token{}, false
+	}
+	value := it.value
+	if value == "" {
+		value = delimiters[kind]
+	}
+	return Token{Kind: kind, Value: value, Range: itemsRange(it, it)}, true
+}
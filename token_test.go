@@ -0,0 +1,160 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLexTokens(t *testing.T) {
+	tokens, err := Lex([]byte("/// Port\nport = 8080\nserver {\n    hosts = [\"a\", \"b\"]\n    up = upper(name) // web\n}\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Token{
+		{Kind: TokenComment, Value: "/// Port", Range: Range{Position{1, 1}, Position{1, 9}}},
+		{Kind: TokenAttribute, Value: "port", Range: Range{Position{2, 1}, Position{2, 5}}},
+		{Kind: TokenInt, Value: "8080", Range: Range{Position{2, 8}, Position{2, 12}}},
+		{Kind: TokenBlockStart, Value: "server", Range: Range{Position{3, 1}, Position{3, 7}}},
+		{Kind: TokenAttribute, Value: "hosts", Range: Range{Position{4, 5}, Position{4, 10}}},
+		{Kind: TokenArrayStart, Value: "[", Range: Range{Position{4, 13}, Position{4, 14}}},
+		{Kind: TokenArrayElement, Value: `"a"`, Range: Range{Position{4, 14}, Position{4, 17}}},
+		{Kind: TokenArrayElement, Value: `"b"`, Range: Range{Position{4, 19}, Position{4, 22}}},
+		{Kind: TokenArrayEnd, Value: "]", Range: Range{Position{4, 22}, Position{4, 23}}},
+		{Kind: TokenAttribute, Value: "up", Range: Range{Position{5, 5}, Position{5, 7}}},
+		{Kind: TokenFunction, Value: "upper(name)", Range: Range{Position{5, 10}, Position{5, 21}}},
+		{Kind: TokenComment, Value: "// web", Range: Range{Position{5, 22}, Position{5, 28}}},
+		{Kind: TokenBlockEnd, Value: "}", Range: Range{Position{6, 1}, Position{6, 2}}},
+	}, tokens)
+	assert.Equal(t, "block start", TokenBlockStart.String())
+
+	// Documents that can't be decoded are still split
+	tokens, err = Lex([]byte("port = 80\nport = undefined\n"))
+	assert.NoError(t, err)
+	assert.Len(t, tokens, 4)
+	assert.Equal(t, TokenReference, tokens[3].Kind)
+
+	tokens, err = Lex(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, tokens)
+
+	// Indented documents
+	tokens, err = Lex([]byte("    port = 80\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Token{
+		{Kind: TokenAttribute, Value: "port", Range: Range{Position{1, 5}, Position{1, 9}}},
+		{Kind: TokenInt, Value: "80", Range: Range{Position{1, 12}, Position{1, 14}}},
+	}, tokens)
+
+	_, err = Lex([]byte("a = \"x\" \\\n"))
+	assert.ErrorIs(t, err, ErrUnclosedString)
+}
+
+func TestLexArrayBlocks(t *testing.T) {
+	// The statements of blocks of arrays keep their positions
+	tokens, err := Lex([]byte("e = [{ a = 1, b = \"x\" },\n    {\n        c = true\n    }]\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Token{
+		{Kind: TokenAttribute, Value: "e", Range: Range{Position{1, 1}, Position{1, 2}}},
+		{Kind: TokenArrayStart, Value: "[", Range: Range{Position{1, 5}, Position{1, 6}}},
+		{Kind: TokenBlockStart, Value: "{", Range: Range{Position{1, 6}, Position{1, 7}}},
+		{Kind: TokenAttribute, Value: "a", Range: Range{Position{1, 8}, Position{1, 9}}},
+		{Kind: TokenInt, Value: "1", Range: Range{Position{1, 12}, Position{1, 13}}},
+		{Kind: TokenAttribute, Value: "b", Range: Range{Position{1, 15}, Position{1, 16}}},
+		{Kind: TokenString, Value: `"x"`, Range: Range{Position{1, 19}, Position{1, 22}}},
+		{Kind: TokenBlockEnd, Value: "}", Range: Range{Position{1, 23}, Position{1, 24}}},
+		{Kind: TokenBlockStart, Value: "{", Range: Range{Position{2, 5}, Position{2, 6}}},
+		{Kind: TokenAttribute, Value: "c", Range: Range{Position{3, 9}, Position{3, 10}}},
+		{Kind: TokenBool, Value: "true", Range: Range{Position{3, 13}, Position{3, 17}}},
+		{Kind: TokenBlockEnd, Value: "}", Range: Range{Position{4, 5}, Position{4, 6}}},
+		{Kind: TokenArrayEnd, Value: "]", Range: Range{Position{4, 6}, Position{4, 7}}},
+	}, tokens)
+}
+
+func TestLexInlineBlocks(t *testing.T) {
+	// The statements of blocks written in a line keep their positions
+	tokens, err := Lex([]byte("server { port = 80, tls { on = true } }\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Token{
+		{Kind: TokenBlockStart, Value: "server", Range: Range{Position{1, 1}, Position{1, 7}}},
+		{Kind: TokenAttribute, Value: "port", Range: Range{Position{1, 10}, Position{1, 14}}},
+		{Kind: TokenInt, Value: "80", Range: Range{Position{1, 17}, Position{1, 19}}},
+		{Kind: TokenBlockStart, Value: "tls", Range: Range{Position{1, 21}, Position{1, 24}}},
+		{Kind: TokenAttribute, Value: "on", Range: Range{Position{1, 27}, Position{1, 29}}},
+		{Kind: TokenBool, Value: "true", Range: Range{Position{1, 32}, Position{1, 36}}},
+		{Kind: TokenBlockEnd, Value: "}", Range: Range{Position{1, 37}, Position{1, 38}}},
+		{Kind: TokenBlockEnd, Value: "}", Range: Range{Position{1, 39}, Position{1, 40}}},
+	}, tokens)
+}
+
+func TestLexUnicode(t *testing.T) {
+	// Columns count runes, not bytes
+	tokens, err := Lex([]byte("名前 = \"café 🎉\" // コメント\nsérie = 1\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Token{
+		{Kind: TokenAttribute, Value: "名前", Range: Range{Position{1, 1}, Position{1, 3}}},
+		{Kind: TokenString, Value: `"café 🎉"`, Range: Range{Position{1, 6}, Position{1, 14}}},
+		{Kind: TokenComment, Value: "// コメント", Range: Range{Position{1, 15}, Position{1, 22}}},
+		{Kind: TokenAttribute, Value: "série", Range: Range{Position{2, 1}, Position{2, 6}}},
+		{Kind: TokenInt, Value: "1", Range: Range{Position{2, 9}, Position{2, 10}}},
+	}, tokens)
+}
+
+func TestLexReader(t *testing.T) {
+	src := generateServers(1000)
+	expected, err := Lex(src)
+	assert.NoError(t, err)
+
+	tokens := []Token{}
+	err = LexReader(bytes.NewReader(src), func(token Token) error {
+		tokens = append(tokens, token)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, tokens)
+
+	// Lexing stops at the first error of fn
+	stop := errors.New("stop")
+	count := 0
+	err = LexReader(bytes.NewReader(src), func(Token) error {
+		count++
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, count)
+
+	err = LexReader(strings.NewReader("a = \"x\" \\\n"), func(Token) error { return nil })
+	assert.ErrorIs(t, err, ErrUnclosedString)
+}
+
+// Any input is either lexed or rejected with a *ParseError, whole or in
+// chunks
+func FuzzLex(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, src []byte) {
+		_, err := Lex(src)
+		var parseErr *ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			t.Fatalf("lexing %q failed with %v", src, err)
+		}
+		err = LexReader(bytes.NewReader(src), func(Token) error { return nil })
+		if err != nil && !errors.As(err, &parseErr) {
+			t.Fatalf("lexing %q in chunks failed with %v", src, err)
+		}
+	})
+}
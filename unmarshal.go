@@ -0,0 +1,800 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// UnmarshalError is returned by Unmarshal when an attribute or a block can't
+// be stored in its field, or doesn't pass its validations
+type UnmarshalError struct {
+	// File the attribute or block was decoded from, if decoding a file
+	File string
+
+	// Path of the attribute or block, as in "block.nested.name"
+	Path string
+
+	// Line of the definition, 0 if unknown
+	Line int
+
+	// What went wrong
+	Err error
+}
+
+func (e *UnmarshalError) Error() string {
+	msg := e.Err.Error()
+	if e.Path != "" {
+		msg = e.Path + ": " + msg
+	}
+	if e.Line > 0 {
+		msg = fmt.Sprintf("line %d: %s", e.Line, msg)
+	}
+	if e.File != "" {
+		return e.File + ": " + msg
+	}
+	return msg
+}
+
+func (e *UnmarshalError) Unwrap() error {
+	return e.Err
+}
+
+// Unmarshaler is implemented by types that decode the values of their
+// attributes or blocks themselves, like enums and IDs
+// UnmarshalCAFE is called with the value of the attribute, or the
+// JSON-like map of the block
+type Unmarshaler interface {
+	UnmarshalCAFE(value Value) error
+}
+
+// DecodeHook transforms the value of an attribute or block before Unmarshal
+// stores it in a field, or in an element of an array or map, of the target
+// type, returning the value to store instead
+// The kind is the one of the value: reflect.String, Int, Float64 or Bool,
+// reflect.Slice for arrays, reflect.Map for blocks, and reflect.Invalid for
+// null. Hooks of pointer fields get the type they point to
+// Values the hook doesn't handle should be returned as they are
+type DecodeHook func(kind reflect.Kind, value Value, target reflect.Type) (Value, error)
+
+// Unmarshal decodes a CAFE document and stores it in the struct pointed to
+// by v, see Parser.Unmarshal
+func Unmarshal(src []byte, v interface{}, opts ...Option) error {
+	p, err := DecodeBytes(src, opts...)
+	if err != nil {
+		return err
+	}
+	return p.Unmarshal(v)
+}
+
+// Unmarshal stores the document in the struct pointed to by v
+//
+// Attributes and blocks are stored in the fields named by their `cafe` tag,
+// as in `cafe:"max_conns"`, or else in the field with the same name, case
+// insensitively, or named after the field by the WithKeyNaming option the
+// document was decoded with. Fields tagged with `cafe:"-"` are skipped.
+// The tag can be followed by options:
+//
+//	required   the attribute or block must be defined
+//	omitempty  Marshal skips the field when it's empty
+//	squash     the fields of the struct, or pointer to one, are stored in the
+//	           block of the field instead of a nested block, like `inline`
+//	remain     the map, like a map[string]interface{}, stores the attributes
+//	           and blocks no other field of the struct stores
+//	default=v  value of the field when the attribute isn't defined, even if
+//	           it's defined with a zero value. It must be the last option,
+//	           and arrays separate their elements with spaces
+//
+// Blocks can be stored in structs, maps and pointers to them, and
+// attributes in fields of a compatible type: ints can be stored in floats,
+// but floats are only stored in ints when they have an integral value.
+// Attributes and blocks without a field are ignored, unless the document
+// was decoded with WithDisallowUnknownKeys.
+//
+// Values go through the hooks of the WithDecodeHook option first.
+// Strings are converted to time.Duration, as in "1h30m", time.Time, as RFC
+// 3339 timestamps or dates, net.IP and url.URL fields. Fields of types
+// implementing Unmarshaler decode their values themselves, and so do the
+// ones implementing encoding.TextUnmarshaler with the values of string
+// attributes.
+//
+// Decoded structs implementing Validator are validated, and so are the
+// fields with a `validate` tag.
+func (p *Parser) Unmarshal(v interface{}) error {
+	return p.unmarshalRoot("Unmarshal", nil, p.Attributes, p.Blocks, v)
+}
+
+// DecodeBlock stores the block at a path, as in "database" or "services.db",
+// in the struct pointed to by v, the same way Unmarshal stores the whole
+// document
+// Paths in errors and metadata are the full paths of the attributes
+// Along with WithOnlyBlocks, large shared documents are decoded only as far
+// as the blocks a program uses
+func (p *Parser) DecodeBlock(path string, v interface{}) error {
+	names, err := mutationPath(path)
+	if err != nil {
+		return fmt.Errorf("cafe: %w", err)
+	}
+	attributes, blocks, err := p.bodyAt(names, false)
+	if err != nil {
+		return fmt.Errorf("cafe: %w", err)
+	}
+	return p.unmarshalRoot("DecodeBlock", names, attributes, blocks, v)
+}
+
+// Stores the body of the block at path, or of the document, in the struct
+// pointed to by v
+func (p *Parser) unmarshalRoot(caller string, path Path, attributes map[string]Attribute, blocks map[string]Block, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("cafe: %s needs a non-nil pointer to a struct, got %T", caller, v)
+	}
+	md := p.opts.metadata
+	if md != nil {
+		*md = Metadata{Decoded: []string{}, Unused: []string{}, TypeMismatches: []*UnmarshalError{}}
+	}
+	if err := p.unmarshalBody(path, attributes, blocks, rv.Elem()); err != nil {
+		return err
+	}
+	if md != nil {
+		sort.Strings(md.Decoded)
+		sort.Strings(md.Unused)
+		if len(md.TypeMismatches) > 0 {
+			return md.TypeMismatches[0]
+		}
+	}
+	return nil
+}
+
+// Metadata reports what Unmarshal did with the attributes and blocks of a
+// document, so applications can log the keys they ignore, see WithMetadata
+type Metadata struct {
+	// Paths of the attributes and blocks stored in a field or a map, sorted
+	Decoded []string
+
+	// Paths of the attributes and blocks no field stores, sorted
+	// The attributes and blocks of an unused block aren't listed
+	Unused []string
+
+	// Values that don't fit their fields, which are left as they were, in
+	// the order they were found
+	TypeMismatches []*UnmarshalError
+}
+
+// Records the path of an attribute or block stored in a field or a map in
+// the metadata of the unmarshalling, if any
+func (p *Parser) recordDecoded(path Path) {
+	if md := p.opts.metadata; md != nil {
+		md.Decoded = append(md.Decoded, path.String())
+	}
+}
+
+// Returns the UnmarshalError of a value that doesn't fit its field
+// With metadata, the error is recorded instead, so the other values are
+// still stored and every mismatch is reported
+func (p *Parser) typeMismatch(path Path, err error) error {
+	mismatch := p.unmarshalError(path, err)
+	if md := p.opts.metadata; md != nil {
+		md.TypeMismatches = append(md.TypeMismatches, mismatch.(*UnmarshalError))
+		return nil
+	}
+	return mismatch
+}
+
+// Stores the attributes and blocks of a body in a struct or a map
+func (p *Parser) unmarshalBody(path Path, attributes map[string]Attribute, blocks map[string]Block, rv reflect.Value) error {
+	if len(p.opts.decodeHooks) > 0 && rv.Kind() != reflect.Pointer {
+		value, err := p.runDecodeHooks(path, bodyToJSON(attributes, blocks), rv.Type())
+		if err != nil {
+			return err
+		}
+		obj, isBlock := value.(map[string]interface{})
+		if !isBlock {
+			return p.storeValue(path, value, rv)
+		}
+		attributes, blocks = bodyFromValue(obj)
+	}
+	if unmarshaled, err := p.unmarshalCustom(path, bodyToJSON(attributes, blocks), rv); unmarshaled {
+		return err
+	}
+
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return p.unmarshalBody(path, attributes, blocks, rv.Elem())
+	case reflect.Interface:
+		if rv.NumMethod() == 0 {
+			rv.Set(reflect.ValueOf(bodyToJSON(attributes, blocks)))
+			return nil
+		}
+	case reflect.Map:
+		if rv.Type().Key().Kind() == reflect.String {
+			return p.unmarshalMap(path, attributes, blocks, rv)
+		}
+	case reflect.Struct:
+		return p.unmarshalStruct(path, attributes, blocks, rv)
+	}
+	return p.typeMismatch(path, fmt.Errorf("can't store a block in a %s", rv.Type()))
+}
+
+// Stores the attributes and blocks of a body in the fields of a struct
+func (p *Parser) unmarshalStruct(path Path, attributes map[string]Attribute, blocks map[string]Block, rv reflect.Value) error {
+	used := map[string]bool{}
+	var remain reflect.Value
+	if err := p.unmarshalFields(path, attributes, blocks, rv, used, &remain); err != nil {
+		return err
+	}
+
+	// The remain field stores everything the other fields don't
+	if remain.IsValid() {
+		remainingAttributes := map[string]Attribute{}
+		remainingBlocks := map[string]Block{}
+		for name, attr := range attributes {
+			if !used[name] {
+				remainingAttributes[name] = attr
+			}
+		}
+		for name, b := range blocks {
+			if !used[name] {
+				remainingBlocks[name] = b
+			}
+		}
+		if len(remainingAttributes) > 0 || len(remainingBlocks) > 0 {
+			return p.unmarshalMap(path, remainingAttributes, remainingBlocks, remain)
+		}
+		return nil
+	}
+	if !p.opts.disallowUnknownKeys && p.opts.metadata == nil {
+		return nil
+	}
+	for _, name := range p.orderedNames(path, attributes, blocks) {
+		if used[name] {
+			continue
+		}
+		if !p.opts.disallowUnknownKeys {
+			p.opts.metadata.Unused = append(p.opts.metadata.Unused, childPath(path, name).String())
+			continue
+		}
+		kind := "attribute"
+		if _, isBlock := blocks[name]; isBlock {
+			kind = "block"
+		}
+		return p.unmarshalError(childPath(path, name), fmt.Errorf("%w, no field stores the %s", ErrUnknownKey, kind))
+	}
+	return nil
+}
+
+// Stores the attributes and blocks of a body in the fields of a struct,
+// including the ones of its squashed structs, recording the names stored
+// and the remain field
+func (p *Parser) unmarshalFields(path Path, attributes map[string]Attribute, blocks map[string]Block, rv reflect.Value, used map[string]bool, remain *reflect.Value) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		tag, ok := parseFieldTag(field, p.opts.naming)
+		if !ok {
+			continue
+		}
+		if tag.squash {
+			fieldValue, err := squashedStruct(field, rv.Field(i))
+			if err != nil {
+				return p.unmarshalError(path, err)
+			}
+			if err := p.unmarshalFields(path, attributes, blocks, fieldValue, used, remain); err != nil {
+				return err
+			}
+			continue
+		}
+		if tag.remain {
+			if err := remainMap(field); err != nil {
+				return p.unmarshalError(path, err)
+			}
+			*remain = rv.Field(i)
+			continue
+		}
+
+		attrName, isAttr := findName(attributes, tag.name)
+		blockName, isBlock := findName(blocks, tag.name)
+		if isAttr {
+			used[attrName] = true
+		}
+		if isBlock {
+			used[blockName] = true
+		}
+		switch {
+		case !isAttr && !isBlock && tag.required:
+			return p.unmarshalError(childPath(path, tag.name), errors.New("required attribute is missing"))
+		case !isAttr && !isBlock && tag.hasDefault:
+			if err := unmarshalDefault(tag.defaultValue, rv.Field(i), p.opts); err != nil {
+				return p.unmarshalError(childPath(path, tag.name), err)
+			}
+		case isAttr:
+			fieldPath := childPath(path, attrName)
+			p.recordDecoded(fieldPath)
+			if err := p.unmarshalValue(fieldPath, attributes[attrName].Value, rv.Field(i)); err != nil {
+				return err
+			}
+			if err := p.validateField(fieldPath, field, rv.Field(i)); err != nil {
+				return err
+			}
+		case isBlock:
+			fieldPath := childPath(path, blockName)
+			p.recordDecoded(fieldPath)
+			b := blocks[blockName]
+			if err := p.unmarshalBody(fieldPath, b.Attributes, b.Blocks, rv.Field(i)); err != nil {
+				return err
+			}
+			if err := p.validateField(fieldPath, field, rv.Field(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return p.validateStruct(path, rv)
+}
+
+// Stores the attributes and blocks of a body in a map
+func (p *Parser) unmarshalMap(path Path, attributes map[string]Attribute, blocks map[string]Block, rv reflect.Value) error {
+	if rv.IsNil() {
+		rv.Set(reflect.MakeMap(rv.Type()))
+	}
+	elemType := rv.Type().Elem()
+	for name, attr := range attributes {
+		elem := reflect.New(elemType).Elem()
+		p.recordDecoded(childPath(path, name))
+		if err := p.unmarshalValue(childPath(path, name), attr.Value, elem); err != nil {
+			return err
+		}
+		rv.SetMapIndex(reflect.ValueOf(name).Convert(rv.Type().Key()), elem)
+	}
+	for name, b := range blocks {
+		elem := reflect.New(elemType).Elem()
+		p.recordDecoded(childPath(path, name))
+		if err := p.unmarshalBody(childPath(path, name), b.Attributes, b.Blocks, elem); err != nil {
+			return err
+		}
+		rv.SetMapIndex(reflect.ValueOf(name).Convert(rv.Type().Key()), elem)
+	}
+	return nil
+}
+
+// Stores the value of an attribute in a field
+func (p *Parser) unmarshalValue(path Path, value interface{}, rv reflect.Value) error {
+	value, err := p.runDecodeHooks(path, value, rv.Type())
+	if err != nil {
+		return err
+	}
+	return p.storeValue(path, value, rv)
+}
+
+// Runs the decode hooks on a value stored in a field of the target type
+func (p *Parser) runDecodeHooks(path Path, value interface{}, target reflect.Type) (interface{}, error) {
+	if target.Kind() == reflect.Pointer {
+		return value, nil
+	}
+	for _, hook := range p.opts.decodeHooks {
+		kind := reflect.Invalid
+		if value != nil {
+			kind = reflect.TypeOf(value).Kind()
+		}
+		hooked, err := hook(kind, value, target)
+		if err != nil {
+			return nil, p.unmarshalError(path, err)
+		}
+		value = hooked
+	}
+	return value, nil
+}
+
+// Stores a value, once through the decode hooks, in a field
+func (p *Parser) storeValue(path Path, value interface{}, rv reflect.Value) error {
+	if value == nil {
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+
+	if parse, isText := textTypes[rv.Type()]; isText {
+		if str, isString := value.(string); isString {
+			parsed, err := parse(str)
+			if err != nil {
+				return p.unmarshalError(path, err)
+			}
+			rv.Set(reflect.ValueOf(parsed))
+			return nil
+		}
+	}
+	if unmarshaled, err := p.unmarshalCustom(path, value, rv); unmarshaled {
+		return err
+	}
+
+	mismatch := func() error {
+		return p.typeMismatch(path, fmt.Errorf("can't store %v (%s) in a %s", value, valueKindName(value), rv.Type()))
+	}
+
+	switch rv.Kind() {
+	case reflect.Pointer:
+		elem := reflect.New(rv.Type().Elem())
+		if err := p.unmarshalValue(path, value, elem.Elem()); err != nil {
+			return err
+		}
+		rv.Set(elem)
+	case reflect.Interface:
+		if rv.NumMethod() != 0 {
+			return mismatch()
+		}
+		rv.Set(reflect.ValueOf(value))
+	case reflect.String:
+		str, ok := value.(string)
+		if !ok {
+			return mismatch()
+		}
+		rv.SetString(str)
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return mismatch()
+		}
+		rv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := integralValue(value)
+		if !ok || rv.OverflowInt(n) {
+			return mismatch()
+		}
+		rv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := integralValue(value)
+		if !ok || n < 0 || rv.OverflowUint(uint64(n)) {
+			return mismatch()
+		}
+		rv.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		f, ok := toFloat(value)
+		if !ok || rv.OverflowFloat(f) {
+			return mismatch()
+		}
+		rv.SetFloat(f)
+	case reflect.Slice, reflect.Array:
+		array, ok := value.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		if rv.Kind() == reflect.Array && rv.Len() != len(array) {
+			return p.typeMismatch(path, fmt.Errorf("can't store an array of %d elements in a %s", len(array), rv.Type()))
+		}
+		if rv.Kind() == reflect.Slice {
+			rv.Set(reflect.MakeSlice(rv.Type(), len(array), len(array)))
+		}
+		for i, elem := range array {
+			if err := p.unmarshalValue(append(append(Path{}, path...), fmt.Sprint(i)), elem, rv.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map, reflect.Struct:
+		// Values of blocks referenced by an attribute
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		attributes, blocks := bodyFromValue(obj)
+		return p.unmarshalBody(path, attributes, blocks, rv)
+	default:
+		return mismatch()
+	}
+	return nil
+}
+
+// Types of the standard library converted from strings, with the functions
+// parsing them
+var textTypes = map[reflect.Type]func(text string) (interface{}, error){
+	reflect.TypeOf(time.Duration(0)): func(text string) (interface{}, error) {
+		duration, err := time.ParseDuration(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %q, expected a duration like \"1h30m\"", text)
+		}
+		return duration, nil
+	},
+	reflect.TypeOf(time.Time{}): func(text string) (interface{}, error) {
+		if timestamp, err := time.Parse(time.RFC3339, text); err == nil {
+			return timestamp, nil
+		}
+		if date, err := time.Parse(time.DateOnly, text); err == nil {
+			return date, nil
+		}
+		return nil, fmt.Errorf("invalid time %q, expected an RFC 3339 timestamp like \"2006-01-02T15:04:05Z\" or a date like \"2006-01-02\"", text)
+	},
+	reflect.TypeOf(net.IP{}): func(text string) (interface{}, error) {
+		ip := net.ParseIP(text)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", text)
+		}
+		return ip, nil
+	},
+	reflect.TypeOf(url.URL{}): func(text string) (interface{}, error) {
+		u, err := url.Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL %q: %w", text, errors.Unwrap(err))
+		}
+		return *u, nil
+	},
+}
+
+// Stores a value in a field whose type decodes it itself, reporting if it
+// does: Unmarshaler types decode every value, and TextUnmarshaler types
+// decode strings
+func (p *Parser) unmarshalCustom(path Path, value interface{}, rv reflect.Value) (bool, error) {
+	if !rv.CanAddr() {
+		return false, nil
+	}
+
+	var err error
+	switch unmarshaler := rv.Addr().Interface().(type) {
+	case Unmarshaler:
+		err = unmarshaler.UnmarshalCAFE(value)
+	case encoding.TextUnmarshaler:
+		str, isString := value.(string)
+		if !isString {
+			return false, nil
+		}
+		err = unmarshaler.UnmarshalText([]byte(str))
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return true, p.unmarshalError(path, err)
+	}
+	return true, nil
+}
+
+// Splits the value of a block back into its attributes and blocks
+func bodyFromValue(obj map[string]interface{}) (map[string]Attribute, map[string]Block) {
+	attributes := map[string]Attribute{}
+	blocks := map[string]Block{}
+	for name, member := range obj {
+		if nested, isBlock := member.(map[string]interface{}); isBlock {
+			nestedAttributes, nestedBlocks := bodyFromValue(nested)
+			blocks[name] = Block{Name: name, Attributes: nestedAttributes, Blocks: nestedBlocks}
+			continue
+		}
+		attributes[name] = Attribute{Name: name, Value: member}
+	}
+	return attributes, blocks
+}
+
+// Returns the value of an int, or of a float with an integral value
+func integralValue(value interface{}) (int64, bool) {
+	switch val := value.(type) {
+	case int:
+		return int64(val), true
+	case float64:
+		if val == math.Trunc(val) && val >= math.MinInt64 && val <= math.MaxInt64 {
+			return int64(val), true
+		}
+	}
+	return 0, false
+}
+
+// Returns the name of the kind of a value, as written in errors
+func valueKindName(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case int:
+		return "int"
+	case float64:
+		return "float"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "block"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// fieldTag holds the `cafe` tag of a field
+type fieldTag struct {
+	// Name of the attribute or block stored in the field
+	name string
+
+	// The attribute or block must be defined
+	required bool
+
+	// Marshal skips the field when it's empty
+	omitEmpty bool
+
+	// The fields of the struct belong to the block of the field
+	squash bool
+
+	// The map stores the attributes and blocks no other field stores
+	remain bool
+
+	// Value of the field when the attribute isn't defined
+	defaultValue string
+	hasDefault   bool
+}
+
+// Parses the `cafe` tag of a field, false if the field isn't stored
+func parseFieldTag(field reflect.StructField, naming KeyNaming) (fieldTag, bool) {
+	name, options, _ := strings.Cut(field.Tag.Get("cafe"), ",")
+	if name == "-" {
+		return fieldTag{}, false
+	}
+
+	tag := fieldTag{name: name}
+	if tag.name == "" {
+		tag.name = fieldKey(field.Name, naming)
+	}
+	for options != "" {
+		var option string
+		if strings.HasPrefix(options, "default=") {
+			// Defaults can hold commas
+			option, options = options, ""
+		} else {
+			option, options, _ = strings.Cut(options, ",")
+		}
+
+		switch {
+		case option == "required":
+			tag.required = true
+		case option == "omitempty":
+			tag.omitEmpty = true
+		case option == "squash" || option == "inline":
+			tag.squash = true
+		case option == "remain":
+			tag.remain = true
+		case strings.HasPrefix(option, "default="):
+			tag.defaultValue = strings.TrimPrefix(option, "default=")
+			tag.hasDefault = true
+		}
+	}
+
+	// Unexported embedded structs can only be squashed, their exported
+	// fields are still settable
+	if !field.IsExported() && !(tag.squash && field.Anonymous && field.Type.Kind() == reflect.Struct) {
+		return fieldTag{}, false
+	}
+	return tag, true
+}
+
+// Returns the name of the attribute or block of a field
+func fieldKey(name string, naming KeyNaming) string {
+	switch naming {
+	case NamingSnakeCase:
+		return strings.ToLower(strings.Join(splitWords(name), "_"))
+	case NamingCamelCase:
+		words := splitWords(name)
+		for i, word := range words {
+			runes := []rune(strings.ToLower(word))
+			if i > 0 {
+				runes[0] = unicode.ToUpper(runes[0])
+			}
+			words[i] = string(runes)
+		}
+		return strings.Join(words, "")
+	default:
+		return name
+	}
+}
+
+// Splits a Go name into its words, keeping acronyms together, as in "HTTP"
+// and "Server" for HTTPServer
+func splitWords(name string) []string {
+	runes := []rune(name)
+	words := []string{}
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		prev, curr := runes[i-1], runes[i]
+		acronymEnd := unicode.IsUpper(prev) && unicode.IsUpper(curr) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+		if (unicode.IsUpper(curr) && !unicode.IsUpper(prev)) || acronymEnd || curr == '_' {
+			if word := strings.Trim(string(runes[start:i]), "_"); word != "" {
+				words = append(words, word)
+			}
+			start = i
+		}
+	}
+	if word := strings.Trim(string(runes[start:]), "_"); word != "" {
+		words = append(words, word)
+	}
+	return words
+}
+
+// Returns the struct, allocating the pointer to it if needed, whose fields
+// are squashed into the block of a field
+func squashedStruct(field reflect.StructField, rv reflect.Value) (reflect.Value, error) {
+	if rv.Kind() == reflect.Pointer && rv.Type().Elem().Kind() == reflect.Struct {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("field %s can't be squashed, it's a %s and not a struct", field.Name, field.Type)
+	}
+	return rv, nil
+}
+
+// Checks that a remain field is a map with string keys
+func remainMap(field reflect.StructField) error {
+	if field.Type.Kind() != reflect.Map || field.Type.Key().Kind() != reflect.String {
+		return fmt.Errorf("field %s can't hold the remaining keys, it's a %s and not a map", field.Name, field.Type)
+	}
+	return nil
+}
+
+// Stores the default value of a field, written in its tag
+func unmarshalDefault(text string, rv reflect.Value, o options) error {
+	var value interface{}
+	switch rv.Kind() {
+	case reflect.String:
+		value = text
+	case reflect.Slice, reflect.Array:
+		elems := []interface{}{}
+		for _, elem := range strings.Fields(text) {
+			if rv.Type().Elem().Kind() == reflect.String {
+				elems = append(elems, elem)
+				continue
+			}
+			elems = append(elems, transformValue(elem))
+		}
+		value = elems
+	default:
+		value = transformValue(text)
+	}
+
+	// Defaults aren't defined in the document, their errors have no line,
+	// and they're not part of the metadata
+	o.metadata = nil
+	p := newParserWithOptions(nil, o)
+	if err := p.unmarshalValue(nil, value, rv); err != nil {
+		return errors.Unwrap(err)
+	}
+	return nil
+}
+
+// Finds the name of a map equal to a field name, exactly or else case
+// insensitively
+func findName[V any](m map[string]V, name string) (string, bool) {
+	if _, found := m[name]; found {
+		return name, true
+	}
+	for _, key := range sortedKeys(m) {
+		if strings.EqualFold(key, name) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// Returns an UnmarshalError for the attribute or block at path
+// Elements of arrays point at the definition of their array
+func (p *Parser) unmarshalError(path Path, err error) error {
+	line := 0
+	for i := len(path); i > 0 && line == 0; i-- {
+		line = p.definitionLine(path[:i-1], path[i-1])
+	}
+	return &UnmarshalError{File: p.filename, Path: path.String(), Line: line, Err: err}
+}
@@ -0,0 +1,540 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testUnmarshalTLS struct {
+	Enabled bool
+}
+
+type testUnmarshalServer struct {
+	Host     string
+	MaxConns int `cafe:"max_conns"`
+	TLS      *testUnmarshalTLS
+}
+
+type testUnmarshalConfig struct {
+	Name     string
+	Version  uint8
+	Ratio    float32
+	Debug    bool
+	Tags     []string
+	Ports    [2]int
+	Nothing  *string
+	Server   testUnmarshalServer
+	Limits   map[string]int
+	Defaults map[string]interface{}
+	Ignored  string `cafe:"-"`
+	hidden   string
+}
+
+func TestUnmarshal(t *testing.T) {
+	p, err := Decode("./test_data/test-unmarshal.cafe")
+	assert.NoError(t, err)
+
+	var config testUnmarshalConfig
+	assert.NoError(t, p.Unmarshal(&config))
+	assert.Equal(t, testUnmarshalConfig{
+		Name:    "cafe",
+		Version: 2,
+		Ratio:   1.5,
+		Debug:   true,
+		Tags:    []string{"web", "api"},
+		Ports:   [2]int{80, 443},
+		Server: testUnmarshalServer{
+			Host:     "localhost",
+			MaxConns: 100,
+			TLS:      &testUnmarshalTLS{Enabled: true},
+		},
+		Limits: map[string]int{"cpu": 2, "memory": 512},
+		Defaults: map[string]interface{}{
+			"host":      "localhost",
+			"max_conns": 100,
+			"tls":       map[string]interface{}{"enabled": true},
+		},
+	}, config)
+
+	// Values that don't fit their fields point at their definition
+	var wrongType struct {
+		Server struct {
+			Host int
+		}
+	}
+	err = p.Unmarshal(&wrongType)
+	assert.EqualError(t, err, "./test_data/test-unmarshal.cafe: line 9: server.host: can't store localhost (string) in a int")
+	var unmarshalErr *UnmarshalError
+	assert.ErrorAs(t, err, &unmarshalErr)
+	assert.Equal(t, 9, unmarshalErr.Line)
+
+	var overflow struct {
+		Server struct {
+			MaxConns int8 `cafe:"max_conns"`
+		}
+	}
+	assert.NoError(t, p.Unmarshal(&overflow))
+	assert.Equal(t, int8(100), overflow.Server.MaxConns)
+	var tooSmall struct {
+		Ports []int8
+	}
+	assert.EqualError(t, p.Unmarshal(&tooSmall), "./test_data/test-unmarshal.cafe: line 6: ports.1: can't store 443 (int) in a int8")
+	var wrongLength struct {
+		Ports [3]int
+	}
+	assert.EqualError(t, p.Unmarshal(&wrongLength), "./test_data/test-unmarshal.cafe: line 6: ports: can't store an array of 2 elements in a [3]int")
+
+	assert.Error(t, p.Unmarshal(config))
+}
+
+func TestUnmarshalBytes(t *testing.T) {
+	var config struct {
+		Port  int
+		Ratio float64
+	}
+	assert.NoError(t, Unmarshal([]byte("port = 8080\nratio = 2\n"), &config))
+	assert.Equal(t, 8080, config.Port)
+	assert.Equal(t, 2.0, config.Ratio)
+
+	err := Unmarshal([]byte("port = 80.5\n"), &config)
+	assert.EqualError(t, err, "line 1: port: can't store 80.5 (float) in a int")
+}
+
+func TestUnmarshalTags(t *testing.T) {
+	type server struct {
+		Host    string   `cafe:"host,default=localhost"`
+		Port    int      `cafe:"port,required"`
+		Timeout float64  `cafe:"timeout,default=2.5"`
+		Debug   bool     `cafe:"debug,default=true"`
+		Tags    []string `cafe:"tags,default=web api"`
+		Sizes   []int    `cafe:"sizes,default=1 2"`
+		Note    string   `cafe:"note,default=a, b"`
+	}
+	type config struct {
+		Server server `cafe:"server,required"`
+	}
+
+	var c config
+	assert.NoError(t, Unmarshal([]byte("server {\n    port = 8080\n}\n"), &c))
+	assert.Equal(t, server{
+		Host:    "localhost",
+		Port:    8080,
+		Timeout: 2.5,
+		Debug:   true,
+		Tags:    []string{"web", "api"},
+		Sizes:   []int{1, 2},
+		Note:    "a, b",
+	}, c.Server)
+
+	// Defaults are only used for missing attributes, not for zero values
+	c = config{}
+	assert.NoError(t, Unmarshal([]byte("server {\n    port = 0\n    debug = false\n    host = \"\"\n}\n"), &c))
+	assert.Equal(t, 0, c.Server.Port)
+	assert.Equal(t, false, c.Server.Debug)
+	assert.Equal(t, "", c.Server.Host)
+
+	err := Unmarshal([]byte("server {\n    host = \"cafe\"\n}\n"), &config{})
+	assert.EqualError(t, err, "line 1: server.port: required attribute is missing")
+	err = Unmarshal([]byte("name = \"cafe\"\n"), &config{})
+	assert.EqualError(t, err, "server: required attribute is missing")
+
+	var wrongDefault struct {
+		Port int `cafe:"port,default=http"`
+	}
+	assert.EqualError(t, Unmarshal([]byte("name = \"cafe\"\n"), &wrongDefault), "port: can't store http (string) in a int")
+}
+
+type testLevel int
+
+func (l *testLevel) UnmarshalText(text []byte) error {
+	for i, name := range []string{"debug", "info", "error"} {
+		if string(text) == name {
+			*l = testLevel(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown level %q", text)
+}
+
+type testID string
+
+func (id *testID) UnmarshalCAFE(value Value) error {
+	switch v := value.(type) {
+	case string:
+		*id = testID(v)
+	case int:
+		*id = testID(fmt.Sprintf("id-%d", v))
+	case map[string]interface{}:
+		*id = testID(fmt.Sprintf("%v/%v", v["zone"], v["name"]))
+	default:
+		return errors.New("invalid id")
+	}
+	return nil
+}
+
+func TestUnmarshalCustom(t *testing.T) {
+	type config struct {
+		Level    testLevel            `cafe:"level"`
+		Fallback testLevel            `cafe:"fallback,default=error"`
+		Levels   []testLevel          `cafe:"levels"`
+		Optional *testLevel           `cafe:"optional"`
+		Owners   map[string]testLevel `cafe:"owners"`
+		ID       testID               `cafe:"id"`
+		Number   testID               `cafe:"number"`
+		Zone     testID               `cafe:"zone"`
+		Started  time.Time            `cafe:"started"`
+		Address  net.IP               `cafe:"address"`
+	}
+
+	var c config
+	assert.NoError(t, Unmarshal([]byte(strings.Join([]string{
+		`level = "info"`,
+		`levels = ["debug", "error"]`,
+		`optional = "error"`,
+		`owners.web = "debug"`,
+		`id = "a1"`,
+		`number = 7`,
+		`zone {`,
+		`    zone = "eu"`,
+		`    name = "db"`,
+		`}`,
+		`started = "2023-01-02T03:04:05Z"`,
+		`address = "10.0.0.1"`,
+	}, "\n")), &c))
+	optional := testLevel(2)
+	assert.Equal(t, config{
+		Level:    1,
+		Fallback: 2,
+		Levels:   []testLevel{0, 2},
+		Optional: &optional,
+		Owners:   map[string]testLevel{"web": 0},
+		ID:       "a1",
+		Number:   "id-7",
+		Zone:     "eu/db",
+		Started:  time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+		Address:  net.ParseIP("10.0.0.1"),
+	}, c)
+
+	// Errors of the types point at the definition of the attribute
+	err := Unmarshal([]byte("name = \"cafe\"\nlevel = \"trace\"\n"), &config{})
+	assert.EqualError(t, err, `line 2: level: unknown level "trace"`)
+	err = Unmarshal([]byte("id = true\n"), &config{})
+	assert.EqualError(t, err, "line 1: id: invalid id")
+
+	// Text unmarshalers only decode strings
+	err = Unmarshal([]byte("level = 1\n"), &config{})
+	assert.NoError(t, err)
+	err = Unmarshal([]byte("started = 1\n"), &config{})
+	assert.Error(t, err)
+}
+
+func TestUnmarshalStdlib(t *testing.T) {
+	type config struct {
+		Timeout  time.Duration            `cafe:"timeout"`
+		Interval time.Duration            `cafe:"interval,default=30s"`
+		Retries  time.Duration            `cafe:"retries"`
+		Started  time.Time                `cafe:"started"`
+		Expires  *time.Time               `cafe:"expires"`
+		Address  net.IP                   `cafe:"address"`
+		Peers    []net.IP                 `cafe:"peers"`
+		Endpoint url.URL                  `cafe:"endpoint"`
+		Proxy    *url.URL                 `cafe:"proxy"`
+		Backoff  map[string]time.Duration `cafe:"backoff"`
+	}
+
+	var c config
+	assert.NoError(t, Unmarshal([]byte(strings.Join([]string{
+		`timeout = "1h30m"`,
+		`retries = 5`,
+		`started = "2023-01-02T03:04:05+01:00"`,
+		`expires = "2024-06-30"`,
+		`address = "::1"`,
+		`peers = ["10.0.0.1", "10.0.0.2"]`,
+		`endpoint = "https://example.com:8443/api?v=2"`,
+		`proxy = "http://proxy.local"`,
+		`backoff.first = "100ms"`,
+	}, "\n")), &c))
+	assert.Equal(t, 90*time.Minute, c.Timeout)
+	assert.Equal(t, 30*time.Second, c.Interval)
+	assert.Equal(t, time.Duration(5), c.Retries)
+	assert.True(t, c.Started.Equal(time.Date(2023, 1, 2, 2, 4, 5, 0, time.UTC)))
+	assert.Equal(t, time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC), *c.Expires)
+	assert.Equal(t, net.IPv6loopback, c.Address)
+	assert.Equal(t, []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}, c.Peers)
+	assert.Equal(t, "example.com:8443", c.Endpoint.Host)
+	assert.Equal(t, "/api", c.Endpoint.Path)
+	assert.Equal(t, "proxy.local", c.Proxy.Host)
+	assert.Equal(t, map[string]time.Duration{"first": 100 * time.Millisecond}, c.Backoff)
+
+	// Invalid formats point at the definition of the attribute
+	for src, msg := range map[string]string{
+		`timeout = "soon"`:        `line 1: timeout: invalid duration "soon", expected a duration like "1h30m"`,
+		`started = "yesterday"`:   `line 1: started: invalid time "yesterday", expected an RFC 3339 timestamp like "2006-01-02T15:04:05Z" or a date like "2006-01-02"`,
+		`address = "10.0.0"`:      `line 1: address: invalid IP address "10.0.0"`,
+		`endpoint = "http://a b"`: `line 1: endpoint: invalid URL "http://a b": invalid character " " in host name`,
+		`started = 10`:            "line 1: started: can't store 10 (int) in a time.Time",
+	} {
+		assert.EqualError(t, Unmarshal([]byte(src+"\n"), &config{}), msg, src)
+	}
+}
+
+func TestUnmarshalTagOptions(t *testing.T) {
+	type common struct {
+		Region   string
+		MaxConns int
+	}
+	type config struct {
+		common     `cafe:",squash"`
+		*Ownership `cafe:",inline"`
+		HTTPPort   int
+		Server     struct{ ReadTimeout time.Duration }
+	}
+
+	src := []byte("region = \"eu\"\nmax_conns = 10\nowner = \"ops\"\nhttp_port = 80\nserver {\n    read_timeout = \"5s\"\n}\n")
+	var c config
+	assert.NoError(t, Unmarshal(src, &c, WithKeyNaming(NamingSnakeCase)))
+	assert.Equal(t, "eu", c.Region)
+	assert.Equal(t, 10, c.MaxConns)
+	assert.Equal(t, "ops", c.Owner)
+	assert.Equal(t, 80, c.HTTPPort)
+	assert.Equal(t, 5*time.Second, c.Server.ReadTimeout)
+
+	// Without the naming, field names only match case insensitively
+	c = config{}
+	assert.NoError(t, Unmarshal(src, &c))
+	assert.Equal(t, 0, c.MaxConns)
+	c = config{}
+	assert.NoError(t, Unmarshal([]byte("maxConns = 3\nhttpPort = 8080\n"), &c, WithKeyNaming(NamingCamelCase)))
+	assert.Equal(t, 3, c.MaxConns)
+	assert.Equal(t, 8080, c.HTTPPort)
+
+	var wrong struct {
+		Name string `cafe:",squash"`
+	}
+	assert.EqualError(t, Unmarshal(src, &wrong), "field Name can't be squashed, it's a string and not a struct")
+}
+
+type Ownership struct {
+	Owner string
+}
+
+type testByteSize int64
+
+func TestUnmarshalDecodeHooks(t *testing.T) {
+	// Sizes like "10MB" are stored in byte sizes
+	sizes := func(kind reflect.Kind, value Value, target reflect.Type) (Value, error) {
+		if kind != reflect.String || target != reflect.TypeOf(testByteSize(0)) {
+			return value, nil
+		}
+		var n int
+		var unit string
+		if _, err := fmt.Sscanf(value.(string), "%d%s", &n, &unit); err != nil || unit != "MB" {
+			return nil, fmt.Errorf("invalid size %q", value)
+		}
+		return n << 20, nil
+	}
+	// Blocks with a host and a port are stored in strings as addresses
+	addresses := func(kind reflect.Kind, value Value, target reflect.Type) (Value, error) {
+		if kind != reflect.Map || target.Kind() != reflect.String {
+			return value, nil
+		}
+		obj := value.(map[string]interface{})
+		return fmt.Sprintf("%v:%v", obj["host"], obj["port"]), nil
+	}
+	// Hooks run in order, on the values returned by the previous ones
+	trim := func(kind reflect.Kind, value Value, target reflect.Type) (Value, error) {
+		if kind == reflect.String {
+			return strings.TrimSpace(value.(string)), nil
+		}
+		return value, nil
+	}
+
+	type config struct {
+		Cache    testByteSize   `cafe:"cache"`
+		Buffers  []testByteSize `cafe:"buffers"`
+		Limit    *testByteSize  `cafe:"limit"`
+		Fallback testByteSize   `cafe:"fallback,default=1MB"`
+		Upstream string         `cafe:"upstream"`
+		Name     string         `cafe:"name"`
+	}
+
+	src := []byte(strings.Join([]string{
+		`cache = " 10MB "`,
+		`buffers = ["1MB", "2MB"]`,
+		`limit = "4MB"`,
+		`name = " cafe "`,
+		`upstream {`,
+		`    host = "10.0.0.1"`,
+		`    port = 8080`,
+		`}`,
+	}, "\n"))
+	var c config
+	assert.NoError(t, Unmarshal(src, &c, WithDecodeHook(trim, sizes), WithDecodeHook(addresses)))
+	limit := testByteSize(4 << 20)
+	assert.Equal(t, config{
+		Cache:    10 << 20,
+		Buffers:  []testByteSize{1 << 20, 2 << 20},
+		Limit:    &limit,
+		Fallback: 1 << 20,
+		Upstream: "10.0.0.1:8080",
+		Name:     "cafe",
+	}, c)
+
+	// Errors of the hooks point at the definition of the attribute
+	err := Unmarshal([]byte("name = \"cafe\"\ncache = \"lots\"\n"), &config{}, WithDecodeHook(sizes))
+	assert.EqualError(t, err, `line 2: cache: invalid size "lots"`)
+
+	// Without the hooks, the values don't fit their fields
+	assert.Error(t, Unmarshal(src, &config{}))
+}
+
+func TestUnmarshalUnknownKeys(t *testing.T) {
+	type common struct {
+		Region string `cafe:"region"`
+	}
+	type config struct {
+		common `cafe:",squash"`
+		Port   int `cafe:"port"`
+		Server struct {
+			Host string `cafe:"host"`
+		} `cafe:"server"`
+		Labels map[string]string `cafe:"labels"`
+	}
+
+	src := "region = \"eu\"\nport = 80\nserver {\n    host = \"localhost\"\n}\nlabels {\n    team = \"ops\"\n}\n"
+	assert.NoError(t, Unmarshal([]byte(src), &config{}, WithDisallowUnknownKeys()))
+
+	err := Unmarshal([]byte("region = \"eu\"\nprot = 8080\n"), &config{}, WithDisallowUnknownKeys())
+	assert.EqualError(t, err, "line 2: prot: unknown key, no field stores the attribute")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	err = Unmarshal([]byte("server {\n    host = \"localhost\"\n    tls {\n    }\n}\n"), &config{}, WithDisallowUnknownKeys())
+	assert.EqualError(t, err, "line 3: server.tls: unknown key, no field stores the block")
+
+	// Unknown keys are ignored by default
+	assert.NoError(t, Unmarshal([]byte("prot = 8080\n"), &config{}))
+}
+
+func TestUnmarshalRemain(t *testing.T) {
+	type common struct {
+		Region string `cafe:"region"`
+	}
+	type plugin struct {
+		common   `cafe:",squash"`
+		Name     string                 `cafe:"name"`
+		Settings map[string]interface{} `cafe:",remain"`
+	}
+
+	src := "name = \"cache\"\nregion = \"eu\"\nsize = 10\nbackend {\n    host = \"redis\"\n}\n"
+	var p plugin
+	assert.NoError(t, Unmarshal([]byte(src), &p, WithDisallowUnknownKeys()))
+	assert.Equal(t, "cache", p.Name)
+	assert.Equal(t, "eu", p.Region)
+	assert.Equal(t, map[string]interface{}{
+		"size":    10,
+		"backend": map[string]interface{}{"host": "redis"},
+	}, p.Settings)
+
+	// The remaining keys are written back in the block of the struct
+	data, err := Marshal(p)
+	assert.NoError(t, err)
+	assert.Equal(t, "region = \"eu\"\nname = \"cache\"\nbackend {\n    host = \"redis\"\n}\nsize = 10\n", string(data))
+
+	// Nothing remaining leaves the map as it is
+	p = plugin{}
+	assert.NoError(t, Unmarshal([]byte("name = \"cache\"\n"), &p))
+	assert.Nil(t, p.Settings)
+
+	var wrong struct {
+		Settings []string `cafe:",remain"`
+	}
+	assert.EqualError(t, Unmarshal([]byte(src), &wrong), "field Settings can't hold the remaining keys, it's a []string and not a map")
+}
+
+func TestUnmarshalMetadata(t *testing.T) {
+	type config struct {
+		Name   string `cafe:"name"`
+		Port   int    `cafe:"port"`
+		Ratio  int    `cafe:"ratio"`
+		Server struct {
+			Host string `cafe:"host"`
+		} `cafe:"server"`
+		Limits map[string]int `cafe:"limits"`
+		Debug  bool           `cafe:"debug,default=true"`
+	}
+
+	src := strings.Join([]string{
+		`name = "cafe"`,
+		`port = "http"`,
+		`ratio = 1.5`,
+		`prot = 8080`,
+		`server {`,
+		`    host = "localhost"`,
+		`    tls {`,
+		`        cert = "cert.pem"`,
+		`    }`,
+		`}`,
+		`limits.cpu = 2`,
+		`legacy {`,
+		`}`,
+	}, "\n")
+	var c config
+	var md Metadata
+	err := Unmarshal([]byte(src), &c, WithMetadata(&md))
+	assert.EqualError(t, err, `line 2: port: can't store http (string) in a int`)
+
+	// Type mismatches don't stop the unmarshalling
+	assert.Equal(t, "cafe", c.Name)
+	assert.Equal(t, "localhost", c.Server.Host)
+	assert.Equal(t, map[string]int{"cpu": 2}, c.Limits)
+	assert.True(t, c.Debug)
+	assert.Equal(t, []string{"limits", "limits.cpu", "name", "port", "ratio", "server", "server.host"}, md.Decoded)
+	assert.Equal(t, []string{"legacy", "prot", "server.tls"}, md.Unused)
+	assert.Len(t, md.TypeMismatches, 2)
+	assert.Equal(t, "ratio", md.TypeMismatches[1].Path)
+	assert.Equal(t, 3, md.TypeMismatches[1].Line)
+
+	// The metadata is reset by every unmarshalling
+	assert.NoError(t, Unmarshal([]byte("name = \"cafe\"\n"), &c, WithMetadata(&md)))
+	assert.Equal(t, Metadata{Decoded: []string{"name"}, Unused: []string{}, TypeMismatches: []*UnmarshalError{}}, md)
+}
+
+func TestDecodeBlock(t *testing.T) {
+	type database struct {
+		Host string `cafe:"host"`
+		Port int    `cafe:"port,required"`
+	}
+
+	p, err := DecodeBytes([]byte("name = \"cafe\"\nservices {\n    db {\n        host = \"localhost\"\n        port = 5432\n    }\n    cache {\n        host = \"redis\"\n    }\n}\n"))
+	assert.NoError(t, err)
+	var db database
+	assert.NoError(t, p.DecodeBlock("services.db", &db))
+	assert.Equal(t, database{Host: "localhost", Port: 5432}, db)
+
+	// Errors have the full paths of the attributes
+	assert.EqualError(t, p.DecodeBlock("services.cache", &database{}), "line 7: services.cache.port: required attribute is missing")
+	assert.EqualError(t, p.DecodeBlock("services.web", &db), "cafe: block services.web is not defined: undefined name")
+	assert.EqualError(t, p.DecodeBlock("name", &db), "cafe: name is an attribute, not a block: type mismatch")
+	assert.EqualError(t, p.DecodeBlock("services.db", db), "cafe: DecodeBlock needs a non-nil pointer to a struct, got cafe.database")
+}
@@ -15,33 +15,121 @@
 package cafe
 
 import (
-	"bufio"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"unicode/utf8"
 )
 
-// readFile reads the contents of a file into an array of strings
-func readCAFEFile(filepath string) ([]string, error) {
-	if !strings.HasSuffix(filepath, ".cafe") {
-		err := fmt.Errorf("%s is not a .cafe file", filepath)
+// readFile reads the contents of a file into a slice of runes
+func readCAFEFile(filepath string) ([]rune, error) {
+	return options{}.readCAFEFile(filepath)
+}
+
+// readCAFEBytes reads the contents of a file, which must be a .cafe file
+func readCAFEBytes(filepath string) ([]byte, error) {
+	return options{}.readCAFEBytes(filepath)
+}
+
+// Reads the contents of a file of a decoding into a slice of runes
+func (o options) readCAFEFile(name string) ([]rune, error) {
+	data, err := o.readCAFEBytes(name)
+	if err != nil {
 		return nil, err
 	}
+	return []rune(string(data)), nil
+}
 
-	file, err := os.Open(filepath)
-	if err != nil {
+// Reads the contents of a file of a decoding, which must be a .cafe file
+// unless the decoding takes any extension
+func (o options) readCAFEBytes(name string) ([]byte, error) {
+	if !o.anyExtension && !strings.HasSuffix(name, ".cafe") {
+		err := fmt.Errorf("%s is not a .cafe file", name)
 		return nil, err
 	}
-	defer file.Close()
+	return o.readFile(name)
+}
+
+// Reads a file from the file system of a decoding, or else from the one of
+// the OS
+func (o options) readFile(name string) ([]byte, error) {
+	if o.fsys != nil {
+		return fs.ReadFile(o.fsys, name)
+	}
+	return os.ReadFile(name)
+}
+
+// Returns the information of a file of the file system of a decoding
+func (o options) stat(name string) (fs.FileInfo, error) {
+	if o.fsys != nil {
+		return fs.Stat(o.fsys, name)
+	}
+	return os.Stat(name)
+}
 
-	data := []string{}
-	scanner := bufio.NewScanner(file)
-	scanner.Split(bufio.ScanRunes)
-	for scanner.Scan() {
-		data = append(data, scanner.Text())
+// Reads a directory of the file system of a decoding
+func (o options) readDir(name string) ([]fs.DirEntry, error) {
+	if o.fsys != nil {
+		return fs.ReadDir(o.fsys, name)
 	}
+	return os.ReadDir(name)
+}
+
+// Joins the path of a directory and the name of one of its files
+func (o options) joinPath(dir string, name string) string {
+	if o.fsys != nil {
+		return path.Join(dir, name)
+	}
+	return filepath.Join(dir, name)
+}
 
-	return data, nil
+// Resolves a path relative to the directory of a file, if there's one
+// Paths of file systems are slash-separated and never absolute
+func (o options) resolvePath(file string, name string) string {
+	if o.fsys != nil {
+		if file != "" && !strings.HasPrefix(name, "/") {
+			name = path.Join(path.Dir(file), name)
+		}
+		return path.Clean(strings.TrimPrefix(name, "/"))
+	}
+	if !filepath.IsAbs(name) && file != "" {
+		name = filepath.Join(filepath.Dir(file), name)
+	}
+	return name
+}
+
+// Returns the absolute path of a file, which is already the case for the
+// paths of file systems
+func (o options) absPath(name string) (string, error) {
+	if o.fsys != nil {
+		return path.Clean(name), nil
+	}
+	return filepath.Abs(name)
+}
+
+// readRunes reads the contents of a reader into a slice of runes
+// Invalid UTF-8 bytes are read as utf8.RuneError
+func readRunes(r io.Reader) ([]rune, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return []rune(string(data)), nil
+}
+
+// Checks if a slice of runes holds a rune
+func containsRune(runes []rune, r rune) bool {
+	for _, c := range runes {
+		if c == r {
+			return true
+		}
+	}
+	return false
 }
 
 // Checks if a given string matches any of the elements
@@ -65,3 +153,108 @@ func hasPrefixToMany(target string, values []string) bool {
 	}
 	return false
 }
+
+// Checks if two lists hold the same strings in the same order
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks if a string may be an int or a float, so strings that can't be
+// aren't converted, as failed conversions allocate their errors
+func mayBeNumber(s string) bool {
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		s = s[1:]
+	}
+	if s == "" {
+		return false
+	}
+	c := s[0]
+	return ('0' <= c && c <= '9') || c == '.' || c == 'i' || c == 'I' || c == 'n' || c == 'N'
+}
+
+// Checks if a string is written as an int, like mayBeNumber, so only its
+// range is left for the conversion to check
+func mayBeInt(s string) bool {
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		s = s[1:]
+	}
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks if a string may be a boolean, like mayBeNumber
+func mayBeBool(s string) bool {
+	return s != "" && strings.IndexByte("01tTfF", s[0]) >= 0
+}
+
+// Longest string an interner shares
+const maxInternedLength = 64
+
+// An interner keeps a single copy of repeated short strings, such as the
+// names of the attributes of generated configurations
+// A nil interner returns the strings as they are
+type interner map[string]string
+
+// Returns the shared copy of a string
+func (in interner) intern(s string) string {
+	if in == nil || len(s) > maxInternedLength {
+		return s
+	}
+	if shared, found := in[s]; found {
+		return shared
+	}
+	in[s] = s
+	return s
+}
+
+// Returns the shared copy of the string of runes, only allocating it the
+// first time it's seen
+func (in interner) internRunes(r []rune) string {
+	if in == nil || len(r) > maxInternedLength {
+		return string(r)
+	}
+	var buf [maxInternedLength * utf8.UTFMax]byte
+	b := buf[:0]
+	for _, c := range r {
+		b = utf8.AppendRune(b, c)
+	}
+	if shared, found := in[string(b)]; found {
+		return shared
+	}
+	return in.intern(string(b))
+}
+
+// Names of attributes that can be written without quotes
+var bareKeyRegexp = regexp.MustCompile(`^[\p{L}\p{N}_-]+$`)
+
+// Returns the name of an attribute as written in a document, quoting the
+// names bare keys can't hold, such as the ones with spaces or dots
+func quoteKey(name string) string {
+	if bareKeyRegexp.MatchString(name) {
+		return name
+	}
+	return `"` + name + `"`
+}
+
+// Returns the name of an attribute without the quotes of its key
+func unquoteKey(key string) string {
+	if len(key) >= 2 && strings.HasPrefix(key, `"`) && strings.HasSuffix(key, `"`) {
+		return key[1 : len(key)-1]
+	}
+	return key
+}
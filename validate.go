@@ -0,0 +1,132 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Validator is implemented by the structs that check their own values once
+// Unmarshal has stored them
+type Validator interface {
+	Validate() error
+}
+
+// Calls the Validate method of a decoded struct, if it has one
+func (p *Parser) validateStruct(path Path, rv reflect.Value) error {
+	// Squashed unexported structs can't be called
+	if !rv.CanInterface() {
+		return nil
+	}
+	validator, ok := rv.Interface().(Validator)
+	if !ok && rv.CanAddr() {
+		validator, ok = rv.Addr().Interface().(Validator)
+	}
+	if !ok {
+		return nil
+	}
+	if err := validator.Validate(); err != nil {
+		return p.unmarshalError(path, err)
+	}
+	return nil
+}
+
+// Checks the value of a field against the rules of its `validate` tag,
+// separated by commas:
+//
+//	min=n     numbers can't be less than n, strings, arrays and maps
+//	          can't be shorter than n
+//	max=n     numbers can't be greater than n, strings, arrays and maps
+//	          can't be longer than n
+//	oneof=a b the value must be one of the values separated by spaces
+func (p *Parser) validateField(path Path, field reflect.StructField, rv reflect.Value) error {
+	tag := field.Tag.Get("validate")
+	if tag == "" {
+		return nil
+	}
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+
+	for _, rule := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		var err error
+		switch name {
+		case "min", "max":
+			err = validateBound(name, arg, rv)
+		case "oneof":
+			err = validateOneOf(arg, rv)
+		default:
+			err = fmt.Errorf("unknown validation %q of field %s", name, field.Name)
+		}
+		if err != nil {
+			return p.unmarshalError(path, err)
+		}
+	}
+	return nil
+}
+
+// Checks a min or max rule
+func validateBound(rule string, arg string, rv reflect.Value) error {
+	bound, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s validation %q", rule, arg)
+	}
+
+	var value float64
+	subject := "value"
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value = float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value = float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		value = rv.Float()
+	case reflect.String:
+		value = float64(len([]rune(rv.String())))
+		subject = "length"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		value = float64(rv.Len())
+		subject = "length"
+	default:
+		return fmt.Errorf("%s validation can't check a %s", rule, rv.Type())
+	}
+
+	if rule == "min" && value < bound {
+		return fmt.Errorf("%s must be at least %s, got %v", subject, arg, value)
+	}
+	if rule == "max" && value > bound {
+		return fmt.Errorf("%s must be at most %s, got %v", subject, arg, value)
+	}
+	return nil
+}
+
+// Checks a oneof rule
+func validateOneOf(arg string, rv reflect.Value) error {
+	allowed := strings.Fields(arg)
+	value := fmt.Sprint(rv.Interface())
+	for _, a := range allowed {
+		if value == a {
+			return nil
+		}
+	}
+	return fmt.Errorf("value must be one of %s, got %s", strings.Join(allowed, ", "), value)
+}
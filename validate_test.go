@@ -0,0 +1,82 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testValidatedServer struct {
+	Host string
+	Port int
+}
+
+func (s *testValidatedServer) Validate() error {
+	if s.Host == "localhost" && s.Port == 443 {
+		return errors.New("localhost can't serve TLS")
+	}
+	return nil
+}
+
+type testValidatedConfig struct {
+	Name    string   `validate:"min=3,max=10"`
+	Workers int      `validate:"min=1,max=64"`
+	Level   string   `validate:"oneof=debug info warn"`
+	Tags    []string `validate:"max=2"`
+	Server  testValidatedServer
+}
+
+func TestValidate(t *testing.T) {
+	valid := `name = "cafe"
+workers = 4
+level = "info"
+tags = ["web"]
+server {
+    host = "localhost"
+    port = 8080
+}
+`
+	var config testValidatedConfig
+	assert.NoError(t, Unmarshal([]byte(valid), &config))
+
+	tests := []struct {
+		src string
+		err string
+	}{
+		{`name = "ca"`, `line 1: name: length must be at least 3, got 2`},
+		{`workers = 100`, `line 1: workers: value must be at most 64, got 100`},
+		{`workers = 0`, `line 1: workers: value must be at least 1, got 0`},
+		{`level = "trace"`, `line 1: level: value must be one of debug, info, warn, got trace`},
+		{`tags = ["a", "b", "c"]`, `line 1: tags: length must be at most 2, got 3`},
+		{"server {\n    host = \"localhost\"\n    port = 443\n}", `line 1: server: localhost can't serve TLS`},
+	}
+	for _, test := range tests {
+		err := Unmarshal([]byte(test.src+"\n"), &testValidatedConfig{})
+		assert.EqualError(t, err, test.err, test.src)
+	}
+
+	// Top level validations have no path
+	var root testValidatedServer
+	err := Unmarshal([]byte("host = \"localhost\"\nport = 443\n"), &root)
+	assert.EqualError(t, err, "localhost can't serve TLS")
+
+	var invalid struct {
+		Port int `validate:"positive"`
+	}
+	assert.Error(t, Unmarshal([]byte("port = 1\n"), &invalid))
+}
@@ -0,0 +1,81 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import "reflect"
+
+// EachAttribute calls fn with the path, as in "server.tls.cert", and the
+// attribute of every attribute of the document, going through the blocks in
+// the order they were defined in, until fn returns false
+func (p *Parser) EachAttribute(fn func(path string, a Attribute) bool) {
+	p.walk(nil, p.Attributes, p.Blocks, func(path Path, a Attribute) bool {
+		return fn(path.String(), a)
+	}, nil)
+}
+
+// EachBlock calls fn with the path and the block of every block of the
+// document, parents before the blocks nested in them, in the order they
+// were defined in, until fn returns false
+func (p *Parser) EachBlock(fn func(path string, b Block) bool) {
+	p.walk(nil, p.Attributes, p.Blocks, nil, func(path Path, b Block) bool {
+		return fn(path.String(), b)
+	})
+}
+
+// BlocksWhere returns the blocks of the document, at any depth, for which
+// fn returns true, in the order of EachBlock
+func (p *Parser) BlocksWhere(fn func(b Block) bool) []Match {
+	matches := []Match{}
+	p.walk(nil, p.Attributes, p.Blocks, nil, func(path Path, b Block) bool {
+		if fn(b) {
+			matches = append(matches, Match{Path: path, Value: bodyToJSON(b.Attributes, b.Blocks), Range: b.Range, Doc: b.Doc})
+		}
+		return true
+	})
+	return matches
+}
+
+// AttributeEquals returns a filter for BlocksWhere matching the blocks
+// with an attribute of a value, as in AttributeEquals("enabled", true)
+func AttributeEquals(name string, value Value) func(b Block) bool {
+	return func(b Block) bool {
+		attr, found := b.Attributes[name]
+		return found && reflect.DeepEqual(attr.Value, value)
+	}
+}
+
+// Walks the attributes and blocks of the body at path in the order they
+// were defined in, until one of the functions returns false, which is
+// reported
+// Nil functions are skipped
+func (p *Parser) walk(path Path, attributes map[string]Attribute, blocks map[string]Block, onAttribute func(Path, Attribute) bool, onBlock func(Path, Block) bool) bool {
+	for _, name := range p.orderedNames(path, attributes, blocks) {
+		if attr, isAttribute := attributes[name]; isAttribute {
+			if onAttribute != nil && !onAttribute(childPath(path, name), attr) {
+				return false
+			}
+			continue
+		}
+
+		b := blocks[name]
+		if onBlock != nil && !onBlock(childPath(path, name), b) {
+			return false
+		}
+		if !p.walk(childPath(path, name), b.Attributes, b.Blocks, onAttribute, onBlock) {
+			return false
+		}
+	}
+	return true
+}
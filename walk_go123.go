@@ -0,0 +1,36 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build go1.23
+
+package cafe
+
+import "iter"
+
+// AllAttributes returns an iterator over the paths and the attributes of
+// the document, in the order of EachAttribute, for range loops
+func (p *Parser) AllAttributes() iter.Seq2[string, Attribute] {
+	return func(yield func(string, Attribute) bool) {
+		p.EachAttribute(yield)
+	}
+}
+
+// AllBlocks returns an iterator over the paths and the blocks of the
+// document, in the order of EachBlock, for range loops
+func (p *Parser) AllBlocks() iter.Seq2[string, Block] {
+	return func(yield func(string, Block) bool) {
+		p.EachBlock(yield)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build go1.23
+
+package cafe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllAttributes(t *testing.T) {
+	p, err := DecodeBytes([]byte(walkSrc))
+	assert.NoError(t, err)
+
+	paths := []string{}
+	for path, a := range p.AllAttributes() {
+		if path == "debug" {
+			break
+		}
+		paths = append(paths, path+"="+a.Name)
+	}
+	assert.Equal(t, []string{"name=name", "server.port=port", "server.tls.cert=cert", `server."listen address"=listen address`}, paths)
+
+	blocks := []string{}
+	for path, b := range p.AllBlocks() {
+		blocks = append(blocks, path+"="+b.Name)
+	}
+	assert.Equal(t, []string{"server=server", "server.tls=tls"}, blocks)
+}
@@ -0,0 +1,107 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Document walked by the tests
+const walkSrc = `name = "cafe"
+server {
+    port = 8080
+    tls {
+        cert = "cert.pem"
+    }
+    "listen address" = "0.0.0.0"
+}
+debug = false
+`
+
+func TestEachAttribute(t *testing.T) {
+	p, err := DecodeBytes([]byte(walkSrc))
+	assert.NoError(t, err)
+
+	paths, values := []string{}, []interface{}{}
+	p.EachAttribute(func(path string, a Attribute) bool {
+		paths, values = append(paths, path), append(values, a.Value)
+		return true
+	})
+	assert.Equal(t, []string{"name", "server.port", "server.tls.cert", `server."listen address"`, "debug"}, paths)
+	assert.Equal(t, []interface{}{"cafe", 8080, "cert.pem", "0.0.0.0", false}, values)
+
+	// Walking stops once fn returns false
+	paths = []string{}
+	p.EachAttribute(func(path string, a Attribute) bool {
+		paths = append(paths, path)
+		return path != "server.tls.cert"
+	})
+	assert.Equal(t, []string{"name", "server.port", "server.tls.cert"}, paths)
+}
+
+func TestEachBlock(t *testing.T) {
+	p, err := DecodeBytes([]byte(walkSrc))
+	assert.NoError(t, err)
+
+	paths := []string{}
+	p.EachBlock(func(path string, b Block) bool {
+		paths = append(paths, path)
+		return true
+	})
+	assert.Equal(t, []string{"server", "server.tls"}, paths)
+
+	paths = []string{}
+	p.EachBlock(func(path string, b Block) bool {
+		paths = append(paths, path)
+		return false
+	})
+	assert.Equal(t, []string{"server"}, paths)
+}
+
+func TestBlocksWhere(t *testing.T) {
+	p, err := DecodeBytes([]byte(`services {
+    web {
+        enabled = true
+        port = 8080
+    }
+    db {
+        enabled = false
+        port = 5432
+        replica {
+            enabled = true
+            port = 5433
+        }
+    }
+}
+`))
+	assert.NoError(t, err)
+
+	matches := p.BlocksWhere(AttributeEquals("enabled", true))
+	assert.Equal(t, []Match{
+		{Path: Path{"services", "web"}, Value: map[string]interface{}{"enabled": true, "port": 8080}, Range: Range{Position{2, 5}, Position{5, 6}}},
+		{Path: Path{"services", "db", "replica"}, Value: map[string]interface{}{"enabled": true, "port": 5433}, Range: Range{Position{9, 9}, Position{12, 10}}},
+	}, matches)
+
+	matches = p.BlocksWhere(func(b Block) bool {
+		port, isInt := b.Attributes["port"].Value.(int)
+		return isInt && port > 5000
+	})
+	assert.Len(t, matches, 3)
+	assert.Equal(t, Path{"services", "web"}, matches[0].Path)
+
+	assert.Empty(t, p.BlocksWhere(AttributeEquals("port", "8080")))
+}
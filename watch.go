@@ -0,0 +1,204 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// How long a file must go without changes before it's decoded again, as
+// editors and deployment tools often write a file in many steps
+const watchDelay = 100 * time.Millisecond
+
+// Watcher decodes a file again every time it changes, for long-running
+// services that reload their configuration without restarting
+// It's safe for concurrent use
+type Watcher struct {
+	path  string
+	fn    func(*Parser, error)
+	cache *Cache
+	store *Store
+
+	// Last document decoded without errors
+	current atomic.Pointer[Parser]
+	watcher *fsnotify.Watcher
+
+	// Absolute paths of the files whose changes reload the document, and
+	// the directories being watched for them
+	files map[string]bool
+	dirs  map[string]bool
+
+	closeOnce sync.Once
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+// Watch decodes a file and watches it, decoding it again once it changes
+// and passing the new document, or the error that kept it from being
+// decoded, to fn
+// Changes of the override file and of the included files of the document
+// reload it too, and changes made in quick succession reload it once
+// Current returns the last document decoded without errors, which is
+// replaced at once, so a document that can't be decoded never replaces a
+// good one
+// Documents decoded without errors are pushed to the store of WithStore
+// Watch returns an error, without watching the file, if it can't be decoded
+// in the first place
+func Watch(path string, fn func(*Parser, error), opts ...Option) (*Watcher, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	cache := NewCache(opts...)
+	p, err := cache.Decode(abs)
+	if err != nil {
+		return nil, err
+	}
+	store := newOptions(opts).store
+	if store != nil {
+		if _, err := store.Push(p); err != nil {
+			return nil, err
+		}
+	}
+
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		path:    abs,
+		fn:      fn,
+		cache:   cache,
+		store:   store,
+		watcher: fsWatcher,
+		files:   map[string]bool{},
+		dirs:    map[string]bool{},
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	w.current.Store(p)
+	if err := w.watchFiles(p); err != nil {
+		fsWatcher.Close()
+		return nil, err
+	}
+
+	go w.run()
+	return w, nil
+}
+
+// Current returns the last document decoded without errors, or the current
+// document of the store of WithStore, which Store.Rollback can swap for an
+// older one
+func (w *Watcher) Current() *Parser {
+	if w.store != nil {
+		if snapshot, found := w.store.Current(); found {
+			return snapshot.Parser
+		}
+	}
+	return w.current.Load()
+}
+
+// Close stops watching the file
+// No calls to fn are made once Close returns, so it can't be called from fn
+func (w *Watcher) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.done)
+		<-w.stopped
+		err = w.watcher.Close()
+	})
+	return err
+}
+
+// Waits for the changes of the files of the document until the watcher is
+// closed
+func (w *Watcher) run() {
+	defer close(w.stopped)
+
+	// Each change delays the reload a bit more
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-w.done:
+			return
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op != fsnotify.Chmod && w.files[filepath.Clean(event.Name)] {
+				reload = time.After(watchDelay)
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.fn(nil, err)
+		case <-reload:
+			reload = nil
+			w.reload()
+		}
+	}
+}
+
+// Decodes the file again and swaps the current document for the new one
+// Files touched without changing their content keep their document, and
+// fn isn't called for them
+func (w *Watcher) reload() {
+	p, err := w.cache.Decode(w.path)
+	if err != nil {
+		w.fn(nil, err)
+		return
+	}
+	if p == w.current.Load() {
+		return
+	}
+	w.current.Store(p)
+	if w.store != nil {
+		if _, err := w.store.Push(p); err != nil {
+			w.fn(nil, err)
+		}
+	}
+	if err := w.watchFiles(p); err != nil {
+		w.fn(nil, err)
+	}
+	w.fn(p, nil)
+}
+
+// Watches the directories of the files a document was decoded from
+// Directories are watched instead of the files, so files replaced by
+// renaming another one over them are still watched
+func (w *Watcher) watchFiles(p *Parser) error {
+	files := append([]string{w.path}, p.includedFiles...)
+	if override, hasOverride := overrideFile(w.path); hasOverride {
+		files = append(files, override)
+	}
+	for _, file := range files {
+		w.files[file] = true
+		dir := filepath.Dir(file)
+		if w.dirs[dir] {
+			continue
+		}
+		if err := w.watcher.Add(dir); err != nil {
+			return err
+		}
+		w.dirs[dir] = true
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright (C) 2023 Lucas de Ataides <lucasatab@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package cafe
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "config.cafe")
+	assert.NoError(t, os.WriteFile(filename, []byte("port = 8080\n"), 0o644))
+
+	type reload struct {
+		p   *Parser
+		err error
+	}
+	reloads := make(chan reload, 10)
+	w, err := Watch(filename, func(p *Parser, err error) {
+		reloads <- reload{p, err}
+	})
+	assert.NoError(t, err)
+	defer w.Close()
+	assert.Equal(t, 8080, w.Current().Attributes["port"].Value)
+
+	next := func() reload {
+		select {
+		case r := <-reloads:
+			return r
+		case <-time.After(5 * time.Second):
+			t.Fatal("the file wasn't decoded again")
+			return reload{}
+		}
+	}
+
+	// Writes in quick succession reload the document once
+	assert.NoError(t, os.WriteFile(filename, []byte("port = 9090\n"), 0o644))
+	assert.NoError(t, os.WriteFile(filename, []byte("port = 9091\n"), 0o644))
+	r := next()
+	assert.NoError(t, r.err)
+	assert.Equal(t, 9091, r.p.Attributes["port"].Value)
+	assert.Same(t, r.p, w.Current())
+
+	// A document that can't be decoded keeps the current one
+	assert.NoError(t, os.WriteFile(filename, []byte("port = \"unclosed\n"), 0o644))
+	r = next()
+	assert.Error(t, r.err)
+	assert.Equal(t, 9091, w.Current().Attributes["port"].Value)
+
+	// Files replaced by renaming another one over them are still watched
+	replacement := filepath.Join(dir, "replacement.cafe")
+	assert.NoError(t, os.WriteFile(replacement, []byte("port = 443\n"), 0o644))
+	assert.NoError(t, os.Rename(replacement, filename))
+	r = next()
+	assert.NoError(t, r.err)
+	assert.Equal(t, 443, w.Current().Attributes["port"].Value)
+
+	assert.NoError(t, w.Close())
+	assert.NoError(t, w.Close())
+	assert.Empty(t, reloads)
+
+	_, err = Watch(filepath.Join(dir, "missing.cafe"), func(*Parser, error) {})
+	assert.Error(t, err)
+}
+
+func TestWatchStore(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "config.cafe")
+	assert.NoError(t, os.WriteFile(filename, []byte("port = 8080\n"), 0o644))
+
+	reloads := make(chan error, 10)
+	store := NewStore(5)
+	w, err := Watch(filename, func(p *Parser, err error) {
+		reloads <- err
+	}, WithStore(store))
+	assert.NoError(t, err)
+	defer w.Close()
+
+	next := func() error {
+		select {
+		case err := <-reloads:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("the file wasn't decoded again")
+			return nil
+		}
+	}
+
+	// The first document and every good reload are pushed
+	assert.NoError(t, os.WriteFile(filename, []byte("port = 9090\n"), 0o644))
+	assert.NoError(t, next())
+	assert.NoError(t, os.WriteFile(filename, []byte("port = \"unclosed\n"), 0o644))
+	assert.Error(t, next())
+
+	snapshots := store.Snapshots()
+	if assert.Len(t, snapshots, 2) {
+		assert.Equal(t, 8080, snapshots[0].Parser.Attributes["port"].Value)
+		assert.Same(t, w.Current(), snapshots[1].Parser)
+	}
+
+	// Rolling the store back swaps the document the watcher serves
+	_, err = store.Rollback(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 8080, w.Current().Attributes["port"].Value)
+
+	// Until the file is decoded again
+	assert.NoError(t, os.WriteFile(filename, []byte("port = 443\n"), 0o644))
+	assert.NoError(t, next())
+	assert.Equal(t, 443, w.Current().Attributes["port"].Value)
+}